@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/agent"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/metrics"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const keyTLSCA = "TLS_CA"
+
+// addAgentCommand adds the agent command, which runs the gRPC node agent.
+func (a *application) addAgentCommand() {
+	agentCommand := a.command(a.root, "agent", "Serve the node agent over gRPC", cobra.NoArgs, a.runAgent)
+	agentCommand.CobraRoot.Long = "Serve the gRPC node agent, which maps, mounts, unmounts, unmaps and resizes " +
+		"the images of this host for a controller. Controllers must present a certificate signed by " +
+		"--tls-ca. Images are only mounted below --mount-root, and trimmed every --trim-interval. Changes to " +
+		"the configuration file apply to later calls."
+
+	a.stringFlag(agentCommand, false, "listen", ":7443", "address to listen on")
+	a.stringFlag(agentCommand, false, "tls-cert", "", "certificate of the agent")
+	a.stringFlag(agentCommand, false, "tls-key", "", "private key of the certificate")
+	a.stringFlag(agentCommand, false, "tls-ca", "", "CA signing the certificates of the controllers")
+	a.stringFlag(agentCommand, false, "map-backend", string(rbd.MapBackendKRBD), "backend mapping images: krbd or nbd")
+	a.stringFlag(agentCommand, false, "mount-root", defaultMountRoot, "directory below which images may be mounted")
+	a.addMetricsFlag(agentCommand)
+	a.addTrimFlag(agentCommand)
+
+	for _, name := range []string{"tls-cert", "tls-key", "tls-ca"} {
+		_ = agentCommand.CobraRoot.MarkFlagRequired(name)
+	}
+}
+
+func (a *application) runAgent(_ []string) error {
+	files := &agent.TLSFiles{
+		Certificate: a.stringValue(keyTLSCert),
+		Key:         a.stringValue(keyTLSKey),
+		CA:          a.stringValue(keyTLSCA),
+	}
+
+	tlsConfig, err := files.ServerConfig()
+	if err != nil {
+		return err
+	}
+
+	rbdClient, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	server := &agent.Server{RBD: rbdClient, MountRoot: a.stringValue(keyMountRoot)}
+	collector := &metrics.Collector{RBD: rbdClient, Ceph: a.cephClient()}
+
+	trimScheduler, stopTrim, err := a.scheduleTrim(rbdClient)
+	if err != nil {
+		return err
+	}
+	defer stopTrim()
+
+	if err := a.running.WatchConfig(func(settings *config.Config) {
+		a.setSettings(settings)
+
+		rbdClient, err := a.rbdClient()
+		if err != nil {
+			log.Error().Err(err).Msg("agent client could not be reconfigured")
+
+			return
+		}
+
+		server.Reconfigure(rbdClient)
+		collector.Reconfigure(rbdClient, a.cephClient())
+		trimScheduler.Reconfigure(rbdClient)
+	}); err != nil {
+		log.Debug().Err(err).Msg("configuration file is not watched")
+	}
+
+	stopMetrics, err := a.serveMetrics(collector)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	listener, err := net.Listen("tcp", a.stringValue(keyListen))
+	if err != nil {
+		return fmt.Errorf("ERROR: agent failed: %w", err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), recoverPanics),
+	)
+	server.Register(grpcServer)
+
+	return serveGRPCUntilSignal(grpcServer, listener, "agent")
+}
+
+// recoverPanics turns a panic of a call into an Internal error, so that one failed call does not
+// stop the server and the calls running next to it.
+func recoverPanics(
+	ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+) (response interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Error().Str("Method", info.FullMethod).Interface("Panic", recovered).Bytes("Stack", debug.Stack()).
+				Msg("gRPC call panicked")
+
+			err = status.Errorf(codes.Internal, "%s panicked: %v", info.FullMethod, recovered)
+		}
+	}()
+
+	return handler(ctx, request)
+}
+
+// serveGRPCUntilSignal serves until SIGINT or SIGTERM, then waits for running calls to finish.
+func serveGRPCUntilSignal(grpcServer *grpc.Server, listener net.Listener, name string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+
+	go func() {
+		log.Info().Str("Address", listener.Addr().String()).Msg(name + " listening")
+
+		served <- grpcServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("ERROR: serving the %s failed: %w", name, err)
+	case <-ctx.Done():
+	}
+
+	grpcServer.GracefulStop()
+
+	return nil
+}
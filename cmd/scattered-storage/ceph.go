@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/spf13/cobra"
+)
+
+// addCephCommands adds the ceph command and its subcommands.
+func (a *application) addCephCommands() {
+	group := a.command(a.root, "ceph", "Inspect the pools of the Ceph cluster", cobra.NoArgs, nil)
+
+	a.command(group, "pools", "List all pools", cobra.NoArgs, a.runPools)
+	a.command(group, "rbd-pools", "List the pools tagged for rbd", cobra.NoArgs, a.runRBDPools)
+	a.command(group, "app-tags <pool>", "Show the application tags of a pool", cobra.ExactArgs(1), a.runAppTags)
+}
+
+func (a *application) runPools(_ []string) error {
+	pools, err := a.cephClient().GetOSDPoolList()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(pools)
+}
+
+func (a *application) runRBDPools(_ []string) error {
+	pools := a.cephClient().GetRBDPools()
+	if pools == nil {
+		pools = ceph.OSDPoolList{}
+	}
+
+	return a.print(pools)
+}
+
+func (a *application) runAppTags(args []string) error {
+	tags, err := a.cephClient().GetApplicationTag(args[0])
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(tags)
+}
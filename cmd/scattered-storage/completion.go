@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/cli"
+	"github.com/spf13/cobra"
+)
+
+// completionCacheTTL is how long pools and images listed for completion are reused.
+const completionCacheTTL = 30 * time.Second
+
+var errNoPools = errors.New("rbd pools could not be listed")
+
+// addCompletionCommand adds the completion command, which replaces the default one of cobra.
+func (a *application) addCompletionCommand() {
+	a.root.CobraRoot.CompletionOptions.DisableDefaultCmd = true
+
+	completion := a.command(a.root, "completion <bash|zsh|fish>", "Generate the completion script for a shell",
+		cobra.ExactValidArgs(1), a.runCompletion)
+	completion.CobraRoot.ValidArgs = []string{"bash", "zsh", "fish"}
+	completion.CobraRoot.Long = "Generate the completion script for a shell, for example:\n\n" +
+		"  source <(scattered-storage completion bash)\n" +
+		"  scattered-storage completion zsh > \"${fpath[1]}/_scattered-storage\"\n" +
+		"  scattered-storage completion fish > ~/.config/fish/completions/scattered-storage.fish\n\n" +
+		"Pools and images are suggested from the cluster and cached for 30 seconds."
+}
+
+func (a *application) runCompletion(args []string) error {
+	var err error
+
+	switch args[0] {
+	case "bash":
+		err = a.root.CobraRoot.GenBashCompletionV2(a.out, true)
+	case "zsh":
+		err = a.root.CobraRoot.GenZshCompletion(a.out)
+	case "fish":
+		err = a.root.CobraRoot.GenFishCompletion(a.out, true)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// completeImageSpecs completes the image spec argument of a command, first with the rbd pools
+// and then, when images is set, with the images of the chosen pool and namespace.
+func (a *application) completeImageSpecs(cmd *cli.Cmd, images bool) {
+	cmd.CobraRoot.ValidArgsFunction = a.completion(cmd, func(args []string, toComplete string) (
+		[]string, cobra.ShellCompDirective,
+	) {
+		if len(args) > 0 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		parts := strings.Split(toComplete, "/")
+		if !images && len(parts) > 1 {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+
+		if len(parts) == 1 || len(parts) > 3 {
+			pools, _ := a.completePools(toComplete)
+
+			for index := range pools {
+				pools[index] += "/"
+			}
+
+			return pools, cobra.ShellCompDirectiveNoSpace | cobra.ShellCompDirectiveNoFileComp
+		}
+
+		namespace := ""
+		if len(parts) == 3 {
+			namespace = parts[1]
+		}
+
+		return a.completeImages(parts[0], namespace, toComplete), cobra.ShellCompDirectiveNoFileComp
+	})
+}
+
+// completePoolFlag completes the --pool flag of a command with the rbd pools.
+func (a *application) completePoolFlag(cmd *cli.Cmd) {
+	_ = cmd.CobraRoot.RegisterFlagCompletionFunc("pool", a.completion(cmd,
+		func(_ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return a.completePools(toComplete)
+		}))
+}
+
+// completeProfiles completes the profile argument of a command with the profiles of the
+// configuration file.
+func (a *application) completeProfiles(cmd *cli.Cmd) {
+	cmd.CobraRoot.ValidArgsFunction = a.completion(cmd,
+		func(args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) > 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			var names []string
+
+			for _, profile := range a.currentSettings().ProfileList() {
+				if strings.HasPrefix(profile.Name, toComplete) {
+					names = append(names, profile.Name)
+				}
+			}
+
+			return names, cobra.ShellCompDirectiveNoFileComp
+		})
+}
+
+// completion wraps a completion function so that it runs with the configuration of the command,
+// which cobra does not load while completing.
+func (a *application) completion(
+	cmd *cli.Cmd, complete func(args []string, toComplete string) ([]string, cobra.ShellCompDirective),
+) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(_ *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if err := cmd.LoadConfiguration(); err != nil {
+			return nil, cobra.ShellCompDirectiveError
+		}
+
+		a.running = cmd
+		a.setSettings(cmd.CurrentConfig())
+		a.configureLogging()
+
+		return complete(args, toComplete)
+	}
+}
+
+// completePools returns the rbd pools starting with toComplete.
+func (a *application) completePools(toComplete string) ([]string, cobra.ShellCompDirective) {
+	pools, err := a.completionCache().get(a.completionKey("pools"), func() ([]string, error) {
+		pools := a.cephClient().GetRBDPools()
+		if pools == nil {
+			return nil, errNoPools
+		}
+
+		return []string(pools), nil
+	})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveError
+	}
+
+	return withPrefix(pools, "", toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeImages returns the image specs of a pool and namespace starting with toComplete.
+func (a *application) completeImages(pool, namespace, toComplete string) []string {
+	images, err := a.completionCache().get(a.completionKey("images", pool, namespace), func() ([]string, error) {
+		client, err := a.rbdClient()
+		if err != nil {
+			return nil, err
+		}
+
+		return client.GetRBDList(pool, namespace) //nolint:wrapcheck
+	})
+	if err != nil {
+		return nil
+	}
+
+	prefix := pool + "/"
+	if namespace != "" {
+		prefix += namespace + "/"
+	}
+
+	return withPrefix(images, prefix, toComplete)
+}
+
+// completionKey identifies a list of candidates on the cluster in use.
+func (a *application) completionKey(parts ...string) string {
+	cluster := ""
+	if settings := a.currentSettings(); settings != nil {
+		cluster = fmt.Sprintf("%+v", settings.Cluster)
+	}
+
+	return strings.Join(append([]string{cluster}, parts...), "\x00")
+}
+
+// completionCache returns the cache of completion candidates of the application.
+func (a *application) completionCache() *completionCache {
+	return &completionCache{directory: a.cacheDirectory, ttl: completionCacheTTL}
+}
+
+// withPrefix returns the candidates, each preceded by prefix, that start with toComplete.
+func withPrefix(candidates []string, prefix, toComplete string) []string {
+	var matches []string
+
+	for _, candidate := range candidates {
+		if candidate = prefix + candidate; strings.HasPrefix(candidate, toComplete) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	return matches
+}
+
+// completionCache keeps completion candidates on disk for a short time, so that pressing tab
+// repeatedly does not query the cluster every time. An empty directory disables the cache.
+type completionCache struct {
+	directory string
+	ttl       time.Duration
+}
+
+// get returns the cached candidates of a key, or loads and caches them when they are missing or
+// older than the ttl. Errors of the cache itself are ignored.
+func (c *completionCache) get(key string, load func() ([]string, error)) ([]string, error) {
+	if c.directory == "" {
+		return load()
+	}
+
+	path := filepath.Join(c.directory, fmt.Sprintf("%x.json", sha256.Sum256([]byte(key))))
+
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < c.ttl {
+		var candidates []string
+
+		if content, err := os.ReadFile(path); err == nil && json.Unmarshal(content, &candidates) == nil {
+			return candidates, nil
+		}
+	}
+
+	candidates, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	if content, err := json.Marshal(candidates); err == nil && os.MkdirAll(c.directory, 0o700) == nil {
+		_ = os.WriteFile(path, content, 0o600)
+	}
+
+	return candidates, nil
+}
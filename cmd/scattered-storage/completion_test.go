@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+// completionReplies lists two rbd pools, docker-ssd and rbd, next to a pool of another application.
+func completionReplies() map[string]string {
+	return map[string]string{
+		"ceph osd pool ls --format json":                          `["rbd","docker-ssd","cephfs_data"]`,
+		"ceph osd pool application get rbd --format json":         `{"rbd":{}}`,
+		"ceph osd pool application get docker-ssd --format json":  `{"rbd":{}}`,
+		"ceph osd pool application get cephfs_data --format json": `{"cephfs":{"data":"cephfs"}}`,
+		"rbd --pool rbd --namespace  list --format json":          `["test1","test2","web"]`,
+		"rbd --pool rbd --namespace tenant1 list --format json":   `["test3"]`,
+	}
+}
+
+// complete runs the hidden completion command of cobra and returns the candidates it printed,
+// without the trailing directive.
+func complete(t *testing.T, app *application, args ...string) []string {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	app.root.CobraRoot.SetArgs(append([]string{"__complete"}, args...))
+	app.root.CobraRoot.SetOut(&out)
+	app.root.CobraRoot.SetErr(io.Discard)
+
+	if err := app.root.CobraRoot.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	return lines[:len(lines)-1]
+}
+
+// TestCompletion tests the candidates suggested for pools and image specs.
+func TestCompletion(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{name: "TestPools", args: []string{"rbd", "info", ""}, want: []string{"rbd/", "docker-ssd/"}},
+		{name: "TestPoolPrefix", args: []string{"rbd", "mount", "doc"}, want: []string{"docker-ssd/"}},
+		{name: "TestImages", args: []string{"rbd", "info", "rbd/te"}, want: []string{"rbd/test1", "rbd/test2"}},
+		{name: "TestNamespace", args: []string{"rbd", "map", "rbd/tenant1/"}, want: []string{"rbd/tenant1/test3"}},
+		{name: "TestCreate", args: []string{"rbd", "create", "rbd/"}, want: []string{}},
+		{name: "TestPoolFlag", args: []string{"rbd", "list", "--pool", "r"}, want: []string{"rbd"}},
+		{name: "TestShells", args: []string{"completion", "z"}, want: []string{"zsh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newApplication(io.Discard, &helperstest.Runner{Replies: completionReplies()})
+			app.cacheDirectory = ""
+
+			if got := complete(t, app, tt.args...); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("complete(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCompletionCache tests that a second completion reuses the pools listed by the first.
+func TestCompletionCache(t *testing.T) {
+	directory := t.TempDir()
+	runner := &helperstest.Runner{Replies: completionReplies()}
+
+	for range []int{1, 2} {
+		app := newApplication(io.Discard, runner)
+		app.cacheDirectory = directory
+
+		if got := complete(t, app, "rbd", "info", ""); len(got) != 2 {
+			t.Fatalf("complete() = %q, want 2 pools", got)
+		}
+	}
+
+	listed := 0
+
+	for _, call := range runner.Calls {
+		if call == "ceph osd pool ls --format json" {
+			listed++
+		}
+	}
+
+	if listed != 1 {
+		t.Errorf("pools were listed %d times, want 1", listed)
+	}
+}
+
+// TestCompletionScripts tests that a script is generated for each shell.
+func TestCompletionScripts(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		out, err := execute(t, &helperstest.Runner{}, "completion", shell)
+		if err != nil {
+			t.Fatalf("completion %s error = %v", shell, err)
+		}
+
+		if !strings.Contains(out, "scattered-storage") {
+			t.Errorf("completion %s printed no script", shell)
+		}
+	}
+
+	if _, err := execute(t, &helperstest.Runner{}, "completion", "powershell"); err == nil {
+		t.Error("completion powershell succeeded, want an error")
+	}
+}
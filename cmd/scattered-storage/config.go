@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// addConfigCommands adds the config command and its subcommands.
+func (a *application) addConfigCommands() {
+	group := a.command(a.root, "config", "Inspect and change the configuration file", cobra.NoArgs, nil)
+	profiles := a.command(group, "profiles", "Manage the cluster profiles of the configuration file", cobra.NoArgs, nil)
+
+	a.command(profiles, "list", "List the profiles and mark the one in use", cobra.NoArgs, a.runProfilesList)
+	use := a.command(profiles, "use <profile>", "Make a profile the current-profile of the configuration file",
+		cobra.ExactArgs(1), a.runProfilesUse)
+	show := a.command(profiles, "show [<profile>]", "Show a profile, by default the one in use",
+		cobra.MaximumNArgs(1), a.runProfilesShow)
+
+	a.completeProfiles(use)
+	a.completeProfiles(show)
+}
+
+func (a *application) runProfilesList(_ []string) error {
+	return a.print(a.currentSettings().ProfileList())
+}
+
+func (a *application) runProfilesUse(args []string) error {
+	if err := a.running.UseProfile(args[0]); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	profile, err := a.currentSettings().NamedProfile(args[0])
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(profile)
+}
+
+func (a *application) runProfilesShow(args []string) error {
+	name := ""
+	if len(args) > 0 {
+		name = args[0]
+	}
+
+	profile, err := a.currentSettings().NamedProfile(name)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(profile)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/csi"
+	"github.com/scattered-network/scattered-storage/lib/metrics"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+)
+
+const (
+	keyEndpoint   = "ENDPOINT"
+	keyNodeID     = "NODE_ID"
+	keyDriverName = "DRIVER_NAME"
+)
+
+var ErrInvalidEndpoint = errors.New("endpoint must be unix:///path or tcp://host:port")
+
+// addCSICommand adds the csi command, which runs the Kubernetes CSI driver.
+func (a *application) addCSICommand() {
+	csiCommand := a.command(a.root, "csi", "Serve the Kubernetes CSI driver", cobra.NoArgs, a.runCSI)
+	csiCommand.CobraRoot.Long = "Serve the Identity, Controller and Node services of the Container Storage " +
+		"Interface, so that Kubernetes creates, snapshots, expands, maps and mounts images like the other " +
+		"commands do for Docker. StorageClasses choose the pool and namespace of their volumes with the " +
+		"'pool' and 'namespace' parameters; the configured pool is used otherwise."
+
+	hostname, _ := os.Hostname()
+
+	a.stringFlag(csiCommand, false, "endpoint", "unix:///csi/csi.sock", "endpoint to serve on")
+	a.stringFlag(csiCommand, false, "node-id", hostname, "ID of this node")
+	a.stringFlag(csiCommand, false, "driver-name", csi.DefaultName, "name of the driver")
+	a.stringFlag(csiCommand, false, "map-backend", string(rbd.MapBackendKRBD), "backend mapping images: krbd or nbd")
+	a.addMetricsFlag(csiCommand)
+}
+
+func (a *application) runCSI(_ []string) error {
+	rbdClient, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	driver := &csi.Driver{
+		Name:    a.stringValue(keyDriverName),
+		Version: version,
+		NodeID:  a.stringValue(keyNodeID),
+		RBD:     rbdClient,
+	}
+	if settings := a.currentSettings(); settings != nil {
+		driver.DefaultPool = settings.Pool
+	}
+
+	collector := &metrics.Collector{RBD: rbdClient, Ceph: a.cephClient()}
+
+	if err := a.running.WatchConfig(func(settings *config.Config) {
+		a.setSettings(settings)
+
+		rbdClient, err := a.rbdClient()
+		if err != nil {
+			log.Error().Err(err).Msg("CSI driver could not be reconfigured")
+
+			return
+		}
+
+		driver.Reconfigure(rbdClient, settings.Pool)
+		collector.Reconfigure(rbdClient, a.cephClient())
+	}); err != nil {
+		log.Debug().Err(err).Msg("configuration file is not watched")
+	}
+
+	stopMetrics, err := a.serveMetrics(collector)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	listener, err := listenEndpoint(a.stringValue(keyEndpoint))
+	if err != nil {
+		return err
+	}
+
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(otelgrpc.UnaryServerInterceptor(), recoverPanics))
+	driver.Register(grpcServer)
+
+	return serveGRPCUntilSignal(grpcServer, listener, "CSI driver")
+}
+
+// listenEndpoint listens on a unix:// or tcp:// endpoint, removing the socket left behind by a
+// previous run.
+func listenEndpoint(endpoint string) (net.Listener, error) {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+
+	address := parsed.Host
+	switch parsed.Scheme {
+	case "unix":
+		address = parsed.Path
+		if err := os.Remove(address); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("ERROR: csi failed: %w", err)
+		}
+	case "tcp":
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+
+	if address == "" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEndpoint, endpoint)
+	}
+
+	listener, err := net.Listen(parsed.Scheme, address)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: csi failed: %w", err)
+	}
+
+	return listener, nil
+}
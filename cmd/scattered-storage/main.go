@@ -0,0 +1,295 @@
+// Command scattered-storage manages RBD images, their mappings and mounts, and the Ceph pools
+// they live in from the command line.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/cli"
+	"github.com/scattered-network/scattered-storage/lib/cluster"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/metrics"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+)
+
+const (
+	applicationName = "scattered-storage"
+	envPrefix       = "SCATTERED_STORAGE"
+)
+
+// version is reported to Kubernetes by the CSI driver and recorded in traces. Release builds set it
+// with -ldflags "-X main.version=<version>".
+var version = "dev" //nolint:gochecknoglobals
+
+// Keys of the ConfigMap. Each matches the flag of the same name, upper-cased with dashes replaced
+// by underscores, and is set from SCATTERED_STORAGE_<KEY> when the flag is not given.
+const (
+	keyDebug       = "DEBUG"
+	keyOutput      = "OUTPUT"
+	keyMapBackend  = "MAP_BACKEND"
+	keyPool        = "POOL"
+	keyNamespace   = "NAMESPACE"
+	keySize        = "SIZE"
+	keyPath        = "PATH"
+	keyFilesystem  = "FILESYSTEM"
+	keyReadOnly    = "READ_ONLY"
+	keyNoAtime     = "NO_ATIME"
+	keyNoDiscard   = "NO_DISCARD"
+	keyForceFormat = "FORCE_FORMAT"
+	keyWholeDevice = "WHOLE_DEVICE"
+
+	keyTraceExporter = "TRACE_EXPORTER"
+)
+
+// application holds the command tree, the configuration shared by its commands and the clients
+// they use. Running is the command being run and settings its typed configuration, which the
+// watcher of the configuration file replaces while daemons serve and is read through
+// currentSettings. Runner replaces the local host in tests, and commands records every command run
+// through it. CacheDirectory keeps the candidates of shell completion; empty disables the cache.
+type application struct {
+	config        map[string]*cli.ConfigMap
+	settings      *config.Config
+	settingsMutex sync.RWMutex
+	root          *cli.Cmd
+	running       *cli.Cmd
+	runner        helpers.Runner
+	commands      *metrics.Commands
+	out           io.Writer
+
+	cacheDirectory string
+}
+
+func main() {
+	if err := newApplication(os.Stdout, nil).root.CobraRoot.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// newApplication builds the command tree writing results to out.
+func newApplication(out io.Writer, runner helpers.Runner) *application {
+	app := &application{config: map[string]*cli.ConfigMap{}, runner: runner, commands: metrics.NewCommands(), out: out}
+	if directory, err := os.UserCacheDir(); err == nil {
+		app.cacheDirectory = filepath.Join(directory, applicationName)
+	}
+
+	app.root = cli.NewCLICommand(applicationName, "Manage Ceph RBD images, mappings and mounts",
+		"scattered-storage creates, maps and mounts RBD images on the local host and inspects the "+
+			"Ceph pools they are stored in.", envPrefix, nil, app.config, nil)
+	app.root.CobraRoot.SilenceUsage = true
+	app.boolFlag(app.root, true, "debug", false, "log every executed command")
+	app.root.CobraRoot.PersistentFlags().StringP("output", "o", cli.OutputTable,
+		"output format: table, json, yaml, go-template=<template> or jsonpath=<kubectl jsonpath expression>")
+	app.addKey("output", cli.OutputTable, helpers.TypeString)
+	app.stringFlag(app.root, true, "trace-exporter", tracing.ExporterNone,
+		"export OpenTelemetry traces: none, otlp (configured by OTEL_EXPORTER_OTLP_*) or stdout (to stderr)")
+
+	app.addRBDCommands()
+	app.addCephCommands()
+	app.addConfigCommands()
+	app.addServeCommand()
+	app.addAgentCommand()
+	app.addCSICommand()
+	app.addMetricsCommand()
+	app.addCompletionCommand()
+
+	return app
+}
+
+// command adds a subcommand to parent that shares the configuration of the application. A nil
+// run adds a command that only groups other commands.
+func (a *application) command(
+	parent *cli.Cmd, use, short string, args cobra.PositionalArgs, run func(args []string) error,
+) *cli.Cmd {
+	cmd := cli.NewCLICommand(use, short, short, envPrefix, parent.CobraRoot, a.config, nil)
+	cmd.CobraRoot.Args = args
+
+	if run != nil {
+		cmd.CobraRoot.RunE = func(_ *cobra.Command, args []string) error {
+			a.running = cmd
+			a.setSettings(cmd.CurrentConfig())
+			a.configureLogging()
+
+			// Reject a malformed --output before anything is changed.
+			if _, err := cli.NewPrinter(a.stringValue(keyOutput)); err != nil {
+				return fmt.Errorf("%w", err)
+			}
+
+			shutdown, err := tracing.Setup(context.Background(), a.stringValue(keyTraceExporter),
+				applicationName, version, os.Stderr)
+			if err != nil {
+				return err
+			}
+
+			defer a.flushTraces(shutdown)
+
+			return run(args)
+		}
+	}
+
+	return cmd
+}
+
+// flushTraces exports the spans that have not been exported yet before the command exits.
+func (a *application) flushTraces(shutdown func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := shutdown(ctx); err != nil {
+		log.Warn().Err(err).Msg("traces could not be exported")
+	}
+}
+
+// configureLogging only shows warnings and errors, unless --debug is set.
+func (a *application) configureLogging() {
+	if a.boolValue(keyDebug) {
+		zerolog.SetGlobalLevel(zerolog.TraceLevel)
+	} else {
+		zerolog.SetGlobalLevel(zerolog.WarnLevel)
+	}
+}
+
+// stringFlag adds a string flag to a command and its key to the ConfigMap.
+func (a *application) stringFlag(cmd *cli.Cmd, persistent bool, name, value, usage string) {
+	flags := cmd.CobraRoot.Flags()
+	if persistent {
+		flags = cmd.CobraRoot.PersistentFlags()
+	}
+
+	flags.String(name, value, usage)
+	a.addKey(name, value, helpers.TypeString)
+}
+
+// boolFlag adds a boolean flag to a command and its key to the ConfigMap.
+func (a *application) boolFlag(cmd *cli.Cmd, persistent bool, name string, value bool, usage string) {
+	flags := cmd.CobraRoot.Flags()
+	if persistent {
+		flags = cmd.CobraRoot.PersistentFlags()
+	}
+
+	flags.Bool(name, value, usage)
+	a.addKey(name, value, helpers.TypeBoolean)
+}
+
+// addKey adds the ConfigMap key of a flag with its default value. Flags of the same name on
+// different commands share the key.
+func (a *application) addKey(name string, value interface{}, dataType string) {
+	key := flagKey(name)
+	if _, ok := a.config[key]; ok {
+		return
+	}
+
+	a.config[key] = &cli.ConfigMap{}
+	a.config[key].SetValue(value, dataType)
+}
+
+// flagKey returns the ConfigMap key of a flag, the way cli.Cmd derives it.
+func flagKey(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// stringValue returns the value of a key from the ConfigMap.
+func (a *application) stringValue(key string) string {
+	if data, ok := a.config[key]; ok {
+		return cast.ToString(data.GetValue())
+	}
+
+	return ""
+}
+
+// boolValue returns the value of a key from the ConfigMap.
+func (a *application) boolValue(key string) bool {
+	if data, ok := a.config[key]; ok {
+		return cast.ToBool(data.GetValue())
+	}
+
+	return false
+}
+
+// setSettings replaces the typed configuration used by the clients created afterwards.
+func (a *application) setSettings(settings *config.Config) {
+	a.settingsMutex.Lock()
+	defer a.settingsMutex.Unlock()
+
+	a.settings = settings
+}
+
+// currentSettings returns the typed configuration in effect, which may be nil.
+func (a *application) currentSettings() *config.Config {
+	a.settingsMutex.RLock()
+	defer a.settingsMutex.RUnlock()
+
+	return a.settings
+}
+
+// rbdClient returns a client for the local host using the configured map backend.
+func (a *application) rbdClient() (*rbd.RadosBlockDeviceClient, error) {
+	backend := rbd.MapBackend(a.stringValue(keyMapBackend))
+	if err := rbd.ValidateMapBackend(backend); err != nil {
+		return nil, err
+	}
+
+	settings := a.currentSettings()
+
+	client := &rbd.RadosBlockDeviceClient{Runner: a.clusterRunner(settings), MapBackend: backend}
+	if settings != nil {
+		client.CommandTimeout = settings.Timeouts.Command
+		client.MakeFilesystemTimeout = settings.Timeouts.MakeFilesystem
+	}
+
+	return client, nil
+}
+
+// cephClient returns a client for the ceph command line tools of the local host.
+func (a *application) cephClient() *ceph.CephCLI {
+	settings := a.currentSettings()
+
+	client := &ceph.CephCLI{Runner: a.clusterRunner(settings)}
+	if settings != nil {
+		client.CommandTimeout = settings.Timeouts.Command
+	}
+
+	return client
+}
+
+// clusterRunner returns the runner of the application, adding the connection options of the
+// cluster of the settings to the Ceph commands when any is set. The commands are recorded in the
+// command metrics and traced as they were given, without the connection options.
+func (a *application) clusterRunner(settings *config.Config) helpers.Runner {
+	if settings == nil || settings.Cluster == (config.Cluster{}) {
+		return &metrics.Runner{Commands: a.commands, Next: &tracing.Runner{Next: a.runner}}
+	}
+
+	return &metrics.Runner{
+		Commands: a.commands,
+		Next: &tracing.Runner{
+			Next: &cluster.Runner{Config: settings.Cluster.ClusterConfig(), Next: a.runner},
+		},
+	}
+}
+
+// print writes a result in the format chosen with --output.
+func (a *application) print(result interface{}) error {
+	printer, err := cli.NewPrinter(a.stringValue(keyOutput))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := printer.Print(a.out, result); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// execute runs the application with arguments and returns what it printed.
+func execute(t *testing.T, runner *helperstest.Runner, args ...string) (string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+
+	app := newApplication(&out, runner)
+	app.cacheDirectory = ""
+	app.root.CobraRoot.SetArgs(args)
+	app.root.CobraRoot.SetOut(io.Discard)
+	app.root.CobraRoot.SetErr(io.Discard)
+
+	err := app.root.CobraRoot.Execute()
+
+	return out.String(), err
+}
+
+// TestCommands tests that subcommands run the expected commands and print their results.
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		replies  map[string]string
+		wantCall string
+		wantOut  string
+		wantErr  bool
+	}{
+		{
+			name:     "TestList",
+			args:     []string{"rbd", "list", "--pool", "docker-ssd", "-o", "json"},
+			replies:  map[string]string{"rbd --pool docker-ssd --namespace  list --format json": `["test1","test2"]`},
+			wantCall: "rbd --pool docker-ssd --namespace  list --format json",
+			wantOut:  `"test2"`,
+		},
+		{
+			name:     "TestListEnvironment",
+			args:     []string{"rbd", "list", "-o", "json"},
+			env:      map[string]string{"SCATTERED_STORAGE_POOL": "docker-hdd"},
+			replies:  map[string]string{"rbd --pool docker-hdd --namespace  list --format json": "[]"},
+			wantCall: "rbd --pool docker-hdd --namespace  list --format json",
+			wantOut:  "[]",
+		},
+		{
+			name:     "TestCreate",
+			args:     []string{"rbd", "create", "rbd/tenant1/test1", "--size", "10G", "-o", "json"},
+			wantCall: "rbd create --image-feature layering --image-feature striping --image-feature exclusive-lock --image-feature object-map --image-feature fast-diff --size 10737418240B rbd/tenant1/test1",
+			wantOut:  `"namespace": "tenant1"`,
+		},
+		{
+			name: "TestShowMapped",
+			args: []string{"rbd", "showmapped", "-o", "jsonpath={[*].device}"},
+			replies: map[string]string{
+				"rbd showmapped --format json":      `[{"id":"0","pool":"rbd","namespace":"","name":"test1","snap":"-","device":"/dev/rbd0"}]`,
+				"rbd-nbd list-mapped --format json": "[]",
+			},
+			wantOut: "/dev/rbd0\n",
+		},
+		{
+			name:     "TestAppTags",
+			args:     []string{"ceph", "app-tags", "rbd", "-o", "json"},
+			replies:  map[string]string{"ceph osd pool application get rbd --format json": `{"rbd":{}}`},
+			wantCall: "ceph osd pool application get rbd --format json",
+			wantOut:  `"rbd": {}`,
+		},
+		{
+			name:     "TestPoolsTable",
+			args:     []string{"ceph", "pools"},
+			replies:  map[string]string{"ceph osd pool ls --format json": `["rbd","docker-ssd"]`},
+			wantCall: "ceph osd pool ls --format json",
+			wantOut:  "NAME\nrbd\ndocker-ssd\n",
+		},
+		{
+			name:    "TestInvalidOutput",
+			args:    []string{"rbd", "delete", "rbd/test1", "-o", "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "TestInvalidSpec",
+			args:    []string{"rbd", "info", "not-a-spec"},
+			wantErr: true,
+		},
+		{
+			name:    "TestMountWithoutPath",
+			args:    []string{"rbd", "mount", "rbd/test1"},
+			wantErr: true,
+		},
+		{
+			name:    "TestInvalidMapBackend",
+			args:    []string{"rbd", "map", "rbd/test1", "--map-backend", "iscsi"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			runner := &helperstest.Runner{Replies: tt.replies}
+
+			out, err := execute(t, runner, tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantCall != "" && !contains(runner.Calls, tt.wantCall) {
+				t.Errorf("Execute() ran %q, want %q", runner.Calls, tt.wantCall)
+			}
+
+			if !strings.Contains(out, tt.wantOut) {
+				t.Errorf("Execute() printed %q, want %q", out, tt.wantOut)
+			}
+		})
+	}
+}
+
+func contains(calls []string, call string) bool {
+	for _, ran := range calls {
+		if ran == call {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TestConfigFile tests that the pool and cluster of the configuration file reach the commands.
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scattered-storage.yaml")
+	content := "cluster:\n  name: backup\n  user: client.storage\npool: docker-ssd\n"
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "rbd --cluster backup --id storage --pool docker-ssd --namespace  list --format json"
+	runner := &helperstest.Runner{Replies: map[string]string{want: "[]"}}
+
+	if _, err := execute(t, runner, "rbd", "list", "--config", path); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	if !contains(runner.Calls, want) {
+		t.Errorf("Execute() ran %q, want %q", runner.Calls, want)
+	}
+
+	if _, err := execute(t, runner, "rbd", "list", "--config", path+".missing"); err == nil {
+		t.Error("Execute() with a missing config file succeeded, want an error")
+	}
+}
+
+// TestProfiles tests that profiles select the cluster and pool and can be switched.
+func TestProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scattered-storage.yaml")
+	content := "pool: rbd\ncurrent-profile: prod\nprofiles:\n" +
+		"  prod:\n    conf: /etc/ceph/prod.conf\n    user: admin\n    pool: docker-ssd\n" +
+		"  dr:\n    conf: /etc/ceph/dr.conf\n    user: client.dr\n    pool: docker-hdd\n"
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCall string
+		wantOut  string
+	}{
+		{
+			name:     "TestCurrentProfile",
+			args:     []string{"rbd", "list"},
+			wantCall: "rbd --conf /etc/ceph/prod.conf --id admin --pool docker-ssd --namespace  list --format json",
+		},
+		{
+			name:     "TestProfileFlag",
+			args:     []string{"rbd", "list", "--profile", "dr"},
+			wantCall: "rbd --conf /etc/ceph/dr.conf --id dr --pool docker-hdd --namespace  list --format json",
+		},
+		{
+			name:     "TestProfilePoolFlag",
+			args:     []string{"rbd", "list", "--profile", "dr", "--pool", "docker-ssd"},
+			wantCall: "rbd --conf /etc/ceph/dr.conf --id dr --pool docker-ssd --namespace  list --format json",
+		},
+		{
+			name: "TestList",
+			args: []string{"config", "profiles", "list"},
+			wantOut: "CURRENT   NAME   CLUSTER   CONF                  USER        KEYRING   POOL\n" +
+				"          dr               /etc/ceph/dr.conf     client.dr             docker-hdd\n" +
+				"*         prod             /etc/ceph/prod.conf   admin                 docker-ssd\n",
+		},
+		{
+			name:    "TestShow",
+			args:    []string{"config", "profiles", "show", "-o", "jsonpath={.conf}"},
+			wantOut: "/etc/ceph/prod.conf\n",
+		},
+		{
+			name:    "TestUse",
+			args:    []string{"config", "profiles", "use", "dr", "-o", "jsonpath={.name} {.current}"},
+			wantOut: "dr true\n",
+		},
+		{
+			name:    "TestShowAfterUse",
+			args:    []string{"config", "profiles", "show", "-o", "jsonpath={.name}"},
+			wantOut: "dr\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SCATTERED_STORAGE_CONFIG", path)
+
+			runner := &helperstest.Runner{Replies: map[string]string{tt.wantCall: "[]"}}
+
+			out, err := execute(t, runner, tt.args...)
+			if err != nil {
+				t.Fatalf("Execute() error = %v", err)
+			}
+
+			if tt.wantCall != "" && !contains(runner.Calls, tt.wantCall) {
+				t.Errorf("Execute() ran %q, want %q", runner.Calls, tt.wantCall)
+			}
+
+			if tt.wantOut != "" && out != tt.wantOut {
+				t.Errorf("Execute() printed %q, want %q", out, tt.wantOut)
+			}
+		})
+	}
+
+	if _, err := execute(t, &helperstest.Runner{}, "rbd", "list", "--config", path, "--profile", "staging"); err == nil {
+		t.Error("Execute() with an unknown profile succeeded, want an error")
+	}
+}
+
+// TestServeArguments tests the checks made before the API starts listening.
+func TestServeArguments(t *testing.T) {
+	directory := t.TempDir()
+	tokens := filepath.Join(directory, "tokens")
+	empty := filepath.Join(directory, "empty")
+
+	if err := os.WriteFile(tokens, []byte("# portal\ns3cr3t\n\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(empty, []byte("# no tokens yet\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := readTokens(tokens); err != nil || strings.Join(got, ",") != "s3cr3t" {
+		t.Errorf("readTokens() = %q, %v, want [s3cr3t]", got, err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "TestNoTokens", args: []string{"serve", "--token-file", empty}, wantErr: ErrNoTokens},
+		{name: "TestTLSKeyMissing", args: []string{"serve", "--token-file", tokens, "--tls-cert", "cert.pem"}, wantErr: ErrIncompleteTLSKey},
+		{
+			name:    "TestInvalidTrimInterval",
+			args:    []string{"serve", "--token-file", tokens, "--trim-interval", "daily"},
+			wantErr: validators.ErrInvalidTrimSchedule,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := execute(t, &helperstest.Runner{}, tt.args...); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := execute(t, &helperstest.Runner{}, "serve"); err == nil {
+		t.Error("serve without --token-file succeeded, want an error")
+	}
+}
+
+// TestAgentArguments tests the checks made before the agent starts listening.
+func TestAgentArguments(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "agent.crt")
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "TestNoCA", args: []string{"agent", "--tls-cert", "agent.crt", "--tls-key", "agent.key"}, wantErr: "tls-ca"},
+		{
+			name:    "TestMissingCertificate",
+			args:    []string{"agent", "--tls-cert", missing, "--tls-key", "agent.key", "--tls-ca", "ca.crt"},
+			wantErr: missing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := execute(t, &helperstest.Runner{}, tt.args...); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Execute() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestRecoverPanics tests that a panicking gRPC call fails with Internal instead of stopping the server.
+func TestRecoverPanics(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/agent.Agent/Mount"}
+
+	_, err := recoverPanics(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		var info *rbd.ListBlock
+
+		return info.Blockdevices[0], nil
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("recoverPanics() error = %v, want code %v", err, codes.Internal)
+	}
+
+	response, err := recoverPanics(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+		return "mounted", nil
+	})
+	if response != "mounted" || err != nil {
+		t.Errorf("recoverPanics() = %v, %v", response, err)
+	}
+}
+
+// TestCSIEndpoint tests the endpoints the CSI driver serves on.
+func TestCSIEndpoint(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "csi.sock")
+	if err := os.WriteFile(socket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := listenEndpoint("unix://" + socket)
+	if err != nil {
+		t.Fatalf("listenEndpoint() with a stale socket error = %v", err)
+	}
+
+	_ = listener.Close()
+
+	for _, endpoint := range []string{"/csi/csi.sock", "http://localhost:8080", "unix://", "tcp://"} {
+		if _, err := listenEndpoint(endpoint); !errors.Is(err, ErrInvalidEndpoint) {
+			t.Errorf("listenEndpoint(%q) error = %v, want %v", endpoint, err, ErrInvalidEndpoint)
+		}
+	}
+}
+
+// TestMetrics tests that the commands run by subcommands are served as metrics.
+func TestMetrics(t *testing.T) {
+	app := newApplication(io.Discard, &helperstest.Runner{
+		Replies: map[string]string{"rbd --pool docker-ssd --namespace  list --format json": `["test1"]`},
+	})
+	app.cacheDirectory = ""
+	app.root.CobraRoot.SetArgs([]string{"rbd", "list", "--pool", "docker-ssd"})
+	app.root.CobraRoot.SetOut(io.Discard)
+	app.root.CobraRoot.SetErr(io.Discard)
+
+	if err := app.root.CobraRoot.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	app.metricsHandler(nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	want := `scattered_storage_commands_total{binary="rbd",result="success",subcommand="list"} 1`
+	if !strings.Contains(recorder.Body.String(), want) {
+		t.Errorf("/metrics does not hold %s:\n%s", want, recorder.Body.String())
+	}
+}
+
+// TestTraceExporter tests that an unknown trace exporter is rejected before anything runs.
+func TestTraceExporter(t *testing.T) {
+	runner := &helperstest.Runner{}
+
+	if _, err := execute(t, runner, "rbd", "list", "--trace-exporter", "jaeger"); !errors.Is(err, tracing.ErrUnknownExporter) {
+		t.Errorf("Execute() error = %v, want %v", err, tracing.ErrUnknownExporter)
+	}
+
+	if len(runner.Calls) != 0 {
+		t.Errorf("commands run = %q, want none", runner.Calls)
+	}
+}
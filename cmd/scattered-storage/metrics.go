@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/cli"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/metrics"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/spf13/cobra"
+)
+
+const (
+	keyMetricsListen        = "METRICS_LISTEN"
+	keyImageMetricsInterval = "IMAGE_METRICS_INTERVAL"
+)
+
+// addMetricsCommand adds the metrics command, which serves the Prometheus metrics on their own.
+func (a *application) addMetricsCommand() {
+	metricsCommand := a.command(a.root, "metrics", "Serve Prometheus metrics over HTTP", cobra.NoArgs, a.runMetrics)
+	metricsCommand.CobraRoot.Long = "Serve the Prometheus metrics of this host at /metrics: the images mapped to " +
+		"and mounted on it, their locks, and the usage and quota of the RBD pools and their images. The serve, " +
+		"agent and csi commands serve the same metrics, and those of the commands they run, with --metrics-listen. " +
+		"The usage of the images is read every --image-metrics-interval rather than on every scrape."
+
+	a.stringFlag(metricsCommand, false, "listen", ":9810", "address to listen on")
+	a.stringFlag(metricsCommand, false, "map-backend", string(rbd.MapBackendKRBD), "backend mapping images: krbd or nbd")
+	a.addImageMetricsFlag(metricsCommand)
+}
+
+func (a *application) runMetrics(_ []string) error {
+	collector, err := a.metricsCollector()
+	if err != nil {
+		return err
+	}
+
+	if err := a.running.WatchConfig(func(settings *config.Config) {
+		a.setSettings(settings)
+		a.reconfigureCollector(collector)
+	}); err != nil {
+		log.Debug().Err(err).Msg("configuration file is not watched")
+	}
+
+	stopImages, err := a.refreshImageMetrics(collector)
+	if err != nil {
+		return err
+	}
+	defer stopImages()
+
+	httpServer := &http.Server{ //nolint:exhaustruct
+		Addr:              a.stringValue(keyListen),
+		Handler:           a.metricsHandler(collector),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return serveUntilSignal(httpServer, "", "", "metrics")
+}
+
+// addMetricsFlag adds --metrics-listen to a command serving for a long time.
+func (a *application) addMetricsFlag(cmd *cli.Cmd) {
+	a.stringFlag(cmd, false, "metrics-listen", "", "address to serve Prometheus metrics on; empty disables them")
+	a.addImageMetricsFlag(cmd)
+}
+
+// addImageMetricsFlag adds --image-metrics-interval to a command serving metrics.
+func (a *application) addImageMetricsFlag(cmd *cli.Cmd) {
+	a.stringFlag(cmd, false, "image-metrics-interval", "5m",
+		"how often the usage of every image is read for the metrics; 0 disables the image metrics")
+}
+
+// refreshImageMetrics refreshes the image metrics of a collector in the background every
+// --image-metrics-interval, and returns the function stopping the refresh.
+func (a *application) refreshImageMetrics(collector *metrics.Collector) (func(), error) {
+	interval, err := time.ParseDuration(a.stringValue(keyImageMetricsInterval))
+	if err != nil || interval < 0 {
+		return nil, fmt.Errorf("%w: --image-metrics-interval %q", metrics.ErrInvalidImageInterval,
+			a.stringValue(keyImageMetricsInterval))
+	}
+
+	if interval == 0 {
+		return func() {}, nil
+	}
+
+	collector.ImageInterval = interval
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() { _ = collector.Run(ctx) }()
+
+	return cancel, nil
+}
+
+// metricsCollector returns a collector using the configured clients.
+func (a *application) metricsCollector() (*metrics.Collector, error) {
+	rbdClient, err := a.rbdClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return &metrics.Collector{RBD: rbdClient, Ceph: a.cephClient()}, nil
+}
+
+// reconfigureCollector gives a collector the clients of a changed configuration.
+func (a *application) reconfigureCollector(collector *metrics.Collector) {
+	rbdClient, err := a.rbdClient()
+	if err != nil {
+		log.Error().Err(err).Msg("metrics collector could not be reconfigured")
+
+		return
+	}
+
+	collector.Reconfigure(rbdClient, a.cephClient())
+}
+
+// metricsHandler serves the command metrics of the application and those of a collector at /metrics.
+func (a *application) metricsHandler(collector *metrics.Collector) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(metrics.NewRegistry(a.commands, collector)))
+
+	return mux
+}
+
+// serveMetrics serves the metrics in the background on the address of --metrics-listen, and
+// returns the function stopping them. Nothing is served when the address is empty.
+func (a *application) serveMetrics(collector *metrics.Collector) (func(), error) {
+	address := a.stringValue(keyMetricsListen)
+	if address == "" {
+		return func() {}, nil
+	}
+
+	stopImages, err := a.refreshImageMetrics(collector)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		stopImages()
+
+		return nil, fmt.Errorf("ERROR: serving the metrics failed: %w", err)
+	}
+
+	httpServer := &http.Server{ //nolint:exhaustruct
+		Handler:           a.metricsHandler(collector),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	go func() {
+		log.Info().Str("Address", listener.Addr().String()).Msg("metrics listening")
+
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("serving the metrics failed")
+		}
+	}()
+
+	return func() {
+		stopImages()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		_ = httpServer.Shutdown(shutdown)
+	}, nil
+}
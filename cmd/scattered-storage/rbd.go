@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/scattered-network/scattered-storage/lib/cli"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/spf13/cobra"
+)
+
+const imageSpecUsage = " <pool>/[<namespace>/]<image>"
+
+// addRBDCommands adds the rbd command and its subcommands.
+func (a *application) addRBDCommands() {
+	group := a.command(a.root, "rbd", "Manage RBD images on the local host", cobra.NoArgs, nil)
+	a.stringFlag(group, true, "map-backend", string(rbd.MapBackendKRBD), "backend mapping images: krbd or nbd")
+
+	create := a.command(group, "create"+imageSpecUsage, "Create an image", cobra.ExactArgs(1), a.runCreate)
+	a.stringFlag(create, false, "size", "", "size of the image, for example 10G")
+	_ = create.CobraRoot.MarkFlagRequired("size")
+	a.completeImageSpecs(create, false)
+
+	list := a.command(group, "list", "List the images of a pool", cobra.NoArgs, a.runList)
+	a.stringFlag(list, false, "pool", "rbd", "pool to list")
+	a.stringFlag(list, false, "namespace", "", "namespace to list")
+	a.completePoolFlag(list)
+
+	info := a.command(group, "info"+imageSpecUsage, "Show the details of an image", cobra.ExactArgs(1), a.runInfo)
+	remove := a.command(group, "delete"+imageSpecUsage, "Delete an image", cobra.ExactArgs(1), a.runDelete)
+
+	mount := a.command(group, "mount"+imageSpecUsage+"[@<snapshot>]",
+		"Map an image and mount its filesystem, creating it on first use", cobra.ExactArgs(1), a.runMount)
+	a.stringFlag(mount, false, "path", "", "directory to mount the filesystem on")
+	_ = mount.CobraRoot.MarkFlagRequired("path")
+	a.stringFlag(mount, false, "filesystem", rbd.TagXfs, "filesystem created on first use: xfs or ext4")
+	a.boolFlag(mount, false, "read-only", false, "mount the filesystem read-only")
+	a.boolFlag(mount, false, "no-atime", false, "do not update access times")
+	a.boolFlag(mount, false, "no-discard", false, "do not discard freed blocks")
+	a.boolFlag(mount, false, "force-format", false, "erase existing signatures before formatting")
+	a.boolFlag(mount, false, "whole-device", false, "create the filesystem without a partition table")
+
+	unmount := a.command(group, "unmount"+imageSpecUsage, "Unmount the filesystem of an image",
+		cobra.ExactArgs(1), a.runUnmount)
+	mapImage := a.command(group, "map"+imageSpecUsage+"[@<snapshot>]", "Map an image to the local host",
+		cobra.ExactArgs(1), a.runMap)
+	unmap := a.command(group, "unmap"+imageSpecUsage, "Unmap an image from the local host", cobra.ExactArgs(1), a.runUnmap)
+	a.command(group, "showmapped", "List the images mapped to the local host", cobra.NoArgs, a.runShowMapped)
+	locks := a.command(group, "locks"+imageSpecUsage, "List the locks held on an image", cobra.ExactArgs(1), a.runLocks)
+
+	for _, cmd := range []*cli.Cmd{info, remove, mount, unmount, mapImage, unmap, locks} {
+		a.completeImageSpecs(cmd, true)
+	}
+}
+
+func (a *application) runCreate(args []string) error {
+	spec, err := rbd.ParseImageSpec(args[0])
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	size, err := units.ParseSize(a.stringValue(keySize))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	client, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.CreateRBD(spec, size); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(spec)
+}
+
+func (a *application) runList(_ []string) error {
+	client, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	images, err := client.GetRBDList(a.stringValue(keyPool), a.stringValue(keyNamespace))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if images == nil {
+		images = []string{}
+	}
+
+	return a.print(images)
+}
+
+func (a *application) runInfo(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		info, err := client.GetImageInfo(spec)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return a.print(info)
+	})
+}
+
+func (a *application) runDelete(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return client.DeleteRBD(spec)
+	})
+}
+
+func (a *application) runMount(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		path := a.stringValue(keyPath)
+		options := &rbd.MountOptions{
+			Filesystem:  a.stringValue(keyFilesystem),
+			ReadOnly:    a.boolValue(keyReadOnly),
+			NoAtime:     a.boolValue(keyNoAtime),
+			NoDiscard:   a.boolValue(keyNoDiscard),
+			ForceFormat: a.boolValue(keyForceFormat),
+			WholeDevice: a.boolValue(keyWholeDevice),
+		}
+
+		if spec.Snapshot != "" {
+			return client.MountSnapshot(spec, path, options)
+		}
+
+		return client.Mount(spec, path, options)
+	})
+}
+
+func (a *application) runUnmount(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return client.Unmount(spec)
+	})
+}
+
+func (a *application) runMap(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		device, err := client.Map(spec)
+		if err != nil {
+			return err
+		}
+
+		return a.print(&rbd.ShowMapped{{
+			Pool: spec.Pool, Namespace: spec.Namespace, Name: spec.Image, Snap: spec.Snapshot, Device: device,
+		}})
+	})
+}
+
+func (a *application) runUnmap(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return client.Unmap(spec)
+	})
+}
+
+func (a *application) runShowMapped(_ []string) error {
+	client, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	mapped, err := client.ListMappedImages()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return a.print(mapped)
+}
+
+func (a *application) runLocks(args []string) error {
+	return a.withImage(args, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		locks, err := client.ListLocks(spec)
+		if err != nil {
+			return err
+		}
+
+		if locks == nil {
+			locks = []*rbd.Lock{}
+		}
+
+		return a.print(locks)
+	})
+}
+
+// withImage parses the image spec argument and runs an operation on it.
+func (a *application) withImage(
+	args []string, operation func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error,
+) error {
+	spec, err := rbd.ParseImageSpec(args[0])
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	client, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	if err := operation(client, spec); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
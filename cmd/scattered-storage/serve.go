@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/api"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/metrics"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/spf13/cobra"
+)
+
+const (
+	keyListen    = "LISTEN"
+	keyTokenFile = "TOKEN_FILE"
+	keyTLSCert   = "TLS_CERT"
+	keyTLSKey    = "TLS_KEY"
+	keyMountRoot = "MOUNT_ROOT"
+
+	defaultMountRoot = "/srv/scattered-storage"
+
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 30 * time.Second
+)
+
+var (
+	ErrNoTokens         = errors.New("token file holds no tokens")
+	ErrIncompleteTLSKey = errors.New("--tls-cert and --tls-key must be given together")
+)
+
+// addServeCommand adds the serve command, which runs the management API.
+func (a *application) addServeCommand() {
+	serve := a.command(a.root, "serve", "Serve the management API over HTTP", cobra.NoArgs, a.runServe)
+	serve.CobraRoot.Long = "Serve the JSON management API for the pools, images, snapshots, mappings and mounts of " +
+		"this host. Every request needs one of the tokens of --token-file, one per line, as a bearer token. " +
+		"Images are only mounted below --mount-root, and trimmed every --trim-interval. The OpenAPI " +
+		"specification is served at /v1/openapi.yaml. " +
+		"Changes to the configuration file apply to later requests."
+
+	a.stringFlag(serve, false, "listen", "127.0.0.1:8080", "address to listen on")
+	a.stringFlag(serve, false, "token-file", "", "file holding the accepted bearer tokens, one per line")
+	_ = serve.CobraRoot.MarkFlagRequired("token-file")
+	a.stringFlag(serve, false, "tls-cert", "", "certificate to serve HTTPS with")
+	a.stringFlag(serve, false, "tls-key", "", "private key of the certificate")
+	a.stringFlag(serve, false, "map-backend", string(rbd.MapBackendKRBD), "backend mapping images: krbd or nbd")
+	a.stringFlag(serve, false, "mount-root", defaultMountRoot, "directory below which images may be mounted")
+	a.addMetricsFlag(serve)
+	a.addTrimFlag(serve)
+}
+
+func (a *application) runServe(_ []string) error {
+	tokens, err := readTokens(a.stringValue(keyTokenFile))
+	if err != nil {
+		return err
+	}
+
+	certificate, key := a.stringValue(keyTLSCert), a.stringValue(keyTLSKey)
+	if (certificate == "") != (key == "") {
+		return ErrIncompleteTLSKey
+	}
+
+	rbdClient, err := a.rbdClient()
+	if err != nil {
+		return err
+	}
+
+	server := &api.Server{RBD: rbdClient, Ceph: a.cephClient(), Tokens: tokens, MountRoot: a.stringValue(keyMountRoot)}
+	collector := &metrics.Collector{RBD: rbdClient, Ceph: server.Ceph}
+
+	trimScheduler, stopTrim, err := a.scheduleTrim(rbdClient)
+	if err != nil {
+		return err
+	}
+	defer stopTrim()
+
+	if err := a.running.WatchConfig(func(settings *config.Config) {
+		a.setSettings(settings)
+
+		rbdClient, err := a.rbdClient()
+		if err != nil {
+			log.Error().Err(err).Msg("API clients could not be reconfigured")
+
+			return
+		}
+
+		server.Reconfigure(rbdClient, a.cephClient())
+		collector.Reconfigure(rbdClient, a.cephClient())
+		trimScheduler.Reconfigure(rbdClient)
+	}); err != nil {
+		log.Debug().Err(err).Msg("configuration file is not watched")
+	}
+
+	stopMetrics, err := a.serveMetrics(collector)
+	if err != nil {
+		return err
+	}
+	defer stopMetrics()
+
+	httpServer := &http.Server{ //nolint:exhaustruct
+		Addr:              a.stringValue(keyListen),
+		Handler:           server.Handler(),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return serveUntilSignal(httpServer, certificate, key, "API")
+}
+
+// serveUntilSignal serves until SIGINT or SIGTERM, then waits for running requests to finish.
+func serveUntilSignal(httpServer *http.Server, certificate, key, name string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	served := make(chan error, 1)
+
+	go func() {
+		log.Info().Str("Address", httpServer.Addr).Bool("TLS", certificate != "").Msg(name + " listening")
+
+		if certificate != "" {
+			served <- httpServer.ListenAndServeTLS(certificate, key)
+		} else {
+			served <- httpServer.ListenAndServe()
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return fmt.Errorf("ERROR: serving the %s failed: %w", name, err)
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(shutdown); err != nil {
+		return fmt.Errorf("ERROR: stopping the %s failed: %w", name, err)
+	}
+
+	return nil
+}
+
+// readTokens reads the tokens of a file, one per line. Empty lines and lines starting with '#' are
+// skipped.
+func readTokens(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var tokens []string
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if token := strings.TrimSpace(line); token != "" && !strings.HasPrefix(token, "#") {
+			tokens = append(tokens, token)
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoTokens, path)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/cli"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+const (
+	keyTrimInterval = "TRIM_INTERVAL"
+
+	// trimJitterDivisor spreads the trims of the hosts of a cluster over a tenth of the interval.
+	trimJitterDivisor = 10
+)
+
+// addTrimFlag adds --trim-interval to a command serving the images of this host.
+func (a *application) addTrimFlag(cmd *cli.Cmd) {
+	a.stringFlag(cmd, false, "trim-interval", "24h",
+		"how often the mounted images mapped by this tool are trimmed; 0 disables trimming")
+}
+
+// scheduleTrim trims the mounted images in the background every --trim-interval, and returns the
+// scheduler, to be reconfigured along with the other clients, and the function stopping it.
+func (a *application) scheduleTrim(client *rbd.RadosBlockDeviceClient) (*rbd.TrimScheduler, func(), error) {
+	interval, err := time.ParseDuration(a.stringValue(keyTrimInterval))
+	if err != nil || interval < 0 {
+		return nil, nil, fmt.Errorf("%w: --trim-interval %q", validators.ErrInvalidTrimSchedule,
+			a.stringValue(keyTrimInterval))
+	}
+
+	scheduler := &rbd.TrimScheduler{Client: client, Interval: interval, Jitter: interval / trimJitterDivisor}
+	if interval == 0 {
+		return scheduler, func() {}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() { _ = scheduler.Run(ctx) }()
+
+	return scheduler, cancel, nil
+}
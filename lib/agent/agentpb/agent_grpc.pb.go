@@ -0,0 +1,349 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: agent.proto
+
+package agentpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Agent_MapImage_FullMethodName      = "/scattered.storage.agent.v1.Agent/MapImage"
+	Agent_UnmapImage_FullMethodName    = "/scattered.storage.agent.v1.Agent/UnmapImage"
+	Agent_Mount_FullMethodName         = "/scattered.storage.agent.v1.Agent/Mount"
+	Agent_Unmount_FullMethodName       = "/scattered.storage.agent.v1.Agent/Unmount"
+	Agent_ListMapped_FullMethodName    = "/scattered.storage.agent.v1.Agent/ListMapped"
+	Agent_GetMountPoint_FullMethodName = "/scattered.storage.agent.v1.Agent/GetMountPoint"
+	Agent_Resize_FullMethodName        = "/scattered.storage.agent.v1.Agent/Resize"
+)
+
+// AgentClient is the client API for Agent service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AgentClient interface {
+	// MapImage maps an image, or a snapshot read-only, unless it is mapped already.
+	MapImage(ctx context.Context, in *MapImageRequest, opts ...grpc.CallOption) (*MapImageResponse, error)
+	// UnmapImage unmaps an image.
+	UnmapImage(ctx context.Context, in *UnmapImageRequest, opts ...grpc.CallOption) (*UnmapImageResponse, error)
+	// Mount maps an image and mounts its filesystem, creating it on first use. A snapshot is
+	// mounted read-only and never formatted.
+	Mount(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (*MountResponse, error)
+	// Unmount unmounts the filesystem of an image and unmaps it.
+	Unmount(ctx context.Context, in *UnmountRequest, opts ...grpc.CallOption) (*UnmountResponse, error)
+	// ListMapped lists the images mapped to the host.
+	ListMapped(ctx context.Context, in *ListMappedRequest, opts ...grpc.CallOption) (*ListMappedResponse, error)
+	// GetMountPoint returns where the filesystem of an image is mounted, or nothing.
+	GetMountPoint(ctx context.Context, in *GetMountPointRequest, opts ...grpc.CallOption) (*GetMountPointResponse, error)
+	// Resize changes the size of an image. When the image is mounted on the host and grew, its
+	// partition and filesystem are grown as well; otherwise they are grown where it is mounted.
+	Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (*ResizeResponse, error)
+}
+
+type agentClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAgentClient(cc grpc.ClientConnInterface) AgentClient {
+	return &agentClient{cc}
+}
+
+func (c *agentClient) MapImage(ctx context.Context, in *MapImageRequest, opts ...grpc.CallOption) (*MapImageResponse, error) {
+	out := new(MapImageResponse)
+	err := c.cc.Invoke(ctx, Agent_MapImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) UnmapImage(ctx context.Context, in *UnmapImageRequest, opts ...grpc.CallOption) (*UnmapImageResponse, error) {
+	out := new(UnmapImageResponse)
+	err := c.cc.Invoke(ctx, Agent_UnmapImage_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Mount(ctx context.Context, in *MountRequest, opts ...grpc.CallOption) (*MountResponse, error) {
+	out := new(MountResponse)
+	err := c.cc.Invoke(ctx, Agent_Mount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Unmount(ctx context.Context, in *UnmountRequest, opts ...grpc.CallOption) (*UnmountResponse, error) {
+	out := new(UnmountResponse)
+	err := c.cc.Invoke(ctx, Agent_Unmount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) ListMapped(ctx context.Context, in *ListMappedRequest, opts ...grpc.CallOption) (*ListMappedResponse, error) {
+	out := new(ListMappedResponse)
+	err := c.cc.Invoke(ctx, Agent_ListMapped_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) GetMountPoint(ctx context.Context, in *GetMountPointRequest, opts ...grpc.CallOption) (*GetMountPointResponse, error) {
+	out := new(GetMountPointResponse)
+	err := c.cc.Invoke(ctx, Agent_GetMountPoint_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *agentClient) Resize(ctx context.Context, in *ResizeRequest, opts ...grpc.CallOption) (*ResizeResponse, error) {
+	out := new(ResizeResponse)
+	err := c.cc.Invoke(ctx, Agent_Resize_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AgentServer is the server API for Agent service.
+// All implementations must embed UnimplementedAgentServer
+// for forward compatibility
+type AgentServer interface {
+	// MapImage maps an image, or a snapshot read-only, unless it is mapped already.
+	MapImage(context.Context, *MapImageRequest) (*MapImageResponse, error)
+	// UnmapImage unmaps an image.
+	UnmapImage(context.Context, *UnmapImageRequest) (*UnmapImageResponse, error)
+	// Mount maps an image and mounts its filesystem, creating it on first use. A snapshot is
+	// mounted read-only and never formatted.
+	Mount(context.Context, *MountRequest) (*MountResponse, error)
+	// Unmount unmounts the filesystem of an image and unmaps it.
+	Unmount(context.Context, *UnmountRequest) (*UnmountResponse, error)
+	// ListMapped lists the images mapped to the host.
+	ListMapped(context.Context, *ListMappedRequest) (*ListMappedResponse, error)
+	// GetMountPoint returns where the filesystem of an image is mounted, or nothing.
+	GetMountPoint(context.Context, *GetMountPointRequest) (*GetMountPointResponse, error)
+	// Resize changes the size of an image. When the image is mounted on the host and grew, its
+	// partition and filesystem are grown as well; otherwise they are grown where it is mounted.
+	Resize(context.Context, *ResizeRequest) (*ResizeResponse, error)
+	mustEmbedUnimplementedAgentServer()
+}
+
+// UnimplementedAgentServer must be embedded to have forward compatible implementations.
+type UnimplementedAgentServer struct {
+}
+
+func (UnimplementedAgentServer) MapImage(context.Context, *MapImageRequest) (*MapImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MapImage not implemented")
+}
+func (UnimplementedAgentServer) UnmapImage(context.Context, *UnmapImageRequest) (*UnmapImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnmapImage not implemented")
+}
+func (UnimplementedAgentServer) Mount(context.Context, *MountRequest) (*MountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Mount not implemented")
+}
+func (UnimplementedAgentServer) Unmount(context.Context, *UnmountRequest) (*UnmountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unmount not implemented")
+}
+func (UnimplementedAgentServer) ListMapped(context.Context, *ListMappedRequest) (*ListMappedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMapped not implemented")
+}
+func (UnimplementedAgentServer) GetMountPoint(context.Context, *GetMountPointRequest) (*GetMountPointResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMountPoint not implemented")
+}
+func (UnimplementedAgentServer) Resize(context.Context, *ResizeRequest) (*ResizeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resize not implemented")
+}
+func (UnimplementedAgentServer) mustEmbedUnimplementedAgentServer() {}
+
+// UnsafeAgentServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AgentServer will
+// result in compilation errors.
+type UnsafeAgentServer interface {
+	mustEmbedUnimplementedAgentServer()
+}
+
+func RegisterAgentServer(s grpc.ServiceRegistrar, srv AgentServer) {
+	s.RegisterService(&Agent_ServiceDesc, srv)
+}
+
+func _Agent_MapImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MapImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).MapImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_MapImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).MapImage(ctx, req.(*MapImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_UnmapImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnmapImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).UnmapImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_UnmapImage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).UnmapImage(ctx, req.(*UnmapImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Mount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Mount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_Mount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Mount(ctx, req.(*MountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Unmount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnmountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Unmount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_Unmount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Unmount(ctx, req.(*UnmountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_ListMapped_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMappedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).ListMapped(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_ListMapped_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).ListMapped(ctx, req.(*ListMappedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_GetMountPoint_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMountPointRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).GetMountPoint(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_GetMountPoint_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).GetMountPoint(ctx, req.(*GetMountPointRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Agent_Resize_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResizeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServer).Resize(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Agent_Resize_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServer).Resize(ctx, req.(*ResizeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Agent_ServiceDesc is the grpc.ServiceDesc for Agent service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Agent_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scattered.storage.agent.v1.Agent",
+	HandlerType: (*AgentServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "MapImage",
+			Handler:    _Agent_MapImage_Handler,
+		},
+		{
+			MethodName: "UnmapImage",
+			Handler:    _Agent_UnmapImage_Handler,
+		},
+		{
+			MethodName: "Mount",
+			Handler:    _Agent_Mount_Handler,
+		},
+		{
+			MethodName: "Unmount",
+			Handler:    _Agent_Unmount_Handler,
+		},
+		{
+			MethodName: "ListMapped",
+			Handler:    _Agent_ListMapped_Handler,
+		},
+		{
+			MethodName: "GetMountPoint",
+			Handler:    _Agent_GetMountPoint_Handler,
+		},
+		{
+			MethodName: "Resize",
+			Handler:    _Agent_Resize_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "agent.proto",
+}
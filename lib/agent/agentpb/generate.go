@@ -0,0 +1,4 @@
+// Package agentpb holds the protocol of the node agent and the code generated from it.
+package agentpb
+
+//go:generate buf generate
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"github.com/scattered-network/scattered-storage/lib/agent/agentpb"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Controller keeps a connection to the agent of each host it drives. TLS is the client side of the
+// mutual TLS with the agents, see TLSFiles.ClientConfig. DialOptions are added to every connection;
+// tests use them to dial agents over bufconn. Calls carry the trace of their context to the agent.
+type Controller struct {
+	TLS         *tls.Config
+	DialOptions []grpc.DialOption
+
+	mutex       sync.Mutex
+	connections map[string]*grpc.ClientConn
+}
+
+// Agent returns a client for the agent listening on address, such as 'storage-1:7443'. The
+// connection is made once and shared by later calls.
+func (c *Controller) Agent(address string) (agentpb.AgentClient, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if connection, ok := c.connections[address]; ok {
+		return agentpb.NewAgentClient(connection), nil
+	}
+
+	options := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(c.TLS)),
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+	}, c.DialOptions...)
+
+	connection, err := grpc.Dial(address, options...)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: connecting to agent %s failed: %w", address, err)
+	}
+
+	if c.connections == nil {
+		c.connections = map[string]*grpc.ClientConn{}
+	}
+
+	c.connections[address] = connection
+
+	return agentpb.NewAgentClient(connection), nil
+}
+
+// Close closes the connections to all agents.
+func (c *Controller) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var firstError error
+
+	for address, connection := range c.connections {
+		if err := connection.Close(); err != nil && firstError == nil {
+			firstError = fmt.Errorf("ERROR: closing connection to agent %s failed: %w", address, err)
+		}
+
+		delete(c.connections, address)
+	}
+
+	return firstError
+}
@@ -0,0 +1,273 @@
+// Package agent runs the functions of lib/rbd that act on the local host, such as mapping and
+// mounting, as a gRPC service, so that a controller can drive the images of many hosts.
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/agent/agentpb"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements the Agent service with the RBD client of the host. Images are only mounted
+// below MountRoot; a Server without one refuses to mount. Reconfigure swaps the client of a running
+// Server, for example after the configuration file changed.
+type Server struct {
+	agentpb.UnimplementedAgentServer
+
+	RBD       *rbd.RadosBlockDeviceClient
+	MountRoot string
+
+	mutex sync.RWMutex
+}
+
+// Register adds the Agent service to a gRPC server.
+func (s *Server) Register(grpcServer *grpc.Server) {
+	agentpb.RegisterAgentServer(grpcServer, s)
+}
+
+// Reconfigure replaces the client used by later calls.
+func (s *Server) Reconfigure(client *rbd.RadosBlockDeviceClient) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.RBD = client
+}
+
+// client returns the client for a call, tracing its operations below the span of the call.
+func (s *Server) client(ctx context.Context) *rbd.RadosBlockDeviceClient {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	if s.RBD == nil {
+		return (&rbd.RadosBlockDeviceClient{}).WithContext(ctx)
+	}
+
+	return s.RBD.WithContext(ctx)
+}
+
+// MapImage maps an image, or a snapshot read-only, unless it is mapped already.
+func (s *Server) MapImage(ctx context.Context, request *agentpb.MapImageRequest) (*agentpb.MapImageResponse, error) {
+	spec := imageSpec(request.GetImage())
+
+	log.Trace().Str("Image", spec.String()).Msg("agent MapImage")
+
+	device, err := s.client(ctx).Map(spec)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.MapImageResponse{Device: device}, nil
+}
+
+// UnmapImage unmaps an image.
+func (s *Server) UnmapImage(
+	ctx context.Context, request *agentpb.UnmapImageRequest,
+) (*agentpb.UnmapImageResponse, error) {
+	spec := imageSpec(request.GetImage())
+
+	log.Trace().Str("Image", spec.String()).Msg("agent UnmapImage")
+
+	if err := s.client(ctx).Unmap(spec); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.UnmapImageResponse{}, nil
+}
+
+// Mount maps an image and mounts its filesystem, or mounts a snapshot read-only.
+func (s *Server) Mount(ctx context.Context, request *agentpb.MountRequest) (*agentpb.MountResponse, error) {
+	spec := imageSpec(request.GetImage())
+
+	log.Trace().Str("Image", spec.String()).Str("Path", request.GetPath()).Msg("agent Mount")
+
+	if err := validators.ValidateMountPathBelow(request.GetPath(), s.MountRoot); err != nil {
+		return nil, statusError(err)
+	}
+
+	requested := request.GetOptions()
+	options := &rbd.MountOptions{
+		Filesystem:  requested.GetFilesystem(),
+		ReadOnly:    requested.GetReadOnly(),
+		NoAtime:     requested.GetNoAtime(),
+		NoDiscard:   requested.GetNoDiscard(),
+		ForceFormat: requested.GetForceFormat(),
+		WholeDevice: requested.GetWholeDevice(),
+	}
+
+	var err error
+	if spec.Snapshot != "" {
+		err = s.client(ctx).MountSnapshot(spec, request.GetPath(), options)
+	} else {
+		err = s.client(ctx).Mount(spec, request.GetPath(), options)
+	}
+
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.MountResponse{}, nil
+}
+
+// Unmount unmounts the filesystem of an image.
+func (s *Server) Unmount(ctx context.Context, request *agentpb.UnmountRequest) (*agentpb.UnmountResponse, error) {
+	spec := imageSpec(request.GetImage())
+
+	log.Trace().Str("Image", spec.String()).Msg("agent Unmount")
+
+	if err := s.client(ctx).Unmount(spec); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.UnmountResponse{}, nil
+}
+
+// ListMapped lists the images mapped to the host with any backend.
+func (s *Server) ListMapped(ctx context.Context, _ *agentpb.ListMappedRequest) (*agentpb.ListMappedResponse, error) {
+	mapped, err := s.client(ctx).ListMappedImages()
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	response := &agentpb.ListMappedResponse{}
+
+	for _, image := range *mapped {
+		snapshot := image.Snap
+		if snapshot == "-" {
+			snapshot = ""
+		}
+
+		response.Images = append(response.Images, &agentpb.MappedImage{
+			Id: image.ID,
+			Image: &agentpb.ImageSpec{
+				Pool: image.Pool, Namespace: image.Namespace, Image: image.Name, Snapshot: snapshot,
+			},
+			Device:  image.Device,
+			Backend: string(image.Backend),
+		})
+	}
+
+	return response, nil
+}
+
+// GetMountPoint returns where the filesystem of an image is mounted, or nothing.
+func (s *Server) GetMountPoint(
+	ctx context.Context, request *agentpb.GetMountPointRequest,
+) (*agentpb.GetMountPointResponse, error) {
+	mountPoint, err := s.client(ctx).GetMountPoint(imageSpec(request.GetImage()))
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.GetMountPointResponse{MountPoint: mountPoint}, nil
+}
+
+// Resize changes the size of an image. An image that grew and is mounted on this host has its
+// partition and filesystem grown as well, the way NodeExpandVolume of the CSI driver does; the
+// response tells the controller whether that happened.
+func (s *Server) Resize(ctx context.Context, request *agentpb.ResizeRequest) (*agentpb.ResizeResponse, error) {
+	spec := imageSpec(request.GetImage())
+	client := s.client(ctx)
+
+	log.Trace().Str("Image", spec.String()).Uint64("Size", request.GetSizeBytes()).Msg("agent Resize")
+
+	image, err := client.GetImageInfo(spec)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	if err := client.ResizeRBD(spec, units.Size(request.GetSizeBytes()), request.GetAllowShrink()); err != nil {
+		return nil, statusError(err)
+	}
+
+	if request.GetSizeBytes() <= uint64(image.Size) {
+		return &agentpb.ResizeResponse{}, nil
+	}
+
+	// Filesystems of images mounted elsewhere, or encrypted, are left to the host mounting them.
+	err = client.ExpandFilesystem(spec)
+	if errors.Is(err, rbd.ErrNotMounted) || errors.Is(err, rbd.ErrImageEncrypted) {
+		return &agentpb.ResizeResponse{}, nil
+	} else if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &agentpb.ResizeResponse{FilesystemExpanded: true}, nil
+}
+
+// imageSpec converts the spec of a request. A missing spec fails validation in lib/rbd.
+func imageSpec(spec *agentpb.ImageSpec) rbd.ImageSpec {
+	return rbd.ImageSpec{
+		Pool:      spec.GetPool(),
+		Namespace: spec.GetNamespace(),
+		Image:     spec.GetImage(),
+		Snapshot:  spec.GetSnapshot(),
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	// invalidArgumentErrors are caused by the request and not by the state of the host.
+	invalidArgumentErrors = []error{
+		validators.ErrInvalidPoolName,
+		validators.ErrInvalidNamespace,
+		validators.ErrInvalidRBDName,
+		validators.ErrInvalidSnapshotName,
+		validators.ErrInvalidImageSpec,
+		validators.ErrSnapshotNotSupported,
+		validators.ErrInvalidSize,
+		validators.ErrInvalidMountOptions,
+		validators.ErrInvalidMountPath,
+		validators.ErrInvalidMakeOptions,
+		rbd.ErrSnapshotRequired,
+	}
+
+	// failedPreconditionErrors conflict with the state of an image or the host.
+	failedPreconditionErrors = []error{
+		rbd.ErrDeviceNotEmpty,
+		rbd.ErrNotMounted,
+		rbd.ErrFilesystemMounted,
+		rbd.ErrImageEncrypted,
+		rbd.ErrMountFailed,
+	}
+)
+
+// statusError converts an error of lib/rbd to a gRPC status. Errors of the rbd command are mapped
+// by their errno.
+func statusError(err error) error {
+	code := codes.Internal
+
+	for _, target := range invalidArgumentErrors {
+		if errors.Is(err, target) {
+			code = codes.InvalidArgument
+		}
+	}
+
+	for _, target := range failedPreconditionErrors {
+		if errors.Is(err, target) {
+			code = codes.FailedPrecondition
+		}
+	}
+
+	if code == codes.Internal {
+		switch helpers.ExitCode(err) {
+		case helpers.ExitNotFound:
+			code = codes.NotFound
+		case helpers.ExitExists:
+			code = codes.AlreadyExists
+		case helpers.ExitBusy:
+			code = codes.FailedPrecondition
+		}
+	}
+
+	return status.Error(code, err.Error()) //nolint:wrapcheck
+}
@@ -0,0 +1,352 @@
+package agent
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/agent/agentpb"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	agentAddress = "storage-1.test"
+	showMapped   = `[{"id":"0","pool":"rbd","namespace":"","name":"volume-1","snap":"-","device":"/dev/rbd0"}]`
+	lsblkMounted = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","fstype":null,"mountpoint":null,"type":"disk",` +
+		`"children":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"xfs","mountpoint":"/srv/volume-1","type":"part"}]}]}`
+)
+
+// testPKI holds the TLS files of an agent and a controller signed by one CA, and of a controller
+// signed by another CA.
+type testPKI struct {
+	agent, controller, stranger *TLSFiles
+}
+
+// newTestPKI writes the certificates and keys of a test PKI to a temporary directory.
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	directory := t.TempDir()
+	caCertificate, caKey := newCertificate(t, directory, "ca", nil, nil)
+	strangerCertificate, strangerKey := newCertificate(t, directory, "stranger-ca", nil, nil)
+
+	newCertificate(t, directory, "agent", caCertificate, caKey)
+	newCertificate(t, directory, "controller", caCertificate, caKey)
+	newCertificate(t, directory, "stranger", strangerCertificate, strangerKey)
+
+	files := func(name, ca string) *TLSFiles {
+		return &TLSFiles{
+			Certificate: filepath.Join(directory, name+".crt"),
+			Key:         filepath.Join(directory, name+".key"),
+			CA:          filepath.Join(directory, ca+".crt"),
+		}
+	}
+
+	return &testPKI{
+		agent:      files("agent", "ca"),
+		controller: files("controller", "ca"),
+		stranger:   files("stranger", "ca"),
+	}
+}
+
+// newCertificate writes name.crt and name.key, signed by the parent or self-signed as a CA.
+func newCertificate(
+	t *testing.T, directory, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey,
+) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{agentAddress},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePEM(t, filepath.Join(directory, name+".crt"), "CERTIFICATE", der)
+	writePEM(t, filepath.Join(directory, name+".key"), "EC PRIVATE KEY", keyDER)
+
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate, key
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startAgent serves an agent using runner over bufconn and returns a controller dialing it with the
+// TLS files of the controller.
+func startAgent(t *testing.T, runner *helperstest.Runner, controllerFiles *TLSFiles, agentFiles *TLSFiles) *Controller {
+	t.Helper()
+
+	serverTLS, err := agentFiles.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(serverTLS)))
+	(&Server{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, MountRoot: "/srv"}).Register(grpcServer)
+
+	go func() { _ = grpcServer.Serve(listener) }()
+
+	clientTLS, err := controllerFiles.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	controller := &Controller{
+		TLS: clientTLS,
+		DialOptions: []grpc.DialOption{grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		})},
+	}
+
+	t.Cleanup(func() {
+		_ = controller.Close()
+		grpcServer.Stop()
+	})
+
+	return controller
+}
+
+// TestAgent tests each call of the agent over mutual TLS.
+func TestAgent(t *testing.T) {
+	pki := newTestPKI(t)
+	spec := &agentpb.ImageSpec{Pool: "rbd", Image: "volume-1"}
+	imageNotFound := &helpers.CommandError{Command: "rbd info", ExitStatus: 2}
+
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context, client agentpb.AgentClient) (interface{}, error)
+		replies  map[string]string
+		errors   map[string]error
+		wantCode codes.Code
+		want     string
+		wantCall string
+	}{
+		{
+			name: "TestMapImage",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				response, err := client.MapImage(ctx, &agentpb.MapImageRequest{Image: spec})
+
+				return response.GetDevice(), err
+			},
+			replies: map[string]string{"rbd showmapped --format json": showMapped, "rbd-nbd list-mapped --format json": "[]"},
+			want:    "/dev/rbd0",
+		},
+		{
+			name: "TestListMapped",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				response, err := client.ListMapped(ctx, &agentpb.ListMappedRequest{})
+				if err != nil {
+					return nil, err
+				}
+
+				image := response.GetImages()[0]
+
+				return image.GetImage().GetImage() + "@" + image.GetImage().GetSnapshot() + " " + image.GetBackend(), nil
+			},
+			replies: map[string]string{"rbd showmapped --format json": showMapped, "rbd-nbd list-mapped --format json": "[]"},
+			want:    "volume-1@ krbd",
+		},
+		{
+			name: "TestGetMountPoint",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				response, err := client.GetMountPoint(ctx, &agentpb.GetMountPointRequest{Image: spec})
+
+				return response.GetMountPoint(), err
+			},
+			replies: map[string]string{
+				"rbd showmapped --format json":                           showMapped,
+				"rbd-nbd list-mapped --format json":                      "[]",
+				"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+			},
+			want: "/srv/volume-1",
+		},
+		{
+			name: "TestMountRelativePath",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				return client.Mount(ctx, &agentpb.MountRequest{Image: spec, Path: "srv/volume-1"})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestMountOutsideRoot",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				return client.Mount(ctx, &agentpb.MountRequest{Image: spec, Path: "/etc/volume-1"})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestMapWithoutImage",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				return client.MapImage(ctx, &agentpb.MapImageRequest{})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestResize",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				return client.Resize(ctx, &agentpb.ResizeRequest{Image: spec, SizeBytes: 20 << 30})
+			},
+			replies:  map[string]string{"rbd info rbd/volume-1 --format json": `{"name":"volume-1","object_size":4194304}`},
+			wantCall: "rbd resize --size 21474836480B rbd/volume-1",
+		},
+		{
+			name: "TestResizeMounted",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				response, err := client.Resize(ctx, &agentpb.ResizeRequest{Image: spec, SizeBytes: 20 << 30})
+
+				return fmt.Sprint(response.GetFilesystemExpanded()), err
+			},
+			replies: map[string]string{
+				"rbd info rbd/volume-1 --format json":                    `{"name":"volume-1","size":10737418240,"object_size":4194304}`,
+				"rbd showmapped --format json":                           showMapped,
+				"rbd-nbd list-mapped --format json":                      "[]",
+				"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+				"blkid -o export /dev/rbd0p1":                            "DEVNAME=/dev/rbd0p1\nTYPE=xfs\n",
+			},
+			want:     "true",
+			wantCall: "xfs_growfs /srv/volume-1",
+		},
+		{
+			name: "TestResizeNotMounted",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				response, err := client.Resize(ctx, &agentpb.ResizeRequest{Image: spec, SizeBytes: 20 << 30})
+
+				return fmt.Sprint(response.GetFilesystemExpanded()), err
+			},
+			replies: map[string]string{
+				"rbd info rbd/volume-1 --format json": `{"name":"volume-1","size":10737418240,"object_size":4194304}`,
+				"rbd showmapped --format json":        "[]",
+				"rbd-nbd list-mapped --format json":   "[]",
+			},
+			want: "false",
+		},
+		{
+			name: "TestResizeMissing",
+			call: func(ctx context.Context, client agentpb.AgentClient) (interface{}, error) {
+				return client.Resize(ctx, &agentpb.ResizeRequest{Image: spec, SizeBytes: 20 << 30})
+			},
+			errors:   map[string]error{"rbd info rbd/volume-1 --format json": imageNotFound},
+			wantCode: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{Replies: tt.replies, Errors: tt.errors}
+			controller := startAgent(t, runner, pki.controller, pki.agent)
+
+			client, err := controller.Agent(agentAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			got, err := tt.call(ctx, client)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v: %v", code, tt.wantCode, err)
+			}
+
+			if tt.want != "" && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			if tt.wantCall != "" && !runner.Called(tt.wantCall) {
+				t.Errorf("ran %q, want %q", runner.Calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+// TestAgentRejectsStrangers tests that agents refuse controllers without a certificate of their CA.
+func TestAgentRejectsStrangers(t *testing.T) {
+	pki := newTestPKI(t)
+
+	strangerTLS, err := pki.stranger.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withoutCertificate := strangerTLS.Clone()
+	withoutCertificate.Certificates = nil
+
+	for name, clientTLS := range map[string]*tls.Config{"TestOtherCA": strangerTLS, "TestNoCertificate": withoutCertificate} {
+		t.Run(name, func(t *testing.T) {
+			controller := startAgent(t, &helperstest.Runner{}, pki.controller, pki.agent)
+			controller.TLS = clientTLS
+
+			client, err := controller.Agent(agentAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			if _, err := client.ListMapped(ctx, &agentpb.ListMappedRequest{}); status.Code(err) != codes.Unavailable {
+				t.Errorf("ListMapped() error = %v, want %v", err, codes.Unavailable)
+			}
+		})
+	}
+}
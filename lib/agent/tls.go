@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var ErrInvalidCA = errors.New("no certificate found in the CA file")
+
+// TLSFiles are the PEM files of one side of the mutual TLS between a controller and its agents:
+// its own certificate and key, and the CA that signed the certificates of the other side.
+type TLSFiles struct {
+	Certificate string
+	Key         string
+	CA          string
+}
+
+// ServerConfig returns the TLS configuration of an agent, which only accepts controllers
+// presenting a certificate signed by the CA.
+func (f *TLSFiles) ServerConfig() (*tls.Config, error) {
+	certificate, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{ //nolint:exhaustruct
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ClientConfig returns the TLS configuration of a controller, which only trusts agents presenting a
+// certificate signed by the CA.
+func (f *TLSFiles) ClientConfig() (*tls.Config, error) {
+	certificate, pool, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{ //nolint:exhaustruct
+		Certificates: []tls.Certificate{certificate},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// load reads the key pair and the CA.
+func (f *TLSFiles) load() (tls.Certificate, *x509.CertPool, error) {
+	certificate, err := tls.LoadX509KeyPair(f.Certificate, f.Key)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("ERROR: loading %s failed: %w", f.Certificate, err)
+	}
+
+	content, err := os.ReadFile(f.CA)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("ERROR: loading %s failed: %w", f.CA, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return tls.Certificate{}, nil, fmt.Errorf("%w: %s", ErrInvalidCA, f.CA)
+	}
+
+	return certificate, pool, nil
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+var (
+	ErrUnauthorized     = errors.New("a valid bearer token is required")
+	ErrNotFound         = errors.New("no such API path")
+	ErrMethodNotAllowed = errors.New("method not allowed on this API path")
+	ErrInvalidRequest   = errors.New("invalid request body")
+	ErrPoolsUnavailable = errors.New("rbd pools could not be listed")
+)
+
+// errorResponse is the body of every error response.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+//nolint:gochecknoglobals
+var (
+	// badRequestErrors are caused by the request and not by the state of the cluster.
+	badRequestErrors = []error{
+		ErrInvalidRequest,
+		validators.ErrInvalidPoolName,
+		validators.ErrInvalidNamespace,
+		validators.ErrInvalidRBDName,
+		validators.ErrInvalidSnapshotName,
+		validators.ErrInvalidImageSpec,
+		validators.ErrSnapshotNotSupported,
+		validators.ErrInvalidSize,
+		validators.ErrInvalidMapBackend,
+		validators.ErrInvalidMountOptions,
+		validators.ErrInvalidMountPath,
+		validators.ErrInvalidMakeOptions,
+		validators.ErrInvalidSELinuxContext,
+		rbd.ErrSnapshotRequired,
+	}
+
+	// conflictErrors conflict with the current state of an image.
+	conflictErrors = []error{
+		validators.ErrRBDExists,
+		validators.ErrSnapshotExists,
+		rbd.ErrDeviceNotEmpty,
+		rbd.ErrNotMounted,
+		rbd.ErrFilesystemMounted,
+		rbd.ErrImageEncrypted,
+	}
+)
+
+// statusCode maps an error to the HTTP status of its response. Errors of the rbd command are mapped
+// by their errno: a missing image is 404 and an existing or busy one 409.
+func statusCode(err error) int {
+	for _, target := range badRequestErrors {
+		if errors.Is(err, target) {
+			return http.StatusBadRequest
+		}
+	}
+
+	for _, target := range conflictErrors {
+		if errors.Is(err, target) {
+			return http.StatusConflict
+		}
+	}
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, ErrPoolsUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	}
+
+	switch helpers.ExitCode(err) {
+	case helpers.ExitNotFound:
+		return http.StatusNotFound
+	case helpers.ExitBusy, helpers.ExitExists:
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
+
+// writeError writes an error response with the status of the error.
+func writeError(writer http.ResponseWriter, status int, err error) {
+	writeJSON(writer, status, &errorResponse{Error: err.Error()})
+}
+
+// writeFailure writes the response of a failed operation.
+func writeFailure(writer http.ResponseWriter, err error) {
+	writeError(writer, statusCode(err), err)
+}
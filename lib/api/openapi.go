@@ -0,0 +1,25 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// specificationPath serves the OpenAPI specification, which needs no token.
+const specificationPath = "/v1/openapi.yaml"
+
+// Specification is the OpenAPI 3 specification of the API.
+//
+//go:embed openapi.yaml
+var Specification []byte
+
+func serveSpecification(writer http.ResponseWriter) {
+	writer.Header().Set("Content-Type", "application/yaml")
+	writer.WriteHeader(http.StatusOK)
+
+	if _, err := writer.Write(Specification); err != nil {
+		log.Error().Err(err).Msg("API specification could not be written")
+	}
+}
@@ -0,0 +1,488 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+const imagePath = "/v1/pools/{pool}/images/{image}"
+
+// route is an API operation: a method and a path pattern whose '{name}' segments are parameters.
+type route struct {
+	method  string
+	pattern string
+	handle  func(writer http.ResponseWriter, request *http.Request, params map[string]string)
+}
+
+type router []route
+
+// routes returns the operations of the API, matching the paths of the OpenAPI specification.
+func (s *Server) routes() router {
+	return router{
+		{http.MethodGet, "/v1/pools", s.listPools},
+		{http.MethodGet, "/v1/pools/{pool}/images", s.listImages},
+		{http.MethodPost, "/v1/pools/{pool}/images", s.createImage},
+		{http.MethodGet, imagePath, s.getImage},
+		{http.MethodDelete, imagePath, s.deleteImage},
+		{http.MethodPost, imagePath + "/resize", s.resizeImage},
+		{http.MethodGet, imagePath + "/snapshots", s.listSnapshots},
+		{http.MethodPost, imagePath + "/snapshots", s.createSnapshot},
+		{http.MethodDelete, imagePath + "/snapshots/{snapshot}", s.deleteSnapshot},
+		{http.MethodPost, imagePath + "/map", s.mapImage},
+		{http.MethodPost, imagePath + "/unmap", s.unmapImage},
+		{http.MethodPost, imagePath + "/mount", s.mountImage},
+		{http.MethodPost, imagePath + "/unmount", s.unmountImage},
+		{http.MethodGet, "/v1/mappings", s.listMappings},
+	}
+}
+
+// serve runs the operation matching the request. A path that matches with another method is
+// answered with 405 and the allowed methods.
+func (r router) serve(writer http.ResponseWriter, request *http.Request) {
+	var allowed []string
+
+	for _, route := range r {
+		params, ok := match(route.pattern, request.URL.Path)
+		if !ok {
+			continue
+		}
+
+		if route.method == request.Method {
+			route.handle(writer, request, params)
+
+			return
+		}
+
+		allowed = append(allowed, route.method)
+	}
+
+	if len(allowed) > 0 {
+		sort.Strings(allowed)
+		writer.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("%w: %s", ErrMethodNotAllowed, request.Method))
+
+		return
+	}
+
+	writeError(writer, http.StatusNotFound, fmt.Errorf("%w: %s", ErrNotFound, request.URL.Path))
+}
+
+// match compares a path with a pattern segment by segment and returns the parameters.
+func match(pattern, path string) (map[string]string, bool) {
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+
+	for index, segment := range patternSegments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			if pathSegments[index] == "" {
+				return nil, false
+			}
+
+			params[strings.Trim(segment, "{}")] = pathSegments[index]
+		} else if segment != pathSegments[index] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// imageSpec returns the validated spec of the image of a request. The namespace is given with the
+// namespace query parameter.
+func imageSpec(request *http.Request, params map[string]string) (rbd.ImageSpec, error) {
+	spec := rbd.ImageSpec{
+		Pool:      params["pool"],
+		Namespace: request.URL.Query().Get("namespace"),
+		Image:     params["image"],
+		Snapshot:  params["snapshot"],
+	}
+
+	return spec, spec.Validate()
+}
+
+// decode reads the JSON body of a request, rejecting unknown fields.
+func decode(request *http.Request, body interface{}) error {
+	return decodeBody(request, body, false)
+}
+
+// decodeBody reads the JSON body of a request. An optional body may be empty.
+func decodeBody(request *http.Request, body interface{}, optional bool) error {
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(body); err != nil && !(optional && errors.Is(err, io.EOF)) {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	return nil
+}
+
+func (s *Server) listPools(writer http.ResponseWriter, request *http.Request, _ map[string]string) {
+	_, cephClient := s.clients(request)
+
+	pools := cephClient.GetRBDPools()
+	if pools == nil {
+		writeFailure(writer, ErrPoolsUnavailable)
+
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, pools)
+}
+
+func (s *Server) listImages(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	pool, namespace := params["pool"], request.URL.Query().Get("namespace")
+
+	if err := validators.ValidatePoolName(pool); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	if err := validators.ValidateNamespaceName(namespace); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	rbdClient, _ := s.clients(request)
+
+	images, err := rbdClient.GetRBDList(pool, namespace)
+	if err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	if images == nil {
+		images = []string{}
+	}
+
+	writeJSON(writer, http.StatusOK, images)
+}
+
+// CreateImageRequest
+/*
+POST /v1/pools/rbd/images?namespace=tenant1
+{"name": "volume-1", "size": "10G"}
+CreateImageRequest is used to create an image, whose size is parsed by units.ParseSize. */
+type CreateImageRequest struct {
+	Name string `json:"name"`
+	Size string `json:"size"`
+}
+
+func (s *Server) createImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var body CreateImageRequest
+	if err := decode(request, &body); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	params["image"] = body.Name
+
+	spec, err := imageSpec(request, params)
+	if err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	size, err := units.ParseSize(body.Size)
+	if err != nil {
+		writeFailure(writer, fmt.Errorf("%w: %v", validators.ErrInvalidSize, err))
+
+		return
+	}
+
+	rbdClient, _ := s.clients(request)
+
+	if err := rbdClient.CreateRBD(spec, size); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	writeJSON(writer, http.StatusCreated, spec)
+}
+
+func (s *Server) getImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		info, err := client.GetImageInfo(spec)
+		if err != nil {
+			return err
+		}
+
+		writeJSON(writer, http.StatusOK, info)
+
+		return nil
+	})
+}
+
+func (s *Server) deleteImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return noContent(writer, client.DeleteRBD(spec))
+	})
+}
+
+// ResizeImageRequest
+/*
+POST /v1/pools/rbd/images/volume-1/resize
+{"size": "20G", "allowShrink": false}
+ResizeImageRequest is used to grow an image, or to shrink it when AllowShrink is set. */
+type ResizeImageRequest struct {
+	Size        string `json:"size"`
+	AllowShrink bool   `json:"allowShrink"`
+}
+
+func (s *Server) resizeImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var body ResizeImageRequest
+	if err := decode(request, &body); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	size, err := units.ParseSize(body.Size)
+	if err != nil {
+		writeFailure(writer, fmt.Errorf("%w: %v", validators.ErrInvalidSize, err))
+
+		return
+	}
+
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return noContent(writer, client.ResizeRBD(spec, size, body.AllowShrink))
+	})
+}
+
+func (s *Server) listSnapshots(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		snapshots, err := client.ListSnapshots(spec)
+		if err != nil {
+			return err
+		}
+
+		writeJSON(writer, http.StatusOK, snapshots)
+
+		return nil
+	})
+}
+
+// CreateSnapshotRequest
+/*
+POST /v1/pools/rbd/images/volume-1/snapshots
+{"name": "daily"}
+CreateSnapshotRequest is used to take a snapshot of an image. */
+type CreateSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) createSnapshot(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var body CreateSnapshotRequest
+	if err := decode(request, &body); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	if body.Name == "" {
+		writeFailure(writer, fmt.Errorf("%w: the snapshot name is required", validators.ErrInvalidSnapshotName))
+
+		return
+	}
+
+	params["snapshot"] = body.Name
+
+	s.withSnapshot(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		if err := client.CreateSnapshot(spec); err != nil {
+			return err
+		}
+
+		writeJSON(writer, http.StatusCreated, spec)
+
+		return nil
+	})
+}
+
+func (s *Server) deleteSnapshot(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withSnapshot(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return noContent(writer, client.RemoveSnapshot(spec))
+	})
+}
+
+// MapRequest
+/*
+POST /v1/pools/rbd/images/volume-1/map
+{"snapshot": "daily"}
+MapRequest is used to map an image, or one of its snapshots, to the host. The body is optional. */
+type MapRequest struct {
+	Snapshot string `json:"snapshot"`
+}
+
+// MapResponse
+/*
+{"pool": "rbd", "namespace": "", "image": "volume-1", "snapshot": "", "device": "/dev/rbd0"}
+MapResponse is used to report the device an image was mapped to. */
+type MapResponse struct {
+	rbd.ImageSpec
+	Device string `json:"device"`
+}
+
+func (s *Server) mapImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var body MapRequest
+	if err := decodeBody(request, &body, true); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	params["snapshot"] = body.Snapshot
+
+	s.withSnapshot(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		device, err := client.Map(spec)
+		if err != nil {
+			return err
+		}
+
+		writeJSON(writer, http.StatusOK, &MapResponse{ImageSpec: spec, Device: device})
+
+		return nil
+	})
+}
+
+func (s *Server) unmapImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return noContent(writer, client.Unmap(spec))
+	})
+}
+
+// MountRequest
+/*
+POST /v1/pools/rbd/images/volume-1/mount
+{"path": "/srv/volume-1", "filesystem": "xfs", "noAtime": true}
+MountRequest is used to map an image and mount its filesystem, creating it on first use. A
+snapshot is mounted read-only and never formatted. */
+type MountRequest struct {
+	Path        string `json:"path"`
+	Snapshot    string `json:"snapshot"`
+	Filesystem  string `json:"filesystem"`
+	ReadOnly    bool   `json:"readOnly"`
+	NoAtime     bool   `json:"noAtime"`
+	NoDiscard   bool   `json:"noDiscard"`
+	ForceFormat bool   `json:"forceFormat"`
+	WholeDevice bool   `json:"wholeDevice"`
+}
+
+func (s *Server) mountImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	var body MountRequest
+	if err := decode(request, &body); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	if err := validators.ValidateMountPathBelow(body.Path, s.MountRoot); err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	if body.Filesystem != "" && body.Filesystem != rbd.TagXfs && body.Filesystem != rbd.TagExt4 {
+		writeFailure(writer, fmt.Errorf("%w: filesystem %q is not xfs or ext4", validators.ErrInvalidMountOptions,
+			body.Filesystem))
+
+		return
+	}
+
+	params["snapshot"] = body.Snapshot
+	options := &rbd.MountOptions{
+		Filesystem:  body.Filesystem,
+		ReadOnly:    body.ReadOnly,
+		NoAtime:     body.NoAtime,
+		NoDiscard:   body.NoDiscard,
+		ForceFormat: body.ForceFormat,
+		WholeDevice: body.WholeDevice,
+	}
+
+	s.withSnapshot(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		if spec.Snapshot != "" {
+			return noContent(writer, client.MountSnapshot(spec, body.Path, options))
+		}
+
+		return noContent(writer, client.Mount(spec, body.Path, options))
+	})
+}
+
+func (s *Server) unmountImage(writer http.ResponseWriter, request *http.Request, params map[string]string) {
+	s.withImage(writer, request, params, func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error {
+		return noContent(writer, client.Unmount(spec))
+	})
+}
+
+func (s *Server) listMappings(writer http.ResponseWriter, request *http.Request, _ map[string]string) {
+	rbdClient, _ := s.clients(request)
+
+	mapped, err := rbdClient.ListMappedImages()
+	if err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, mapped)
+}
+
+// withImage validates the image of a request, which must not name a snapshot, and runs an
+// operation on it. An error of the operation is written as the response.
+func (s *Server) withImage(
+	writer http.ResponseWriter, request *http.Request, params map[string]string,
+	operation func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error,
+) {
+	delete(params, "snapshot")
+	s.withSnapshot(writer, request, params, operation)
+}
+
+// withSnapshot validates the image, or snapshot, of a request and runs an operation on it.
+func (s *Server) withSnapshot(
+	writer http.ResponseWriter, request *http.Request, params map[string]string,
+	operation func(client *rbd.RadosBlockDeviceClient, spec rbd.ImageSpec) error,
+) {
+	spec, err := imageSpec(request, params)
+	if err != nil {
+		writeFailure(writer, err)
+
+		return
+	}
+
+	rbdClient, _ := s.clients(request)
+
+	if err := operation(rbdClient, spec); err != nil {
+		writeFailure(writer, err)
+	}
+}
+
+// noContent answers a successful operation without a body.
+func noContent(writer http.ResponseWriter, err error) error {
+	if err != nil {
+		return err
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
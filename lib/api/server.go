@@ -0,0 +1,131 @@
+// Package api serves a JSON management API over HTTP for the pools, images, snapshots, mappings
+// and mounts of the host it runs on.
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// maxRequestBody limits the size of request bodies, which are small JSON documents.
+const maxRequestBody = 1 << 20
+
+// Server answers API requests using the RBD and Ceph clients. Every request but the OpenAPI
+// specification requires one of Tokens as a bearer token; a Server without tokens rejects all of
+// them. Images are only mounted below MountRoot; a Server without one refuses to mount.
+// Reconfigure swaps the clients of a running Server, for example after the configuration file
+// changed.
+type Server struct {
+	RBD       *rbd.RadosBlockDeviceClient
+	Ceph      *ceph.CephCLI
+	Tokens    []string
+	MountRoot string
+
+	mutex sync.RWMutex
+}
+
+// Reconfigure replaces the clients used by later requests.
+func (s *Server) Reconfigure(rbdClient *rbd.RadosBlockDeviceClient, cephClient *ceph.CephCLI) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.RBD = rbdClient
+	s.Ceph = cephClient
+}
+
+// clients returns the clients for a request, tracing their operations below the span of the request.
+func (s *Server) clients(request *http.Request) (*rbd.RadosBlockDeviceClient, *ceph.CephCLI) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	rbdClient, cephClient := s.RBD, s.Ceph
+	if rbdClient == nil {
+		rbdClient = &rbd.RadosBlockDeviceClient{}
+	}
+
+	if cephClient == nil {
+		cephClient = &ceph.CephCLI{}
+	}
+
+	return rbdClient.WithContext(request.Context()), cephClient.WithContext(request.Context())
+}
+
+// Handler returns the handler serving the API below /v1. Each request is traced in a span that
+// continues the trace of its traceparent header, if any.
+func (s *Server) Handler() http.Handler {
+	routes := s.routes()
+
+	handler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: writer, status: http.StatusOK}
+
+		if request.URL.Path == specificationPath && request.Method == http.MethodGet {
+			serveSpecification(recorder)
+		} else if !s.authorized(request) {
+			recorder.Header().Set("WWW-Authenticate", `Bearer realm="scattered-storage"`)
+			writeError(recorder, http.StatusUnauthorized, ErrUnauthorized)
+		} else {
+			request.Body = http.MaxBytesReader(recorder, request.Body, maxRequestBody)
+			routes.serve(recorder, request)
+		}
+
+		log.Debug().Str("Method", request.Method).Str("Path", request.URL.Path).Int("Status", recorder.status).
+			Dur("Duration", time.Since(start)).Msg("API request")
+	})
+
+	return otelhttp.NewHandler(handler, "api", otelhttp.WithSpanNameFormatter(
+		func(_ string, request *http.Request) string { return request.Method + " " + request.URL.Path },
+	))
+}
+
+// authorized reports whether the request carries one of the tokens of the Server.
+func (s *Server) authorized(request *http.Request) bool {
+	header := request.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return false
+	}
+
+	token := []byte(strings.TrimSpace(header[len("Bearer "):]))
+	authorized := false
+
+	for _, candidate := range s.Tokens {
+		if candidate != "" && subtle.ConstantTimeCompare(token, []byte(candidate)) == 1 {
+			authorized = true
+		}
+	}
+
+	return authorized
+}
+
+// statusRecorder remembers the status of a response for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// writeJSON writes a result as the JSON body of a response.
+func writeJSON(writer http.ResponseWriter, status int, result interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+
+	if err := encoder.Encode(result); err != nil {
+		log.Error().Err(err).Msg("API response could not be written")
+	}
+}
@@ -0,0 +1,275 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gopkg.in/yaml.v3"
+)
+
+const testToken = "s3cr3t"
+
+// newTestServer returns a server running commands through runner.
+func newTestServer(runner *helperstest.Runner) *Server {
+	return &Server{
+		RBD:       &rbd.RadosBlockDeviceClient{Runner: runner},
+		Ceph:      &ceph.CephCLI{Runner: runner},
+		Tokens:    []string{"other", testToken},
+		MountRoot: "/srv",
+	}
+}
+
+// TestHandler tests the status, body and commands of API requests.
+func TestHandler(t *testing.T) {
+	imageNotFound := &helpers.CommandError{Command: "rbd info", ExitStatus: 2, Stderr: "No such file or directory"}
+	imageExists := &helpers.CommandError{Command: "rbd create", ExitStatus: 17}
+	createCommand := "rbd create --image-feature layering --image-feature striping --image-feature exclusive-lock " +
+		"--image-feature object-map --image-feature fast-diff --size 10737418240B rbd/tenant1/volume-1"
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		token      string
+		replies    map[string]string
+		errors     map[string]error
+		wantStatus int
+		wantBody   string
+		wantCall   string
+	}{
+		{
+			name: "TestMissingToken", method: http.MethodGet, path: "/v1/pools", token: "-",
+			wantStatus: http.StatusUnauthorized, wantBody: `"error": "a valid bearer token is required"`,
+		},
+		{
+			name: "TestWrongToken", method: http.MethodGet, path: "/v1/pools", token: "s3cr3",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "TestPools", method: http.MethodGet, path: "/v1/pools",
+			replies: map[string]string{
+				"ceph osd pool ls --format json":                          `["rbd","cephfs_data"]`,
+				"ceph osd pool application get rbd --format json":         `{"rbd":{}}`,
+				"ceph osd pool application get cephfs_data --format json": `{"cephfs":{}}`,
+			},
+			wantStatus: http.StatusOK, wantBody: "[\n  \"rbd\"\n]",
+		},
+		{
+			name: "TestImages", method: http.MethodGet, path: "/v1/pools/rbd/images?namespace=tenant1",
+			replies:    map[string]string{"rbd --pool rbd --namespace tenant1 list --format json": `["volume-1"]`},
+			wantStatus: http.StatusOK, wantBody: `"volume-1"`,
+		},
+		{
+			name: "TestCreateImage", method: http.MethodPost, path: "/v1/pools/rbd/images?namespace=tenant1",
+			body:       `{"name":"volume-1","size":"10G"}`,
+			wantStatus: http.StatusCreated, wantBody: `"namespace": "tenant1"`, wantCall: createCommand,
+		},
+		{
+			name: "TestCreateExisting", method: http.MethodPost, path: "/v1/pools/rbd/images?namespace=tenant1",
+			body: `{"name":"volume-1","size":"10G"}`, errors: map[string]error{createCommand: imageExists},
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name: "TestCreateInvalidName", method: http.MethodPost, path: "/v1/pools/rbd/images",
+			body: `{"name":"volume 1","size":"10G"}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestCreateInvalidSize", method: http.MethodPost, path: "/v1/pools/rbd/images",
+			body: `{"name":"volume-1","size":"ten"}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestCreateUnknownField", method: http.MethodPost, path: "/v1/pools/rbd/images",
+			body: `{"name":"volume-1","size":"10G","features":[]}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestImageNotFound", method: http.MethodGet, path: "/v1/pools/rbd/images/missing",
+			errors:     map[string]error{"rbd info rbd/missing --format json": imageNotFound},
+			wantStatus: http.StatusNotFound, wantBody: "No such file or directory",
+		},
+		{
+			name: "TestDeleteImage", method: http.MethodDelete, path: "/v1/pools/rbd/images/volume-1",
+			wantStatus: http.StatusNoContent, wantCall: "rbd rm rbd/volume-1",
+		},
+		{
+			name: "TestSnapshots", method: http.MethodGet, path: "/v1/pools/rbd/images/volume-1/snapshots",
+			replies: map[string]string{
+				"rbd snap ls rbd/volume-1 --format json": `[{"id":4,"name":"daily","size":10737418240,"protected":"false"}]`,
+			},
+			wantStatus: http.StatusOK, wantBody: `"name": "daily"`,
+		},
+		{
+			name: "TestCreateSnapshot", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/snapshots",
+			body: `{"name":"daily"}`, wantStatus: http.StatusCreated, wantCall: "rbd snap create rbd/volume-1@daily",
+		},
+		{
+			name: "TestCreateSnapshotWithoutName", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/snapshots",
+			body: `{}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestDeleteSnapshot", method: http.MethodDelete, path: "/v1/pools/rbd/images/volume-1/snapshots/daily",
+			wantStatus: http.StatusNoContent, wantCall: "rbd snap rm rbd/volume-1@daily",
+		},
+		{
+			name: "TestMapMapped", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/map",
+			replies: map[string]string{
+				"rbd showmapped --format json":      `[{"id":"0","pool":"rbd","namespace":"","name":"volume-1","snap":"-","device":"/dev/rbd0"}]`,
+				"rbd-nbd list-mapped --format json": "[]",
+			},
+			wantStatus: http.StatusOK, wantBody: `"device": "/dev/rbd0"`,
+		},
+		{
+			name: "TestMountRelativePath", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/mount",
+			body: `{"path":"srv/volume-1"}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestMountOutsideRoot", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/mount",
+			body: `{"path":"/etc/volume-1"}`, wantStatus: http.StatusBadRequest, wantBody: "is not below the mount root",
+		},
+		{
+			name: "TestMountFilesystem", method: http.MethodPost, path: "/v1/pools/rbd/images/volume-1/mount",
+			body: `{"path":"/srv/volume-1","filesystem":"btrfs"}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "TestMappings", method: http.MethodGet, path: "/v1/mappings",
+			replies: map[string]string{
+				"rbd showmapped --format json":      `[{"id":"0","pool":"rbd","namespace":"","name":"volume-1","snap":"-","device":"/dev/rbd0"}]`,
+				"rbd-nbd list-mapped --format json": "[]",
+			},
+			wantStatus: http.StatusOK, wantBody: `"backend": "krbd"`,
+		},
+		{
+			name: "TestUnknownPath", method: http.MethodGet, path: "/v1/volumes",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "TestMethodNotAllowed", method: http.MethodPut, path: "/v1/pools/rbd/images",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{Replies: tt.replies, Errors: tt.errors}
+
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+
+			switch tt.token {
+			case "":
+				request.Header.Set("Authorization", "Bearer "+testToken)
+			case "-":
+			default:
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			recorder := httptest.NewRecorder()
+			newTestServer(runner).Handler().ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if !strings.Contains(recorder.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), tt.wantBody)
+			}
+
+			if tt.wantCall != "" && !runner.Called(tt.wantCall) {
+				t.Errorf("ran %q, want %q", runner.Calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+// TestSpecification tests that the specification is served without a token and describes every
+// route.
+func TestSpecification(t *testing.T) {
+	server := newTestServer(&helperstest.Runner{})
+	recorder := httptest.NewRecorder()
+
+	server.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, specificationPath, nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var specification struct {
+		Paths map[string]map[string]interface{} `yaml:"paths"`
+	}
+
+	if err := yaml.Unmarshal(recorder.Body.Bytes(), &specification); err != nil {
+		t.Fatalf("specification is not YAML: %v", err)
+	}
+
+	for _, route := range server.routes() {
+		if _, ok := specification.Paths[route.pattern][strings.ToLower(route.method)]; !ok {
+			t.Errorf("specification does not describe %s %s", route.method, route.pattern)
+		}
+	}
+}
+
+// TestReconfigure tests that requests use the clients set by Reconfigure.
+func TestReconfigure(t *testing.T) {
+	before, after := &helperstest.Runner{}, &helperstest.Runner{Replies: map[string]string{"rbd --pool rbd --namespace  list --format json": `["volume-2"]`}}
+	server := newTestServer(before)
+	server.Reconfigure(&rbd.RadosBlockDeviceClient{Runner: after}, &ceph.CephCLI{Runner: after})
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/pools/rbd/images", nil)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+
+	recorder := httptest.NewRecorder()
+	server.Handler().ServeHTTP(recorder, request)
+
+	var images []string
+	if err := json.Unmarshal(recorder.Body.Bytes(), &images); err != nil || len(images) != 1 || len(before.Calls) != 0 {
+		t.Errorf("images = %q (%v), calls before = %q", images, err, before.Calls)
+	}
+}
+
+// TestTracePropagation tests that operations continue the trace of the traceparent of a request.
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	request := httptest.NewRequest(http.MethodGet, "/v1/pools/rbd/images", nil)
+	request.Header.Set("Authorization", "Bearer "+testToken)
+	request.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	newTestServer(&helperstest.Runner{Replies: map[string]string{"rbd --pool rbd --namespace  list --format json": `[]`}}).
+		Handler().ServeHTTP(httptest.NewRecorder(), request)
+
+	names := map[string]bool{}
+
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = true
+
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("span %q trace ID = %s, want %s", span.Name(), got, traceID)
+		}
+	}
+
+	for _, name := range []string{"GET /v1/pools/rbd/images", "rbd.GetRBDList"} {
+		if !names[name] {
+			t.Errorf("no span %q in %v", name, names)
+		}
+	}
+}
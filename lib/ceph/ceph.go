@@ -1,3 +1,69 @@
 package ceph
 
-type CephCLI struct{}
+import (
+	"context"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultCommandTimeout = 10 * time.Second
+
+// CephCLI wraps the ceph command line tools. The zero value runs commands on the
+// local host; set Runner to replay recorded output instead. CommandTimeout replaces the
+// default command timeout when set. Each public operation is traced below the span of the context
+// given to WithContext.
+type CephCLI struct {
+	Runner         helpers.Runner
+	CommandTimeout time.Duration
+
+	ctx context.Context //nolint:containedctx
+}
+
+// WithContext returns a copy of the client whose operations and commands are traced below the span
+// of ctx, such as the span of the API request or gRPC call they serve. Only the span is kept: the
+// commands are limited by their own timeouts, not by the cancellation or deadline of ctx.
+func (c *CephCLI) WithContext(ctx context.Context) *CephCLI {
+	traced := *c
+	traced.ctx = tracing.SpanOnly(ctx)
+
+	return &traced
+}
+
+// context returns the context the commands of the client run with.
+func (c *CephCLI) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// startSpan starts the span of a public operation and returns a copy of the client whose commands,
+// and the operations it calls, are recorded below it.
+func (c *CephCLI) startSpan(operation string, attributes ...attribute.KeyValue) (*CephCLI, trace.Span) {
+	ctx, span := tracing.Start(c.context(), "ceph."+operation, attributes...)
+
+	return c.WithContext(ctx), span
+}
+
+// run executes a command through the configured Runner using the command timeout.
+func (c *CephCLI) run(command string, args ...string) ([]byte, error) {
+	runner := c.Runner
+	if runner == nil {
+		runner = helpers.DefaultRunner
+	}
+
+	timeout := defaultCommandTimeout
+	if c.CommandTimeout > 0 {
+		timeout = c.CommandTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(c.context(), timeout)
+	defer cancel()
+
+	return runner.Run(ctx, command, args...) //nolint:wrapcheck
+}
@@ -0,0 +1,140 @@
+package ceph
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+const fsList = `[{"name":"mushroomfs","metadata_pool":"cephfs.mushroomfs.meta","metadata_pool_id":12,` +
+	`"data_pool_ids":[13],"data_pools":["cephfs.mushroomfs.data"]}]`
+
+// TestGetFSList tests the decoding of ceph fs ls.
+func TestGetFSList(t *testing.T) {
+	runner := &helperstest.Runner{Replies: map[string]string{"ceph fs ls --format json": fsList}}
+
+	filesystems, err := (&CephCLI{Runner: runner}).GetFSList()
+	if err != nil {
+		t.Fatalf("GetFSList() error = %v", err)
+	}
+
+	if len(filesystems) != 1 || filesystems[0].Name != "mushroomfs" ||
+		!reflect.DeepEqual(filesystems[0].DataPools, []string{"cephfs.mushroomfs.data"}) {
+		t.Errorf("GetFSList() = %+v", filesystems)
+	}
+}
+
+// TestSubvolumes tests the commands of the subvolume operations and the decoding of their output.
+func TestSubvolumes(t *testing.T) {
+	runner := &helperstest.Runner{Replies: map[string]string{
+		"ceph fs subvolume ls mushroomfs --group_name csi --format json": `[{"name":"volume-1"},{"name":"volume-2"}]`,
+		"ceph fs subvolumegroup ls mushroomfs --format json":             `[{"name":"csi"}]`,
+		"ceph fs subvolume getpath mushroomfs volume-1 --group_name csi": "/volumes/csi/volume-1/0e5b0a4c\n",
+		"ceph fs subvolume resize mushroomfs volume-1 10737418240 --group_name csi --no_shrink --format json": `[` +
+			`{"bytes_used":4096},{"bytes_quota":10737418240},{"bytes_pcent":"0.00"}]`,
+	}}
+	client := &CephCLI{Runner: runner}
+
+	if err := client.CreateSubvolume("mushroomfs", "csi", "volume-1", 10*units.GiB); err != nil {
+		t.Fatalf("CreateSubvolume() error = %v", err)
+	}
+
+	if err := client.CreateSubvolume("mushroomfs", "", "volume-2", 0); err != nil {
+		t.Fatalf("CreateSubvolume() without group error = %v", err)
+	}
+
+	names, err := client.ListSubvolumes("mushroomfs", "csi")
+	if err != nil || !reflect.DeepEqual(names, []string{"volume-1", "volume-2"}) {
+		t.Errorf("ListSubvolumes() = %q, %v", names, err)
+	}
+
+	groups, err := client.ListSubvolumeGroups("mushroomfs")
+	if err != nil || !reflect.DeepEqual(groups, []string{"csi"}) {
+		t.Errorf("ListSubvolumeGroups() = %q, %v", groups, err)
+	}
+
+	path, err := client.GetSubvolumePath("mushroomfs", "csi", "volume-1")
+	if err != nil || path != "/volumes/csi/volume-1/0e5b0a4c" {
+		t.Errorf("GetSubvolumePath() = %q, %v", path, err)
+	}
+
+	usage, err := client.ResizeSubvolume("mushroomfs", "csi", "volume-1", 10*units.GiB, true)
+	if err != nil || usage.BytesUsed != 4096 || usage.BytesQuota != 10*units.GiB || usage.BytesPercent != "0.00" {
+		t.Errorf("ResizeSubvolume() = %+v, %v", usage, err)
+	}
+
+	if err := client.RemoveSubvolumeGroup("mushroomfs", ""); !errors.Is(err, validators.ErrInvalidSubvolumeGroupName) {
+		t.Errorf("RemoveSubvolumeGroup() of the default group error = %v", err)
+	}
+
+	for _, want := range []string{
+		"ceph fs subvolume create mushroomfs volume-1 --group_name csi --size 10737418240",
+		"ceph fs subvolume create mushroomfs volume-2",
+	} {
+		if !runner.Called(want) {
+			t.Errorf("%q was not run, calls = %q", want, runner.Calls)
+		}
+	}
+}
+
+// TestMountSubvolume tests the mount.ceph options and the refusal of secret files that would inject options.
+func TestMountSubvolume(t *testing.T) {
+	const getPath = "ceph fs subvolume getpath mushroomfs volume-1 --group_name csi"
+
+	commandError := &helpers.CommandError{Command: getPath, ExitStatus: 2}
+
+	tests := []struct {
+		name       string
+		secretFile string
+		errors     map[string]error
+		wantMount  string
+		wantErr    error
+	}{
+		{
+			name:      "TestKeyring",
+			wantMount: "name=docker,mds_namespace=mushroomfs",
+		},
+		{
+			name:       "TestSecretFile",
+			secretFile: "/etc/ceph/docker.secret",
+			wantMount:  "name=docker,mds_namespace=mushroomfs,secretfile=/etc/ceph/docker.secret",
+		},
+		{name: "TestSecretFileComma", secretFile: "/etc/ceph/docker.secret,rw", wantErr: validators.ErrInvalidSecretFile},
+		{name: "TestSecretFileRelative", secretFile: "docker.secret", wantErr: validators.ErrInvalidSecretFile},
+		{name: "TestMissingSubvolume", errors: map[string]error{getPath: commandError}, wantErr: commandError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{
+				Replies: map[string]string{getPath: "/volumes/csi/volume-1/0e5b0a4c\n"},
+				Errors:  tt.errors,
+			}
+
+			path := filepath.Join(t.TempDir(), "volume-1")
+
+			err := (&CephCLI{Runner: runner}).MountSubvolume("mushroomfs", "csi", "volume-1", "client.docker", tt.secretFile, path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MountSubvolume() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if len(runner.Calls) > 1 {
+					t.Errorf("commands run after the error: %q", runner.Calls)
+				}
+
+				return
+			}
+
+			if want := "mount -t ceph :/volumes/csi/volume-1/0e5b0a4c " + path + " -o " + tt.wantMount; !runner.Called(want) {
+				t.Errorf("%q was not run, calls = %q", want, runner.Calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+)
+
+// DiskFree
+/* ceph df detail --format json
+
+{
+  "stats": {
+    "total_bytes": 322122547200,
+    "total_avail_bytes": 290984034304,
+    "total_used_bytes": 31138512896,
+    "total_used_raw_bytes": 31138512896,
+    "total_used_raw_ratio": 0.09666692
+  },
+  "pools": [
+    {
+      "name": "rbd",
+      "id": 2,
+      "stats": {
+        "stored": 10296414208,
+        "objects": 2498,
+        "kb_used": 30165216,
+        "bytes_used": 30889181184,
+        "percent_used": 0.0340413,
+        "max_avail": 91848630272,
+        "quota_objects": 0,
+        "quota_bytes": 107374182400
+      }
+    }
+  ]
+}
+
+DiskFree is used to report the usage and quota of each pool. A quota of zero is not set. */
+type DiskFree struct {
+	Stats struct {
+		TotalBytes      units.Size `json:"total_bytes"`       //nolint:tagliatelle
+		TotalAvailBytes units.Size `json:"total_avail_bytes"` //nolint:tagliatelle
+		TotalUsedBytes  units.Size `json:"total_used_bytes"`  //nolint:tagliatelle
+	} `json:"stats"`
+	Pools []*PoolUsage `json:"pools"`
+}
+
+// PoolUsage is the usage of a single pool. Stored is the data written by clients and BytesUsed
+// what it takes up with replication.
+type PoolUsage struct {
+	Name  string `json:"name"`
+	ID    int    `json:"id"`
+	Stats struct {
+		Stored       units.Size `json:"stored"`
+		Objects      uint64     `json:"objects"`
+		BytesUsed    units.Size `json:"bytes_used"`    //nolint:tagliatelle
+		PercentUsed  float64    `json:"percent_used"`  //nolint:tagliatelle
+		MaxAvail     units.Size `json:"max_avail"`     //nolint:tagliatelle
+		QuotaObjects uint64     `json:"quota_objects"` //nolint:tagliatelle
+		QuotaBytes   units.Size `json:"quota_bytes"`   //nolint:tagliatelle
+	} `json:"stats"`
+}
+
+// GetDiskFree returns the usage and quota of the cluster and its pools.
+func (c *CephCLI) GetDiskFree() (_ *DiskFree, err error) {
+	c, span := c.startSpan("GetDiskFree")
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Msg("GetDiskFree")
+
+	stdOut, err := c.run("ceph", "df", "detail", "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: ceph df failed: %w", err)
+	}
+
+	result := &DiskFree{}
+
+	if err := json.Unmarshal(stdOut, &result); err != nil {
+		log.Trace().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+
+		return nil, fmt.Errorf("ERROR: json for ceph df could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,78 @@
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+// FSList
+/* ceph fs ls --format json
+
+[
+  {
+    "name": "mushroomfs",
+    "metadata_pool": "cephfs.mushroomfs.meta",
+    "metadata_pool_id": 12,
+    "data_pool_ids": [
+      13
+    ],
+    "data_pools": [
+      "cephfs.mushroomfs.data"
+    ]
+  }
+]
+
+FSList is used to discover the CephFS filesystems of a cluster. */
+type FSList []*Filesystem
+
+type Filesystem struct {
+	Name           string   `json:"name"`
+	MetadataPool   string   `json:"metadata_pool"`    //nolint:tagliatelle
+	MetadataPoolID int      `json:"metadata_pool_id"` //nolint:tagliatelle
+	DataPoolIDs    []int    `json:"data_pool_ids"`    //nolint:tagliatelle
+	DataPools      []string `json:"data_pools"`       //nolint:tagliatelle
+}
+
+// GetFSList returns the CephFS filesystems of the cluster.
+func (c *CephCLI) GetFSList() (_ FSList, err error) {
+	c, span := c.startSpan("GetFSList")
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Msg("GetFSList")
+
+	stdOut, err := c.run("ceph", "fs", "ls", "--format", "json")
+	if err != nil {
+		log.Error().Str("Error", err.Error()).Msg(language.ErrExecutingCommand)
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var result FSList
+
+	if err := json.Unmarshal(stdOut, &result); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return result, nil
+}
+
+// IsCephFSPool returns true when the pool is tagged as either the data or the metadata pool of a filesystem.
+func (c *CephCLI) IsCephFSPool(pool string) (_ bool, err error) {
+	c, span := c.startSpan("IsCephFSPool", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
+
+	if tag, err := c.GetApplicationTag(pool); err != nil {
+		return false, err
+	} else {
+		if tag.Cephfs != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
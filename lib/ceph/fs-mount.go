@@ -0,0 +1,121 @@
+package ceph
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/spf13/cast"
+)
+
+const (
+	quotaMaxBytesAttribute = "ceph.quota.max_bytes"
+	quotaMaxFilesAttribute = "ceph.quota.max_files"
+)
+
+// MountSubvolume mounts a subvolume at path with the kernel CephFS client, authenticating as the
+// given cephx user, with the key read from secretFile when it is not empty. The monitors are
+// taken from the local ceph.conf by mount.ceph.
+func (c *CephCLI) MountSubvolume(filesystem, group, name, user, secretFile, path string) (err error) {
+	c, span := c.startSpan("MountSubvolume", tracing.FilesystemKey.String(filesystem), tracing.MountPointKey.String(path))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSubvolume(filesystem, group, name); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateCephxUser(user); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateMountPath(path); err != nil {
+		return err
+	}
+
+	if secretFile != "" {
+		if err := validators.ValidateSecretFile(secretFile); err != nil {
+			return err
+		}
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Str("Name", name).Str("User", user).
+		Str("Path", path).Msg("MountSubvolume")
+
+	subvolumePath, pathError := c.GetSubvolumePath(filesystem, group, name)
+	if pathError != nil {
+		return pathError
+	}
+
+	if err := os.MkdirAll(path, 0o701); err != nil {
+		log.Error().Str("Path", path).Str("Error", err.Error()).Msg("could not create directory")
+
+		return fmt.Errorf("%w", err)
+	}
+
+	options := []string{
+		"name=" + strings.TrimPrefix(user, "client."),
+		"mds_namespace=" + filesystem,
+	}
+
+	if secretFile != "" {
+		options = append(options, "secretfile="+secretFile)
+	}
+
+	if _, err := c.run("mount", "-t", "ceph", ":"+subvolumePath, path, "-o", strings.Join(options, ",")); err != nil {
+		return fmt.Errorf("ERROR: mount -t ceph failed: %w", err)
+	}
+
+	return nil
+}
+
+// UnmountSubvolume unmounts a CephFS mount created with MountSubvolume.
+func (c *CephCLI) UnmountSubvolume(path string) (err error) {
+	c, span := c.startSpan("UnmountSubvolume", tracing.MountPointKey.String(path))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateMountPath(path); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Path", path).Msg("UnmountSubvolume")
+
+	if _, err := c.run("umount", path); err != nil {
+		return fmt.Errorf("ERROR: umount failed: %w", err)
+	}
+
+	return nil
+}
+
+// SetDirectoryQuota sets the size and file quotas of a directory on a mounted CephFS filesystem.
+// A limit of 0 removes that quota.
+func (c *CephCLI) SetDirectoryQuota(path string, maxSize units.Size, maxFiles int64) (err error) {
+	c, span := c.startSpan("SetDirectoryQuota", tracing.MountPointKey.String(path))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateMountPath(path); err != nil {
+		return err
+	}
+
+	if maxFiles < 0 {
+		return validators.ErrInvalidQuota
+	}
+
+	log.Trace().Str("Path", path).Str("MaxSize", maxSize.String()).Int64("MaxFiles", maxFiles).
+		Msg("SetDirectoryQuota")
+
+	if _, err := c.run(
+		"setfattr", "-n", quotaMaxBytesAttribute, "-v", cast.ToString(maxSize.Bytes()), path,
+	); err != nil {
+		return fmt.Errorf("ERROR: setfattr %s failed: %w", quotaMaxBytesAttribute, err)
+	}
+
+	if _, err := c.run("setfattr", "-n", quotaMaxFilesAttribute, "-v", cast.ToString(maxFiles), path); err != nil {
+		return fmt.Errorf("ERROR: setfattr %s failed: %w", quotaMaxFilesAttribute, err)
+	}
+
+	return nil
+}
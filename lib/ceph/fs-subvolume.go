@@ -0,0 +1,315 @@
+package ceph
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/spf13/cast"
+)
+
+// SubvolumeList
+/* ceph fs subvolume ls mushroomfs --group_name csi --format json
+
+[
+  {
+    "name": "volume-1"
+  },
+  {
+    "name": "volume-2"
+  }
+]
+
+SubvolumeList is used for both subvolume and subvolume group listings. */
+type SubvolumeList []*struct {
+	Name string `json:"name"`
+}
+
+// SubvolumeUsage
+/* ceph fs subvolume resize mushroomfs volume-1 10737418240 --group_name csi --format json
+
+[
+  {
+    "bytes_used": 4096
+  },
+  {
+    "bytes_quota": 10737418240
+  },
+  {
+    "bytes_pcent": "0.00"
+  }
+]
+
+SubvolumeUsage is the usage reported after a subvolume has been resized. */
+type SubvolumeUsage struct {
+	BytesUsed    units.Size `json:"bytes_used"`  //nolint:tagliatelle
+	BytesQuota   units.Size `json:"bytes_quota"` //nolint:tagliatelle
+	BytesPercent string     `json:"bytes_pcent"` //nolint:tagliatelle
+}
+
+func validateSubvolume(filesystem, group, name string) error {
+	if err := validators.ValidateFilesystemName(filesystem); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateSubvolumeGroupName(group); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateSubvolumeName(name); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateGroup requires an explicit subvolume group for the operations that act on the group itself.
+func validateGroup(group string) error {
+	if group == "" {
+		return fmt.Errorf("%w: the default group cannot be created or removed", validators.ErrInvalidSubvolumeGroupName)
+	}
+
+	return validators.ValidateSubvolumeGroupName(group)
+}
+
+// groupArguments returns the --group_name argument when a group other than the default was requested.
+func groupArguments(group string) []string {
+	if group == "" {
+		return nil
+	}
+
+	return []string{"--group_name", group}
+}
+
+// CreateSubvolumeGroup creates a subvolume group within the filesystem.
+func (c *CephCLI) CreateSubvolumeGroup(filesystem, group string) (err error) {
+	c, span := c.startSpan("CreateSubvolumeGroup", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateFilesystemName(filesystem); err != nil {
+		return err
+	}
+
+	if err := validateGroup(group); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Msg("CreateSubvolumeGroup")
+
+	if _, err := c.run("ceph", "fs", "subvolumegroup", "create", filesystem, group); err != nil {
+		return fmt.Errorf("ERROR: ceph fs subvolumegroup create failed: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveSubvolumeGroup removes an empty subvolume group from the filesystem.
+func (c *CephCLI) RemoveSubvolumeGroup(filesystem, group string) (err error) {
+	c, span := c.startSpan("RemoveSubvolumeGroup", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateFilesystemName(filesystem); err != nil {
+		return err
+	}
+
+	if err := validateGroup(group); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Msg("RemoveSubvolumeGroup")
+
+	if _, err := c.run("ceph", "fs", "subvolumegroup", "rm", filesystem, group); err != nil {
+		return fmt.Errorf("ERROR: ceph fs subvolumegroup rm failed: %w", err)
+	}
+
+	return nil
+}
+
+// ListSubvolumeGroups returns the names of the subvolume groups within the filesystem.
+func (c *CephCLI) ListSubvolumeGroups(filesystem string) (_ []string, err error) {
+	c, span := c.startSpan("ListSubvolumeGroups", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateFilesystemName(filesystem); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Msg("ListSubvolumeGroups")
+
+	return c.executeSubvolumeList("subvolumegroup", filesystem)
+}
+
+// CreateSubvolume creates a subvolume, limited to size when size is greater than 0.
+// An empty group places the subvolume within the default group.
+func (c *CephCLI) CreateSubvolume(filesystem, group, name string, size units.Size) (err error) {
+	c, span := c.startSpan("CreateSubvolume", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSubvolume(filesystem, group, name); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Str("Name", name).Str("Size", size.String()).
+		Msg("CreateSubvolume")
+
+	args := []string{"fs", "subvolume", "create", filesystem, name}
+	args = append(args, groupArguments(group)...)
+
+	if size > 0 {
+		args = append(args, "--size", cast.ToString(size.Bytes()))
+	}
+
+	if _, err := c.run("ceph", args...); err != nil {
+		return fmt.Errorf("ERROR: ceph fs subvolume create failed: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveSubvolume removes a subvolume and its data.
+func (c *CephCLI) RemoveSubvolume(filesystem, group, name string) (err error) {
+	c, span := c.startSpan("RemoveSubvolume", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSubvolume(filesystem, group, name); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Str("Name", name).Msg("RemoveSubvolume")
+
+	args := append([]string{"fs", "subvolume", "rm", filesystem, name}, groupArguments(group)...)
+
+	if _, err := c.run("ceph", args...); err != nil {
+		return fmt.Errorf("ERROR: ceph fs subvolume rm failed: %w", err)
+	}
+
+	return nil
+}
+
+// ResizeSubvolume sets the quota of a subvolume to size. A size of 0 removes the quota.
+// With noShrink set, the resize is refused when it would drop below the bytes already in use.
+func (c *CephCLI) ResizeSubvolume(
+	filesystem, group, name string, size units.Size, noShrink bool,
+) (_ *SubvolumeUsage, err error) {
+	c, span := c.startSpan("ResizeSubvolume", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSubvolume(filesystem, group, name); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Str("Name", name).Str("Size", size.String()).
+		Msg("ResizeSubvolume")
+
+	newSize := "infinite"
+	if size > 0 {
+		newSize = cast.ToString(size.Bytes())
+	}
+
+	args := []string{"fs", "subvolume", "resize", filesystem, name, newSize}
+	args = append(args, groupArguments(group)...)
+
+	if noShrink {
+		args = append(args, "--no_shrink")
+	}
+
+	stdOut, err := c.run("ceph", append(args, "--format", "json")...)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: ceph fs subvolume resize failed: %w", err)
+	}
+
+	var entries []map[string]interface{}
+
+	if err := json.Unmarshal(stdOut, &entries); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	usage := &SubvolumeUsage{}
+
+	for _, entry := range entries {
+		if value, ok := entry["bytes_used"]; ok {
+			usage.BytesUsed = units.Size(cast.ToUint64(value))
+		}
+
+		if value, ok := entry["bytes_quota"]; ok {
+			usage.BytesQuota = units.Size(cast.ToUint64(value))
+		}
+
+		if value, ok := entry["bytes_pcent"]; ok {
+			usage.BytesPercent = cast.ToString(value)
+		}
+	}
+
+	return usage, nil
+}
+
+// GetSubvolumePath returns the path of the subvolume relative to the root of the filesystem.
+func (c *CephCLI) GetSubvolumePath(filesystem, group, name string) (_ string, err error) {
+	c, span := c.startSpan("GetSubvolumePath", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSubvolume(filesystem, group, name); err != nil {
+		return "", err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Str("Name", name).Msg("GetSubvolumePath")
+
+	args := append([]string{"fs", "subvolume", "getpath", filesystem, name}, groupArguments(group)...)
+
+	stdOut, err := c.run("ceph", args...)
+	if err != nil {
+		return "", fmt.Errorf("ERROR: ceph fs subvolume getpath failed: %w", err)
+	}
+
+	return strings.TrimSpace(string(stdOut)), nil
+}
+
+// ListSubvolumes returns the names of the subvolumes within a group of the filesystem.
+func (c *CephCLI) ListSubvolumes(filesystem, group string) (_ []string, err error) {
+	c, span := c.startSpan("ListSubvolumes", tracing.FilesystemKey.String(filesystem))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateFilesystemName(filesystem); err != nil {
+		return nil, err
+	}
+
+	if err := validators.ValidateSubvolumeGroupName(group); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Filesystem", filesystem).Str("Group", group).Msg("ListSubvolumes")
+
+	return c.executeSubvolumeList("subvolume", filesystem, groupArguments(group)...)
+}
+
+// executeSubvolumeList runs the ls command of either 'subvolume' or 'subvolumegroup' and returns the names found.
+func (c *CephCLI) executeSubvolumeList(kind, filesystem string, extra ...string) ([]string, error) {
+	args := append([]string{"fs", kind, "ls", filesystem}, extra...)
+
+	stdOut, err := c.run("ceph", append(args, "--format", "json")...)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: ceph fs %s ls failed: %w", kind, err)
+	}
+
+	var list SubvolumeList
+
+	if err := json.Unmarshal(stdOut, &list); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	names := make([]string, 0, len(list))
+	for _, entry := range list {
+		names = append(names, entry.Name)
+	}
+
+	return names, nil
+}
@@ -1,15 +1,12 @@
 package ceph
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
 // ApplicationTag
@@ -32,36 +29,30 @@ type ApplicationTag struct {
 	} `json:"cephfs"`
 }
 
-func (c *CephCLI) GetApplicationTag(pool string) (*ApplicationTag, error) {
-	var stdOut, stdErr bytes.Buffer
+func (c *CephCLI) GetApplicationTag(pool string) (_ *ApplicationTag, err error) {
+	c, span := c.startSpan("GetApplicationTag", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "ceph", "osd", "pool", "application", "get", pool, "--format", "json")
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("ceph", "osd", "pool", "application", "get", pool, "--format", "json")
+	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 
 	result := &ApplicationTag{}
 
-	if err := json.Unmarshal(stdOut.Bytes(), &result); err != nil {
-		log.Trace().Str("Response", stdOut.String()).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+	if err := json.Unmarshal(stdOut, &result); err != nil {
+		log.Trace().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
 
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutionCompleted)
-
 	return result, nil
 }
 
-func (c *CephCLI) IsRBDPool(pool string) (bool, error) {
+func (c *CephCLI) IsRBDPool(pool string) (_ bool, err error) {
+	c, span := c.startSpan("IsRBDPool", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
+
 	if tag, err := c.GetApplicationTag(pool); err != nil {
 		return false, err
 	} else {
@@ -72,7 +63,10 @@ func (c *CephCLI) IsRBDPool(pool string) (bool, error) {
 	return false, nil
 }
 
-func (c *CephCLI) IsRGWPool(pool string) (bool, error) {
+func (c *CephCLI) IsRGWPool(pool string) (_ bool, err error) {
+	c, span := c.startSpan("IsRGWPool", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
+
 	if tag, err := c.GetApplicationTag(pool); err != nil {
 		return false, err
 	} else {
@@ -83,7 +77,10 @@ func (c *CephCLI) IsRGWPool(pool string) (bool, error) {
 	return false, nil
 }
 
-func (c *CephCLI) IsMgrDevicehealthPool(pool string) (bool, error) {
+func (c *CephCLI) IsMgrDevicehealthPool(pool string) (_ bool, err error) {
+	c, span := c.startSpan("IsMgrDevicehealthPool", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
+
 	if tag, err := c.GetApplicationTag(pool); err != nil {
 		return false, err
 	} else {
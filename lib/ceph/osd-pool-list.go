@@ -1,16 +1,13 @@
 package ceph
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/scattered-network/scattered-storage/lib/helpers"
 	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
 // OSDPoolList
@@ -36,19 +33,12 @@ import (
 OSDPoolList is used process the pool list output. */
 type OSDPoolList helpers.List
 
-func (c *CephCLI) GetOSDPoolList() (*OSDPoolList, error) {
-	var stdOut, stdErr bytes.Buffer
+func (c *CephCLI) GetOSDPoolList() (_ *OSDPoolList, err error) {
+	c, span := c.startSpan("GetOSDPoolList")
+	defer func() { tracing.End(span, err) }()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "ceph", "osd", "pool", "ls", "--format", "json")
-	log.Trace().Str("Command", cmd.String()).Msg("Executing command")
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("ceph", "osd", "pool", "ls", "--format", "json")
+	if err != nil {
 		log.Error().Str("Error", err.Error()).Msg(language.ErrExecutingCommand)
 
 		return nil, fmt.Errorf("%w", err)
@@ -56,19 +46,20 @@ func (c *CephCLI) GetOSDPoolList() (*OSDPoolList, error) {
 
 	result := &OSDPoolList{}
 
-	if err := json.Unmarshal(stdOut.Bytes(), &result); err != nil {
-		log.Error().Str("Response", stdOut.String()).Str("Error", err.Error()).
+	if err := json.Unmarshal(stdOut, &result); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).
 			Msg("Encountered Error Unmarshalling Response")
 
 		return nil, fmt.Errorf("%w", err)
 	}
 
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutionCompleted)
-
 	return result, nil
 }
 
 func (c *CephCLI) GetRBDPools() OSDPoolList {
+	c, span := c.startSpan("GetRBDPools")
+	defer span.End()
+
 	log.Trace().Msg("Getting list of RBD pools")
 
 	found := map[int]string{}
@@ -0,0 +1,229 @@
+package cli
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+)
+
+// Column is a column of a table: its header and the function rendering its cell for a row.
+type Column struct {
+	Header string
+	Cell   func(row interface{}) string
+}
+
+// Table defines how a type is printed as a table. Rows splits a result into its rows; without it,
+// every element of a slice is a row and any other value is a single row.
+type Table struct {
+	Rows    func(result interface{}) []interface{}
+	Columns []Column
+}
+
+// tables returns the table definitions keyed by the type they print.
+func tables() map[reflect.Type]Table {
+	return map[reflect.Type]Table{
+		reflect.TypeOf(rbd.RBD{}):             imageTable,
+		reflect.TypeOf(rbd.ImageSpec{}):       imageSpecTable,
+		reflect.TypeOf(rbd.ShowMapped{}):      showMappedTable,
+		reflect.TypeOf(rbd.ListBlock{}):       listBlockTable,
+		reflect.TypeOf([]*rbd.Lock{}):         lockTable,
+		reflect.TypeOf(ceph.OSDPoolList{}):    nameTable,
+		reflect.TypeOf(ceph.ApplicationTag{}): applicationTagTable,
+		reflect.TypeOf(helpers.List{}):        nameTable,
+		reflect.TypeOf([]string{}):            nameTable,
+		reflect.TypeOf(map[string]string{}):   keyValueTable,
+		reflect.TypeOf(config.ProfileList{}):  profileTable,
+		reflect.TypeOf(config.NamedProfile{}): profileTable,
+	}
+}
+
+//nolint:gochecknoglobals
+var (
+	imageTable = Table{Columns: []Column{
+		{"NAME", func(row interface{}) string { return row.(rbd.RBD).Name }},
+		{"ID", func(row interface{}) string { return row.(rbd.RBD).ID }},
+		{"SIZE", func(row interface{}) string { return units.Size(row.(rbd.RBD).Size).String() }},
+		{"OBJECTS", func(row interface{}) string { return strconv.Itoa(row.(rbd.RBD).Objects) }},
+		{"FORMAT", func(row interface{}) string { return strconv.Itoa(row.(rbd.RBD).Format) }},
+		{"SNAPSHOTS", func(row interface{}) string { return strconv.Itoa(row.(rbd.RBD).SnapshotCount) }},
+		{"FEATURES", func(row interface{}) string {
+			features := make([]string, 0, len(row.(rbd.RBD).Features))
+			for _, feature := range row.(rbd.RBD).Features {
+				if feature != nil {
+					features = append(features, *feature)
+				}
+			}
+
+			return strings.Join(features, ",")
+		}},
+	}}
+
+	imageSpecTable = Table{Columns: []Column{
+		{"POOL", func(row interface{}) string { return row.(rbd.ImageSpec).Pool }},
+		{"NAMESPACE", func(row interface{}) string { return row.(rbd.ImageSpec).Namespace }},
+		{"IMAGE", func(row interface{}) string { return row.(rbd.ImageSpec).Image }},
+		{"SNAPSHOT", func(row interface{}) string { return row.(rbd.ImageSpec).Snapshot }},
+	}}
+
+	showMappedTable = Table{Columns: []Column{
+		{"ID", func(row interface{}) string { return row.(*rbd.MappedImage).ID }},
+		{"POOL", func(row interface{}) string { return row.(*rbd.MappedImage).Pool }},
+		{"NAMESPACE", func(row interface{}) string { return row.(*rbd.MappedImage).Namespace }},
+		{"IMAGE", func(row interface{}) string { return row.(*rbd.MappedImage).Name }},
+		{"SNAP", func(row interface{}) string { return row.(*rbd.MappedImage).Snap }},
+		{"DEVICE", func(row interface{}) string { return row.(*rbd.MappedImage).Device }},
+		{"BACKEND", func(row interface{}) string { return string(row.(*rbd.MappedImage).Backend) }},
+	}}
+
+	listBlockTable = Table{
+		Rows: blockDeviceRows,
+		Columns: []Column{
+			{"NAME", func(row interface{}) string { return row.(blockDeviceRow).name }},
+			{"PATH", func(row interface{}) string { return row.(blockDeviceRow).path }},
+			{"TYPE", func(row interface{}) string { return row.(blockDeviceRow).kind }},
+			{"FSTYPE", func(row interface{}) string { return row.(blockDeviceRow).fsType }},
+			{"MOUNTPOINT", func(row interface{}) string { return row.(blockDeviceRow).mountPoint }},
+		},
+	}
+
+	lockTable = Table{Columns: []Column{
+		{"ID", func(row interface{}) string { return row.(*rbd.Lock).ID }},
+		{"LOCKER", func(row interface{}) string { return row.(*rbd.Lock).Locker }},
+		{"ADDRESS", func(row interface{}) string { return row.(*rbd.Lock).Address }},
+	}}
+
+	nameTable = Table{Columns: []Column{
+		{"NAME", func(row interface{}) string { return row.(string) }},
+	}}
+
+	applicationTagTable = Table{
+		Rows: applicationTagRows,
+		Columns: []Column{
+			{"APPLICATION", func(row interface{}) string { return row.([2]string)[0] }},
+			{"VALUE", func(row interface{}) string { return row.([2]string)[1] }},
+		},
+	}
+
+	keyValueTable = Table{
+		Rows: keyValueRows,
+		Columns: []Column{
+			{"KEY", func(row interface{}) string { return row.([2]string)[0] }},
+			{"VALUE", func(row interface{}) string { return row.([2]string)[1] }},
+		},
+	}
+
+	profileTable = Table{
+		Rows: profileRows,
+		Columns: []Column{
+			{"CURRENT", func(row interface{}) string {
+				if row.(*config.NamedProfile).Current {
+					return "*"
+				}
+
+				return ""
+			}},
+			{"NAME", func(row interface{}) string { return row.(*config.NamedProfile).Name }},
+			{"CLUSTER", func(row interface{}) string { return row.(*config.NamedProfile).Cluster.Name }},
+			{"CONF", func(row interface{}) string { return row.(*config.NamedProfile).Conf }},
+			{"USER", func(row interface{}) string { return row.(*config.NamedProfile).User }},
+			{"KEYRING", func(row interface{}) string { return row.(*config.NamedProfile).Keyring }},
+			{"POOL", func(row interface{}) string { return row.(*config.NamedProfile).Pool }},
+		},
+	}
+)
+
+// profileRows lists the profiles of a ProfileList, or the single profile of a NamedProfile.
+func profileRows(result interface{}) []interface{} {
+	if profile, ok := result.(config.NamedProfile); ok {
+		return []interface{}{&profile}
+	}
+
+	profiles := result.(config.ProfileList)
+
+	rows := make([]interface{}, 0, len(profiles))
+	for _, profile := range profiles {
+		rows = append(rows, profile)
+	}
+
+	return rows
+}
+
+// blockDeviceRow is a device or partition of an lsblk listing.
+type blockDeviceRow struct {
+	name, path, kind, fsType, mountPoint string
+}
+
+// blockDeviceRows lists each device followed by its partitions, which are indented.
+func blockDeviceRows(result interface{}) []interface{} {
+	var rows []interface{}
+
+	for _, device := range result.(rbd.ListBlock).Blockdevices {
+		rows = append(rows, blockDeviceRow{device.Name, device.Path, device.Type, device.FSType, device.Mountpoint})
+
+		for _, child := range device.Children {
+			rows = append(rows, blockDeviceRow{"  " + child.Name, child.Path, child.Type, child.FSType, child.Mountpoint})
+		}
+	}
+
+	return rows
+}
+
+// applicationTagRows lists the applications a pool is tagged for.
+func applicationTagRows(result interface{}) []interface{} {
+	tag := result.(ceph.ApplicationTag)
+
+	var rows []interface{}
+
+	if tag.RBD != nil {
+		rows = append(rows, [2]string{"rbd", ""})
+	}
+
+	if tag.RGW != nil {
+		rows = append(rows, [2]string{"rgw", ""})
+	}
+
+	if tag.MgrDevicehealth != nil {
+		rows = append(rows, [2]string{"mgr_devicehealth", ""})
+	}
+
+	if tag.Cephfs != nil {
+		var values []string
+		if tag.Cephfs.Data != nil {
+			values = append(values, "data="+*tag.Cephfs.Data)
+		}
+
+		if tag.Cephfs.Metadata != nil {
+			values = append(values, "metadata="+*tag.Cephfs.Metadata)
+		}
+
+		rows = append(rows, [2]string{"cephfs", strings.Join(values, ",")})
+	}
+
+	return rows
+}
+
+// keyValueRows lists the entries of a map sorted by key.
+func keyValueRows(result interface{}) []interface{} {
+	values := result.(map[string]string)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	rows := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, [2]string{key, values[key]})
+	}
+
+	return rows
+}
@@ -1,13 +1,21 @@
 package cli
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/config"
 	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/spf13/cast"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -15,16 +23,34 @@ import (
 
 type ConfigMap = helpers.Data
 
+var (
+	ErrInvalidConfig           = errors.New("configuration could not be read")
+	ErrUnsupportedConfigFormat = errors.New("unsupported configuration file format, use .json, .yaml, .yml or .toml")
+	ErrNoConfigFile            = errors.New("no configuration file was read")
+	ErrEmptyConfig             = errors.New("configuration file is empty")
+)
+
 const (
 	defaultConfigFile       = "/etc/scattered-storage/rbd-docker-plugin"
 	defaultOperationTimeout = 5
+	// configReloadDelay is how long the configuration file must stay unchanged before it is
+	// reloaded, as editors and config management write a file in several steps.
+	configReloadDelay = 500 * time.Millisecond
 )
 
+// Cmd wraps a cobra command. CobraRoot is the command itself, which for the root command of an
+// application is the root of the command tree. Config is the typed configuration, loaded when the
+// command starts. Once WatchConfig runs, Config is replaced from the goroutine of the watcher and
+// must be read through CurrentConfig.
 type Cmd struct {
 	ConfigMap    map[string]*ConfigMap
+	Config       *config.Config
 	envPrefix    string
 	DebugEnabled bool
 	CobraRoot    *cobra.Command
+	viper        *viper.Viper
+	configRead   bool
+	mutex        sync.RWMutex
 }
 
 // NewCLICommand returns a *Cmd struct that includes a ConfigMap,
@@ -40,6 +66,10 @@ func NewCLICommand(
 		envPrefix:    envPrefix,
 		DebugEnabled: false,
 		CobraRoot:    nil,
+		Config:       nil,
+		viper:        nil,
+		configRead:   false,
+		mutex:        sync.RWMutex{},
 	}
 
 	//nolint:exhaustruct
@@ -56,11 +86,13 @@ func NewCLICommand(
 	if parent != nil {
 		parent.AddCommand(cobraCmd)
 	} else {
-		cobraCmd.Flags().StringP("config", "c", defaultConfigFile, "config file")
-		cobraCmd.Flags().IntP("timeout", "t", defaultOperationTimeout, "timeout for operations (in seconds)")
-		newCmd.CobraRoot = cobraCmd
+		cobraCmd.PersistentFlags().StringP("config", "c", defaultConfigFile, "config file")
+		cobraCmd.PersistentFlags().IntP("timeout", "t", defaultOperationTimeout, "timeout for operations (in seconds)")
+		cobraCmd.PersistentFlags().String("profile", "", "profile of the config file to use instead of current-profile")
 	}
 
+	newCmd.CobraRoot = cobraCmd
+
 	return newCmd
 }
 
@@ -74,53 +106,338 @@ func (c *Cmd) KeyExists(key string) bool {
 	return false
 }
 
-// initConfiguration will attempt to set the path to the configuration file of the application.
-// If --config is used then that will have priority, then environment variables, finally
-// the default value passed into NewCLICommand() will be used.
+// initConfiguration reads the configuration of the application and merges it into the ConfigMap
+// and the typed Config. Values are taken, from lowest to highest priority, from the defaults, the
+// configuration file, the <envPrefix>_<KEY> environment variables and the flags. The file is set
+// with --config or <envPrefix>_CONFIG, and its format follows its extension: .json, .yaml, .yml
+// or .toml. A file without an extension is read as JSON. A missing file is only an error when it
+// was given explicitly.
 func (c *Cmd) initConfiguration(defaultConfigFile string) error {
-	c.bindEnvironmentVariables()
+	mamba, err := c.newViper()
+	if err != nil {
+		return err
+	}
 
-	mamba := viper.New()
-	mamba.SetConfigType("json")
+	configFile := mamba.GetString("config")
+	explicit := mamba.IsSet("config")
 
-	if configFile, err := c.CobraRoot.Flags().GetString("config"); err != nil {
-		if configFile != "" {
-			mamba.SetConfigFile(configFile)
-		} else {
-			mamba.SetConfigFile(defaultConfigFile)
-		}
+	if configFile == "" {
+		configFile = defaultConfigFile
+	}
+
+	configType, err := configFileType(configFile)
+	if err != nil {
+		return err
 	}
 
-	if err := mamba.ReadInConfig(); errors.Is(err, os.ErrNotExist) {
-		if c.DebugEnabled {
-			log.Error().Str("Config", mamba.ConfigFileUsed()).Msg("config file does not exist")
+	mamba.SetConfigFile(configFile)
+	mamba.SetConfigType(configType)
+
+	c.configRead = false
+
+	if err := mamba.ReadInConfig(); err != nil {
+		if explicit || !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, configFile, err)
 		}
+
+		log.Debug().Str("Config", configFile).Msg("config file does not exist")
+	} else {
+		c.configRead = true
 	}
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.viper = mamba
+
+	return c.loadConfig()
+}
+
+// CurrentConfig returns the typed configuration in effect, which WatchConfig may have reloaded.
+func (c *Cmd) CurrentConfig() *config.Config {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.Config
+}
+
+// newViper returns a viper instance with the defaults, the environment variables and the flags of
+// the command, but without a configuration file.
+func (c *Cmd) newViper() (*viper.Viper, error) {
+	mamba := viper.New()
+	mamba.SetEnvPrefix(c.envPrefix)
+	mamba.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
+	mamba.AutomaticEnv()
+	config.SetDefaults(mamba)
+
+	if err := mamba.BindPFlags(c.CobraRoot.Flags()); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	return mamba, nil
+}
+
+// LoadConfiguration reads the configuration the way the command does before it runs. Completion
+// functions call it because cobra does not run PersistentPreRunE while completing.
+func (c *Cmd) LoadConfiguration() error {
+	return c.initConfiguration(defaultConfigFile)
+}
+
+// configFileType returns the viper config type of a file from its extension.
+func configFileType(configFile string) (string, error) {
+	switch extension := strings.ToLower(filepath.Ext(configFile)); extension {
+	case "":
+		return "json", nil
+	case ".json", ".toml", ".yaml":
+		return strings.TrimPrefix(extension, "."), nil
+	case ".yml":
+		return "yaml", nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedConfigFormat, configFile)
+	}
+}
+
+// loadConfig applies the selected profile and stores the merged values in the ConfigMap and the
+// typed Config. The --timeout flag, when given, overrides the command timeout of the configuration
+// file.
+func (c *Cmd) loadConfig() error {
+	loaded, err := decodeConfig(c.viper)
+	if err != nil {
+		return err
+	}
+
+	c.bindEnvironmentVariables()
+	c.Config = loaded
+
 	return nil
 }
 
-// bindEnvironmentVariables steps through each flag.
+// decodeConfig applies the selected profile of a viper instance and returns its typed Config.
+func decodeConfig(mamba *viper.Viper) (*config.Config, error) {
+	profile, err := config.SelectProfile(mamba)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	loaded := &config.Config{}
+	if err := mamba.Unmarshal(loaded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	loaded.Profile = profile
+
+	if mamba.IsSet("timeout") {
+		loaded.Timeouts.Command = time.Duration(mamba.GetInt("timeout")) * time.Second
+	}
+
+	return loaded, nil
+}
+
+// bindEnvironmentVariables steps through each flag, including those inherited from parent commands,
+// and stores its merged value in the ConfigMap. Flags that were not given take the merged value, so
+// that both the ConfigMap and the flags report the value in effect.
 func (c *Cmd) bindEnvironmentVariables() {
 	c.CobraRoot.Flags().VisitAll(
 		func(flag *pflag.Flag) {
 			variableName := strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
-			optionName := fmt.Sprintf("%s_%s", c.envPrefix, variableName)
-			optionValue, envFound := os.LookupEnv(optionName)
-			if _, found := c.ConfigMap[variableName]; found {
-				if envFound && !flag.Changed { // use ENV if found, unless flag is used
-					c.ConfigMap[variableName].SetValue(optionValue, flag.Value.Type())
-					err := flag.Value.Set(optionValue) // overwrite flag value content with ENV
-					if err != nil {
-						log.Info().Str("OptionName", optionName).Str("optionValue", optionValue).
-							Msgf("Flag %s could not be set to %s", optionName, optionValue)
-
-						return
-					}
-				} else { // anything else needs value set into config map
-					c.ConfigMap[variableName].SetValue(flag.Value, flag.Value.Type())
+			if _, found := c.ConfigMap[variableName]; !found {
+				return
+			}
+
+			optionValue := cast.ToString(c.viper.Get(flag.Name))
+			if !flag.Changed && optionValue != flag.Value.String() {
+				if err := flag.Value.Set(optionValue); err != nil {
+					log.Info().Str("OptionName", flag.Name).Str("optionValue", optionValue).
+						Msgf("Flag %s could not be set to %s", flag.Name, optionValue)
+
+					return
 				}
 			}
+
+			c.ConfigMap[variableName].SetValue(flag.Value.String(), flag.Value.Type())
 		},
 	)
 }
+
+// UseProfile makes a profile the current-profile of the configuration file that was read. The file
+// is written back in its own format with the values it held; comments are not kept.
+func (c *Cmd) UseProfile(name string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.viper == nil || !c.configRead {
+		return ErrNoConfigFile
+	}
+
+	name = strings.ToLower(name)
+	if _, ok := c.Config.Profiles[name]; !ok {
+		return fmt.Errorf("%w: %s", config.ErrUnknownProfile, name)
+	}
+
+	configFile := c.viper.ConfigFileUsed()
+
+	configType, err := configFileType(configFile)
+	if err != nil {
+		return err
+	}
+
+	// Read the file again so that defaults, environment variables and flags are not written to it.
+	file := viper.New()
+	file.SetConfigFile(configFile)
+	file.SetConfigType(configType)
+
+	if err := file.ReadInConfig(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, configFile, err)
+	}
+
+	file.Set("current-profile", name)
+
+	if err := file.WriteConfig(); err != nil {
+		return fmt.Errorf("ERROR: writing %s failed: %w", configFile, err)
+	}
+
+	c.Config.CurrentProfile = name
+	if !c.viper.IsSet("profile") {
+		c.Config.Profile = name
+	}
+
+	return nil
+}
+
+// WatchConfig reloads the configuration whenever the configuration file changes and passes the new
+// typed Config to onChange, which runs on the goroutine of the watcher. Changes are reloaded once the
+// file has been left alone for configReloadDelay. A file that cannot be read, is empty or does not
+// decode is logged and the configuration in effect is kept. Reloading only updates Config; the flags
+// and the ConfigMap keep the values the command started with. It is meant for daemons, and only
+// works after the command has started and read a configuration file.
+func (c *Cmd) WatchConfig(onChange func(*config.Config)) error {
+	configFile, ok := c.configFileRead()
+	if !ok {
+		return ErrNoConfigFile
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("ERROR: watching %s failed: %w", configFile, err)
+	}
+
+	// The directory is watched so that files replaced by a rename, and the symlinks of Kubernetes
+	// ConfigMaps, are followed.
+	if err := watcher.Add(filepath.Dir(configFile)); err != nil {
+		watcher.Close()
+
+		return fmt.Errorf("ERROR: watching %s failed: %w", configFile, err)
+	}
+
+	// The timer may fire again while a reload is running, so reloads and onChange are serialized.
+	var reloading sync.Mutex
+
+	reload := func() {
+		reloading.Lock()
+		defer reloading.Unlock()
+
+		loaded, err := c.reloadConfig(configFile)
+		if err != nil {
+			log.Error().Err(err).Str("Config", configFile).Msg("config file could not be reloaded")
+
+			return
+		}
+
+		log.Info().Str("Config", configFile).Msg("config file reloaded")
+
+		if onChange != nil {
+			onChange(loaded)
+		}
+	}
+
+	go func() {
+		realConfigFile, _ := filepath.EvalSymlinks(configFile)
+
+		var timer *time.Timer
+
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				currentConfigFile, _ := filepath.EvalSymlinks(configFile)
+				if filepath.Clean(event.Name) != configFile && currentConfigFile == realConfigFile {
+					continue
+				}
+
+				realConfigFile = currentConfigFile
+
+				if timer == nil {
+					timer = time.AfterFunc(configReloadDelay, reload)
+				} else {
+					timer.Reset(configReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Error().Err(err).Str("Config", configFile).Msg("config file watcher failed")
+			}
+		}
+	}()
+
+	return nil
+}
+
+// configFileRead returns the configuration file the command read, if it read one.
+func (c *Cmd) configFileRead() (string, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.viper == nil || !c.configRead {
+		return "", false
+	}
+
+	return filepath.Clean(c.viper.ConfigFileUsed()), true
+}
+
+// reloadConfig reads the configuration file again on top of the defaults, environment variables and
+// flags, and stores the result in Config while holding the lock CurrentConfig takes.
+func (c *Cmd) reloadConfig(configFile string) (*config.Config, error) {
+	content, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, configFile, err)
+	}
+
+	if len(bytes.TrimSpace(content)) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyConfig, configFile)
+	}
+
+	configType, err := configFileType(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	mamba, err := c.newViper()
+	if err != nil {
+		return nil, err
+	}
+
+	mamba.SetConfigFile(configFile)
+	mamba.SetConfigType(configType)
+
+	if err := mamba.ReadConfig(bytes.NewReader(content)); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidConfig, configFile, err)
+	}
+
+	loaded, err := decodeConfig(mamba)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.viper = mamba
+	c.Config = loaded
+
+	return loaded, nil
+}
@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/config"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/spf13/cobra"
+)
+
+// newTestCommand returns a root command with a pool flag and a subcommand that runs it.
+func newTestCommand() (*Cmd, *Cmd) {
+	configMap := map[string]*ConfigMap{"POOL": {}}
+	configMap["POOL"].SetValue("rbd", helpers.TypeString)
+
+	root := NewCLICommand("test", "test", "test", "TEST", nil, configMap, nil)
+	root.CobraRoot.PersistentFlags().String("pool", "rbd", "pool")
+	sub := NewCLICommand("run", "run", "run", "TEST", root.CobraRoot, configMap, nil)
+	sub.CobraRoot.RunE = func(_ *cobra.Command, _ []string) error { return nil }
+
+	return root, sub
+}
+
+// TestConfiguration tests the precedence of defaults, files of each format, environment and flags.
+func TestConfiguration(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "pool: from-file\ntimeouts:\n  command: 30s\ncluster:\n  user: admin\n",
+		"config.yml":  "pool: from-file\ntimeouts:\n  command: 30s\ncluster:\n  user: admin\n",
+		"config.toml": "pool = \"from-file\"\n[timeouts]\ncommand = \"30s\"\n[cluster]\nuser = \"admin\"\n",
+		"config.json": `{"pool": "from-file", "timeouts": {"command": "30s"}, "cluster": {"user": "admin"}}`,
+		"config":      `{"pool": "from-file", "timeouts": {"command": "30s"}, "cluster": {"user": "admin"}}`,
+	}
+
+	for name, content := range files {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name        string
+			args        []string
+			env         map[string]string
+			wantPool    string
+			wantTimeout time.Duration
+		}{
+			{name: "TestFile", args: []string{"run", "--config", path}, wantPool: "from-file", wantTimeout: 30 * time.Second},
+			{
+				name: "TestEnvironment", args: []string{"run", "--config", path},
+				env:      map[string]string{"TEST_POOL": "from-env", "TEST_TIMEOUTS_COMMAND": "1m"},
+				wantPool: "from-env", wantTimeout: time.Minute,
+			},
+			{
+				name: "TestFlag", args: []string{"run", "--config", path, "--pool", "from-flag", "--timeout", "2"},
+				env:      map[string]string{"TEST_POOL": "from-env"},
+				wantPool: "from-flag", wantTimeout: 2 * time.Second,
+			},
+			{
+				name: "TestConfigEnvironment", args: []string{"run"},
+				env:      map[string]string{"TEST_CONFIG": path},
+				wantPool: "from-file", wantTimeout: 30 * time.Second,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				for variable, value := range tt.env {
+					t.Setenv(variable, value)
+				}
+
+				root, sub := newTestCommand()
+				root.CobraRoot.SetArgs(tt.args)
+
+				if err := root.CobraRoot.Execute(); err != nil {
+					t.Fatalf("Execute() error = %v", err)
+				}
+
+				if got := sub.ConfigMap["POOL"].GetValue(); got != tt.wantPool {
+					t.Errorf("ConfigMap[POOL] = %v, want %v", got, tt.wantPool)
+				}
+
+				if got := sub.Config.Pool; got != tt.wantPool {
+					t.Errorf("Config.Pool = %v, want %v", got, tt.wantPool)
+				}
+
+				if got := sub.Config.Timeouts.Command; got != tt.wantTimeout {
+					t.Errorf("Config.Timeouts.Command = %v, want %v", got, tt.wantTimeout)
+				}
+
+				if got := sub.Config.Cluster.User; got != "admin" {
+					t.Errorf("Config.Cluster.User = %v, want admin", got)
+				}
+			})
+		}
+	}
+}
+
+// TestConfigurationErrors tests that unreadable configuration files are reported.
+func TestConfigurationErrors(t *testing.T) {
+	directory := t.TempDir()
+	invalid := filepath.Join(directory, "config.yaml")
+
+	if err := os.WriteFile(invalid, []byte("pool: [unclosed\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr error
+	}{
+		{name: "TestMissing", args: []string{"run", "--config", filepath.Join(directory, "missing.yaml")}, wantErr: ErrInvalidConfig},
+		{name: "TestInvalid", args: []string{"run", "--config", invalid}, wantErr: ErrInvalidConfig},
+		{name: "TestFormat", args: []string{"run", "--config", filepath.Join(directory, "config.ini")}, wantErr: ErrUnsupportedConfigFormat},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, _ := newTestCommand()
+			root.CobraRoot.SetArgs(tt.args)
+			root.CobraRoot.SilenceErrors = true
+			root.CobraRoot.SilenceUsage = true
+
+			if err := root.CobraRoot.Execute(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Execute() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestWatchConfig tests that changes to the configuration file are reloaded once the file settles,
+// and that an empty file does not replace the configuration in effect.
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("pool: before\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	root, sub := newTestCommand()
+	root.CobraRoot.SetArgs([]string{"run", "--config", path})
+
+	if err := root.CobraRoot.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	reloaded := make(chan string, 16)
+	if err := sub.WatchConfig(func(cfg *config.Config) {
+		reloaded <- cfg.Pool
+	}); err != nil {
+		t.Fatalf("WatchConfig() error = %v", err)
+	}
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case pool := <-reloaded:
+		t.Fatalf("empty configuration file was reloaded, pool = %q", pool)
+	case <-time.After(3 * configReloadDelay):
+	}
+
+	for _, content := range []string{"pool: during\n", "pool: after\n"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case pool := <-reloaded:
+		if pool != "after" {
+			t.Errorf("reloaded pool = %q, want %q", pool, "after")
+		}
+
+		if got := sub.CurrentConfig().Pool; got != "after" {
+			t.Errorf("CurrentConfig().Pool = %q, want %q", got, "after")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("configuration was not reloaded")
+	}
+
+	select {
+	case pool := <-reloaded:
+		t.Errorf("configuration was reloaded again, pool = %q", pool)
+	case <-time.After(3 * configReloadDelay):
+	}
+}
@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+var (
+	ErrInvalidOutputFormat = errors.New("invalid output format")
+	ErrNoTableColumns      = errors.New("no table columns defined")
+)
+
+// Output formats accepted by NewPrinter. The template formats take their template after an '=',
+// for example 'go-template={{.name}}' or 'jsonpath={.images[*].name}'. JSONPath expressions follow
+// kubectl: fields, indexes and slices, '[*]', '..', filters like '[?(@.backend=="nbd")]',
+// '{range}...{end}' and quoted literals.
+const (
+	OutputTable      = "table"
+	OutputJSON       = "json"
+	OutputYAML       = "yaml"
+	OutputGoTemplate = "go-template"
+	OutputJSONPath   = "jsonpath"
+)
+
+// Printer renders structured results in the format chosen with --output. Templates see the
+// result the way it is written as JSON, so fields are referenced by their JSON names.
+type Printer struct {
+	format   string
+	template *template.Template
+	jsonPath *jsonpath.JSONPath
+}
+
+// NewPrinter parses an output format. An empty format prints a table.
+func NewPrinter(output string) (*Printer, error) {
+	format, expression, hasExpression := strings.Cut(output, "=")
+
+	switch format {
+	case "", OutputTable, OutputJSON, OutputYAML:
+		if hasExpression {
+			return nil, fmt.Errorf("%w: %s does not take a template", ErrInvalidOutputFormat, format)
+		}
+
+		if format == "" {
+			format = OutputTable
+		}
+
+		return &Printer{format: format}, nil
+	case OutputGoTemplate:
+		if expression == "" {
+			return nil, fmt.Errorf("%w: empty go-template", ErrInvalidOutputFormat)
+		}
+
+		parsed, err := template.New("output").Option("missingkey=error").Parse(expression)
+		if err != nil {
+			return nil, fmt.Errorf("%w: go-template %q: %v", ErrInvalidOutputFormat, expression, err) //nolint:errorlint
+		}
+
+		return &Printer{format: format, template: parsed}, nil
+	case OutputJSONPath:
+		if expression == "" {
+			return nil, fmt.Errorf("%w: empty jsonpath", ErrInvalidOutputFormat)
+		}
+
+		parsed := jsonpath.New("output")
+		if err := parsed.Parse(expression); err != nil {
+			return nil, fmt.Errorf("%w: jsonpath %q: %v", ErrInvalidOutputFormat, expression, err) //nolint:errorlint
+		}
+
+		return &Printer{format: format, jsonPath: parsed}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q must be %s, %s, %s, %s=<template> or %s=<expression>", ErrInvalidOutputFormat,
+		output, OutputTable, OutputJSON, OutputYAML, OutputGoTemplate, OutputJSONPath)
+}
+
+// Print writes a result to w.
+func (p *Printer) Print(w io.Writer, result interface{}) error {
+	switch p.format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(result) //nolint:wrapcheck
+	case OutputYAML:
+		generic, err := toGeneric(result)
+		if err != nil {
+			return err
+		}
+
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+
+		if err := encoder.Encode(generic); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		return encoder.Close() //nolint:wrapcheck
+	case OutputGoTemplate:
+		generic, err := toGeneric(result)
+		if err != nil {
+			return err
+		}
+
+		if err := p.template.Execute(w, generic); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		_, err = fmt.Fprintln(w)
+
+		return err //nolint:wrapcheck
+	case OutputJSONPath:
+		generic, err := toGeneric(result)
+		if err != nil {
+			return err
+		}
+
+		if err := p.jsonPath.Execute(w, fromNumbers(generic)); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		_, err = fmt.Fprintln(w)
+
+		return err //nolint:wrapcheck
+	}
+
+	return printTable(w, result)
+}
+
+// toGeneric converts a result to the maps, slices and values its JSON encoding decodes to.
+func toGeneric(result interface{}) (interface{}, error) {
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var generic interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return generic, nil
+}
+
+// fromNumbers replaces the json.Number values of a generic result with int64 or float64 values, so
+// that jsonpath filters compare them as numbers.
+func fromNumbers(generic interface{}) interface{} {
+	switch value := generic.(type) {
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = fromNumbers(element)
+		}
+	case []interface{}:
+		for index, element := range value {
+			value[index] = fromNumbers(element)
+		}
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+
+		if float, err := value.Float64(); err == nil {
+			return float
+		}
+	}
+
+	return generic
+}
+
+// printTable writes a result as a table with the columns defined for its type.
+func printTable(w io.Writer, result interface{}) error {
+	value := reflect.ValueOf(result)
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+
+	table, ok := tables()[value.Type()]
+	if !ok {
+		return fmt.Errorf("%w: %s, use --output json or yaml", ErrNoTableColumns, value.Type())
+	}
+
+	var rows []interface{}
+
+	switch {
+	case table.Rows != nil:
+		rows = table.Rows(value.Interface())
+	case value.Kind() == reflect.Slice:
+		for index := 0; index < value.Len(); index++ {
+			rows = append(rows, value.Index(index).Interface())
+		}
+	default:
+		rows = []interface{}{value.Interface()}
+	}
+
+	writer := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+
+	headers := make([]string, 0, len(table.Columns))
+	for _, column := range table.Columns {
+		headers = append(headers, column.Header)
+	}
+
+	fmt.Fprintln(writer, strings.Join(headers, "\t"))
+
+	for _, row := range rows {
+		cells := make([]string, 0, len(table.Columns))
+		for _, column := range table.Columns {
+			cells = append(cells, column.Cell(row))
+		}
+
+		fmt.Fprintln(writer, strings.Join(cells, "\t"))
+	}
+
+	return writer.Flush() //nolint:wrapcheck
+}
@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+var testMapped = &rbd.ShowMapped{
+	{ID: "0", Pool: "rbd", Name: "test1", Snap: "-", Device: "/dev/rbd0", Backend: rbd.MapBackendKRBD},
+	{ID: "4026", Pool: "rbd", Namespace: "tenant1", Name: "test2", Snap: "-", Device: "/dev/nbd0", Backend: rbd.MapBackendNBD},
+}
+
+// TestPrinter tests every output format against the same result.
+func TestPrinter(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		result interface{}
+		want   string
+	}{
+		{
+			name:   "TestTable",
+			output: "",
+			result: testMapped,
+			want: "ID     POOL   NAMESPACE   IMAGE   SNAP   DEVICE      BACKEND\n" +
+				"0      rbd                test1   -      /dev/rbd0   krbd\n" +
+				"4026   rbd    tenant1     test2   -      /dev/nbd0   nbd\n",
+		},
+		{
+			name:   "TestTablePools",
+			output: "table",
+			result: &ceph.OSDPoolList{"rbd", "docker-ssd"},
+			want:   "NAME\nrbd\ndocker-ssd\n",
+		},
+		{
+			name:   "TestJSON",
+			output: "json",
+			result: rbd.ImageSpec{Pool: "rbd", Image: "test1"},
+			want:   "{\n  \"pool\": \"rbd\",\n  \"namespace\": \"\",\n  \"image\": \"test1\",\n  \"snapshot\": \"\"\n}\n",
+		},
+		{
+			name:   "TestYAML",
+			output: "yaml",
+			result: []*rbd.Lock{{ID: "auto 1", Locker: "client.4157", Address: "10.0.0.1:0/1"}},
+			want:   "- address: 10.0.0.1:0/1\n  id: auto 1\n  locker: client.4157\n",
+		},
+		{
+			name:   "TestGoTemplate",
+			output: `go-template={{range .}}{{.device}} {{end}}`,
+			result: testMapped,
+			want:   "/dev/rbd0 /dev/nbd0 \n",
+		},
+		{
+			name:   "TestJSONPath",
+			output: "jsonpath={[*].device}",
+			result: testMapped,
+			want:   "/dev/rbd0 /dev/nbd0\n",
+		},
+		{
+			name:   "TestJSONPathRange",
+			output: `jsonpath={range [*]}{.name}{"\t"}{.backend}{"\n"}{end}`,
+			result: testMapped,
+			want:   "test1\tkrbd\ntest2\tnbd\n\n",
+		},
+		{
+			name:   "TestJSONPathIndex",
+			output: "jsonpath={[-1]['namespace']}",
+			result: testMapped,
+			want:   "tenant1\n",
+		},
+		{
+			name:   "TestJSONPathFilter",
+			output: `jsonpath={[?(@.backend=="nbd")].device}`,
+			result: testMapped,
+			want:   "/dev/nbd0\n",
+		},
+		{
+			name:   "TestJSONPathSlice",
+			output: "jsonpath={[0:1].name}",
+			result: testMapped,
+			want:   "test1\n",
+		},
+		{
+			name:   "TestJSONPathRecursive",
+			output: "jsonpath={..pool}",
+			result: testMapped,
+			want:   "rbd rbd\n",
+		},
+		{
+			name:   "TestJSONPathNumberFilter",
+			output: "jsonpath={[?(@.size>1073741824)].name}",
+			result: []map[string]interface{}{{"name": "small", "size": 1073741824}, {"name": "large", "size": 10737418240}},
+			want:   "large\n",
+		},
+		{
+			name:   "TestJSONPathLiteral",
+			output: `jsonpath={.image}{"}"}`,
+			result: rbd.ImageSpec{Pool: "rbd", Image: "test1"},
+			want:   "test1}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			printer, err := NewPrinter(tt.output)
+			if err != nil {
+				t.Fatalf("NewPrinter() error = %v", err)
+			}
+
+			var out bytes.Buffer
+			if err := printer.Print(&out, tt.result); err != nil {
+				t.Fatalf("Print() error = %v", err)
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("Print() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestNewPrinterInvalid tests that malformed formats are rejected before anything runs.
+func TestNewPrinterInvalid(t *testing.T) {
+	for _, output := range []string{"xml", "json=x", "go-template=", "go-template={{.name", "jsonpath=", "jsonpath={.a", "jsonpath={.a[}", "jsonpath={[?(@.a}", "jsonpath={[1:x]}"} {
+		if _, err := NewPrinter(output); !errors.Is(err, ErrInvalidOutputFormat) {
+			t.Errorf("NewPrinter(%q) error = %v, want %v", output, err, ErrInvalidOutputFormat)
+		}
+	}
+
+	printer, _ := NewPrinter("jsonpath={.missing}")
+	if err := printer.Print(&bytes.Buffer{}, rbd.ImageSpec{}); err == nil {
+		t.Error("Print() of a missing field error = <nil>")
+	}
+
+	printer, _ = NewPrinter("table")
+	if err := printer.Print(&bytes.Buffer{}, struct{}{}); !errors.Is(err, ErrNoTableColumns) {
+		t.Errorf("Print() unknown type error = %v, want %v", err, ErrNoTableColumns)
+	}
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+)
+
+// Runner adds the connection options of a cluster to the ceph, rbd, rbd-nbd and radosgw-admin
+// commands it runs through Next, and runs any other command unchanged. A nil Next runs commands on the local host.
+type Runner struct {
+	Config *Config
+	Next   helpers.Runner
+}
+
+// Run executes the command with the --cluster, --conf, --id and --keyring options that are set.
+func (r *Runner) Run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	next := r.Next
+	if next == nil {
+		next = helpers.DefaultRunner
+	}
+
+	switch command {
+	case "ceph", "rbd", "rbd-nbd", "radosgw-admin":
+		return next.Run(ctx, command, append(r.Config.Arguments(), args...)...) //nolint:wrapcheck
+	}
+
+	return next.Run(ctx, command, args...) //nolint:wrapcheck
+}
+
+// Arguments returns the options the Ceph command line tools take to connect to the cluster.
+func (c *Config) Arguments() []string {
+	if c == nil {
+		return nil
+	}
+
+	var args []string
+
+	if c.name != "" {
+		args = append(args, "--cluster", c.name)
+	}
+
+	if c.conf != "" {
+		args = append(args, "--conf", c.conf)
+	}
+
+	if c.user != "" {
+		args = append(args, "--id", strings.TrimPrefix(c.user, "client."))
+	}
+
+	if c.keyring != "" {
+		args = append(args, "--keyring", c.keyring)
+	}
+
+	return args
+}
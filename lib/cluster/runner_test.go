@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+// TestRunner tests that the connection options are only added to the Ceph command line tools.
+func TestRunner(t *testing.T) {
+	config := &Config{}
+	config.SetName("backup")
+	config.SetConfPath("/etc/ceph/backup.conf")
+	config.SetUser("client.admin")
+	config.SetKeyringPath("/etc/ceph/backup.client.admin.keyring")
+
+	const options = "--cluster backup --conf /etc/ceph/backup.conf --id admin " +
+		"--keyring /etc/ceph/backup.client.admin.keyring"
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+	}{
+		{name: "TestCeph", command: "ceph", args: []string{"df"}, want: "ceph " + options + " df"},
+		{name: "TestRBD", command: "rbd", args: []string{"ls"}, want: "rbd " + options + " ls"},
+		{name: "TestRBDNBD", command: "rbd-nbd", args: []string{"list-mapped"}, want: "rbd-nbd " + options + " list-mapped"},
+		{
+			name: "TestRadosGateway", command: "radosgw-admin", args: []string{"user", "list"},
+			want: "radosgw-admin " + options + " user list",
+		},
+		{name: "TestOther", command: "lsblk", args: []string{"-J"}, want: "lsblk -J"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &helperstest.Runner{}
+
+			if _, err := (&Runner{Config: config, Next: next}).Run(context.Background(), tt.command, tt.args...); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			if !next.Called(tt.want) {
+				t.Errorf("Run() calls = %q, want %q", next.Calls, tt.want)
+			}
+		})
+	}
+
+	next := &helperstest.Runner{}
+
+	_, err := (&Runner{Next: next}).Run(context.Background(), "radosgw-admin", "user", "list")
+	if err != nil || !next.Called("radosgw-admin user list") {
+		t.Errorf("Run() without a config calls = %q, %v", next.Calls, err)
+	}
+}
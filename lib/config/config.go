@@ -0,0 +1,193 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/cluster"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultPool       = "rbd"
+	defaultFilesystem = "xfs"
+)
+
+var (
+	ErrUnknownProfile = errors.New("profile does not exist")
+	ErrNoProfile      = errors.New("no profile is selected")
+)
+
+// Config is the typed configuration of the application. It is read from the configuration file,
+// whose format follows its extension, and overridden by environment variables and flags:
+//
+//	cluster:
+//	  name: ceph
+//	  conf: /etc/ceph/ceph.conf
+//	  user: admin
+//	  keyring: /etc/ceph/ceph.client.admin.keyring
+//	pool: rbd
+//	filesystem: xfs
+//	timeouts:
+//	  command: 5s
+//	  make-filesystem: 5m
+//	current-profile: prod
+//	profiles:
+//	  prod:
+//	    conf: /etc/ceph/prod.conf
+//	    user: admin
+//	    keyring: /etc/ceph/prod.client.admin.keyring
+//	    pool: docker-ssd
+//	  dr:
+//	    conf: /etc/ceph/dr.conf
+//	    user: admin
+//	    pool: docker-hdd
+//
+// Profile is the name of the profile in use, from --profile or current-profile.
+type Config struct {
+	Cluster        Cluster            `mapstructure:"cluster"`
+	Pool           string             `mapstructure:"pool"`
+	Filesystem     string             `mapstructure:"filesystem"`
+	Timeouts       Timeouts           `mapstructure:"timeouts"`
+	CurrentProfile string             `mapstructure:"current-profile"`
+	Profiles       map[string]Profile `mapstructure:"profiles"`
+	Profile        string             `mapstructure:"-"`
+}
+
+// Cluster holds how the ceph, rbd and rbd-nbd commands connect to the cluster. Empty fields use
+// the defaults of those commands.
+type Cluster struct {
+	Name    string `json:"cluster" mapstructure:"name"`
+	Conf    string `json:"conf" mapstructure:"conf"`
+	User    string `json:"user" mapstructure:"user"`
+	Keyring string `json:"keyring" mapstructure:"keyring"`
+}
+
+// Profile is a named cluster and the pool used on it when none is given. The fields a profile sets
+// replace the cluster section and pool at the top of the configuration file.
+type Profile struct {
+	Cluster `mapstructure:",squash"`
+	Pool    string `json:"pool" mapstructure:"pool"`
+}
+
+// NamedProfile
+/*
+scattered-storage config profiles list -o json
+[{"name":"dr","current":false,"cluster":"","conf":"/etc/ceph/dr.conf","user":"admin","keyring":"","pool":"docker-hdd"}]
+NamedProfile is used to list the profiles of the configuration file.
+*/
+type NamedProfile struct {
+	Name    string `json:"name"`
+	Current bool   `json:"current"`
+	Profile
+}
+
+// ProfileList is the list of profiles of the configuration, sorted by name.
+type ProfileList []*NamedProfile
+
+// Timeouts limit how long commands may run. Command applies to most commands and MakeFilesystem
+// to mkfs, which takes longer on large images. Zero keeps the default of each client.
+type Timeouts struct {
+	Command        time.Duration `mapstructure:"command"`
+	MakeFilesystem time.Duration `mapstructure:"make-filesystem"`
+}
+
+// SetDefaults registers the default of every key, which also lets environment variables such as
+// SCATTERED_STORAGE_CLUSTER_USER set keys that appear nowhere else.
+func SetDefaults(mamba *viper.Viper) {
+	mamba.SetDefault("cluster.name", "")
+	mamba.SetDefault("cluster.conf", "")
+	mamba.SetDefault("cluster.user", "")
+	mamba.SetDefault("cluster.keyring", "")
+	mamba.SetDefault("pool", defaultPool)
+	mamba.SetDefault("filesystem", defaultFilesystem)
+	mamba.SetDefault("timeouts.command", time.Duration(0))
+	mamba.SetDefault("timeouts.make-filesystem", time.Duration(0))
+	mamba.SetDefault("current-profile", "")
+}
+
+// SelectProfile merges the profile chosen with the profile key, or else with current-profile, over
+// the cluster section and pool of the configuration file, below environment variables and flags.
+// Profile names are not case-sensitive. It returns the name of the profile, which is empty when
+// none is chosen.
+func SelectProfile(mamba *viper.Viper) (string, error) {
+	name := strings.ToLower(mamba.GetString("profile"))
+	if name == "" {
+		name = strings.ToLower(mamba.GetString("current-profile"))
+	}
+
+	if name == "" {
+		return "", nil
+	}
+
+	profiles := mamba.GetStringMap("profiles")
+
+	profile, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+
+	cluster := map[string]interface{}{}
+	overrides := map[string]interface{}{"cluster": cluster}
+
+	for key, value := range profile {
+		if key == "pool" {
+			overrides[key] = value
+		} else {
+			cluster[key] = value
+		}
+	}
+
+	if err := mamba.MergeConfigMap(overrides); err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	return name, nil
+}
+
+// ProfileList returns the profiles of the configuration, marking the one in use.
+func (c *Config) ProfileList() ProfileList {
+	list := make(ProfileList, 0, len(c.Profiles))
+
+	for name, profile := range c.Profiles {
+		list = append(list, &NamedProfile{Name: name, Current: name == c.Profile, Profile: profile})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list
+}
+
+// NamedProfile returns a profile by name, or the profile in use when name is empty.
+func (c *Config) NamedProfile(name string) (*NamedProfile, error) {
+	if name == "" {
+		name = c.Profile
+	}
+
+	if name == "" {
+		return nil, ErrNoProfile
+	}
+
+	name = strings.ToLower(name)
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+
+	return &NamedProfile{Name: name, Current: name == c.Profile, Profile: profile}, nil
+}
+
+// ClusterConfig returns the cluster section as a cluster.Config.
+func (c *Cluster) ClusterConfig() *cluster.Config {
+	result := &cluster.Config{}
+	result.SetName(c.Name)
+	result.SetConfPath(c.Conf)
+	result.SetUser(c.User)
+	result.SetKeyringPath(c.Keyring)
+
+	return result
+}
@@ -0,0 +1,99 @@
+package csi
+
+import (
+	"fmt"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+//nolint:gochecknoglobals
+var (
+	// accessModes are the access modes of volumes. Images are locked by the host mapping them, like
+	// Docker volumes, so they are only used by a single node.
+	accessModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER:        true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY:   true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_SINGLE_WRITER: true,
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_MULTI_WRITER:  true,
+	}
+
+	// readOnlyModes are the access modes staged read-only.
+	readOnlyModes = map[csi.VolumeCapability_AccessMode_Mode]bool{
+		csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY: true,
+	}
+
+	// mountFlags are the mount flags of filesystem volumes, such as the mountOptions of a
+	// StorageClass, and the MountOptions they set.
+	mountFlags = map[string]func(options *rbd.MountOptions){
+		"ro":        func(options *rbd.MountOptions) { options.ReadOnly = true },
+		"noatime":   func(options *rbd.MountOptions) { options.NoAtime = true },
+		"nodiscard": func(options *rbd.MountOptions) { options.NoDiscard = true },
+		"prjquota":  func(options *rbd.MountOptions) { options.ProjectQuota = true },
+		"nouuid":    func(options *rbd.MountOptions) { options.NoUUID = true },
+	}
+)
+
+// validateCapabilities checks that volumes can be used with every capability: as a block device or
+// as an XFS or ext4 filesystem, on a single node.
+func validateCapabilities(capabilities []*csi.VolumeCapability) error {
+	if len(capabilities) == 0 {
+		return fmt.Errorf("%w: volume capabilities", ErrMissingArgument)
+	}
+
+	for _, capability := range capabilities {
+		if err := validateCapability(capability); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateCapability checks a single capability.
+func validateCapability(capability *csi.VolumeCapability) error {
+	if capability == nil {
+		return fmt.Errorf("%w: volume capability", ErrMissingArgument)
+	}
+
+	if mode := capability.GetAccessMode().GetMode(); !accessModes[mode] {
+		return fmt.Errorf("%w: access mode %s", ErrUnsupportedCapability, mode)
+	}
+
+	if capability.GetBlock() != nil {
+		return nil
+	}
+
+	if capability.GetMount() == nil {
+		return fmt.Errorf("%w: access type must be block or mount", ErrUnsupportedCapability)
+	}
+
+	_, err := mountOptions(capability)
+
+	return err
+}
+
+// mountOptions returns the options mounting a filesystem volume with a capability.
+func mountOptions(capability *csi.VolumeCapability) (*rbd.MountOptions, error) {
+	mount := capability.GetMount()
+	options := &rbd.MountOptions{
+		Filesystem: mount.GetFsType(),
+		ReadOnly:   readOnlyModes[capability.GetAccessMode().GetMode()],
+	}
+
+	for _, flag := range mount.GetMountFlags() {
+		set, ok := mountFlags[flag]
+		if !ok {
+			return nil, fmt.Errorf("%w: mount flag %q is not supported", validators.ErrInvalidMountOptions, flag)
+		}
+
+		set(options)
+	}
+
+	if err := options.Validate(); err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return options, nil
+}
@@ -0,0 +1,349 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// DefaultVolumeSize is the size of volumes created without a capacity range.
+	DefaultVolumeSize = units.GiB
+
+	// parameterPool and parameterNamespace are the StorageClass parameters choosing where images
+	// are created.
+	parameterPool      = "pool"
+	parameterNamespace = "namespace"
+
+	// parameterPrefix is reserved for the parameters Kubernetes passes to every driver.
+	parameterPrefix = "csi.storage.k8s.io/"
+)
+
+//nolint:gochecknoglobals
+var controllerCapabilities = []csi.ControllerServiceCapability_RPC_Type{
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
+	csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+	csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
+	csi.ControllerServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
+
+// CreateVolume creates an image named after the volume in the pool and namespace given by the
+// 'pool' and 'namespace' parameters. Creating a volume that exists already succeeds when its size
+// fits the capacity range.
+func (d *Driver) CreateVolume(ctx context.Context, request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	log.Trace().Str("Name", request.GetName()).Msg("csi CreateVolume")
+
+	if request.GetName() == "" {
+		return nil, missing("name")
+	}
+
+	if err := validateCapabilities(request.GetVolumeCapabilities()); err != nil {
+		return nil, statusError(err)
+	}
+
+	if request.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "volumes cannot be created from a snapshot or volume")
+	}
+
+	spec, err := d.imageSpec(request.GetName(), request.GetParameters())
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	size, err := requestedSize(request.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+
+	client := d.client(ctx)
+
+	if err := client.CreateRBD(spec, size); errors.Is(err, validators.ErrRBDExists) {
+		image, err := client.GetImageInfo(spec)
+		if err != nil {
+			return nil, statusError(err)
+		}
+
+		size = units.Size(image.Size)
+		if !fits(size, request.GetCapacityRange()) {
+			return nil, status.Errorf(codes.AlreadyExists, "volume %s exists with a size of %s", spec.String(), size)
+		}
+	} else if err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{VolumeId: spec.String(), CapacityBytes: int64(size.Bytes())},
+	}, nil
+}
+
+// DeleteVolume removes the image of a volume. Volumes that do not exist are deleted already.
+// Images that are mapped or have snapshots cannot be removed.
+func (d *Driver) DeleteVolume(ctx context.Context, request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Msg("csi DeleteVolume")
+
+	if request.GetVolumeId() == "" {
+		return nil, missing("volume ID")
+	}
+
+	spec, err := volumeSpec(request.GetVolumeId())
+	if err != nil {
+		log.Debug().Str("Volume", request.GetVolumeId()).Str("Error", err.Error()).Msg("not a volume of this driver")
+
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if err := d.client(ctx).DeleteRBD(spec); err != nil && !notFound(err) {
+		return nil, statusError(err)
+	}
+
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ValidateVolumeCapabilities confirms the capabilities of an existing volume when the driver
+// supports all of them.
+func (d *Driver) ValidateVolumeCapabilities(
+	ctx context.Context, request *csi.ValidateVolumeCapabilitiesRequest,
+) (*csi.ValidateVolumeCapabilitiesResponse, error) {
+	if request.GetVolumeId() == "" {
+		return nil, missing("volume ID")
+	}
+
+	if len(request.GetVolumeCapabilities()) == 0 {
+		return nil, missing("volume capabilities")
+	}
+
+	if _, _, err := d.existingVolume(ctx, request.GetVolumeId()); err != nil {
+		return nil, err
+	}
+
+	if err := validateCapabilities(request.GetVolumeCapabilities()); err != nil {
+		return &csi.ValidateVolumeCapabilitiesResponse{Message: err.Error()}, nil
+	}
+
+	return &csi.ValidateVolumeCapabilitiesResponse{
+		Confirmed: &csi.ValidateVolumeCapabilitiesResponse_Confirmed{
+			VolumeContext:      request.GetVolumeContext(),
+			VolumeCapabilities: request.GetVolumeCapabilities(),
+			Parameters:         request.GetParameters(),
+		},
+	}, nil
+}
+
+// ControllerGetCapabilities announces volume creation, snapshots and expansion.
+func (d *Driver) ControllerGetCapabilities(
+	context.Context, *csi.ControllerGetCapabilitiesRequest,
+) (*csi.ControllerGetCapabilitiesResponse, error) {
+	response := &csi.ControllerGetCapabilitiesResponse{}
+
+	for _, capability := range controllerCapabilities {
+		response.Capabilities = append(response.Capabilities, &csi.ControllerServiceCapability{
+			Type: &csi.ControllerServiceCapability_Rpc{Rpc: &csi.ControllerServiceCapability_RPC{Type: capability}},
+		})
+	}
+
+	return response, nil
+}
+
+// CreateSnapshot takes a snapshot of the image of a volume, named after the snapshot. Taking a
+// snapshot that exists already returns it. Snapshot names are only checked against the snapshots
+// of the source volume.
+func (d *Driver) CreateSnapshot(
+	ctx context.Context, request *csi.CreateSnapshotRequest,
+) (*csi.CreateSnapshotResponse, error) {
+	log.Trace().Str("Volume", request.GetSourceVolumeId()).Str("Name", request.GetName()).Msg("csi CreateSnapshot")
+
+	if request.GetSourceVolumeId() == "" {
+		return nil, missing("source volume ID")
+	}
+
+	if request.GetName() == "" {
+		return nil, missing("name")
+	}
+
+	source, err := volumeSpec(request.GetSourceVolumeId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	spec := source
+	spec.Snapshot = request.GetName()
+	client := d.client(ctx)
+
+	if err := client.CreateSnapshot(spec); err != nil && !errors.Is(err, validators.ErrSnapshotExists) {
+		return nil, statusError(err)
+	}
+
+	snapshots, err := client.ListSnapshots(source)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	for _, snapshot := range snapshots {
+		if snapshot.Name == spec.Snapshot {
+			return &csi.CreateSnapshotResponse{
+				Snapshot: &csi.Snapshot{
+					SnapshotId:     spec.String(),
+					SourceVolumeId: request.GetSourceVolumeId(),
+					SizeBytes:      snapshot.Size,
+					CreationTime:   creationTime(snapshot.Timestamp),
+					ReadyToUse:     true,
+				},
+			}, nil
+		}
+	}
+
+	return nil, status.Errorf(codes.Internal, "snapshot %s was not listed after it was taken", spec.String())
+}
+
+// DeleteSnapshot removes a snapshot. Snapshots that do not exist are deleted already.
+func (d *Driver) DeleteSnapshot(
+	ctx context.Context, request *csi.DeleteSnapshotRequest,
+) (*csi.DeleteSnapshotResponse, error) {
+	log.Trace().Str("Snapshot", request.GetSnapshotId()).Msg("csi DeleteSnapshot")
+
+	if request.GetSnapshotId() == "" {
+		return nil, missing("snapshot ID")
+	}
+
+	spec, err := snapshotSpec(request.GetSnapshotId())
+	if err != nil {
+		log.Debug().Str("Snapshot", request.GetSnapshotId()).Str("Error", err.Error()).Msg("not a snapshot of this driver")
+
+		return &csi.DeleteSnapshotResponse{}, nil
+	}
+
+	if err := d.client(ctx).RemoveSnapshot(spec); err != nil && !notFound(err) {
+		return nil, statusError(err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ControllerExpandVolume grows the image of a volume. Images are never shrunk: a volume at least as
+// large as asked for is left alone. Filesystem volumes are grown by NodeExpandVolume afterwards.
+func (d *Driver) ControllerExpandVolume(
+	ctx context.Context, request *csi.ControllerExpandVolumeRequest,
+) (*csi.ControllerExpandVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Msg("csi ControllerExpandVolume")
+
+	if request.GetVolumeId() == "" {
+		return nil, missing("volume ID")
+	}
+
+	if request.GetCapacityRange() == nil {
+		return nil, missing("capacity range")
+	}
+
+	size, err := requestedSize(request.GetCapacityRange())
+	if err != nil {
+		return nil, err
+	}
+
+	spec, image, err := d.existingVolume(ctx, request.GetVolumeId())
+	if err != nil {
+		return nil, err
+	}
+
+	if current := units.Size(image.Size); current >= size {
+		size = current
+	} else if err := d.client(ctx).ResizeRBD(spec, size, false); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         int64(size.Bytes()),
+		NodeExpansionRequired: request.GetVolumeCapability().GetBlock() == nil,
+	}, nil
+}
+
+// imageSpec returns the spec of the image of a new volume from the StorageClass parameters.
+func (d *Driver) imageSpec(name string, parameters map[string]string) (rbd.ImageSpec, error) {
+	spec := rbd.ImageSpec{Pool: d.defaultPool(), Image: name}
+
+	for key, value := range parameters {
+		switch {
+		case key == parameterPool:
+			spec.Pool = value
+		case key == parameterNamespace:
+			spec.Namespace = value
+		case strings.HasPrefix(key, parameterPrefix):
+		default:
+			return rbd.ImageSpec{}, fmt.Errorf("%w: %s", ErrUnsupportedParameter, key)
+		}
+	}
+
+	if spec.Pool == "" {
+		return rbd.ImageSpec{}, ErrNoPool
+	}
+
+	return spec, spec.Validate()
+}
+
+// existingVolume returns the spec and image of a volume, or a NotFound status.
+func (d *Driver) existingVolume(ctx context.Context, volumeID string) (rbd.ImageSpec, *rbd.RBD, error) {
+	spec, err := volumeSpec(volumeID)
+	if err != nil {
+		return rbd.ImageSpec{}, nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	image, err := d.client(ctx).GetImageInfo(spec)
+	if err != nil {
+		return rbd.ImageSpec{}, nil, statusError(err)
+	}
+
+	return spec, image, nil
+}
+
+// requestedSize returns the size of a capacity range rounded up to the object size of new images,
+// or DefaultVolumeSize without a range.
+func requestedSize(capacity *csi.CapacityRange) (units.Size, error) {
+	required, limit := capacity.GetRequiredBytes(), capacity.GetLimitBytes()
+	if required < 0 || limit < 0 || (limit > 0 && required > limit) {
+		return 0, status.Errorf(codes.OutOfRange, "invalid capacity range %d to %d bytes", required, limit)
+	}
+
+	size := DefaultVolumeSize
+	if required > 0 {
+		size = units.Size(required)
+	} else if limit > 0 && units.Size(limit) < size {
+		size = units.Size(limit)
+	}
+
+	size = size.AlignUp(rbd.DefaultObjectSize)
+	if limit > 0 && size > units.Size(limit) {
+		return 0, status.Errorf(codes.OutOfRange, "%d bytes cannot hold a whole number of %s objects",
+			limit, rbd.DefaultObjectSize)
+	}
+
+	return size, nil
+}
+
+// fits reports whether the size of an existing volume satisfies a capacity range.
+func fits(size units.Size, capacity *csi.CapacityRange) bool {
+	required, limit := capacity.GetRequiredBytes(), capacity.GetLimitBytes()
+
+	return size >= units.Size(required) && (limit == 0 || size <= units.Size(limit))
+}
+
+// creationTime converts the timestamp of rbd snap ls, or returns nil when it cannot be parsed.
+func creationTime(timestamp string) *timestamppb.Timestamp {
+	created, err := time.ParseInLocation(time.ANSIC, timestamp, time.Local)
+	if err != nil {
+		log.Debug().Str("Timestamp", timestamp).Str("Error", err.Error()).Msg("snapshot timestamp not parsed")
+
+		return nil
+	}
+
+	return timestamppb.New(created)
+}
@@ -0,0 +1,118 @@
+// Package csi implements the Identity, Controller and Node services of the Container Storage
+// Interface with lib/rbd, so that Kubernetes creates, maps and mounts images the way the
+// scattered-storage commands do for Docker: one image per volume, named after the volume, with an
+// XFS filesystem on a single partition unless another filesystem is asked for.
+package csi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"google.golang.org/grpc"
+)
+
+// DefaultName is the name the driver registers with, which StorageClasses use as provisioner.
+const DefaultName = "rbd.scattered.network"
+
+var (
+	ErrMissingArgument       = errors.New("missing argument")
+	ErrUnsupportedCapability = errors.New("unsupported volume capability")
+	ErrUnsupportedParameter  = errors.New("unsupported parameter")
+	ErrNoPool                = errors.New("no pool given and no default pool configured")
+)
+
+// Driver serves the three CSI services. Name and Version identify the driver, NodeID names the
+// host the Node service runs on and DefaultPool holds the images of StorageClasses without a
+// 'pool' parameter. Reconfigure swaps the client and default pool of a running Driver.
+type Driver struct {
+	csi.UnimplementedIdentityServer
+	csi.UnimplementedControllerServer
+	csi.UnimplementedNodeServer
+
+	Name        string
+	Version     string
+	NodeID      string
+	DefaultPool string
+	RBD         *rbd.RadosBlockDeviceClient
+
+	mutex sync.RWMutex
+}
+
+// Register adds the Identity, Controller and Node services to a gRPC server.
+func (d *Driver) Register(grpcServer *grpc.Server) {
+	csi.RegisterIdentityServer(grpcServer, d)
+	csi.RegisterControllerServer(grpcServer, d)
+	csi.RegisterNodeServer(grpcServer, d)
+}
+
+// Reconfigure replaces the client and default pool used by later calls.
+func (d *Driver) Reconfigure(client *rbd.RadosBlockDeviceClient, defaultPool string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.RBD = client
+	d.DefaultPool = defaultPool
+}
+
+// client returns the client for a call, tracing its operations below the span of the call.
+func (d *Driver) client(ctx context.Context) *rbd.RadosBlockDeviceClient {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.RBD == nil {
+		return (&rbd.RadosBlockDeviceClient{}).WithContext(ctx)
+	}
+
+	return d.RBD.WithContext(ctx)
+}
+
+// defaultPool returns the pool of volumes whose StorageClass names none.
+func (d *Driver) defaultPool() string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	return d.DefaultPool
+}
+
+// name returns the name of the driver.
+func (d *Driver) name() string {
+	if d.Name == "" {
+		return DefaultName
+	}
+
+	return d.Name
+}
+
+// volumeSpec parses a volume ID, which is the spec of its image, such as 'rbd/pvc-1234'.
+func volumeSpec(volumeID string) (rbd.ImageSpec, error) {
+	spec, err := rbd.ParseImageSpec(volumeID)
+	if err != nil {
+		return rbd.ImageSpec{}, fmt.Errorf("%w", err)
+	}
+
+	if spec.Snapshot != "" {
+		return rbd.ImageSpec{}, fmt.Errorf("%w: volume %s names a snapshot", validators.ErrInvalidImageSpec, volumeID)
+	}
+
+	return spec, nil
+}
+
+// snapshotSpec parses a snapshot ID, which is the spec of the snapshot, such as
+// 'rbd/pvc-1234@snapshot-5678'.
+func snapshotSpec(snapshotID string) (rbd.ImageSpec, error) {
+	spec, err := rbd.ParseImageSpec(snapshotID)
+	if err != nil {
+		return rbd.ImageSpec{}, fmt.Errorf("%w", err)
+	}
+
+	if spec.Snapshot == "" {
+		return rbd.ImageSpec{}, fmt.Errorf("%w: %s", rbd.ErrSnapshotRequired, snapshotID)
+	}
+
+	return spec, nil
+}
@@ -0,0 +1,83 @@
+package csi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//nolint:gochecknoglobals
+var (
+	// invalidArgumentErrors are caused by the request and not by the state of the cluster or host.
+	invalidArgumentErrors = []error{
+		ErrMissingArgument,
+		ErrUnsupportedCapability,
+		ErrUnsupportedParameter,
+		ErrNoPool,
+		validators.ErrInvalidPoolName,
+		validators.ErrInvalidNamespace,
+		validators.ErrInvalidRBDName,
+		validators.ErrInvalidSnapshotName,
+		validators.ErrInvalidImageSpec,
+		validators.ErrSnapshotNotSupported,
+		validators.ErrInvalidSize,
+		validators.ErrInvalidMountOptions,
+		validators.ErrInvalidMakeOptions,
+		rbd.ErrSnapshotRequired,
+	}
+
+	// failedPreconditionErrors conflict with the state of an image or the host.
+	failedPreconditionErrors = []error{
+		rbd.ErrDeviceNotEmpty,
+		rbd.ErrNotMounted,
+		rbd.ErrFilesystemMounted,
+		rbd.ErrImageEncrypted,
+		rbd.ErrMountFailed,
+	}
+)
+
+// statusError converts an error of lib/rbd to a gRPC status. Errors of the rbd command are mapped
+// by their errno.
+func statusError(err error) error {
+	code := codes.Internal
+
+	for _, target := range invalidArgumentErrors {
+		if errors.Is(err, target) {
+			code = codes.InvalidArgument
+		}
+	}
+
+	for _, target := range failedPreconditionErrors {
+		if errors.Is(err, target) {
+			code = codes.FailedPrecondition
+		}
+	}
+
+	if code == codes.Internal {
+		switch helpers.ExitCode(err) {
+		case helpers.ExitNotFound:
+			code = codes.NotFound
+		case helpers.ExitExists:
+			code = codes.AlreadyExists
+		case helpers.ExitBusy, helpers.ExitNotEmpty:
+			code = codes.FailedPrecondition
+		}
+	}
+
+	return status.Error(code, err.Error()) //nolint:wrapcheck
+}
+
+// notFound reports whether an error of the rbd command means that the image or snapshot is gone.
+func notFound(err error) bool {
+	return helpers.ExitCode(err) == helpers.ExitNotFound
+}
+
+// missing returns the InvalidArgument status of a request lacking a field.
+func missing(field string) error {
+	return statusError(fmt.Errorf("%w: %s", ErrMissingArgument, field))
+}
@@ -0,0 +1,253 @@
+package csi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//nolint:gochecknoglobals
+var nodeCapabilities = []csi.NodeServiceCapability_RPC_Type{
+	csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
+	csi.NodeServiceCapability_RPC_EXPAND_VOLUME,
+	csi.NodeServiceCapability_RPC_SINGLE_NODE_MULTI_WRITER,
+}
+
+// NodeStageVolume maps the image of a volume. Filesystem volumes are mounted at the staging path
+// as well, which partitions and formats the image the first time, like the mount command does.
+func (d *Driver) NodeStageVolume(
+	ctx context.Context, request *csi.NodeStageVolumeRequest,
+) (*csi.NodeStageVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Str("Path", request.GetStagingTargetPath()).
+		Msg("csi NodeStageVolume")
+
+	spec, err := nodeVolume(request.GetVolumeId(), request.GetStagingTargetPath(), "staging target path")
+	if err != nil {
+		return nil, err
+	}
+
+	capability := request.GetVolumeCapability()
+	if err := validateCapability(capability); err != nil {
+		return nil, statusError(err)
+	}
+
+	client := d.client(ctx)
+
+	if capability.GetBlock() != nil {
+		if _, err := client.Map(spec); err != nil {
+			return nil, statusError(err)
+		}
+
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	options, err := mountOptions(capability)
+	if err != nil {
+		return nil, statusError(err)
+	}
+
+	if err := client.Mount(spec, request.GetStagingTargetPath(), options); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// NodeUnstageVolume unmounts the staging path of a volume, if anything is mounted there, and
+// unmaps its image.
+func (d *Driver) NodeUnstageVolume(
+	ctx context.Context, request *csi.NodeUnstageVolumeRequest,
+) (*csi.NodeUnstageVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Str("Path", request.GetStagingTargetPath()).
+		Msg("csi NodeUnstageVolume")
+
+	spec, err := nodeVolume(request.GetVolumeId(), request.GetStagingTargetPath(), "staging target path")
+	if err != nil {
+		return nil, err
+	}
+
+	client := d.client(ctx)
+
+	if err := unmountPath(client, request.GetStagingTargetPath()); err != nil {
+		return nil, statusError(err)
+	}
+
+	if err := client.Unmap(spec); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// NodePublishVolume bind mounts the staged filesystem of a volume, or its device, at the target
+// path. A target that is mounted already is left alone.
+func (d *Driver) NodePublishVolume(
+	ctx context.Context, request *csi.NodePublishVolumeRequest,
+) (*csi.NodePublishVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Str("Path", request.GetTargetPath()).
+		Msg("csi NodePublishVolume")
+
+	spec, err := nodeVolume(request.GetVolumeId(), request.GetTargetPath(), "target path")
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetStagingTargetPath() == "" {
+		return nil, missing("staging target path")
+	}
+
+	capability := request.GetVolumeCapability()
+	if err := validateCapability(capability); err != nil {
+		return nil, statusError(err)
+	}
+
+	client := d.client(ctx)
+	target := request.GetTargetPath()
+
+	if mounted, err := client.IsMountPoint(target); err != nil {
+		return nil, statusError(err)
+	} else if mounted {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	source := request.GetStagingTargetPath()
+
+	if capability.GetBlock() != nil {
+		if source, err = client.Map(spec); err != nil {
+			return nil, statusError(err)
+		}
+
+		err = createFile(target)
+	} else {
+		err = os.MkdirAll(target, 0o750)
+	}
+
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if err := client.BindMount(source, target, request.GetReadonly()); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// NodeUnpublishVolume unmounts the target path of a volume, if anything is mounted there, and
+// removes it.
+func (d *Driver) NodeUnpublishVolume(
+	ctx context.Context, request *csi.NodeUnpublishVolumeRequest,
+) (*csi.NodeUnpublishVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Str("Path", request.GetTargetPath()).
+		Msg("csi NodeUnpublishVolume")
+
+	if _, err := nodeVolume(request.GetVolumeId(), request.GetTargetPath(), "target path"); err != nil {
+		return nil, err
+	}
+
+	if err := unmountPath(d.client(ctx), request.GetTargetPath()); err != nil {
+		return nil, statusError(err)
+	}
+
+	if err := os.Remove(request.GetTargetPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
+
+// NodeExpandVolume grows the partition and filesystem of a staged filesystem volume after
+// ControllerExpandVolume grew its image. Block volumes grow with their image.
+func (d *Driver) NodeExpandVolume(
+	ctx context.Context, request *csi.NodeExpandVolumeRequest,
+) (*csi.NodeExpandVolumeResponse, error) {
+	log.Trace().Str("Volume", request.GetVolumeId()).Str("Path", request.GetVolumePath()).
+		Msg("csi NodeExpandVolume")
+
+	spec, err := nodeVolume(request.GetVolumeId(), request.GetVolumePath(), "volume path")
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetVolumeCapability().GetBlock() != nil {
+		return &csi.NodeExpandVolumeResponse{}, nil
+	}
+
+	if err := d.client(ctx).ExpandFilesystem(spec); err != nil {
+		return nil, statusError(err)
+	}
+
+	return &csi.NodeExpandVolumeResponse{}, nil
+}
+
+// NodeGetCapabilities announces staging and the expansion of volumes.
+func (d *Driver) NodeGetCapabilities(
+	context.Context, *csi.NodeGetCapabilitiesRequest,
+) (*csi.NodeGetCapabilitiesResponse, error) {
+	response := &csi.NodeGetCapabilitiesResponse{}
+
+	for _, capability := range nodeCapabilities {
+		response.Capabilities = append(response.Capabilities, &csi.NodeServiceCapability{
+			Type: &csi.NodeServiceCapability_Rpc{Rpc: &csi.NodeServiceCapability_RPC{Type: capability}},
+		})
+	}
+
+	return response, nil
+}
+
+// NodeGetInfo returns the ID of the node.
+func (d *Driver) NodeGetInfo(context.Context, *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: d.NodeID}, nil
+}
+
+// nodeVolume checks the volume ID and path every Node call needs and returns the spec of the
+// volume, or a NotFound status for an ID that is not a volume of this driver.
+func nodeVolume(volumeID, path, pathName string) (rbd.ImageSpec, error) {
+	if volumeID == "" {
+		return rbd.ImageSpec{}, missing("volume ID")
+	}
+
+	if path == "" {
+		return rbd.ImageSpec{}, missing(pathName)
+	}
+
+	spec, err := volumeSpec(volumeID)
+	if err != nil {
+		return rbd.ImageSpec{}, status.Error(codes.NotFound, err.Error())
+	}
+
+	return spec, nil
+}
+
+// unmountPath unmounts a path if anything is mounted on it.
+func unmountPath(client *rbd.RadosBlockDeviceClient, path string) error {
+	mounted, err := client.IsMountPoint(path)
+	if err != nil || !mounted {
+		return err
+	}
+
+	return client.UnmountPath(path)
+}
+
+// createFile creates the empty file a block device is bind mounted on.
+func createFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return file.Close() //nolint:wrapcheck
+}
@@ -0,0 +1,701 @@
+package csi
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	volumeID     = "rbd/pvc-1"
+	showMapped   = `[{"id":"0","pool":"rbd","namespace":"","name":"pvc-1","snap":"-","device":"/dev/rbd0"}]`
+	lsblkDevice  = "lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"
+	lsblkWhole   = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","type":"disk"}]}`
+	lsblkStaged  = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","type":"disk","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"xfs","mountpoint":"/staging/pvc-1","type":"part"}]}]}`
+	lsblkPartFS  = `{"blockdevices":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"xfs","type":"part"}]}`
+	snapshotTime = "Mon Oct 19 14:00:00 2026"
+)
+
+// fakeCluster keeps the images and snapshots created through the rbd command, like a small Ceph
+// cluster, and replies to other commands with canned output.
+type fakeCluster struct {
+	mutex     sync.Mutex
+	images    map[string]int64
+	snapshots map[string][]string
+	replies   map[string]string
+	errors    map[string]error
+	calls     []string
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{images: map[string]int64{}, snapshots: map[string][]string{}}
+}
+
+func (c *fakeCluster) Run(_ context.Context, command string, args ...string) ([]byte, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	line := strings.Join(append([]string{command}, args...), " ")
+	c.calls = append(c.calls, line)
+
+	if err, ok := c.errors[line]; ok {
+		return nil, err
+	}
+
+	if command == "rbd" && len(args) > 1 {
+		if out, err, ok := c.rbd(args); ok {
+			return out, err
+		}
+	}
+
+	return []byte(c.replies[line]), nil
+}
+
+// rbd runs the rbd subcommands managing images and snapshots, reporting whether it handled args.
+func (c *fakeCluster) rbd(args []string) ([]byte, error, bool) { //nolint:revive,stylecheck
+	spec := args[len(args)-1]
+
+	switch args[0] {
+	case "create":
+		if _, ok := c.images[spec]; ok {
+			return nil, exitError(17), true
+		}
+
+		c.images[spec] = sizeOf(args)
+	case "info":
+		size, ok := c.images[args[1]]
+		if !ok {
+			return nil, exitError(2), true
+		}
+
+		return []byte(`{"name":"` + args[1] + `","size":` + strconv.FormatInt(size, 10) + `,"object_size":4194304}`), nil, true
+	case "rm":
+		if _, ok := c.images[spec]; !ok {
+			return nil, exitError(2), true
+		}
+
+		if len(c.snapshots[spec]) > 0 {
+			return nil, exitError(39), true
+		}
+
+		delete(c.images, spec)
+	case "resize":
+		c.images[spec] = sizeOf(args)
+	case "snap":
+		return c.snap(args[1], args[2])
+	default:
+		return nil, nil, false
+	}
+
+	return nil, nil, true
+}
+
+// snap runs rbd snap create, ls and rm.
+func (c *fakeCluster) snap(action, spec string) ([]byte, error, bool) { //nolint:revive,stylecheck
+	image, name, _ := strings.Cut(spec, "@")
+	if _, ok := c.images[image]; !ok {
+		return nil, exitError(2), true
+	}
+
+	index := -1
+
+	for i, snapshot := range c.snapshots[image] {
+		if snapshot == name {
+			index = i
+		}
+	}
+
+	switch action {
+	case "create":
+		if index >= 0 {
+			return nil, exitError(17), true
+		}
+
+		c.snapshots[image] = append(c.snapshots[image], name)
+	case "ls":
+		var entries []string
+
+		for i, snapshot := range c.snapshots[image] {
+			entries = append(entries, `{"id":`+strconv.Itoa(i)+`,"name":"`+snapshot+`","size":`+
+				strconv.FormatInt(c.images[image], 10)+`,"protected":"false","timestamp":"`+snapshotTime+`"}`)
+		}
+
+		return []byte("[" + strings.Join(entries, ",") + "]"), nil, true
+	case "rm":
+		if index < 0 {
+			return nil, exitError(2), true
+		}
+
+		c.snapshots[image] = append(c.snapshots[image][:index], c.snapshots[image][index+1:]...)
+	}
+
+	return nil, nil, true
+}
+
+func (c *fakeCluster) called(line string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, call := range c.calls {
+		if call == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sizeOf returns the bytes of the --size argument of rbd create and resize.
+func sizeOf(args []string) int64 {
+	for i, arg := range args[:len(args)-1] {
+		if arg == "--size" {
+			size, _ := strconv.ParseInt(strings.TrimSuffix(args[i+1], "B"), 10, 64)
+
+			return size
+		}
+	}
+
+	return 0
+}
+
+func exitError(status int) error {
+	return &helpers.CommandError{Command: "rbd", ExitStatus: status}
+}
+
+// csiClients are the clients of the three services of a driver.
+type csiClients struct {
+	identity   csi.IdentityClient
+	controller csi.ControllerClient
+	node       csi.NodeClient
+}
+
+// startDriver serves a driver using runner over bufconn.
+func startDriver(t *testing.T, runner helpers.Runner) *csiClients {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := grpc.NewServer()
+	driver := &Driver{
+		Version: "test",
+		NodeID:  "storage-1",
+		RBD:     &rbd.RadosBlockDeviceClient{Runner: runner, PersistRoot: t.TempDir()},
+	}
+	driver.Register(grpcServer)
+
+	go func() { _ = grpcServer.Serve(listener) }()
+
+	connection, err := grpc.Dial("bufnet",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = connection.Close()
+		grpcServer.Stop()
+	})
+
+	return &csiClients{
+		identity:   csi.NewIdentityClient(connection),
+		controller: csi.NewControllerClient(connection),
+		node:       csi.NewNodeClient(connection),
+	}
+}
+
+func mountCapability(fsType string, flags ...string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: fsType, MountFlags: flags}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func testContext(t *testing.T) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	return ctx
+}
+
+// TestIdentity tests the Identity service.
+func TestIdentity(t *testing.T) {
+	clients := startDriver(t, newFakeCluster())
+	ctx := testContext(t)
+
+	info, err := clients.identity.GetPluginInfo(ctx, &csi.GetPluginInfoRequest{})
+	if err != nil || info.GetName() != DefaultName || info.GetVendorVersion() != "test" {
+		t.Errorf("GetPluginInfo() = %v, %v, want %s test", info, err, DefaultName)
+	}
+
+	capabilities, err := clients.identity.GetPluginCapabilities(ctx, &csi.GetPluginCapabilitiesRequest{})
+	if err != nil || len(capabilities.GetCapabilities()) != 2 {
+		t.Errorf("GetPluginCapabilities() = %v, %v, want the controller service and online expansion", capabilities, err)
+	}
+
+	if probe, err := clients.identity.Probe(ctx, &csi.ProbeRequest{}); err != nil || !probe.GetReady().GetValue() {
+		t.Errorf("Probe() = %v, %v, want ready", probe, err)
+	}
+}
+
+// TestController runs the Controller service through the life of a volume, checking the
+// argument validation and idempotency the CSI specification asks for, like csi-sanity does.
+func TestController(t *testing.T) {
+	cluster := newFakeCluster()
+	clients := startDriver(t, cluster)
+	capabilities := []*csi.VolumeCapability{mountCapability("xfs")}
+	gigabyte := &csi.CapacityRange{RequiredBytes: int64(units.GiB)}
+
+	createVolume := func(name string, capacity *csi.CapacityRange, parameters map[string]string,
+		capabilities ...*csi.VolumeCapability,
+	) func(ctx context.Context) (interface{}, error) {
+		return func(ctx context.Context) (interface{}, error) {
+			response, err := clients.controller.CreateVolume(ctx, &csi.CreateVolumeRequest{
+				Name: name, CapacityRange: capacity, Parameters: parameters, VolumeCapabilities: capabilities,
+			})
+
+			return response.GetVolume().GetVolumeId() + " " + strconv.FormatInt(response.GetVolume().GetCapacityBytes(), 10), err
+		}
+	}
+
+	pool := map[string]string{"pool": "rbd", "csi.storage.k8s.io/pvc/name": "data"}
+
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context) (interface{}, error)
+		wantCode codes.Code
+		want     interface{}
+	}{
+		{name: "TestCreateWithoutName", call: createVolume("", gigabyte, pool, capabilities...), wantCode: codes.InvalidArgument},
+		{name: "TestCreateWithoutCapabilities", call: createVolume("pvc-1", gigabyte, pool), wantCode: codes.InvalidArgument},
+		{name: "TestCreateWithoutPool", call: createVolume("pvc-1", gigabyte, nil, capabilities...), wantCode: codes.InvalidArgument},
+		{
+			name:     "TestCreateUnknownParameter",
+			call:     createVolume("pvc-1", gigabyte, map[string]string{"pool": "rbd", "replicas": "3"}, capabilities...),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestCreateMultiNode",
+			call: createVolume("pvc-1", gigabyte, pool, &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			}),
+			wantCode: codes.InvalidArgument,
+		},
+		{name: "TestCreateUnknownFilesystem", call: createVolume("pvc-1", gigabyte, pool, mountCapability("btrfs")), wantCode: codes.InvalidArgument},
+		{
+			name:     "TestCreateUnalignableLimit",
+			call:     createVolume("pvc-1", &csi.CapacityRange{RequiredBytes: 1000, LimitBytes: 2000}, pool, capabilities...),
+			wantCode: codes.OutOfRange,
+		},
+		{name: "TestCreate", call: createVolume("pvc-1", gigabyte, pool, capabilities...), want: "rbd/pvc-1 1073741824"},
+		{name: "TestCreateAgain", call: createVolume("pvc-1", gigabyte, pool, capabilities...), want: "rbd/pvc-1 1073741824"},
+		{
+			name:     "TestCreateAgainLarger",
+			call:     createVolume("pvc-1", &csi.CapacityRange{RequiredBytes: int64(2 * units.GiB)}, pool, capabilities...),
+			wantCode: codes.AlreadyExists,
+		},
+		{name: "TestCreateDefaultSize", call: createVolume("pvc-2", nil, pool, blockCapability()), want: "rbd/pvc-2 1073741824"},
+		{
+			name: "TestValidateCapabilities",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+					VolumeId: volumeID, VolumeCapabilities: capabilities,
+				})
+
+				return response.GetConfirmed() != nil, err
+			},
+			want: true,
+		},
+		{
+			name: "TestValidateUnsupportedCapabilities",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+					VolumeId: volumeID, VolumeCapabilities: []*csi.VolumeCapability{mountCapability("xfs", "sync")},
+				})
+
+				return response.GetConfirmed() != nil, err
+			},
+			want: false,
+		},
+		{
+			name: "TestValidateMissingVolume",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+					VolumeId: "rbd/pvc-9", VolumeCapabilities: capabilities,
+				})
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "TestExpand",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+					VolumeId: volumeID, CapacityRange: &csi.CapacityRange{RequiredBytes: int64(2 * units.GiB)},
+					VolumeCapability: capabilities[0],
+				})
+
+				return strconv.FormatInt(response.GetCapacityBytes(), 10) + " " +
+					strconv.FormatBool(response.GetNodeExpansionRequired()), err
+			},
+			want: "2147483648 true",
+		},
+		{
+			name: "TestExpandSmaller",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+					VolumeId: volumeID, CapacityRange: gigabyte,
+				})
+
+				return response.GetCapacityBytes(), err
+			},
+			want: int64(2 * units.GiB),
+		},
+		{
+			name: "TestExpandMissingVolume",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+					VolumeId: "rbd/pvc-9", CapacityRange: gigabyte,
+				})
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "TestSnapshotWithoutName",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestSnapshotMissingVolume",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: "rbd/pvc-9", Name: "snapshot-1"})
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "TestSnapshot",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID, Name: "snapshot-1"})
+				snapshot := response.GetSnapshot()
+
+				return snapshot.GetSnapshotId() + " " + strconv.FormatBool(snapshot.GetReadyToUse()) + " " +
+					strconv.FormatInt(snapshot.GetSizeBytes(), 10) + " " + strconv.FormatBool(snapshot.GetCreationTime() != nil), err
+			},
+			want: "rbd/pvc-1@snapshot-1 true 2147483648 true",
+		},
+		{
+			name: "TestSnapshotAgain",
+			call: func(ctx context.Context) (interface{}, error) {
+				response, err := clients.controller.CreateSnapshot(ctx, &csi.CreateSnapshotRequest{SourceVolumeId: volumeID, Name: "snapshot-1"})
+
+				return response.GetSnapshot().GetSnapshotId(), err
+			},
+			want: "rbd/pvc-1@snapshot-1",
+		},
+		{
+			name: "TestDeleteVolumeWithSnapshot",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+			},
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name: "TestDeleteSnapshot",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "rbd/pvc-1@snapshot-1"})
+			},
+		},
+		{
+			name: "TestDeleteSnapshotAgain",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "rbd/pvc-1@snapshot-1"})
+			},
+		},
+		{
+			name: "TestDeleteForeignSnapshot",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteSnapshot(ctx, &csi.DeleteSnapshotRequest{SnapshotId: "not-a-snapshot"})
+			},
+		},
+		{
+			name: "TestDeleteWithoutID",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestDelete",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+			},
+		},
+		{
+			name: "TestDeleteAgain",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID})
+			},
+		},
+		{
+			name: "TestDeleteForeignVolume",
+			call: func(ctx context.Context) (interface{}, error) {
+				return clients.controller.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "not-a-volume"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.call(testContext(t))
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v: %v", code, tt.wantCode, err)
+			}
+
+			if tt.want != nil && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if want := "rbd create --image-feature layering --image-feature striping --image-feature exclusive-lock " +
+		"--image-feature object-map --image-feature fast-diff --size 1073741824B rbd/pvc-1"; !cluster.called(want) {
+		t.Errorf("CreateVolume() did not run %q", want)
+	}
+
+	if len(cluster.images) != 1 {
+		t.Errorf("images left = %v, want only rbd/pvc-2", cluster.images)
+	}
+}
+
+// TestNode tests that the Node service stages, publishes and expands block and filesystem volumes
+// with the commands the scattered-storage commands run.
+func TestNode(t *testing.T) {
+	staging, target := t.TempDir(), filepath.Join(t.TempDir(), "pvc-1")
+	notMounted := &helpers.CommandError{Command: "mountpoint", ExitStatus: 32}
+	mapped := map[string]string{"rbd showmapped --format json": showMapped, "rbd-nbd list-mapped --format json": "[]"}
+
+	with := func(replies map[string]string, more map[string]string) map[string]string {
+		result := map[string]string{}
+		for _, m := range []map[string]string{replies, more} {
+			for key, value := range m {
+				result[key] = value
+			}
+		}
+
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		call      func(ctx context.Context, node csi.NodeClient) (interface{}, error)
+		replies   map[string]string
+		errors    map[string]error
+		wantCode  codes.Code
+		want      interface{}
+		wantCalls []string
+	}{
+		{
+			name: "TestGetInfo",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				response, err := node.NodeGetInfo(ctx, &csi.NodeGetInfoRequest{})
+
+				return response.GetNodeId(), err
+			},
+			want: "storage-1",
+		},
+		{
+			name: "TestGetCapabilities",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				response, err := node.NodeGetCapabilities(ctx, &csi.NodeGetCapabilitiesRequest{})
+
+				return len(response.GetCapabilities()), err
+			},
+			want: len(nodeCapabilities),
+		},
+		{
+			name: "TestStageWithoutPath",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: volumeID, VolumeCapability: blockCapability()})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestStageWithoutCapability",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{VolumeId: volumeID, StagingTargetPath: staging})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestStageForeignVolume",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId: "not-a-volume", StagingTargetPath: staging, VolumeCapability: blockCapability(),
+				})
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "TestStageBlock",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, VolumeCapability: blockCapability(),
+				})
+			},
+			replies:   map[string]string{"rbd-nbd list-mapped --format json": "[]"},
+			wantCalls: []string{"rbd --exclusive --options lock_timeout=10 map rbd/pvc-1"},
+		},
+		{
+			name: "TestStageFilesystem",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, VolumeCapability: mountCapability("xfs", "noatime"),
+				})
+			},
+			replies: with(mapped, map[string]string{
+				lsblkDevice: `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","type":"disk","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","type":"part"}]}]}`,
+				"lsblk -J /dev/rbd0p1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkPartFS,
+			}),
+			wantCalls: []string{"mount -o noatime /dev/rbd0p1 " + staging},
+		},
+		{
+			name: "TestStageUnsupportedFlag",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, VolumeCapability: mountCapability("xfs", "sync"),
+				})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestPublishFilesystem",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, TargetPath: target, Readonly: true,
+					VolumeCapability: mountCapability("xfs"),
+				})
+			},
+			errors:    map[string]error{"mountpoint -q " + target: notMounted},
+			wantCalls: []string{"mount -o bind,ro " + staging + " " + target},
+		},
+		{
+			name: "TestPublishBlock",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, TargetPath: target + "-block",
+					VolumeCapability: blockCapability(),
+				})
+			},
+			replies:   mapped,
+			errors:    map[string]error{"mountpoint -q " + target + "-block": notMounted},
+			wantCalls: []string{"mount -o bind /dev/rbd0 " + target + "-block"},
+		},
+		{
+			name: "TestPublishAgain",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+					VolumeId: volumeID, StagingTargetPath: staging, TargetPath: target,
+					VolumeCapability: mountCapability("xfs"),
+				})
+			},
+			wantCalls: []string{"mountpoint -q " + target},
+		},
+		{
+			name: "TestPublishWithoutStagingPath",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+					VolumeId: volumeID, TargetPath: target, VolumeCapability: mountCapability("xfs"),
+				})
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "TestUnpublish",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: target})
+			},
+			wantCalls: []string{"umount " + target},
+		},
+		{
+			name: "TestUnstageBlock",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{VolumeId: volumeID, StagingTargetPath: staging})
+			},
+			replies:   with(mapped, map[string]string{lsblkDevice: lsblkWhole}),
+			errors:    map[string]error{"mountpoint -q " + staging: notMounted},
+			wantCalls: []string{"rbd unmap /dev/rbd0"},
+		},
+		{
+			name: "TestExpandFilesystem",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{
+					VolumeId: volumeID, VolumePath: target, VolumeCapability: mountCapability("xfs"),
+				})
+			},
+			replies: with(mapped, map[string]string{
+				lsblkDevice:                   lsblkStaged,
+				"blkid -o export /dev/rbd0p1": "DEVNAME=/dev/rbd0p1\nTYPE=xfs\n",
+			}),
+			wantCalls: []string{"partprobe /dev/rbd0", "xfs_growfs /staging/pvc-1"},
+		},
+		{
+			name: "TestExpandUnmounted",
+			call: func(ctx context.Context, node csi.NodeClient) (interface{}, error) {
+				return node.NodeExpandVolume(ctx, &csi.NodeExpandVolumeRequest{VolumeId: volumeID, VolumePath: target})
+			},
+			replies:  with(mapped, map[string]string{lsblkDevice: lsblkWhole}),
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster := newFakeCluster()
+			cluster.replies, cluster.errors = tt.replies, tt.errors
+			clients := startDriver(t, cluster)
+
+			got, err := tt.call(testContext(t), clients.node)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %v, want %v: %v", code, tt.wantCode, err)
+			}
+
+			if tt.want != nil && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			for _, want := range tt.wantCalls {
+				if !cluster.called(want) {
+					t.Errorf("ran %q, want %q", cluster.calls, want)
+				}
+			}
+		})
+	}
+}
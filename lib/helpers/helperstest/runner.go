@@ -0,0 +1,44 @@
+// Package helperstest provides a helpers.Runner that replays canned command output, so that the
+// clients can be tested without a cluster.
+package helperstest
+
+import (
+	"context"
+	"strings"
+)
+
+// Runner replies to commands with canned output, keyed by the full command line. Commands listed
+// in Errors fail with that error and commands without a reply succeed with no output. OnCall, if
+// set, sees every command first. Calls records every command line in the order it ran.
+type Runner struct {
+	Replies map[string]string
+	Errors  map[string]error
+	OnCall  func(line string)
+	Calls   []string
+}
+
+func (r *Runner) Run(_ context.Context, command string, args ...string) ([]byte, error) {
+	line := strings.Join(append([]string{command}, args...), " ")
+	r.Calls = append(r.Calls, line)
+
+	if r.OnCall != nil {
+		r.OnCall(line)
+	}
+
+	if err, ok := r.Errors[line]; ok {
+		return nil, err
+	}
+
+	return []byte(r.Replies[line]), nil
+}
+
+// Called reports whether a command line has run.
+func (r *Runner) Called(line string) bool {
+	for _, call := range r.Calls {
+		if call == line {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/language"
+)
+
+// Runner executes an external command and returns what it wrote to stdout.
+// Clients accept a Runner so that recorded command output can stand in for a
+// live cluster during tests.
+type Runner interface {
+	Run(ctx context.Context, command string, args ...string) ([]byte, error)
+}
+
+// DefaultRunner is used by clients that have not been given a Runner of their own.
+var DefaultRunner Runner = &ExecRunner{} //nolint:gochecknoglobals
+
+// ExecRunner runs commands on the local host through os/exec.
+type ExecRunner struct{}
+
+// Run executes the command and returns its stdout. A failed command returns a *CommandError.
+func (r *ExecRunner) Run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	var stdOut, stdErr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
+
+	cmd.Stdout = &stdOut
+	cmd.Stderr = &stdErr
+
+	if err := cmd.Run(); err != nil {
+		commandError := &CommandError{
+			Command:    cmd.String(),
+			Stderr:     strings.TrimSpace(stdErr.String()),
+			ExitStatus: -1,
+			Err:        err,
+		}
+
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) {
+			commandError.ExitStatus = exitError.ExitCode()
+		}
+
+		log.Trace().Str("Command", cmd.String()).Str("Stderr", commandError.Stderr).
+			Int("ExitCode", commandError.ExitStatus).Msg(language.ErrExecutingCommand)
+
+		return stdOut.Bytes(), commandError
+	}
+
+	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutionCompleted)
+
+	return stdOut.Bytes(), nil
+}
+
+// CommandError describes a command that could not be run or exited with a non-zero status.
+type CommandError struct {
+	Command    string
+	Stderr     string
+	ExitStatus int
+	Err        error
+}
+
+func (e *CommandError) Error() string {
+	reason := fmt.Sprintf("exit status %d", e.ExitStatus)
+	if e.Err != nil {
+		reason = e.Err.Error()
+	}
+
+	if e.Stderr != "" {
+		return fmt.Sprintf("%s: %s: %s", e.Command, reason, e.Stderr)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Command, reason)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the exit status of the command.
+func (e *CommandError) ExitCode() int {
+	return e.ExitStatus
+}
+
+// Exit statuses of the rbd and ceph commands, which exit with the errno of the failed operation.
+const (
+	ExitNotFound = 2  // ENOENT
+	ExitBusy     = 16 // EBUSY
+	ExitExists   = 17 // EEXIST
+	ExitNotEmpty = 39 // ENOTEMPTY
+)
+
+// ExitCode returns the exit status carried by err, 0 for a nil error,
+// or -1 when err does not come from an exited command.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var coded interface{ ExitCode() int }
+	if errors.As(err, &coded) {
+		return coded.ExitCode()
+	}
+
+	return -1
+}
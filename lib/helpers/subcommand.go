@@ -0,0 +1,76 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+//nolint:gochecknoglobals
+var (
+	// commandGroups holds the binaries labelled with their subcommand, and the words of each that
+	// are followed by another word of the subcommand, such as 'rbd snap create'. The subcommand
+	// of any other binary is empty, so that paths and devices do not become labels.
+	commandGroups = map[string]map[string]bool{
+		"rbd": {
+			"snap": true, "lock": true, "namespace": true, "image-meta": true, "encryption": true,
+			"device": true, "feature": true, "trash": true, "mirror": true, "group": true,
+		},
+		"ceph": {
+			"osd": true, "pool": true, "application": true, "fs": true, "subvolume": true, "subvolumegroup": true,
+		},
+		"rbd-nbd":       {},
+		"cryptsetup":    {},
+		"systemctl":     {},
+		"radosgw-admin": {"user": true, "bucket": true, "quota": true},
+	}
+
+	// valueOptions are the options given before a subcommand that take the next argument as their
+	// value.
+	valueOptions = map[string]bool{
+		"--format": true, "--pool": true, "-p": true, "--namespace": true, "--options": true, "-o": true,
+		"--cluster": true, "--conf": true, "-c": true, "--id": true, "--keyring": true, "--key-file": true,
+	}
+
+	// subcommandWord matches the words of a subcommand. Pools, images and paths are not taken for
+	// one, since every word after the first has to follow a group word.
+	subcommandWord = regexp.MustCompile(`^[a-z][a-zA-Z-]*$`)
+)
+
+// Subcommand returns the subcommand of a command line to label metrics and traces with, such as
+// 'map' for 'rbd --exclusive map rbd/test' or 'osd pool ls' for 'ceph osd pool ls --format json'.
+func Subcommand(command string, args []string) string {
+	groups, ok := commandGroups[command]
+	if !ok {
+		return ""
+	}
+
+	var words []string
+
+	for index := 0; index < len(args); index++ {
+		arg := args[index]
+
+		if strings.HasPrefix(arg, "-") {
+			if len(words) > 0 {
+				break
+			}
+
+			if valueOptions[arg] {
+				index++
+			}
+
+			continue
+		}
+
+		if !subcommandWord.MatchString(arg) {
+			break
+		}
+
+		words = append(words, arg)
+
+		if !groups[arg] {
+			break
+		}
+	}
+
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,32 @@
+package helpers
+
+import "testing"
+
+// TestSubcommand tests the subcommand labels of command lines.
+func TestSubcommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		want    string
+	}{
+		{name: "TestMap", command: "rbd", args: []string{"--exclusive", "--options", "lock_timeout=10", "map", "rbd/test1"}, want: "map"},
+		{name: "TestSnapCreate", command: "rbd", args: []string{"snap", "create", "rbd/test1@backup"}, want: "snap create"},
+		{name: "TestFormatFirst", command: "rbd", args: []string{"--format", "json", "lock", "ls", "rbd/test1"}, want: "lock ls"},
+		{name: "TestList", command: "rbd", args: []string{"--pool", "rbd", "--namespace", "", "list", "--format", "json"}, want: "list"},
+		{name: "TestPoolNotLabelled", command: "rbd", args: []string{"ls", "rbd"}, want: "ls"},
+		{name: "TestCephPool", command: "ceph", args: []string{"osd", "pool", "application", "get", "rbd", "--format", "json"}, want: "osd pool application get"},
+		{name: "TestCephDf", command: "ceph", args: []string{"df", "detail", "--format", "json"}, want: "df"},
+		{name: "TestCryptsetup", command: "cryptsetup", args: []string{"luksOpen", "--key-file", "/run/key", "/dev/rbd0", "rbd-test1"}, want: "luksOpen"},
+		{name: "TestNoSubcommand", command: "lsblk", args: []string{"-J", "/dev/rbd0"}, want: ""},
+		{name: "TestMount", command: "mount", args: []string{"-o", "bind", "/a", "/b"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Subcommand(tt.command, tt.args); got != tt.want {
+				t.Errorf("Subcommand(%q, %q) = %q, want %q", tt.command, tt.args, got, tt.want)
+			}
+		})
+	}
+}
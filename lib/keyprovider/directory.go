@@ -0,0 +1,211 @@
+package keyprovider
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+// DirectoryProvider keeps one key file per image in a local directory. The directory must only be
+// accessible by its owner (0700) and the key files only readable and writable by it (0600).
+type DirectoryProvider struct {
+	Path string
+}
+
+// checkPermissions rejects a directory or file that group or other users have access to.
+func checkPermissions(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", rbd.ErrKeyNotFound, path)
+		}
+
+		return fmt.Errorf("%w", err)
+	}
+
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("%w: %s has mode %s", ErrInsecurePermissions, path, info.Mode().Perm())
+	}
+
+	return nil
+}
+
+// keyPath returns the path of the key file of an image, after checking the directory permissions.
+func (p *DirectoryProvider) keyPath(spec rbd.ImageSpec) (string, error) {
+	name, err := keyName(spec)
+	if err != nil {
+		return "", err
+	}
+
+	if err := checkPermissions(p.Path); err != nil {
+		if errors.Is(err, rbd.ErrKeyNotFound) {
+			return "", fmt.Errorf("ERROR: key directory %s does not exist", p.Path)
+		}
+
+		return "", err
+	}
+
+	return filepath.Join(p.Path, name), nil
+}
+
+// Get reads the key file of an image.
+func (p *DirectoryProvider) Get(spec rbd.ImageSpec) ([]byte, error) {
+	path, err := p.keyPath(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Path", path).Msg("DirectoryProvider.Get")
+
+	if err := checkPermissions(path); err != nil {
+		return nil, err
+	}
+
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return key, nil
+}
+
+// Create generates a key and writes it to a new key file.
+func (p *DirectoryProvider) Create(spec rbd.ImageSpec) ([]byte, error) {
+	path, err := p.keyPath(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Path", path).Msg("DirectoryProvider.Create")
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("%w: %s", rbd.ErrKeyExists, path)
+		}
+
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	_, writeError := file.Write(key)
+	if closeError := file.Close(); writeError == nil {
+		writeError = closeError
+	}
+
+	if writeError != nil {
+		_ = os.Remove(path)
+
+		return nil, fmt.Errorf("%w", writeError)
+	}
+
+	return key, nil
+}
+
+// pendingSuffix marks the key file holding the pending key of an image. '@' never appears in the
+// name of a key file, as keys belong to images rather than their snapshots.
+const pendingSuffix = "@pending"
+
+// Rotate writes a new key to the pending key file of an image, next to its current key file.
+func (p *DirectoryProvider) Rotate(spec rbd.ImageSpec) ([]byte, error) {
+	path, err := p.keyPath(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Path", path).Msg("DirectoryProvider.Rotate")
+
+	if err := checkPermissions(path); err != nil {
+		return nil, err
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.writeFile(path+pendingSuffix, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Commit renames the pending key file of an image over its current key file.
+func (p *DirectoryProvider) Commit(spec rbd.ImageSpec) error {
+	path, err := p.keyPath(spec)
+	if err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Path", path).Msg("DirectoryProvider.Commit")
+
+	if err := checkPermissions(path + pendingSuffix); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+pendingSuffix, path); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+// writeFile writes a key next to path and renames it over path, so that a key already there stays
+// in place if writing fails.
+func (p *DirectoryProvider) writeFile(path string, key []byte) error {
+	file, err := os.CreateTemp(p.Path, ".rotate-*")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	_, writeError := file.Write(key)
+	if closeError := file.Close(); writeError == nil {
+		writeError = closeError
+	}
+
+	if writeError == nil {
+		writeError = os.Rename(file.Name(), path)
+	}
+
+	if writeError != nil {
+		_ = os.Remove(file.Name())
+
+		return fmt.Errorf("%w", writeError)
+	}
+
+	return nil
+}
+
+// Delete removes the key file of an image and its pending key file, if any.
+func (p *DirectoryProvider) Delete(spec rbd.ImageSpec) error {
+	path, err := p.keyPath(spec)
+	if err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Path", path).Msg("DirectoryProvider.Delete")
+
+	if err := os.Remove(path + pendingSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w", err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: %s", rbd.ErrKeyNotFound, path)
+		}
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
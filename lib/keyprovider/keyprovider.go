@@ -0,0 +1,52 @@
+// Package keyprovider implements rbd.KeyProvider on top of a local directory, RBD image metadata
+// and a HashiCorp Vault compatible KV store.
+package keyprovider
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+// keyLength is the number of random bytes in a generated key. Keys are hex encoded, so that they
+// can be passed to cryptsetup and rbd as passphrase files unchanged.
+const keyLength = 32
+
+var (
+	ErrInsecurePermissions = errors.New("key storage is accessible by other users")
+	ErrInvalidMasterKey    = errors.New("invalid master key")
+)
+
+// generateKey returns a new random hex encoded key.
+func generateKey() ([]byte, error) {
+	raw := make([]byte, keyLength)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("ERROR: could not generate key: %w", err)
+	}
+
+	key := make([]byte, hex.EncodedLen(keyLength))
+	hex.Encode(key, raw)
+
+	return key, nil
+}
+
+// keyName returns a name for the key of an image that is safe to use as a file name or URL path segment.
+func keyName(spec rbd.ImageSpec) (string, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+
+	image := spec
+	image.Snapshot = ""
+
+	return url.PathEscape(image.String()), nil
+}
+
+// isNotFound reports whether an error means that an image has no key yet.
+func isNotFound(err error) bool {
+	return errors.Is(err, rbd.ErrKeyNotFound)
+}
@@ -0,0 +1,288 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+var testImage = rbd.ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "test-image"}
+
+// testProvider runs the Get/Create/Rotate/Commit/Delete life cycle against a provider.
+func testProvider(t *testing.T, provider rbd.KeyProvider) {
+	t.Helper()
+
+	if _, err := provider.Get(testImage); !errors.Is(err, rbd.ErrKeyNotFound) {
+		t.Fatalf("Get() before Create() error = %v, want %v", err, rbd.ErrKeyNotFound)
+	}
+
+	created, err := provider.Create(testImage)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if len(created) != 64 {
+		t.Errorf("Create() key length = %d, want 64", len(created))
+	}
+
+	if _, err := provider.Create(testImage); !errors.Is(err, rbd.ErrKeyExists) {
+		t.Errorf("Create() twice error = %v, want %v", err, rbd.ErrKeyExists)
+	}
+
+	snapshot := testImage
+	snapshot.Snapshot = "daily"
+
+	if got, err := provider.Get(snapshot); err != nil || !bytes.Equal(got, created) {
+		t.Errorf("Get() snapshot = %q, %v, want %q", got, err, created)
+	}
+
+	rotated, err := provider.Rotate(testImage)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if bytes.Equal(rotated, created) {
+		t.Errorf("Rotate() returned the previous key")
+	}
+
+	if got, err := provider.Get(testImage); err != nil || !bytes.Equal(got, created) {
+		t.Errorf("Get() after Rotate() = %q, %v, want the previous key %q", got, err, created)
+	}
+
+	if err := provider.Commit(testImage); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	if got, err := provider.Get(testImage); err != nil || !bytes.Equal(got, rotated) {
+		t.Errorf("Get() after Commit() = %q, %v, want %q", got, err, rotated)
+	}
+
+	if err := provider.Commit(testImage); !errors.Is(err, rbd.ErrKeyNotFound) {
+		t.Errorf("Commit() without a pending key error = %v, want %v", err, rbd.ErrKeyNotFound)
+	}
+
+	if _, err := provider.Rotate(testImage); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if err := provider.Delete(testImage); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := provider.Get(testImage); !errors.Is(err, rbd.ErrKeyNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, rbd.ErrKeyNotFound)
+	}
+
+	if err := provider.Commit(testImage); !errors.Is(err, rbd.ErrKeyNotFound) {
+		t.Errorf("Commit() after Delete() error = %v, want %v", err, rbd.ErrKeyNotFound)
+	}
+}
+
+// TestDirectoryProvider tests the directory provider and its permission checks.
+func TestDirectoryProvider(t *testing.T) {
+	directory := filepath.Join(t.TempDir(), "keys")
+	if err := os.Mkdir(directory, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	testProvider(t, &DirectoryProvider{Path: directory})
+
+	provider := &DirectoryProvider{Path: directory}
+	if _, err := provider.Create(testImage); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	name, _ := keyName(testImage)
+	if err := os.Chmod(filepath.Join(directory, name), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Get(testImage); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("Get() world readable key error = %v, want %v", err, ErrInsecurePermissions)
+	}
+
+	if err := os.Chmod(directory, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Get(testImage); !errors.Is(err, ErrInsecurePermissions) {
+		t.Errorf("Get() world readable directory error = %v, want %v", err, ErrInsecurePermissions)
+	}
+}
+
+// metadataRunner keeps rbd image-meta values in memory.
+type metadataRunner struct {
+	metadata map[string]rbd.ImageMetadata
+}
+
+func (r *metadataRunner) Run(_ context.Context, _ string, args ...string) ([]byte, error) {
+	image := args[2]
+	if r.metadata[image] == nil {
+		r.metadata[image] = rbd.ImageMetadata{}
+	}
+
+	switch args[1] {
+	case "list":
+		return json.Marshal(r.metadata[image]) //nolint:wrapcheck
+	case "set":
+		r.metadata[image][args[3]] = args[4]
+	case "remove":
+		delete(r.metadata[image], args[3])
+	}
+
+	return nil, nil
+}
+
+// TestMetadataProvider tests the metadata provider and that wrapped keys are bound to their image.
+func TestMetadataProvider(t *testing.T) {
+	runner := &metadataRunner{metadata: map[string]rbd.ImageMetadata{}}
+	client := &rbd.RadosBlockDeviceClient{Runner: runner}
+	masterKey := bytes.Repeat([]byte{7}, masterKeyLength)
+
+	testProvider(t, &MetadataProvider{Client: client, MasterKey: masterKey})
+
+	provider := &MetadataProvider{Client: client, MasterKey: masterKey}
+	if _, err := provider.Create(testImage); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	wrapped := runner.metadata[testImage.String()][wrappedKeyMetadataKey]
+	if wrapped == "" || strings.Contains(wrapped, "key") {
+		t.Errorf("Create() stored %q", wrapped)
+	}
+
+	other := rbd.ImageSpec{Pool: "rbd", Image: "other-image"}
+	runner.metadata[other.String()] = rbd.ImageMetadata{wrappedKeyMetadataKey: wrapped}
+
+	if _, err := provider.Get(other); err == nil {
+		t.Errorf("Get() unwrapped a key copied from another image")
+	}
+
+	wrongMaster := &MetadataProvider{Client: client, MasterKey: bytes.Repeat([]byte{8}, masterKeyLength)}
+	if _, err := wrongMaster.Get(testImage); err == nil {
+		t.Errorf("Get() unwrapped a key with the wrong master key")
+	}
+
+	if _, err := (&MetadataProvider{Client: client, MasterKey: []byte("short")}).Get(testImage); !errors.Is(err, ErrInvalidMasterKey) {
+		t.Errorf("Get() short master key error = %v, want %v", err, ErrInvalidMasterKey)
+	}
+}
+
+// TestLoadMasterKey tests the accepted master key file formats.
+func TestLoadMasterKey(t *testing.T) {
+	directory := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+		wantErr error
+	}{
+		{name: "TestRaw", content: strings.Repeat("k", 32), mode: 0o600},
+		{name: "TestHex", content: strings.Repeat("ab", 32) + "\n", mode: 0o400},
+		{name: "TestShort", content: "abcd", mode: 0o600, wantErr: ErrInvalidMasterKey},
+		{name: "TestReadable", content: strings.Repeat("k", 32), mode: 0o644, wantErr: ErrInsecurePermissions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(directory, tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), tt.mode); err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := LoadMasterKey(path)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoadMasterKey() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err == nil && len(key) != masterKeyLength {
+				t.Errorf("LoadMasterKey() length = %d", len(key))
+			}
+		})
+	}
+}
+
+// newVaultServer returns a stand-in for the KV version 2 API of Vault.
+func newVaultServer(t *testing.T, token string) *httptest.Server {
+	t.Helper()
+
+	var mutex sync.Mutex
+
+	secrets := map[string]map[string]string{}
+	versions := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if request.Header.Get("X-Vault-Token") != token {
+			http.Error(writer, `{"errors":["permission denied"]}`, http.StatusForbidden)
+
+			return
+		}
+
+		path := strings.TrimPrefix(request.URL.EscapedPath(), "/v1/secret/")
+		endpoint, name, _ := strings.Cut(path, "/")
+
+		switch {
+		case request.Method == http.MethodGet && endpoint == "data":
+			data, ok := secrets[name]
+			if !ok {
+				http.Error(writer, `{"errors":[]}`, http.StatusNotFound)
+
+				return
+			}
+
+			_ = json.NewEncoder(writer).Encode(map[string]interface{}{
+				"data": map[string]interface{}{"data": data, "metadata": map[string]int{"version": versions[name]}},
+			})
+		case request.Method == http.MethodPost && endpoint == "data":
+			secret := &vaultSecret{}
+			if err := json.NewDecoder(request.Body).Decode(secret); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			if cas, ok := secret.Options["cas"]; ok && cas != versions[name] {
+				http.Error(writer, `{"errors":["check-and-set parameter did not match"]}`, http.StatusBadRequest)
+
+				return
+			}
+
+			secrets[name] = secret.Data
+			versions[name]++
+		case request.Method == http.MethodDelete && endpoint == "metadata":
+			delete(secrets, name)
+			delete(versions, name)
+			writer.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(writer, "unexpected request", http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// TestVaultProvider tests the Vault provider against an httptest stand-in.
+func TestVaultProvider(t *testing.T) {
+	server := newVaultServer(t, "s.token")
+
+	testProvider(t, &VaultProvider{Address: server.URL, Token: "s.token", Prefix: "scattered-storage"})
+
+	denied := &VaultProvider{Address: server.URL, Token: "wrong", Prefix: "scattered-storage"}
+	if _, err := denied.Get(testImage); !errors.Is(err, ErrVaultRequest) {
+		t.Errorf("Get() with a wrong token error = %v, want %v", err, ErrVaultRequest)
+	}
+}
@@ -0,0 +1,214 @@
+package keyprovider
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+// masterKeyLength selects AES-256 for wrapping keys.
+const masterKeyLength = 32
+
+// Image metadata keys holding the wrapped key and the wrapped pending key.
+const (
+	wrappedKeyMetadataKey = "scattered-storage.wrapped-key"
+	pendingKeyMetadataKey = "scattered-storage.wrapped-key.pending"
+)
+
+// MetadataProvider stores the key of each image in the image metadata, wrapped with AES-256-GCM
+// under a master key kept on the host. The image spec is bound to the wrapped key, so a key copied
+// to another image cannot be unwrapped there.
+type MetadataProvider struct {
+	Client    *rbd.RadosBlockDeviceClient
+	MasterKey []byte
+}
+
+// LoadMasterKey reads a master key file holding 32 raw bytes or 64 hex digits. The file must only
+// be accessible by its owner.
+func LoadMasterKey(path string) ([]byte, error) {
+	if err := checkPermissions(path); err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	if len(content) == masterKeyLength {
+		return content, nil
+	}
+
+	key, err := hex.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil || len(key) != masterKeyLength {
+		return nil, fmt.Errorf("%w: %s must hold %d raw bytes or %d hex digits",
+			ErrInvalidMasterKey, path, masterKeyLength, hex.EncodedLen(masterKeyLength))
+	}
+
+	return key, nil
+}
+
+// cipher returns the AEAD used to wrap keys.
+func (p *MetadataProvider) cipher() (cipher.AEAD, error) {
+	if len(p.MasterKey) != masterKeyLength {
+		return nil, fmt.Errorf("%w: must be %d bytes", ErrInvalidMasterKey, masterKeyLength)
+	}
+
+	block, err := aes.NewCipher(p.MasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMasterKey, err.Error())
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMasterKey, err.Error())
+	}
+
+	return aead, nil
+}
+
+// imageOf returns the spec of the image itself, which holds the key for all of its snapshots.
+func imageOf(spec rbd.ImageSpec) rbd.ImageSpec {
+	image := spec
+	image.Snapshot = ""
+
+	return image
+}
+
+// Get unwraps the key stored in the image metadata.
+func (p *MetadataProvider) Get(spec rbd.ImageSpec) ([]byte, error) {
+	image := imageOf(spec)
+	log.Trace().Str("Image", image.String()).Msg("MetadataProvider.Get")
+
+	metadata, err := p.Client.GetImageMetadata(image)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.unwrap(image, metadata, wrappedKeyMetadataKey)
+}
+
+// unwrap unwraps the key stored under a metadata key of an image.
+func (p *MetadataProvider) unwrap(image rbd.ImageSpec, metadata rbd.ImageMetadata, metadataKey string) ([]byte, error) {
+	aead, err := p.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped, ok := metadata[metadataKey]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", rbd.ErrKeyNotFound, image.String())
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ERROR: wrapped key of %s is malformed", image.String())
+	}
+
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(image.String()))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: could not unwrap the key of %s: %w", image.String(), err)
+	}
+
+	return key, nil
+}
+
+// Create generates a key and stores it wrapped in the image metadata.
+func (p *MetadataProvider) Create(spec rbd.ImageSpec) ([]byte, error) {
+	if _, err := p.Get(spec); err == nil {
+		return nil, fmt.Errorf("%w: %s", rbd.ErrKeyExists, imageOf(spec).String())
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+
+	return p.store(imageOf(spec), wrappedKeyMetadataKey)
+}
+
+// Rotate stores a new wrapped key as the pending key in the image metadata.
+func (p *MetadataProvider) Rotate(spec rbd.ImageSpec) ([]byte, error) {
+	if _, err := p.Get(spec); err != nil {
+		return nil, err
+	}
+
+	return p.store(imageOf(spec), pendingKeyMetadataKey)
+}
+
+// Commit replaces the wrapped key in the image metadata with the pending key.
+func (p *MetadataProvider) Commit(spec rbd.ImageSpec) error {
+	image := imageOf(spec)
+	log.Trace().Str("Image", image.String()).Msg("MetadataProvider.Commit")
+
+	metadata, err := p.Client.GetImageMetadata(image)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.unwrap(image, metadata, pendingKeyMetadataKey); err != nil {
+		return err
+	}
+
+	if err := p.Client.SetImageMetadata(image, wrappedKeyMetadataKey, metadata[pendingKeyMetadataKey]); err != nil {
+		return err
+	}
+
+	return p.Client.RemoveImageMetadata(image, pendingKeyMetadataKey)
+}
+
+// Delete removes the wrapped key and the pending key, if any, from the image metadata.
+func (p *MetadataProvider) Delete(spec rbd.ImageSpec) error {
+	image := imageOf(spec)
+	log.Trace().Str("Image", image.String()).Msg("MetadataProvider.Delete")
+
+	metadata, err := p.Client.GetImageMetadata(image)
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.unwrap(image, metadata, wrappedKeyMetadataKey); err != nil {
+		return err
+	}
+
+	if _, ok := metadata[pendingKeyMetadataKey]; ok {
+		if err := p.Client.RemoveImageMetadata(image, pendingKeyMetadataKey); err != nil {
+			return err
+		}
+	}
+
+	return p.Client.RemoveImageMetadata(image, wrappedKeyMetadataKey)
+}
+
+// store generates, wraps and stores a new key under a metadata key of an image.
+func (p *MetadataProvider) store(image rbd.ImageSpec, metadataKey string) ([]byte, error) {
+	aead, err := p.cipher()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", image.String()).Msg("MetadataProvider.store")
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("ERROR: could not generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, key, []byte(image.String()))
+
+	if err := p.Client.SetImageMetadata(image, metadataKey, base64.StdEncoding.EncodeToString(sealed)); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
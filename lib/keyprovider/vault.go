@@ -0,0 +1,239 @@
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+const defaultVaultTimeout = 10 * time.Second
+
+var ErrVaultRequest = errors.New("vault request failed")
+
+// VaultProvider stores keys in a HashiCorp Vault compatible KV version 2 secrets engine, one
+// secret per image below Prefix. Mount defaults to 'secret' and HTTPClient to a client with a
+// 10 second timeout.
+type VaultProvider struct {
+	Address    string
+	Token      string
+	Mount      string
+	Prefix     string
+	HTTPClient *http.Client
+}
+
+// vaultSecret is the body of KV version 2 write requests.
+type vaultSecret struct {
+	Options map[string]int    `json:"options,omitempty"`
+	Data    map[string]string `json:"data"`
+}
+
+// vaultReadResponse
+/* GET /v1/secret/data/scattered-storage/rbd%2Ftest-image
+
+{
+  "data": {
+    "data": {"key": "6b6579", "pending": "6e6577"},
+    "metadata": {"version": 3}
+  }
+}
+vaultReadResponse is used to read the latest version of a key, and of the pending key during a
+rotation. The version is passed back as check-and-set, so that concurrent writes are refused. */
+type vaultReadResponse struct {
+	Data struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+// url returns the URL of a KV version 2 endpoint ('data' or 'metadata') for the key of an image.
+func (p *VaultProvider) url(endpoint string, spec rbd.ImageSpec) (string, error) {
+	name, err := keyName(spec)
+	if err != nil {
+		return "", err
+	}
+
+	mount := p.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	parts := []string{strings.TrimSuffix(p.Address, "/"), "v1", strings.Trim(mount, "/"), endpoint}
+	if prefix := strings.Trim(p.Prefix, "/"); prefix != "" {
+		parts = append(parts, prefix)
+	}
+
+	return strings.Join(append(parts, name), "/"), nil
+}
+
+// request sends a request to Vault and decodes the response into result, if given.
+func (p *VaultProvider) request(method, url string, body, result interface{}) error {
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultVaultTimeout}
+	}
+
+	var payload io.Reader
+
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+
+		payload = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(context.Background(), method, url, payload)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	request.Header.Set("X-Vault-Token", p.Token)
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	log.Trace().Str("Method", method).Str("URL", url).Msg("VaultProvider.request")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrVaultRequest, err.Error())
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrVaultRequest, err.Error())
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s", rbd.ErrKeyNotFound, url)
+	case response.StatusCode >= http.StatusBadRequest:
+		return fmt.Errorf("%w: %s %s: %s: %s", ErrVaultRequest, method, url, response.Status, strings.TrimSpace(string(content)))
+	case result == nil || len(content) == 0:
+		return nil
+	}
+
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("ERROR: json for vault response could not unmarshal:\n%w\n%s", err, string(content))
+	}
+
+	return nil
+}
+
+// Get reads the latest version of the key of an image.
+func (p *VaultProvider) Get(spec rbd.ImageSpec) ([]byte, error) {
+	secret, err := p.read(spec, "key")
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(secret.Data.Data["key"]), nil
+}
+
+// Create generates a key and writes it with check-and-set 0, so that an existing key is never overwritten.
+func (p *VaultProvider) Create(spec rbd.ImageSpec) ([]byte, error) {
+	if _, err := p.Get(spec); err == nil {
+		return nil, fmt.Errorf("%w: %s", rbd.ErrKeyExists, spec.String())
+	} else if !isNotFound(err) {
+		return nil, err
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.write(spec, 0, map[string]string{"key": string(key)}); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Rotate writes a new version of the secret of an image holding a new pending key next to the
+// current key.
+func (p *VaultProvider) Rotate(spec rbd.ImageSpec) ([]byte, error) {
+	secret, err := p.read(spec, "key")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]string{"key": secret.Data.Data["key"], "pending": string(key)}
+	if err := p.write(spec, secret.Data.Metadata.Version, data); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// Commit writes a new version of the secret of an image holding the pending key as its key.
+// Earlier versions are kept by Vault.
+func (p *VaultProvider) Commit(spec rbd.ImageSpec) error {
+	secret, err := p.read(spec, "pending")
+	if err != nil {
+		return err
+	}
+
+	return p.write(spec, secret.Data.Metadata.Version, map[string]string{"key": secret.Data.Data["pending"]})
+}
+
+// Delete removes every version of the key of an image.
+func (p *VaultProvider) Delete(spec rbd.ImageSpec) error {
+	url, err := p.url("metadata", spec)
+	if err != nil {
+		return err
+	}
+
+	return p.request(http.MethodDelete, url, nil, nil)
+}
+
+// read reads the latest version of the secret of an image, which must hold field.
+func (p *VaultProvider) read(spec rbd.ImageSpec, field string) (*vaultReadResponse, error) {
+	url, err := p.url("data", spec)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &vaultReadResponse{}
+	if err := p.request(http.MethodGet, url, nil, response); err != nil {
+		return nil, err
+	}
+
+	if response.Data.Data[field] == "" {
+		return nil, fmt.Errorf("%w: %s", rbd.ErrKeyNotFound, url)
+	}
+
+	return response, nil
+}
+
+// write writes data as a new version of the secret of an image, provided that version is still
+// the latest one.
+func (p *VaultProvider) write(spec rbd.ImageSpec, version int, data map[string]string) error {
+	url, err := p.url("data", spec)
+	if err != nil {
+		return err
+	}
+
+	secret := &vaultSecret{Options: map[string]int{"cas": version}, Data: data}
+
+	return p.request(http.MethodPost, url, secret, nil)
+}
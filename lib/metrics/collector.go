@@ -0,0 +1,350 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+// Names of the parts of Collector, as the collector label of scattered_storage_collector_success.
+const (
+	CollectorHost   = "host"
+	CollectorPools  = "pools"
+	CollectorImages = "images"
+)
+
+//nolint:gochecknoglobals
+var (
+	imageLabels = []string{"pool", "namespace", "image"}
+
+	mappedImagesDesc = newDesc("mapped_images", "Images mapped to this host, by map backend.", "backend")
+	mountedDesc      = newDesc("mounted_volumes", "Mapped images mounted on this host.")
+	locksDesc        = newDesc("image_locks", "Advisory locks held on the images mapped to this host.", imageLabels...)
+	provisionedDesc  = newDesc("image_provisioned_bytes", "Provisioned size of an image.", imageLabels...)
+	usedDesc         = newDesc("image_used_bytes", "Space an image uses, without its snapshots.", imageLabels...)
+	storedDesc       = newDesc("pool_stored_bytes", "Data stored in a pool by its clients.", "pool")
+	poolUsedDesc     = newDesc("pool_used_bytes", "Raw space a pool uses, including replication.", "pool")
+	maxAvailDesc     = newDesc("pool_max_available_bytes", "Data a pool can still store.", "pool")
+	objectsDesc      = newDesc("pool_objects", "Objects stored in a pool.", "pool")
+	quotaBytesDesc   = newDesc("pool_quota_bytes", "Quota on the bytes of a pool, 0 when none is set.", "pool")
+	quotaObjectsDesc = newDesc("pool_quota_objects", "Quota on the objects of a pool, 0 when none is set.", "pool")
+	successDesc      = newDesc("collector_success", "Whether the last collection of a part succeeded.", "collector")
+)
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+}
+
+var (
+	ErrInvalidImageInterval = errors.New("image metrics interval must be positive")
+	ErrImagesIncomplete     = errors.New("disk usage of some namespaces could not be read")
+	ErrHostIncomplete       = errors.New("mount points or locks of some mapped images could not be read")
+)
+
+// Collector reports the images mapped to and mounted on this host with their locks, and the usage
+// and quota of the RBD pools and their images. Every scrape runs the commands of the host and the
+// pools anew. Reading the usage of every image takes an rbd du of each namespace of the cluster, so
+// the image metrics are refreshed by Run every ImageInterval and scrapes report the last refresh;
+// they are missing until the first one. A part that fails is logged and reported by
+// scattered_storage_collector_success, and the other parts are still reported.
+type Collector struct {
+	RBD           *rbd.RadosBlockDeviceClient
+	Ceph          *ceph.CephCLI
+	ImageInterval time.Duration
+
+	mutex       sync.RWMutex
+	images      []prometheus.Metric
+	imagesError error
+	imagesRead  bool
+}
+
+// Reconfigure replaces the clients of later scrapes.
+func (c *Collector) Reconfigure(rbdClient *rbd.RadosBlockDeviceClient, cephClient *ceph.CephCLI) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.RBD = rbdClient
+	c.Ceph = cephClient
+}
+
+func (c *Collector) clients() (*rbd.RadosBlockDeviceClient, *ceph.CephCLI) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.RBD, c.Ceph
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(descriptions chan<- *prometheus.Desc) {
+	for _, description := range []*prometheus.Desc{
+		mappedImagesDesc, mountedDesc, locksDesc, provisionedDesc, usedDesc, storedDesc, poolUsedDesc,
+		maxAvailDesc, objectsDesc, quotaBytesDesc, quotaObjectsDesc, successDesc,
+	} {
+		descriptions <- description
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(metrics chan<- prometheus.Metric) {
+	rbdClient, cephClient := c.clients()
+
+	report := func(collector string, err error) {
+		success := 1.0
+		if err != nil {
+			log.Warn().Str("Collector", collector).Str("Error", err.Error()).Msg("metrics could not be collected")
+
+			success = 0
+		}
+
+		metrics <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, success, collector)
+	}
+
+	report(CollectorHost, collectHost(rbdClient, metrics))
+
+	report(CollectorPools, collectPools(cephClient, metrics))
+
+	c.mutex.RLock()
+	images, imagesError, imagesRead := c.images, c.imagesError, c.imagesRead
+	c.mutex.RUnlock()
+
+	if imagesRead {
+		for _, metric := range images {
+			metrics <- metric
+		}
+
+		report(CollectorImages, imagesError)
+	}
+}
+
+// Run refreshes the image metrics right away and then every ImageInterval until the context is
+// done, and returns the error of the context.
+func (c *Collector) Run(ctx context.Context) error {
+	if c.ImageInterval <= 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidImageInterval, c.ImageInterval)
+	}
+
+	log.Info().Str("Interval", c.ImageInterval.String()).Msg("refreshing image metrics")
+
+	ticker := time.NewTicker(c.ImageInterval)
+	defer ticker.Stop()
+
+	for {
+		c.RefreshImages()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err() //nolint:wrapcheck
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefreshImages reads the usage of the images of the RBD pools and keeps it for later scrapes.
+func (c *Collector) RefreshImages() {
+	rbdClient, cephClient := c.clients()
+
+	var images []prometheus.Metric
+
+	metrics := make(chan prometheus.Metric)
+	done := make(chan struct{})
+
+	go func() {
+		for metric := range metrics {
+			images = append(images, metric)
+		}
+
+		close(done)
+	}()
+
+	err := collectImages(rbdClient, cephClient, metrics)
+
+	close(metrics)
+	<-done
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.images, c.imagesError, c.imagesRead = images, err, true
+}
+
+// collectHost reports the mapped images by backend, how many of them are mounted, and the locks
+// of each mapped image. An image mapped more than once, such as with both krbd and rbd-nbd, reports
+// its locks once. An image whose mount point or locks cannot be
+// read is logged and left out, and the others are still reported.
+func collectHost(client *rbd.RadosBlockDeviceClient, metrics chan<- prometheus.Metric) error {
+	mapped, err := client.ListMappedImages()
+	if err != nil {
+		return err
+	}
+
+	backends := map[rbd.MapBackend]int{rbd.MapBackendKRBD: 0, rbd.MapBackendNBD: 0}
+	locks := map[rbd.ImageSpec]int{}
+	mounted, failed := 0, 0
+
+	for _, image := range *mapped {
+		backends[image.Backend]++
+
+		mountPoint, err := client.GetDeviceMountPoint(image.Device)
+		if err != nil {
+			log.Warn().Str("Device", image.Device).Str("Error", err.Error()).Msg("mount point could not be read")
+
+			failed++
+		} else if mountPoint != "" {
+			mounted++
+		}
+
+		if image.Snap != "" && image.Snap != "-" {
+			continue
+		}
+
+		spec := rbd.ImageSpec{Pool: image.Pool, Namespace: image.Namespace, Image: image.Name}
+		if _, seen := locks[spec]; seen {
+			continue
+		}
+
+		imageLocks, err := client.ListLocks(spec)
+		if err != nil {
+			log.Warn().Str("Image", spec.String()).Str("Error", err.Error()).Msg("locks could not be read")
+
+			failed++
+
+			continue
+		}
+
+		locks[spec] = len(imageLocks)
+	}
+
+	for spec, count := range locks {
+		metrics <- prometheus.MustNewConstMetric(locksDesc, prometheus.GaugeValue, float64(count),
+			spec.Pool, spec.Namespace, spec.Image)
+	}
+
+	for backend, count := range backends {
+		metrics <- prometheus.MustNewConstMetric(mappedImagesDesc, prometheus.GaugeValue, float64(count), string(backend))
+	}
+
+	metrics <- prometheus.MustNewConstMetric(mountedDesc, prometheus.GaugeValue, float64(mounted))
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d failed", ErrHostIncomplete, failed)
+	}
+
+	return nil
+}
+
+// collectPools reports the usage and quota of every pool.
+func collectPools(client *ceph.CephCLI, metrics chan<- prometheus.Metric) error {
+	diskFree, err := client.GetDiskFree()
+	if err != nil {
+		return err
+	}
+
+	for _, pool := range diskFree.Pools {
+		for description, value := range map[*prometheus.Desc]float64{
+			storedDesc:       float64(pool.Stats.Stored),
+			poolUsedDesc:     float64(pool.Stats.BytesUsed),
+			maxAvailDesc:     float64(pool.Stats.MaxAvail),
+			objectsDesc:      float64(pool.Stats.Objects),
+			quotaBytesDesc:   float64(pool.Stats.QuotaBytes),
+			quotaObjectsDesc: float64(pool.Stats.QuotaObjects),
+		} {
+			metrics <- prometheus.MustNewConstMetric(description, prometheus.GaugeValue, value, pool.Name)
+		}
+	}
+
+	return nil
+}
+
+// collectImages reports the provisioned and used size of the images in every namespace of the
+// pools tagged for RBD. A namespace whose usage cannot be read is logged and skipped, and the
+// others are still reported.
+func collectImages(
+	rbdClient *rbd.RadosBlockDeviceClient, cephClient *ceph.CephCLI, metrics chan<- prometheus.Metric,
+) error {
+	diskFree, err := cephClient.GetDiskFree()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+
+	for _, pool := range diskFree.Pools {
+		isRBD, err := cephClient.IsRBDPool(pool.Name)
+		if err != nil {
+			return err
+		}
+
+		if !isRBD {
+			continue
+		}
+
+		namespaces, err := rbdClient.ListNamespaces(pool.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, namespace := range append([]string{""}, namespaces...) {
+			usage, err := rbdClient.GetPoolDiskUsage(pool.Name, namespace)
+			if err != nil {
+				log.Warn().Str("Pool", pool.Name).Str("Namespace", namespace).Str("Error", err.Error()).
+					Msg("image metrics could not be collected")
+
+				failed++
+
+				continue
+			}
+
+			for _, image := range usage.Images {
+				if image.Snapshot != "" {
+					continue
+				}
+
+				metrics <- prometheus.MustNewConstMetric(provisionedDesc, prometheus.GaugeValue,
+					float64(image.ProvisionedSize), pool.Name, namespace, image.Name)
+				metrics <- prometheus.MustNewConstMetric(usedDesc, prometheus.GaugeValue,
+					float64(image.UsedSize), pool.Name, namespace, image.Name)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d failed", ErrImagesIncomplete, failed)
+	}
+
+	return nil
+}
+
+// NewRegistry returns a registry holding the command metrics, the collector and the metrics of
+// the Go runtime and the process. Either of the first two may be nil.
+func NewRegistry(commands *Commands, collector *Collector) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}), //nolint:exhaustruct
+	)
+
+	if commands != nil {
+		registry.MustRegister(commands)
+	}
+
+	if collector != nil {
+		registry.MustRegister(collector)
+	}
+
+	return registry
+}
+
+// Handler serves the metrics of a registry in the Prometheus exposition format.
+func Handler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{}) //nolint:exhaustruct
+}
@@ -0,0 +1,107 @@
+// Package metrics exports Prometheus metrics for the commands run against the cluster and the
+// host, and for the images, mounts and pools they manage.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+)
+
+const namespace = "scattered_storage"
+
+// Results of a command.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultTimeout = "timeout"
+)
+
+// Commands counts the executed commands and the time they took by binary, subcommand and result.
+// It is a prometheus.Collector, so that it can be registered with any registry.
+type Commands struct {
+	total    *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+// NewCommands returns empty command metrics.
+func NewCommands() *Commands {
+	labels := []string{"binary", "subcommand", "result"}
+
+	return &Commands{
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      "commands_total",
+			Help:      "Commands executed, by binary, subcommand and result.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{ //nolint:exhaustruct
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "Time commands took to run, by binary, subcommand and result.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Commands) Describe(descriptions chan<- *prometheus.Desc) {
+	c.total.Describe(descriptions)
+	c.duration.Describe(descriptions)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Commands) Collect(metrics chan<- prometheus.Metric) {
+	c.total.Collect(metrics)
+	c.duration.Collect(metrics)
+}
+
+// observe records a command that ran for elapsed and returned err.
+func (c *Commands) observe(ctx context.Context, command string, args []string, elapsed time.Duration, err error) {
+	labels := prometheus.Labels{
+		"binary":     command,
+		"subcommand": helpers.Subcommand(command, args),
+		"result":     result(ctx, err),
+	}
+
+	c.total.With(labels).Inc()
+	c.duration.With(labels).Observe(elapsed.Seconds())
+}
+
+// result returns the result label of a command.
+func result(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return ResultSuccess
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ResultTimeout
+	default:
+		return ResultFailure
+	}
+}
+
+// Runner records every command it runs through Next in Commands. A nil Next runs commands on the
+// local host. Wrapping the runner shared by the clients covers every command they execute.
+type Runner struct {
+	Commands *Commands
+	Next     helpers.Runner
+}
+
+// Run executes the command and records its result and duration.
+func (r *Runner) Run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	next := r.Next
+	if next == nil {
+		next = helpers.DefaultRunner
+	}
+
+	started := time.Now()
+	stdOut, err := next.Run(ctx, command, args...)
+
+	if r.Commands != nil {
+		r.Commands.observe(ctx, command, args, time.Since(started), err)
+	}
+
+	return stdOut, err //nolint:wrapcheck
+}
@@ -0,0 +1,258 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/scattered-network/scattered-storage/lib/ceph"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/rbd"
+)
+
+// TestRunner tests that commands are counted and timed by result.
+func TestRunner(t *testing.T) {
+	commands := NewCommands()
+	runner := &Runner{Commands: commands, Next: &helperstest.Runner{
+		Errors: map[string]error{
+			"rbd rm rbd/test2": &helpers.CommandError{Command: "rbd rm rbd/test2", ExitStatus: 2},
+			"rbd rm rbd/test3": context.DeadlineExceeded,
+		},
+	}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	for _, image := range []string{"rbd/test1", "rbd/test2"} {
+		_, _ = runner.Run(context.Background(), "rbd", "rm", image)
+	}
+
+	if _, err := runner.Run(ctx, "rbd", "rm", "rbd/test3"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	for result, want := range map[string]float64{ResultSuccess: 1, ResultFailure: 1, ResultTimeout: 1} {
+		if got := testutil.ToFloat64(commands.total.WithLabelValues("rbd", "rm", result)); got != want {
+			t.Errorf("commands_total{result=%q} = %v, want %v", result, got, want)
+		}
+	}
+
+	if got := testutil.CollectAndCount(commands, "scattered_storage_command_duration_seconds"); got != 3 {
+		t.Errorf("command_duration_seconds series = %d, want 3", got)
+	}
+}
+
+const (
+	showMapped = `[{"id":"0","pool":"rbd","namespace":"","name":"test1","snap":"-","device":"/dev/rbd0"},` +
+		`{"id":"1","pool":"rbd","namespace":"","name":"test2","snap":"-","device":"/dev/rbd1"}]`
+	lsblkMounted   = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","mountpoint":"/srv/test-1","fstype":"xfs","type":"part"}]}]}`
+	lsblkUnmounted = `{"blockdevices":[{"name":"rbd1","path":"/dev/rbd1","fstype":"xfs","type":"disk"}]}`
+	cephDf         = `{"stats":{"total_bytes":322122547200},"pools":[` +
+		`{"name":"rbd","id":2,"stats":{"stored":10296414208,"objects":2498,"bytes_used":30889181184,"max_avail":91848630272,"quota_objects":0,"quota_bytes":107374182400}},` +
+		`{"name":".rgw.root","id":3,"stats":{"stored":1024,"objects":4,"bytes_used":3072,"max_avail":91848630272,"quota_objects":0,"quota_bytes":0}}]}`
+	duPool = `{"images":[{"name":"test1","provisioned_size":10737418240,"used_size":3221225472},` +
+		`{"name":"test1","snapshot":"backup","provisioned_size":10737418240,"used_size":1073741824}]}`
+	duTenant = `{"images":[{"name":"test3","provisioned_size":1073741824,"used_size":0}]}`
+)
+
+// newClusterRunner returns a runner for a host mapping two images of the rbd pool, one of them
+// mounted and locked.
+func newClusterRunner() *helperstest.Runner {
+	return &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                           showMapped,
+			"rbd-nbd list-mapped --format json":                      "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+			"lsblk -J /dev/rbd1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkUnmounted,
+			"rbd --format json lock ls rbd/test1":                    `[{"id":"auto 1","locker":"client.4123","address":"10.0.0.1:0/1"}]`,
+			"rbd --format json lock ls rbd/test2":                    `[]`,
+			"ceph df detail --format json":                           cephDf,
+			"ceph osd pool application get rbd --format json":        `{"rbd":{}}`,
+			"ceph osd pool application get .rgw.root --format json":  `{"rgw":{}}`,
+			"rbd namespace ls --pool rbd --format json":              `[{"name":"tenant1"}]`,
+			"rbd du --pool rbd --namespace  --format json":           duPool,
+			"rbd du --pool rbd --namespace tenant1 --format json":    duTenant,
+		},
+	}
+}
+
+// TestCollector tests the metrics of the host, the pools and the images.
+func TestCollector(t *testing.T) {
+	runner := newClusterRunner()
+	collector := &Collector{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, Ceph: &ceph.CephCLI{Runner: runner}}
+	collector.RefreshImages()
+
+	want := `
+# HELP scattered_storage_collector_success Whether the last collection of a part succeeded.
+# TYPE scattered_storage_collector_success gauge
+scattered_storage_collector_success{collector="host"} 1
+scattered_storage_collector_success{collector="images"} 1
+scattered_storage_collector_success{collector="pools"} 1
+# HELP scattered_storage_image_locks Advisory locks held on the images mapped to this host.
+# TYPE scattered_storage_image_locks gauge
+scattered_storage_image_locks{image="test1",namespace="",pool="rbd"} 1
+scattered_storage_image_locks{image="test2",namespace="",pool="rbd"} 0
+# HELP scattered_storage_image_used_bytes Space an image uses, without its snapshots.
+# TYPE scattered_storage_image_used_bytes gauge
+scattered_storage_image_used_bytes{image="test1",namespace="",pool="rbd"} 3.221225472e+09
+scattered_storage_image_used_bytes{image="test3",namespace="tenant1",pool="rbd"} 0
+# HELP scattered_storage_mapped_images Images mapped to this host, by map backend.
+# TYPE scattered_storage_mapped_images gauge
+scattered_storage_mapped_images{backend="krbd"} 2
+scattered_storage_mapped_images{backend="nbd"} 0
+# HELP scattered_storage_mounted_volumes Mapped images mounted on this host.
+# TYPE scattered_storage_mounted_volumes gauge
+scattered_storage_mounted_volumes 1
+# HELP scattered_storage_pool_quota_bytes Quota on the bytes of a pool, 0 when none is set.
+# TYPE scattered_storage_pool_quota_bytes gauge
+scattered_storage_pool_quota_bytes{pool=".rgw.root"} 0
+scattered_storage_pool_quota_bytes{pool="rbd"} 1.073741824e+11
+`
+
+	names := []string{
+		"scattered_storage_collector_success", "scattered_storage_image_locks", "scattered_storage_image_used_bytes",
+		"scattered_storage_mapped_images", "scattered_storage_mounted_volumes", "scattered_storage_pool_quota_bytes",
+	}
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), names...); err != nil {
+		t.Error(err)
+	}
+
+	if got := testutil.CollectAndCount(collector, "scattered_storage_image_provisioned_bytes"); got != 2 {
+		t.Errorf("image_provisioned_bytes series = %d, want 2", got)
+	}
+}
+
+// TestCollectorFailure tests that a failing part is reported while the others still are.
+func TestCollectorFailure(t *testing.T) {
+	runner := newClusterRunner()
+	runner.Errors = map[string]error{
+		"ceph df detail --format json": &helpers.CommandError{Command: "ceph df", ExitStatus: 110},
+	}
+
+	collector := &Collector{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, Ceph: &ceph.CephCLI{Runner: runner}}
+
+	want := `
+# HELP scattered_storage_collector_success Whether the last collection of a part succeeded.
+# TYPE scattered_storage_collector_success gauge
+scattered_storage_collector_success{collector="host"} 1
+scattered_storage_collector_success{collector="pools"} 0
+# HELP scattered_storage_mounted_volumes Mapped images mounted on this host.
+# TYPE scattered_storage_mounted_volumes gauge
+scattered_storage_mounted_volumes 1
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want),
+		"scattered_storage_collector_success", "scattered_storage_mounted_volumes"); err != nil {
+		t.Error(err)
+	}
+
+	registry := NewRegistry(NewCommands(), collector)
+	if _, err := registry.Gather(); err != nil {
+		t.Errorf("Gather() error = %v", err)
+	}
+}
+
+// TestCollectorHost tests that an image mapped twice reports its locks once, and that an image whose
+// locks cannot be read is left out while the others are still reported.
+func TestCollectorHost(t *testing.T) {
+	runner := newClusterRunner()
+	runner.Replies["rbd-nbd list-mapped --format json"] = `[{"id":"4026","pool":"rbd","namespace":"","image":"test1",` +
+		`"snap":"-","device":"/dev/nbd0"}]`
+	runner.Replies["lsblk -J /dev/nbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"] = `{"blockdevices":[{"name":"nbd0",` +
+		`"path":"/dev/nbd0","type":"disk"}]}`
+	runner.Errors = map[string]error{
+		"rbd --format json lock ls rbd/test2": &helpers.CommandError{Command: "rbd lock ls", ExitStatus: 110},
+	}
+
+	collector := &Collector{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, Ceph: &ceph.CephCLI{Runner: runner}}
+
+	want := `
+# HELP scattered_storage_collector_success Whether the last collection of a part succeeded.
+# TYPE scattered_storage_collector_success gauge
+scattered_storage_collector_success{collector="host"} 0
+scattered_storage_collector_success{collector="pools"} 1
+# HELP scattered_storage_image_locks Advisory locks held on the images mapped to this host.
+# TYPE scattered_storage_image_locks gauge
+scattered_storage_image_locks{image="test1",namespace="",pool="rbd"} 1
+# HELP scattered_storage_mapped_images Images mapped to this host, by map backend.
+# TYPE scattered_storage_mapped_images gauge
+scattered_storage_mapped_images{backend="krbd"} 2
+scattered_storage_mapped_images{backend="nbd"} 1
+# HELP scattered_storage_mounted_volumes Mapped images mounted on this host.
+# TYPE scattered_storage_mounted_volumes gauge
+scattered_storage_mounted_volumes 1
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want), "scattered_storage_collector_success",
+		"scattered_storage_image_locks", "scattered_storage_mapped_images", "scattered_storage_mounted_volumes"); err != nil {
+		t.Error(err)
+	}
+
+	if _, err := NewRegistry(NewCommands(), collector).Gather(); err != nil {
+		t.Errorf("Gather() error = %v", err)
+	}
+}
+
+// TestCollectorImages tests that the image metrics are only read by a refresh, and that a namespace
+// whose usage cannot be read is skipped.
+func TestCollectorImages(t *testing.T) {
+	runner := newClusterRunner()
+	runner.Errors = map[string]error{
+		"rbd du --pool rbd --namespace tenant1 --format json": &helpers.CommandError{Command: "rbd du", ExitStatus: 2},
+	}
+
+	collector := &Collector{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, Ceph: &ceph.CephCLI{Runner: runner}}
+
+	if got := testutil.CollectAndCount(collector, "scattered_storage_image_used_bytes"); got != 0 {
+		t.Errorf("image_used_bytes series before the first refresh = %d, want 0", got)
+	}
+
+	if runner.Called("rbd du --pool rbd --namespace  --format json") {
+		t.Error("a scrape ran rbd du")
+	}
+
+	collector.RefreshImages()
+
+	want := `
+# HELP scattered_storage_collector_success Whether the last collection of a part succeeded.
+# TYPE scattered_storage_collector_success gauge
+scattered_storage_collector_success{collector="host"} 1
+scattered_storage_collector_success{collector="images"} 0
+scattered_storage_collector_success{collector="pools"} 1
+# HELP scattered_storage_image_used_bytes Space an image uses, without its snapshots.
+# TYPE scattered_storage_image_used_bytes gauge
+scattered_storage_image_used_bytes{image="test1",namespace="",pool="rbd"} 3.221225472e+09
+`
+
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want),
+		"scattered_storage_collector_success", "scattered_storage_image_used_bytes"); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestCollectorRun tests that Run refreshes the image metrics until its context is done.
+func TestCollectorRun(t *testing.T) {
+	runner := newClusterRunner()
+	collector := &Collector{RBD: &rbd.RadosBlockDeviceClient{Runner: runner}, Ceph: &ceph.CephCLI{Runner: runner}}
+
+	if err := collector.Run(context.Background()); !errors.Is(err, ErrInvalidImageInterval) {
+		t.Errorf("Run() without an interval error = %v, want %v", err, ErrInvalidImageInterval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	collector.ImageInterval = time.Hour
+	if err := collector.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+
+	if got := testutil.CollectAndCount(collector, "scattered_storage_image_used_bytes"); got != 2 {
+		t.Errorf("image_used_bytes series = %d, want 2", got)
+	}
+}
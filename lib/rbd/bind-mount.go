@@ -0,0 +1,71 @@
+package rbd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+// Exit statuses of mountpoint for a path that is not a mount point. util-linux before 2.36 exits
+// with 1, later versions with 32.
+const (
+	exitNotMountPoint       = 32
+	exitNotMountPointLegacy = 1
+)
+
+// BindMount makes the file or directory source visible at target as well, read-only if asked. The
+// target must exist, as an empty file for a device and as a directory for a directory. Mounting a
+// target twice stacks the mounts, so check IsMountPoint first.
+func (c *RadosBlockDeviceClient) BindMount(source, target string, readOnly bool) (err error) {
+	c, span := c.startSpan("BindMount", tracing.MountPointKey.String(target))
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Str("Source", source).Str("Target", target).Bool("ReadOnly", readOnly).Msg("BindMount")
+
+	options := "bind"
+	if readOnly {
+		options = "bind,ro"
+	}
+
+	if _, err := c.run("mount", "-o", options, source, target); err != nil {
+		return fmt.Errorf("%w: %s", ErrMountFailed, err.Error())
+	}
+
+	return nil
+}
+
+// IsMountPoint reports whether a file or directory is mounted on, such as the target of BindMount.
+func (c *RadosBlockDeviceClient) IsMountPoint(path string) (_ bool, err error) {
+	c, span := c.startSpan("IsMountPoint", tracing.MountPointKey.String(path))
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Str("Path", path).Msg("IsMountPoint")
+
+	if _, err := c.run("mountpoint", "-q", path); err != nil {
+		switch helpers.ExitCode(err) {
+		case exitNotMountPoint, exitNotMountPointLegacy:
+			return false, nil
+		}
+
+		return false, fmt.Errorf("ERROR: mountpoint failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// UnmountPath unmounts whatever is mounted on a path, unlike Unmount, which unmounts an image
+// wherever it is mounted.
+func (c *RadosBlockDeviceClient) UnmountPath(path string) (err error) {
+	c, span := c.startSpan("UnmountPath", tracing.MountPointKey.String(path))
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Str("Path", path).Msg("UnmountPath")
+
+	if _, err := c.run("umount", path); err != nil {
+		return fmt.Errorf("ERROR: umount failed: %w", err)
+	}
+
+	return nil
+}
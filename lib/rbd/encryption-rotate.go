@@ -0,0 +1,110 @@
+package rbd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+var ErrImageMapped = errors.New("rbd image is mapped")
+
+// RotateEncryptionKey replaces the passphrase of an encrypted image with a new key from provider.
+// The new key is added to a free LUKS key slot and must open the image before the provider commits
+// it; only then is the slot of the previous key removed. Until the commit the provider keeps
+// returning the previous key, so that an image interrupted at any step still opens with the key it
+// returns. An image that is not mapped is mapped for the rotation and unmapped again. Images
+// encrypted by librbd must not be mapped, as their LUKS header is only reachable on a raw mapping.
+func (c *RadosBlockDeviceClient) RotateEncryptionKey(spec ImageSpec, provider KeyProvider) (err error) {
+	c, span := c.startSpan("RotateEncryptionKey", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if provider == nil {
+		return fmt.Errorf("%w: a key provider is required", validators.ErrInvalidEncryptionOptions)
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("RotateEncryptionKey")
+
+	recorded := c.imageMetadata(spec)[encryptionMetadataKey]
+	if recorded == "" {
+		return fmt.Errorf("%w: %s is not encrypted", ErrEncryptionMismatch, spec.String())
+	}
+
+	device, mapped := c.isMapped(spec)
+	if mapped && strings.HasPrefix(recorded, string(EncryptionModeLibRBD)+":") {
+		return fmt.Errorf("%w: %s is decrypted by librbd on %s and must be unmapped first", ErrImageMapped,
+			spec.String(), device)
+	}
+
+	if !mapped {
+		if device, err = c.mapDevice(spec); err != nil {
+			return err
+		}
+
+		if err := validators.ValidateDevicePath(device); err != nil {
+			return err
+		}
+
+		defer func() {
+			if unmapError := c.executeUnmap(device); unmapError != nil && err == nil {
+				err = unmapError
+			}
+		}()
+	}
+
+	currentFile, cleanupCurrent, err := c.passphraseFile(spec, provider, false)
+	if err != nil {
+		return err
+	}
+	defer cleanupCurrent()
+
+	return c.rekeyDevice(spec, device, provider, currentFile)
+}
+
+// rekeyDevice adds a new pending key of the provider to the LUKS header of device, checks that it
+// opens the device, commits it and removes the key slot of the key in currentFile.
+func (c *RadosBlockDeviceClient) rekeyDevice(spec ImageSpec, device string, provider KeyProvider, currentFile string) error {
+	key, err := provider.Rotate(spec)
+	if err != nil {
+		return fmt.Errorf("ERROR: could not rotate the key for %s: %w", spec.String(), err)
+	}
+
+	newFile, cleanupNew, err := writeKeyFile(key)
+	if err != nil {
+		return err
+	}
+	defer cleanupNew()
+
+	log.Info().Str("Image", spec.String()).Str("Device", device).Msg("adding rotated encryption key")
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "cryptsetup", "luksAddKey", "--batch-mode", "--key-file", currentFile, device, newFile,
+	); err != nil {
+		return fmt.Errorf("ERROR: cryptsetup luksAddKey failed: %w", err)
+	}
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "cryptsetup", "luksOpen", "--test-passphrase", "--key-file", newFile, device,
+	); err != nil {
+		return fmt.Errorf("ERROR: rotated key does not open %s: %w", device, err)
+	}
+
+	if err := provider.Commit(spec); err != nil {
+		return fmt.Errorf("ERROR: could not commit the rotated key for %s: %w", spec.String(), err)
+	}
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "cryptsetup", "luksRemoveKey", "--batch-mode", device, currentFile,
+	); err != nil {
+		return fmt.Errorf("ERROR: cryptsetup luksRemoveKey failed, the previous key still opens %s: %w", device, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package rbd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+var (
+	ErrImageEncrypted     = errors.New("rbd image is encrypted")
+	ErrEncryptionMismatch = errors.New("rbd image encryption does not match")
+	ErrKeyNotFound        = errors.New("encryption key not found")
+	ErrKeyExists          = errors.New("encryption key already exists")
+)
+
+// EncryptionMode selects where an image is encrypted.
+type EncryptionMode string
+
+const (
+	// EncryptionModeLibRBD formats the image with rbd encryption format and decrypts it in librbd,
+	// which requires the nbd map backend.
+	EncryptionModeLibRBD EncryptionMode = "librbd"
+	// EncryptionModeHost layers a dm-crypt mapping created with cryptsetup on top of the mapped
+	// device. It works with either map backend.
+	EncryptionModeHost EncryptionMode = "host"
+)
+
+// EncryptionFormat is the LUKS version used to format an image.
+type EncryptionFormat string
+
+const (
+	EncryptionFormatLUKS1 EncryptionFormat = "luks1"
+	EncryptionFormatLUKS2 EncryptionFormat = "luks2"
+
+	encryptionMetadataKey = metadataPrefix + "encryption"
+)
+
+// KeyProvider stores the passphrases protecting encrypted images, one per image. Get returns
+// ErrKeyNotFound for an image without a key and Create returns ErrKeyExists for an image that
+// already has one. Rotate stores a new pending key next to the current one and returns it; Get
+// keeps returning the current key until Commit replaces it with the pending key, so that the LUKS
+// header can be re-keyed in between, as RotateEncryptionKey does. Commit returns ErrKeyNotFound
+// when no key is pending. Delete removes both keys.
+type KeyProvider interface {
+	Get(spec ImageSpec) ([]byte, error)
+	Create(spec ImageSpec) ([]byte, error)
+	Rotate(spec ImageSpec) ([]byte, error)
+	Commit(spec ImageSpec) error
+	Delete(spec ImageSpec) error
+}
+
+// EncryptionOptions configures MountEncrypted. The passphrase is requested from KeyProvider and
+// created there when an image is formatted for the first time. Format defaults to luks2.
+type EncryptionOptions struct {
+	Mode        EncryptionMode
+	Format      EncryptionFormat
+	KeyProvider KeyProvider
+}
+
+// Validate checks the mode and format and requires a source for the passphrase.
+func (o *EncryptionOptions) Validate() error {
+	if o == nil {
+		return fmt.Errorf("%w: no options given", validators.ErrInvalidEncryptionOptions)
+	}
+
+	switch o.Mode {
+	case EncryptionModeLibRBD, EncryptionModeHost:
+	default:
+		return fmt.Errorf("%w: mode %q must be %q or %q",
+			validators.ErrInvalidEncryptionOptions, o.Mode, EncryptionModeLibRBD, EncryptionModeHost)
+	}
+
+	switch o.Format {
+	case "", EncryptionFormatLUKS1, EncryptionFormatLUKS2:
+	default:
+		return fmt.Errorf("%w: format %q must be %q or %q",
+			validators.ErrInvalidEncryptionOptions, o.Format, EncryptionFormatLUKS1, EncryptionFormatLUKS2)
+	}
+
+	if o.KeyProvider == nil {
+		return fmt.Errorf("%w: a key provider is required", validators.ErrInvalidEncryptionOptions)
+	}
+
+	return nil
+}
+
+// format returns the LUKS version, defaulting to luks2.
+func (o *EncryptionOptions) format() EncryptionFormat {
+	if o.Format == "" {
+		return EncryptionFormatLUKS2
+	}
+
+	return o.Format
+}
+
+// MountEncrypted maps, decrypts, formats on first use and mounts an encrypted RBD image. The
+// encryption mode is recorded in the image metadata when the image is first formatted, and later
+// mounts must use the same mode.
+func (c *RadosBlockDeviceClient) MountEncrypted(
+	spec ImageSpec, path string, options *EncryptionOptions, mountOptions *MountOptions,
+) (err error) {
+	c, span := c.startSpan("MountEncrypted", append(spec.attributes(), tracing.MountPointKey.String(path))...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	if err := mountOptions.Validate(); err != nil {
+		return err
+	}
+
+	if exists, err := PathExists(path); err != nil {
+		return err
+	} else if !exists {
+		return ErrMountFailed
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Mode", string(options.Mode)).Msg("MountEncrypted")
+
+	metadata := c.imageMetadata(spec)
+	recorded := metadata[encryptionMetadataKey]
+
+	if recorded != "" && recorded != encryptionRecord(options) {
+		return fmt.Errorf("%w: %s uses %s encryption", ErrEncryptionMismatch, spec.String(), recorded)
+	}
+
+	passphraseFile, cleanup, err := c.passphraseFile(spec, options.KeyProvider, recorded == "")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if options.Mode == EncryptionModeLibRBD {
+		return c.mountLibRBDEncrypted(spec, path, options, mountOptions, passphraseFile, recorded != "", metadata)
+	}
+
+	return c.mountHostEncrypted(spec, path, options, mountOptions, passphraseFile)
+}
+
+// encryptionRecord is the value stored in the image metadata for an encrypted image.
+func encryptionRecord(options *EncryptionOptions) string {
+	return string(options.Mode) + ":" + string(options.format())
+}
+
+// passphraseFile writes the key of an image to a temporary file readable by the current user only
+// and returns a function removing it again. The key is created first for new images without one.
+func (c *RadosBlockDeviceClient) passphraseFile(spec ImageSpec, provider KeyProvider, create bool) (string, func(), error) {
+	key, err := provider.Get(spec)
+	if create && errors.Is(err, ErrKeyNotFound) {
+		log.Info().Str("Image", spec.String()).Msg("creating encryption key")
+
+		key, err = provider.Create(spec)
+	}
+
+	if err != nil {
+		return "", nil, fmt.Errorf("ERROR: could not get the key for %s: %w", spec.String(), err)
+	}
+
+	return writeKeyFile(key)
+}
+
+// writeKeyFile writes a key to a temporary file readable by the current user only and returns a
+// function removing it again.
+func writeKeyFile(key []byte) (string, func(), error) {
+	file, err := os.CreateTemp("", "scattered-storage-key-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+
+	cleanup := func() {
+		if err := os.Remove(file.Name()); err != nil {
+			log.Error().Str("File", file.Name()).Str("Error", err.Error()).Msg("could not remove passphrase file")
+		}
+	}
+
+	_, writeError := file.Write(key)
+	if closeError := file.Close(); writeError == nil {
+		writeError = closeError
+	}
+
+	if writeError != nil {
+		cleanup()
+
+		return "", nil, fmt.Errorf("%w", writeError)
+	}
+
+	return file.Name(), cleanup, nil
+}
+
+// mountLibRBDEncrypted formats the image with rbd encryption format on first use, maps it with
+// rbd-nbd so that librbd decrypts it, and mounts the decrypted device.
+func (c *RadosBlockDeviceClient) mountLibRBDEncrypted(
+	spec ImageSpec, path string, options *EncryptionOptions, mountOptions *MountOptions, passphraseFile string,
+	formatted bool, metadata ImageMetadata,
+) error {
+	device, mapped := c.isMapped(spec)
+	if mapped {
+		if deviceBackend(device) != MapBackendNBD {
+			return fmt.Errorf("%w: %s is mapped by %s without librbd encryption", ErrEncryptionMismatch, spec.String(), device)
+		}
+
+		return c.mountDevice(device, path, mountOptions)
+	}
+
+	if !formatted {
+		if err := c.executeEncryptionFormat(spec, options.format(), passphraseFile); err != nil {
+			return err
+		}
+
+		if err := c.executeImageMetaSet(spec, encryptionMetadataKey, encryptionRecord(options)); err != nil {
+			return err
+		}
+	}
+
+	mapOptions, stored := c.mapOptions(spec, metadata)
+	if err := mapOptions.Validate(); err != nil {
+		return err
+	}
+
+	nbd := &nbdMapper{client: c}
+	if err := nbd.mapEncryptedImage(spec, mapOptions, options.format(), passphraseFile); err != nil {
+		return err
+	}
+
+	if !stored {
+		c.recordMapOptions(spec, mapOptions)
+	}
+
+	device, _ = c.isMapped(spec)
+
+	return c.mountDevice(device, path, mountOptions)
+}
+
+// executeEncryptionFormat runs rbd encryption format against an image that has not been mapped.
+func (c *RadosBlockDeviceClient) executeEncryptionFormat(spec ImageSpec, format EncryptionFormat, passphraseFile string) error {
+	log.Trace().Str("Image", spec.String()).Str("Format", string(format)).Msg("executeEncryptionFormat")
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "rbd", "encryption", "format", spec.String(), string(format), passphraseFile,
+	); err != nil {
+		return fmt.Errorf("ERROR: rbd encryption format failed: %w", err)
+	}
+
+	return nil
+}
+
+// mapEncryptedImage maps an image formatted with rbd encryption format, letting librbd decrypt it.
+func (m *nbdMapper) mapEncryptedImage(spec ImageSpec, options MapOptions, format EncryptionFormat, passphraseFile string) error {
+	args := []string{"map", "--exclusive"}
+	if _, ok := options["read_only"]; ok {
+		args = append(args, "--read-only")
+	}
+
+	args = append(args, "--encryption-format", string(format), "--encryption-passphrase-file", passphraseFile, spec.String())
+
+	if _, err := m.client.runWithTimeout(encryptionTimeout, "rbd-nbd", args...); err != nil {
+		return fmt.Errorf("ERROR: rbd-nbd map failed: %w", err)
+	}
+
+	return nil
+}
+
+// mountHostEncrypted maps the image, opens a dm-crypt mapping on top of it, formatting the device
+// with LUKS on first use, and mounts a filesystem created directly on the mapping.
+func (c *RadosBlockDeviceClient) mountHostEncrypted(
+	spec ImageSpec, path string, options *EncryptionOptions, mountOptions *MountOptions, passphraseFile string,
+) error {
+	device, err := c.mapDevice(spec)
+	if err != nil {
+		return err
+	}
+
+	mapperDevice, err := c.openEncryption(spec, device, options, passphraseFile)
+	if err != nil {
+		return err
+	}
+
+	info, err := c.executeListBlock(mapperDevice)
+	if err != nil {
+		return err
+	}
+
+	if len(info.Blockdevices) > 0 && info.Blockdevices[0].FSType == "" {
+		if err := c.makeFilesystem(mapperDevice, c.getFilesystemOptionDefaults(mountOptions.filesystem())); err != nil {
+			return err
+		}
+	}
+
+	return c.mountPartition(mapperDevice, path, mountOptions)
+}
+
+// cryptName returns the device mapper name of an image. Dashes in each part are doubled, so that
+// the single dashes separating the parts keep the names of different images apart.
+func cryptName(spec ImageSpec) string {
+	escape := func(part string) string {
+		return strings.ReplaceAll(part, "-", "--")
+	}
+
+	parts := []string{"rbd", escape(spec.Pool)}
+	if spec.Namespace != "" {
+		parts = append(parts, escape(spec.Namespace))
+	}
+
+	return strings.Join(append(parts, escape(spec.Image)), "-")
+}
+
+// openEncryption opens the dm-crypt mapping of a device and returns its path. A device without a
+// LUKS header is formatted first, unless it already holds partitions or other data.
+func (c *RadosBlockDeviceClient) openEncryption(
+	spec ImageSpec, device string, options *EncryptionOptions, passphraseFile string,
+) (string, error) {
+	name := cryptName(spec)
+	mapperDevice := "/dev/mapper/" + name
+
+	if err := validators.ValidateDevicePath(mapperDevice); err != nil {
+		return "", err
+	}
+
+	log.Trace().Str("Device", device).Str("Name", name).Msg("openEncryption")
+
+	if _, err := c.run("cryptsetup", "status", name); err == nil {
+		return mapperDevice, nil
+	}
+
+	if _, err := c.run("cryptsetup", "isLuks", device); err != nil {
+		if helpers.ExitCode(err) != 1 {
+			return "", fmt.Errorf("ERROR: cryptsetup isLuks failed: %w", err)
+		}
+
+		if err := c.formatEncryption(spec, device, options, passphraseFile); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "cryptsetup", "luksOpen", "--key-file", passphraseFile, device, name,
+	); err != nil {
+		return "", fmt.Errorf("ERROR: cryptsetup luksOpen failed: %w", err)
+	}
+
+	return mapperDevice, nil
+}
+
+// formatEncryption writes a LUKS header to a device that holds no data yet.
+func (c *RadosBlockDeviceClient) formatEncryption(
+	spec ImageSpec, device string, options *EncryptionOptions, passphraseFile string,
+) error {
+	info, err := c.executeListBlock(device)
+	if err != nil {
+		return err
+	}
+
+	if len(info.Blockdevices) == 0 || info.Blockdevices[0].FSType != "" || len(info.Blockdevices[0].Children) > 0 {
+		return fmt.Errorf("%w: %s holds data that is not encrypted", ErrEncryptionMismatch, device)
+	}
+
+	log.Info().Str("Device", device).Str("Format", string(options.format())).Msg("formatting device with LUKS")
+
+	if _, err := c.runWithTimeout(
+		encryptionTimeout, "cryptsetup", "luksFormat", "--batch-mode", "--type", string(options.format()),
+		"--key-file", passphraseFile, device,
+	); err != nil {
+		return fmt.Errorf("ERROR: cryptsetup luksFormat failed: %w", err)
+	}
+
+	return c.executeImageMetaSet(spec, encryptionMetadataKey, encryptionRecord(options))
+}
+
+// closeEncryption closes the dm-crypt mappings layered on top of a device. Mappings that have
+// already been closed no longer show up in the listing.
+func (c *RadosBlockDeviceClient) closeEncryption(deviceMountInfo *ListBlock) error {
+	if deviceMountInfo == nil {
+		return nil
+	}
+
+	for _, device := range deviceMountInfo.Blockdevices {
+		for _, child := range device.Children {
+			if child.Type != "crypt" {
+				continue
+			}
+
+			log.Trace().Str("Name", child.Name).Msg("closeEncryption")
+
+			if _, err := c.run("cryptsetup", "luksClose", child.Name); err != nil {
+				return fmt.Errorf("ERROR: cryptsetup luksClose failed: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
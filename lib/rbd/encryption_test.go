@@ -0,0 +1,254 @@
+package rbd
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// memoryKeyProvider keeps keys in memory, keyed by image spec. Pending keys are kept under the
+// spec followed by '#pending'.
+type memoryKeyProvider map[string][]byte
+
+func (p memoryKeyProvider) Get(spec ImageSpec) ([]byte, error) {
+	if key, ok := p[spec.String()]; ok {
+		return key, nil
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+func (p memoryKeyProvider) Create(spec ImageSpec) ([]byte, error) {
+	if _, ok := p[spec.String()]; ok {
+		return nil, ErrKeyExists
+	}
+
+	p[spec.String()] = []byte("secret-" + spec.Image)
+
+	return p[spec.String()], nil
+}
+
+func (p memoryKeyProvider) Rotate(spec ImageSpec) ([]byte, error) {
+	if _, ok := p[spec.String()]; !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	p[spec.String()+"#pending"] = []byte("rotated-" + spec.Image)
+
+	return p[spec.String()+"#pending"], nil
+}
+
+func (p memoryKeyProvider) Commit(spec ImageSpec) error {
+	pending, ok := p[spec.String()+"#pending"]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	p[spec.String()] = pending
+	delete(p, spec.String()+"#pending")
+
+	return nil
+}
+
+func (p memoryKeyProvider) Delete(spec ImageSpec) error {
+	delete(p, spec.String())
+	delete(p, spec.String()+"#pending")
+
+	return nil
+}
+
+// TestEncryptionOptionsValidate tests the validation of the encryption options.
+func TestEncryptionOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *EncryptionOptions
+		wantErr error
+	}{
+		{name: "TestNil", wantErr: validators.ErrInvalidEncryptionOptions},
+		{name: "TestHost", options: &EncryptionOptions{Mode: EncryptionModeHost, KeyProvider: memoryKeyProvider{}}},
+		{name: "TestLibRBD", options: &EncryptionOptions{Mode: EncryptionModeLibRBD, Format: EncryptionFormatLUKS1, KeyProvider: memoryKeyProvider{}}},
+		{name: "TestMode", options: &EncryptionOptions{Mode: "fscrypt", KeyProvider: memoryKeyProvider{}}, wantErr: validators.ErrInvalidEncryptionOptions},
+		{name: "TestFormat", options: &EncryptionOptions{Mode: EncryptionModeHost, Format: "plain", KeyProvider: memoryKeyProvider{}}, wantErr: validators.ErrInvalidEncryptionOptions},
+		{name: "TestNoKey", options: &EncryptionOptions{Mode: EncryptionModeHost}, wantErr: validators.ErrInvalidEncryptionOptions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestCryptName tests that the device mapper names of different images do not collide.
+func TestCryptName(t *testing.T) {
+	first := cryptName(ImageSpec{Pool: "a-b", Image: "c"})
+	second := cryptName(ImageSpec{Pool: "a", Image: "b-c"})
+	third := cryptName(ImageSpec{Pool: "a", Namespace: "b", Image: "c"})
+
+	if first == second || first == third || second == third {
+		t.Errorf("cryptName() = %q, %q, %q", first, second, third)
+	}
+
+	if err := validators.ValidateDevicePath("/dev/mapper/" + first); err != nil {
+		t.Errorf("cryptName() = %q, %v", first, err)
+	}
+}
+
+// TestMountHostEncrypted tests that a new image is formatted with LUKS before the filesystem is created.
+func TestMountHostEncrypted(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Image: "test1"}
+	name := cryptName(spec)
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                                          showMappedKRBD,
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE":                `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0"}]}`,
+			"lsblk -J /dev/mapper/" + name + " -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": `{"blockdevices":[{"name":"` + name + `"}]}`,
+		},
+		Errors: map[string]error{
+			"cryptsetup status " + name:         &helpers.CommandError{Command: "cryptsetup", ExitStatus: 4},
+			"cryptsetup isLuks /dev/rbd0":       &helpers.CommandError{Command: "cryptsetup", ExitStatus: 1},
+			"rbd-nbd list-mapped --format json": errCommandNotFound,
+		},
+	}
+	client := &RadosBlockDeviceClient{Runner: runner}
+	keys := memoryKeyProvider{}
+
+	err := client.MountEncrypted(spec, t.TempDir(), &EncryptionOptions{Mode: EncryptionModeHost, KeyProvider: keys}, nil)
+	if err != nil {
+		t.Fatalf("MountEncrypted() error = %v", err)
+	}
+
+	if _, err := keys.Get(spec); err != nil {
+		t.Errorf("MountEncrypted() did not create a key: %v", err)
+	}
+
+	for _, want := range []string{
+		"rbd image-meta set rbd/test1 scattered-storage.encryption host:luks2",
+		"mkfs.xfs -b size=4096 -K /dev/mapper/" + name,
+	} {
+		if !runner.Called(want) {
+			t.Errorf("MountEncrypted() calls = %v, want %q", runner.Calls, want)
+		}
+	}
+
+	for _, call := range runner.Calls {
+		if fields := strings.Fields(call); len(fields) > 6 && fields[1] == "luksFormat" {
+			if _, err := os.Stat(fields[6]); !os.IsNotExist(err) {
+				t.Errorf("passphrase file %s was not removed", fields[6])
+			}
+		}
+	}
+}
+
+// TestMountEncryptionMismatch tests that an image is only mounted with the encryption it was formatted with.
+func TestMountEncryptionMismatch(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Image: "test1"}
+	metadata := `{"scattered-storage.encryption":"librbd:luks2"}`
+	runner := &helperstest.Runner{Replies: map[string]string{"rbd image-meta list rbd/test1 --format json": metadata}}
+	client := &RadosBlockDeviceClient{Runner: runner}
+
+	err := client.MountEncrypted(spec, t.TempDir(), &EncryptionOptions{Mode: EncryptionModeHost, KeyProvider: memoryKeyProvider{}}, nil)
+	if !errors.Is(err, ErrEncryptionMismatch) {
+		t.Errorf("MountEncrypted() error = %v, want %v", err, ErrEncryptionMismatch)
+	}
+
+	if err := client.Mount(spec, t.TempDir(), nil); !errors.Is(err, ErrImageEncrypted) {
+		t.Errorf("Mount() error = %v, want %v", err, ErrImageEncrypted)
+	}
+}
+
+// TestRotateEncryptionKey tests that the previous key is only removed from the LUKS header once the
+// rotated key opens the image and has been committed.
+func TestRotateEncryptionKey(t *testing.T) {
+	const (
+		metadataList = "rbd image-meta list rbd/test1 --format json"
+		showMapped   = "rbd showmapped --format json"
+	)
+
+	testPassphraseFailed := &helpers.CommandError{Command: "cryptsetup luksOpen", ExitStatus: 2}
+
+	tests := []struct {
+		name       string
+		encryption string
+		mapped     string
+		failTest   bool
+		wantErr    error
+		wantKey    string
+		wantCalls  []string
+	}{
+		{
+			name: "TestHostMapped", encryption: "host:luks2", mapped: showMappedKRBD, wantKey: "rotated-test1",
+			wantCalls: []string{"luksAddKey", "luksOpen", "luksRemoveKey"},
+		},
+		{
+			name: "TestLibRBDUnmapped", encryption: "librbd:luks2", mapped: "[]", wantKey: "rotated-test1",
+			wantCalls: []string{"map", "luksAddKey", "luksOpen", "luksRemoveKey", "unmap"},
+		},
+		{
+			name: "TestRotatedKeyFails", encryption: "host:luks2", mapped: showMappedKRBD, failTest: true,
+			wantErr: testPassphraseFailed, wantKey: "secret-test1", wantCalls: []string{"luksAddKey", "luksOpen"},
+		},
+		{
+			name: "TestLibRBDMapped", encryption: "librbd:luks2", mapped: showMappedKRBD, wantErr: ErrImageMapped,
+			wantKey: "secret-test1",
+		},
+		{name: "TestNotEncrypted", mapped: showMappedKRBD, wantErr: ErrEncryptionMismatch, wantKey: "secret-test1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := ImageSpec{Pool: "rbd", Image: "test1"}
+			keys := memoryKeyProvider{spec.String(): []byte("secret-test1")}
+
+			runner := &helperstest.Runner{Replies: map[string]string{
+				metadataList: `{"scattered-storage.encryption":"` + tt.encryption + `"}`,
+				showMapped:   tt.mapped,
+			}}
+			if tt.encryption == "" {
+				runner.Replies[metadataList] = "{}"
+			}
+
+			runner.OnCall = func(line string) {
+				switch {
+				case strings.HasSuffix(line, " map rbd/test1"):
+					runner.Replies[showMapped] = showMappedKRBD
+				case strings.Contains(line, "--test-passphrase") && tt.failTest:
+					runner.Errors = map[string]error{line: testPassphraseFailed}
+				}
+			}
+
+			err := (&RadosBlockDeviceClient{Runner: runner}).RotateEncryptionKey(spec, keys)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RotateEncryptionKey() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if key, _ := keys.Get(spec); string(key) != tt.wantKey {
+				t.Errorf("key after RotateEncryptionKey() = %q, want %q", key, tt.wantKey)
+			}
+
+			var calls []string
+
+			for _, call := range runner.Calls {
+				for _, field := range strings.Fields(call) {
+					if field == "map" || field == "unmap" || strings.HasPrefix(field, "luks") {
+						calls = append(calls, field)
+
+						break
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("RotateEncryptionKey() ran %q, want %q", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -2,121 +2,78 @@ package rbd
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
-	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
-func (c *RadosBlockDeviceClient) executeRBDMap(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
+// executeRBDMap maps an image, or a snapshot read-only, with the backend and map options of the
+// image. The options are recorded on the image the first time it is mapped.
+func (c *RadosBlockDeviceClient) executeRBDMap(spec ImageSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
 	}
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
-	}
-
-	var stdOut, stdErr bytes.Buffer
+	metadata := c.imageMetadata(spec)
+	backend := c.mapBackend(metadata)
+	options, stored := c.mapOptions(spec, metadata)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if err := options.Validate(); err != nil {
+		return err
+	}
 
-	cmd := exec.CommandContext(ctx, "rbd", "--exclusive", "--options", "lock_timeout=10", "--pool", pool, "map", name)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
+	log.Trace().Str("Image", spec.String()).Str("Backend", string(backend)).Str("Options", options.String()).
+		Msg("executeRBDMap")
 
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
+	if err := c.mapper(backend).mapImage(spec, options); err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("%w", err)
+	if !stored && spec.Snapshot == "" {
+		c.recordMapOptions(spec, options)
 	}
 
 	return nil
 }
 
-func (c *RadosBlockDeviceClient) executeAddLock(pool, name string) error {
+func (c *RadosBlockDeviceClient) executeAddLock(spec ImageSpec) error {
 	log.Trace().Msg("starting executeAddLock")
 
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
-	}
-
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	imageReference := pool + "/" + name
-
-	cmd := exec.CommandContext(ctx, "rbd", "lock", "add", imageReference, "scattered-storage-lock")
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("rbd", "lock", "add", spec.String(), "scattered-storage-lock"); err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
 	return nil
 }
 
-func (c *RadosBlockDeviceClient) executeListLocks(pool, name string) ([]*Lock, error) {
+func (c *RadosBlockDeviceClient) executeListLocks(spec ImageSpec) ([]*Lock, error) {
 	log.Trace().Msg("executeListLocks")
 
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "rbd", "--format", "json", "-p", pool, "lock", "ls", name)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
 	var list []*Lock
 
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("rbd", "--format", "json", "lock", "ls", spec.String())
+	if err != nil {
 		return list, fmt.Errorf("ERROR: rbd lock ls failed:\n%w", err)
 	}
 
-	if err := json.Unmarshal(stdOut.Bytes(), &list); err != nil {
+	if err := json.Unmarshal(stdOut, &list); err != nil {
 		return list, fmt.Errorf(
-			"ERROR: json for rbd lock ls could not unmarshal: %w\n%s", err, stdOut.String(),
+			"ERROR: json for rbd lock ls could not unmarshal: %w\n%s", err, string(stdOut),
 		)
 	}
 
 	return list, nil
 }
 
-func (c *RadosBlockDeviceClient) executeRemoveLock(pool, name string, lock *Lock) error {
-	log.Trace().Str("Pool", pool).Str("Name", name).Interface("Lock", lock).Msg("executeRemoveLock")
-
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+func (c *RadosBlockDeviceClient) executeRemoveLock(spec ImageSpec, lock *Lock) error {
+	log.Trace().Str("Image", spec.String()).Interface("Lock", lock).Msg("executeRemoveLock")
 
-	imageReference := pool + "/" + name
-
-	cmd := exec.CommandContext(ctx, "rbd", "lock", "remove", imageReference, "'"+lock.ID+"'", lock.Locker)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("rbd", "lock", "remove", spec.String(), lock.ID, lock.Locker); err != nil {
 		return fmt.Errorf("ERROR: rbd lock failed: %w", err)
 	}
 
@@ -126,32 +83,38 @@ func (c *RadosBlockDeviceClient) executeRemoveLock(pool, name string, lock *Lock
 func (c *RadosBlockDeviceClient) executeWipeFSWithoutAction(device string) (*WipeFS, error) {
 	log.Trace().Str("Device", device).Msg("executeWipeFSWithoutAction")
 
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "wipefs", "-J", "-n", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("wipefs", "-J", "-n", device)
+	if err != nil {
 		return &WipeFS{
 			Signatures: nil,
 		}, fmt.Errorf("ERROR: wipefs failed: %w", err)
 	}
 
+	// wipefs prints nothing at all for a device without signatures.
+	if len(bytes.TrimSpace(stdOut)) == 0 {
+		return &WipeFS{Signatures: nil}, nil
+	}
+
 	var signatures *WipeFS
 
-	if err := json.Unmarshal(stdOut.Bytes(), &signatures); err != nil {
+	if err := json.Unmarshal(stdOut, &signatures); err != nil {
 		return &WipeFS{
-				Signatures: nil,
-			}, fmt.Errorf(
-				"ERROR: json for wipefs could not unmarshal:\n%w\n%s", err, stdOut.String(),
-			)
+			Signatures: nil,
+		}, fmt.Errorf(
+			"ERROR: json for wipefs could not unmarshal:\n%w\n%s", err, string(stdOut),
+		)
 	}
 
 	return signatures, nil
 }
+
+// executeWipeFSAll erases every signature on a device, so that it can be partitioned and formatted.
+func (c *RadosBlockDeviceClient) executeWipeFSAll(device string) error {
+	log.Info().Str("Device", device).Msg("executeWipeFSAll")
+
+	if _, err := c.run("wipefs", "--all", device); err != nil {
+		return fmt.Errorf("ERROR: wipefs failed: %w", err)
+	}
+
+	return nil
+}
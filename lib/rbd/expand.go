@@ -0,0 +1,99 @@
+package rbd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+// ExpandFilesystem grows the partition and the filesystem of a mounted image to the size of its
+// device, after the image was resized with ResizeRBD. krbd and rbd-nbd pick up the new size of
+// the image on their own. XFS is grown through its mount point and ext4 through its partition, both
+// online. A filesystem written to the whole device has no partition to grow.
+func (c *RadosBlockDeviceClient) ExpandFilesystem(spec ImageSpec) (err error) {
+	c, span := c.startSpan("ExpandFilesystem", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("ExpandFilesystem")
+
+	if encryption := c.imageMetadata(spec)[encryptionMetadataKey]; encryption != "" {
+		return fmt.Errorf("%w: %s uses %s encryption", ErrImageEncrypted, spec.String(), encryption)
+	}
+
+	device, mapped := c.isMapped(spec)
+
+	partition, mountPoint := c.mountedPartition(spec)
+	if !mapped || mountPoint == "" {
+		return fmt.Errorf("%w: %s", ErrNotMounted, spec.String())
+	}
+
+	if partition != device {
+		if err := c.executeGrowPartition(device); err != nil {
+			return err
+		}
+
+		if err := c.Partprobe(device); err != nil {
+			return err
+		}
+	}
+
+	filesystem, err := c.executeBlockID(partition)
+	if err != nil {
+		return err
+	}
+
+	return c.executeGrowFilesystem(filesystem.Type, partition, mountPoint)
+}
+
+// executeGrowPartition moves the backup GPT header to the new end of the device and recreates the
+// partition written by PartitionEntireDisk at the same start, so that it spans the whole device.
+func (c *RadosBlockDeviceClient) executeGrowPartition(device string) error {
+	log.Trace().Str("Device", device).Msg("executeGrowPartition")
+
+	if _, err := c.run(
+		"sgdisk", "--move-second-header", "--delete", "1", "--new", "1::0", "--typecode", "1:8300", device,
+	); err != nil {
+		return fmt.Errorf("ERROR: sgdisk grow failed: %w", err)
+	}
+
+	return nil
+}
+
+// executeGrowFilesystem runs xfs_growfs or resize2fs, which may take as long as mkfs.
+func (c *RadosBlockDeviceClient) executeGrowFilesystem(fsType, partition, mountPoint string) error {
+	log.Info().Str("Device", partition).Str("Filesystem", fsType).Msg("executeGrowFilesystem")
+
+	var command string
+
+	var args []string
+
+	switch fsType {
+	case TagXfs:
+		command, args = "xfs_growfs", []string{mountPoint}
+	case TagExt4:
+		command, args = "resize2fs", []string{partition}
+	default:
+		return fmt.Errorf("%w: %s on %s", ErrNoFilesystem, fsType, partition)
+	}
+
+	if _, err := c.runWithTimeout(c.makeFilesystemTimeout(), command, args...); err != nil {
+		return fmt.Errorf("ERROR: %s failed: %w", command, err)
+	}
+
+	return nil
+}
+
+// makeFilesystemTimeout returns the timeout of commands writing a whole filesystem.
+func (c *RadosBlockDeviceClient) makeFilesystemTimeout() time.Duration {
+	if c.MakeFilesystemTimeout > 0 {
+		return c.MakeFilesystemTimeout
+	}
+
+	return makeFilesystemTimeout
+}
@@ -0,0 +1,113 @@
+package rbd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+// TestExpandFilesystem tests the commands growing the partition and filesystem of a mounted image.
+func TestExpandFilesystem(t *testing.T) {
+	const (
+		lsblkExt4  = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","mountpoint":"/srv/test-1","fstype":"ext4","type":"part"}]}]}`
+		lsblkWhole = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","mountpoint":"/srv/test-1","fstype":"xfs","type":"disk"}]}`
+		growDisk   = "sgdisk --move-second-header --delete 1 --new 1::0 --typecode 1:8300 /dev/rbd0"
+	)
+
+	tests := []struct {
+		name      string
+		lsblk     string
+		blkid     string
+		wantCalls []string
+		notCalled string
+		wantErr   error
+	}{
+		{
+			name:      "TestXFSPartition",
+			lsblk:     lsblkMounted,
+			blkid:     blkidExported,
+			wantCalls: []string{growDisk, "partprobe /dev/rbd0", "xfs_growfs /srv/test-1"},
+		},
+		{
+			name:      "TestExt4Partition",
+			lsblk:     lsblkExt4,
+			blkid:     "DEVNAME=/dev/rbd0p1\nTYPE=ext4\n",
+			wantCalls: []string{growDisk, "resize2fs /dev/rbd0p1"},
+		},
+		{
+			name:      "TestWholeDevice",
+			lsblk:     lsblkWhole,
+			blkid:     "DEVNAME=/dev/rbd0\nTYPE=xfs\n",
+			wantCalls: []string{"xfs_growfs /srv/test-1"},
+			notCalled: growDisk,
+		},
+		{
+			name:    "TestNotMounted",
+			lsblk:   `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","type":"disk"}]}`,
+			wantErr: ErrNotMounted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{
+				Replies: map[string]string{
+					"rbd showmapped --format json":                           showMappedKRBD,
+					"rbd-nbd list-mapped --format json":                      "[]",
+					"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": tt.lsblk,
+					"blkid -o export /dev/rbd0p1":                            tt.blkid,
+					"blkid -o export /dev/rbd0":                              tt.blkid,
+				},
+			}
+			client := &RadosBlockDeviceClient{Runner: runner}
+
+			err := client.ExpandFilesystem(ImageSpec{Pool: "rbd", Image: "test1"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExpandFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for _, want := range tt.wantCalls {
+				if !runner.Called(want) {
+					t.Errorf("ExpandFilesystem() did not run %q, ran %q", want, runner.Calls)
+				}
+			}
+
+			if tt.notCalled != "" && runner.Called(tt.notCalled) {
+				t.Errorf("ExpandFilesystem() ran %q", tt.notCalled)
+			}
+		})
+	}
+}
+
+// TestBindMount tests bind mounts and the mount point check used to keep them idempotent.
+func TestBindMount(t *testing.T) {
+	runner := &helperstest.Runner{
+		Errors: map[string]error{
+			"mountpoint -q /target/new": &helpers.CommandError{Command: "mountpoint", ExitStatus: 32},
+			"mountpoint -q /target/bad": &helpers.CommandError{Command: "mountpoint", ExitStatus: 2},
+		},
+	}
+	client := &RadosBlockDeviceClient{Runner: runner}
+
+	if mounted, err := client.IsMountPoint("/target/new"); mounted || err != nil {
+		t.Errorf("IsMountPoint() = %v, %v, want false", mounted, err)
+	}
+
+	if mounted, err := client.IsMountPoint("/target/used"); !mounted || err != nil {
+		t.Errorf("IsMountPoint() = %v, %v, want true", mounted, err)
+	}
+
+	if _, err := client.IsMountPoint("/target/bad"); err == nil {
+		t.Error("IsMountPoint() of a failing check succeeded")
+	}
+
+	if err := client.BindMount("/staging", "/target/new", true); err != nil {
+		t.Fatalf("BindMount() error = %v", err)
+	}
+
+	if want := "mount -o bind,ro /staging /target/new"; !runner.Called(want) {
+		t.Errorf("BindMount() ran %q, want %q", runner.Calls, want)
+	}
+}
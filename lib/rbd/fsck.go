@@ -0,0 +1,225 @@
+package rbd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+var (
+	ErrFilesystemMounted  = errors.New("rbd image is mounted")
+	ErrNoFilesystem       = errors.New("no supported filesystem found")
+	ErrFilesystemCheckRun = errors.New("filesystem check could not run")
+)
+
+// FilesystemCheck is the report of CheckFilesystem. ExitCode is the exit status of xfs_repair or
+// e2fsck and Meaning describes it. ErrorsFound is set when the check found corruption, and
+// ErrorsCorrected and ErrorsRemaining tell whether a repair fixed it. Output holds what the
+// command wrote to stdout and stderr.
+type FilesystemCheck struct {
+	Image           string `json:"image"`
+	Device          string `json:"device"`
+	Filesystem      string `json:"filesystem"`
+	Repair          bool   `json:"repair"`
+	ExitCode        int    `json:"exitCode"`
+	Meaning         string `json:"meaning"`
+	ErrorsFound     bool   `json:"errorsFound"`
+	ErrorsCorrected bool   `json:"errorsCorrected"`
+	ErrorsRemaining bool   `json:"errorsRemaining"`
+	Output          string `json:"output"`
+}
+
+// CheckFilesystem maps an image if needed and checks the filesystem on it, for example before it
+// is mounted again after a node crashed. The image must not be mounted. With repair set, the
+// filesystem is repaired as well; otherwise it is only checked and never modified. Snapshots can
+// only be checked.
+func (c *RadosBlockDeviceClient) CheckFilesystem(spec ImageSpec, repair bool) (_ *FilesystemCheck, err error) {
+	c, span := c.startSpan("CheckFilesystem", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	if repair && spec.Snapshot != "" {
+		return nil, fmt.Errorf("%w: snapshots are read-only and cannot be repaired", ErrFilesystemCheckRun)
+	}
+
+	log.Trace().Str("Image", spec.String()).Bool("Repair", repair).Msg("CheckFilesystem")
+
+	if encryption := c.imageMetadata(spec)[encryptionMetadataKey]; encryption != "" {
+		return nil, fmt.Errorf("%w: %s uses %s encryption", ErrImageEncrypted, spec.String(), encryption)
+	}
+
+	device, err := c.mapDevice(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.executeListBlock(device)
+	if err != nil {
+		return nil, err
+	}
+
+	if partition, mountPoint := mountedDevice(info); partition != "" {
+		return nil, fmt.Errorf("%w: %s is mounted at %s", ErrFilesystemMounted, partition, mountPoint)
+	}
+
+	target, filesystem, err := c.findFilesystem(device, info)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &FilesystemCheck{Image: spec.String(), Device: target, Filesystem: filesystem, Repair: repair}
+
+	if err := c.executeFilesystemCheck(report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// findFilesystem returns the first partition of a device holding a supported filesystem, or the
+// device itself when the filesystem was written to the whole device.
+func (c *RadosBlockDeviceClient) findFilesystem(device string, info *ListBlock) (string, string, error) {
+	candidates := []string{device}
+
+	if len(info.Blockdevices) > 0 {
+		for _, child := range info.Blockdevices[0].Children {
+			candidates = append([]string{child.Path}, candidates...)
+		}
+	}
+
+	for _, candidate := range candidates {
+		blockID, err := c.executeBlockID(candidate)
+		if err != nil {
+			log.Trace().Str("Device", candidate).Str("Error", err.Error()).Msg("no filesystem found")
+
+			continue
+		}
+
+		if c.isValidFilesystemType(blockID.Type) {
+			return candidate, blockID.Type, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("%w: %s", ErrNoFilesystem, device)
+}
+
+// executeFilesystemCheck runs xfs_repair or e2fsck and fills in the result in the report.
+func (c *RadosBlockDeviceClient) executeFilesystemCheck(report *FilesystemCheck) error {
+	var command string
+
+	var args []string
+
+	switch report.Filesystem {
+	case TagXfs:
+		command = "xfs_repair"
+		if !report.Repair {
+			args = append(args, "-n")
+		}
+	case TagExt4:
+		command = "e2fsck"
+		if report.Repair {
+			args = append(args, "-f", "-y")
+		} else {
+			args = append(args, "-f", "-n")
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrNoFilesystem, report.Filesystem)
+	}
+
+	log.Info().Str("Device", report.Device).Str("Command", command).Bool("Repair", report.Repair).
+		Msg("executeFilesystemCheck")
+
+	stdOut, err := c.runWithTimeout(checkFilesystemTimeout, command, append(args, report.Device)...)
+
+	output := []string{strings.TrimSpace(string(stdOut))}
+
+	var commandError *helpers.CommandError
+	if errors.As(err, &commandError) {
+		output = append(output, commandError.Stderr)
+	}
+
+	report.Output = strings.TrimSpace(strings.Join(output, "\n"))
+	report.ExitCode = helpers.ExitCode(err)
+
+	if report.ExitCode < 0 {
+		return fmt.Errorf("%w: %s", ErrFilesystemCheckRun, err.Error())
+	}
+
+	if report.Filesystem == TagXfs {
+		return report.interpretXFS()
+	}
+
+	return report.interpretE2fsck()
+}
+
+// interpretXFS explains the exit status of xfs_repair.
+func (r *FilesystemCheck) interpretXFS() error {
+	switch {
+	case r.ExitCode == 0 && r.Repair:
+		r.Meaning = "no errors remain"
+		r.ErrorsFound = strings.Contains(r.Output, "fixing") || strings.Contains(r.Output, "correcting")
+		r.ErrorsCorrected = r.ErrorsFound
+	case r.ExitCode == 0:
+		r.Meaning = "no corruption detected"
+	case r.ExitCode == 1 && !r.Repair:
+		r.Meaning = "corruption detected"
+		r.ErrorsFound = true
+		r.ErrorsRemaining = true
+	case r.ExitCode == 2:
+		r.Meaning = "the log holds changes that must be replayed by mounting the filesystem first"
+		r.ErrorsFound = true
+		r.ErrorsRemaining = true
+	default:
+		return fmt.Errorf("%w: xfs_repair exited with %d: %s", ErrFilesystemCheckRun, r.ExitCode, r.Output)
+	}
+
+	return nil
+}
+
+// e2fsck exit status bits, see e2fsck(8).
+const (
+	e2fsckCorrected   = 1
+	e2fsckReboot      = 2
+	e2fsckUncorrected = 4
+	e2fsckFailed      = 8
+)
+
+// interpretE2fsck explains the exit status of e2fsck, which is a combination of bits.
+func (r *FilesystemCheck) interpretE2fsck() error {
+	if r.ExitCode >= e2fsckFailed {
+		return fmt.Errorf("%w: e2fsck exited with %d: %s", ErrFilesystemCheckRun, r.ExitCode, r.Output)
+	}
+
+	var meanings []string
+
+	if r.ExitCode&e2fsckCorrected != 0 {
+		meanings = append(meanings, "errors corrected")
+		r.ErrorsFound = true
+		r.ErrorsCorrected = true
+	}
+
+	if r.ExitCode&e2fsckReboot != 0 {
+		meanings = append(meanings, "the system should be rebooted")
+	}
+
+	if r.ExitCode&e2fsckUncorrected != 0 {
+		meanings = append(meanings, "errors left uncorrected")
+		r.ErrorsFound = true
+		r.ErrorsRemaining = true
+	}
+
+	if len(meanings) == 0 {
+		meanings = append(meanings, "no errors")
+	}
+
+	r.Meaning = strings.Join(meanings, ", ")
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package rbd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+// TestCheckFilesystem tests the commands run for each filesystem and the interpretation of their exit status.
+func TestCheckFilesystem(t *testing.T) {
+	tests := []struct {
+		name        string
+		fsType      string
+		repair      bool
+		exitCode    int
+		wantCommand string
+		wantReport  FilesystemCheck
+		wantErr     error
+	}{
+		{
+			name:        "TestXFSClean",
+			fsType:      TagXfs,
+			wantCommand: "xfs_repair -n /dev/rbd0p1",
+			wantReport:  FilesystemCheck{Meaning: "no corruption detected"},
+		},
+		{
+			name:        "TestXFSCorrupt",
+			fsType:      TagXfs,
+			exitCode:    1,
+			wantCommand: "xfs_repair -n /dev/rbd0p1",
+			wantReport:  FilesystemCheck{Meaning: "corruption detected", ErrorsFound: true, ErrorsRemaining: true},
+		},
+		{
+			name:        "TestXFSDirtyLog",
+			fsType:      TagXfs,
+			repair:      true,
+			exitCode:    2,
+			wantCommand: "xfs_repair /dev/rbd0p1",
+			wantReport:  FilesystemCheck{ErrorsFound: true, ErrorsRemaining: true},
+		},
+		{
+			name:        "TestExt4Corrected",
+			fsType:      TagExt4,
+			repair:      true,
+			exitCode:    3,
+			wantCommand: "e2fsck -f -y /dev/rbd0p1",
+			wantReport: FilesystemCheck{
+				Meaning: "errors corrected, the system should be rebooted", ErrorsFound: true, ErrorsCorrected: true,
+			},
+		},
+		{
+			name:        "TestExt4Uncorrected",
+			fsType:      TagExt4,
+			exitCode:    4,
+			wantCommand: "e2fsck -f -n /dev/rbd0p1",
+			wantReport:  FilesystemCheck{Meaning: "errors left uncorrected", ErrorsFound: true, ErrorsRemaining: true},
+		},
+		{
+			name:        "TestExt4Failed",
+			fsType:      TagExt4,
+			exitCode:    8,
+			wantCommand: "e2fsck -f -n /dev/rbd0p1",
+			wantErr:     ErrFilesystemCheckRun,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{
+				Replies: map[string]string{
+					"rbd showmapped --format json":                           showMappedKRBD,
+					"rbd-nbd list-mapped --format json":                      "[]",
+					"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","type":"part"}]}]}`,
+					"blkid -o export /dev/rbd0p1":                            "UUID=976330da\nTYPE=" + tt.fsType + "\n",
+				},
+				Errors: map[string]error{},
+			}
+
+			if tt.exitCode != 0 {
+				runner.Errors[tt.wantCommand] = &helpers.CommandError{Command: tt.wantCommand, ExitStatus: tt.exitCode}
+			}
+
+			client := &RadosBlockDeviceClient{Runner: runner}
+
+			report, err := client.CheckFilesystem(ImageSpec{Pool: "rbd", Image: "test1"}, tt.repair)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CheckFilesystem() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !runner.Called(tt.wantCommand) {
+				t.Errorf("CheckFilesystem() calls = %v, want %q", runner.Calls, tt.wantCommand)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if report.Filesystem != tt.fsType || report.Device != "/dev/rbd0p1" || report.ExitCode != tt.exitCode {
+				t.Errorf("CheckFilesystem() = %+v", report)
+			}
+
+			if tt.wantReport.Meaning != "" && report.Meaning != tt.wantReport.Meaning {
+				t.Errorf("CheckFilesystem() meaning = %q, want %q", report.Meaning, tt.wantReport.Meaning)
+			}
+
+			if report.ErrorsFound != tt.wantReport.ErrorsFound || report.ErrorsCorrected != tt.wantReport.ErrorsCorrected ||
+				report.ErrorsRemaining != tt.wantReport.ErrorsRemaining {
+				t.Errorf("CheckFilesystem() = %+v, want %+v", report, tt.wantReport)
+			}
+		})
+	}
+}
+
+// TestCheckFilesystemMounted tests that mounted filesystems are not checked.
+func TestCheckFilesystemMounted(t *testing.T) {
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                           showMappedKRBD,
+			"rbd-nbd list-mapped --format json":                      "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+		},
+	}
+	client := &RadosBlockDeviceClient{Runner: runner}
+
+	if _, err := client.CheckFilesystem(ImageSpec{Pool: "rbd", Image: "test1"}, true); !errors.Is(err, ErrFilesystemMounted) {
+		t.Errorf("CheckFilesystem() error = %v, want %v", err, ErrFilesystemMounted)
+	}
+}
@@ -0,0 +1,128 @@
+package rbd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImageSpec identifies an RBD image, or one of its snapshots, using the rbd image-spec
+// notation 'pool/namespace/image@snapshot'. Namespace and Snapshot are optional.
+type ImageSpec struct {
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+	Image     string `json:"image"`
+	Snapshot  string `json:"snapshot"`
+}
+
+// ParseImageSpec parses 'pool/image', 'pool/namespace/image' and either form followed by '@snapshot'.
+func ParseImageSpec(spec string) (ImageSpec, error) {
+	result := ImageSpec{}
+
+	imagePart := spec
+	if index := strings.LastIndex(spec, "@"); index >= 0 {
+		imagePart = spec[:index]
+		result.Snapshot = spec[index+1:]
+
+		if result.Snapshot == "" {
+			return ImageSpec{}, fmt.Errorf("%w: %q", validators.ErrInvalidImageSpec, spec)
+		}
+	}
+
+	parts := strings.Split(imagePart, "/")
+	switch len(parts) {
+	case 2:
+		result.Pool, result.Image = parts[0], parts[1]
+	case 3:
+		result.Pool, result.Namespace, result.Image = parts[0], parts[1], parts[2]
+	default:
+		return ImageSpec{}, fmt.Errorf("%w: %q", validators.ErrInvalidImageSpec, spec)
+	}
+
+	if err := result.Validate(); err != nil {
+		return ImageSpec{}, err
+	}
+
+	return result, nil
+}
+
+// String formats the spec the way the rbd command expects it.
+func (s ImageSpec) String() string {
+	var builder strings.Builder
+
+	builder.WriteString(s.Pool)
+	builder.WriteString("/")
+
+	if s.Namespace != "" {
+		builder.WriteString(s.Namespace)
+		builder.WriteString("/")
+	}
+
+	builder.WriteString(s.Image)
+
+	if s.Snapshot != "" {
+		builder.WriteString("@")
+		builder.WriteString(s.Snapshot)
+	}
+
+	return builder.String()
+}
+
+// Validate checks each part of the spec. The snapshot is optional.
+func (s ImageSpec) Validate() error {
+	if err := validators.ValidatePoolName(s.Pool); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateNamespaceName(s.Namespace); err != nil {
+		return err
+	}
+
+	if err := validators.ValidateImageName(s.Image); err != nil {
+		return err
+	}
+
+	if s.Snapshot != "" {
+		return validators.ValidateSnapshotName(s.Snapshot)
+	}
+
+	return nil
+}
+
+// validateImage checks the spec for operations that act on the image itself and not on a snapshot.
+func (s ImageSpec) validateImage() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	if s.Snapshot != "" {
+		return validators.ErrSnapshotNotSupported
+	}
+
+	return nil
+}
+
+// attributes returns the attributes of the spans of operations on the image.
+func (s ImageSpec) attributes() []attribute.KeyValue {
+	attributes := []attribute.KeyValue{
+		tracing.PoolKey.String(s.Pool), tracing.NamespaceKey.String(s.Namespace), tracing.ImageKey.String(s.Image),
+	}
+
+	if s.Snapshot != "" {
+		attributes = append(attributes, tracing.SnapshotKey.String(s.Snapshot))
+	}
+
+	return attributes
+}
+
+// matches reports whether a mapped image refers to this spec. rbd reports a missing snapshot as '-'.
+func (s ImageSpec) matches(pool, namespace, image, snapshot string) bool {
+	if snapshot == "-" {
+		snapshot = ""
+	}
+
+	return s.Pool == pool && s.Namespace == namespace && s.Image == image && s.Snapshot == snapshot
+}
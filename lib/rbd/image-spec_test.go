@@ -0,0 +1,99 @@
+package rbd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// TestParseImageSpec tests the ParseImageSpec function and the String round trip.
+func TestParseImageSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    ImageSpec
+		wantErr error
+	}{
+		{
+			name: "TestPoolImage",
+			spec: "rbd/test-image",
+			want: ImageSpec{Pool: "rbd", Image: "test-image"},
+		},
+		{
+			name: "TestPoolNamespaceImage",
+			spec: "rbd/tenant1/test-image",
+			want: ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "test-image"},
+		},
+		{
+			name: "TestPoolNamespaceImageSnapshot",
+			spec: "rbd/tenant1/test-image@daily",
+			want: ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "test-image", Snapshot: "daily"},
+		},
+		{
+			name: "TestPoolImageSnapshot",
+			spec: "rbd/test-image@daily",
+			want: ImageSpec{Pool: "rbd", Image: "test-image", Snapshot: "daily"},
+		},
+		{
+			name:    "TestMissingPool",
+			spec:    "test-image",
+			wantErr: validators.ErrInvalidImageSpec,
+		},
+		{
+			name:    "TestEmptySnapshot",
+			spec:    "rbd/test-image@",
+			wantErr: validators.ErrInvalidImageSpec,
+		},
+		{
+			name:    "TestTooManyParts",
+			spec:    "rbd/tenant1/extra/test-image",
+			wantErr: validators.ErrInvalidImageSpec,
+		},
+		{
+			name:    "TestInvalidNamespace",
+			spec:    "rbd/tenant 1/test-image",
+			wantErr: validators.ErrInvalidNamespace,
+		},
+		{
+			name:    "TestEmptyImage",
+			spec:    "rbd/",
+			wantErr: validators.ErrInvalidRBDName,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseImageSpec(tt.spec)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseImageSpec() error = %v, want %v", err, tt.wantErr)
+				}
+
+				if got != tt.want {
+					t.Errorf("ParseImageSpec() = %+v, want %+v", got, tt.want)
+				}
+
+				if tt.wantErr == nil && got.String() != tt.spec {
+					t.Errorf("String() = %s, want %s", got.String(), tt.spec)
+				}
+			},
+		)
+	}
+}
+
+// TestImageSpecMatches tests that mapped images in other namespaces do not collide.
+func TestImageSpecMatches(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "data"}
+
+	if !spec.matches("rbd", "tenant1", "data", "-") {
+		t.Error("matches() = false for the same image")
+	}
+
+	if spec.matches("rbd", "tenant2", "data", "-") {
+		t.Error("matches() = true for an identically named image in another namespace")
+	}
+
+	if spec.matches("rbd", "tenant1", "data", "daily") {
+		t.Error("matches() = true for a snapshot of the image")
+	}
+}
@@ -1,28 +1,24 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
 // ListBlock
-/* lsblk -J -o NAME,PATH,MOUNTPOINT,FSTYPE
+/* lsblk -J -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE
 
 Example output follows.
 
 {
    "blockdevices": [
-      {"name":"rbd0p1", "path":"/dev/rbd0p1", "mountpoint":null, "fstype":null}
-      {"name":"rbd1p1", "path":"/dev/rbd1p1", "mountpoint":null, "fstype":null},
-      {"name":"rbd2p1", "path":"/dev/rbd2p1", "mountpoint":null, "fstype":null}
+      {"name":"rbd0p1", "path":"/dev/rbd0p1", "mountpoint":null, "fstype":null, "type":"part"}
+      {"name":"rbd1p1", "path":"/dev/rbd1p1", "mountpoint":null, "fstype":null, "type":"part"},
+      {"name":"rbd2p1", "path":"/dev/rbd2p1", "mountpoint":null, "fstype":null, "type":"part"}
    ]
 }
 
@@ -33,19 +29,21 @@ type ListBlock struct {
 		Path       string `json:"path"`
 		Mountpoint string `json:"mountpoint"`
 		FSType     string `json:"fstype"`
+		Type       string `json:"type"`
 		Children   []*struct {
 			Name       string `json:"name"`
 			Path       string `json:"path"`
 			Mountpoint string `json:"mountpoint"`
 			FSType     string `json:"fstype"`
+			Type       string `json:"type"`
 		} `json:"children,omitempty"`
 	} `json:"blockdevices"`
 }
 
 // findDevicePath Goes through the list of mapped RBD images to verify the mapping of the image.
 // Once verified, the lsblk command is executed which returns the device mount information.
-func (c *RadosBlockDeviceClient) findDevicePath(pool string, name string) *ListBlock {
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("findDevicePath")
+func (c *RadosBlockDeviceClient) findDevicePath(spec ImageSpec) *ListBlock {
+	log.Trace().Str("Image", spec.String()).Msg("findDevicePath")
 
 	list, showMappedError := c.ListMappedImages()
 	if showMappedError != nil {
@@ -57,7 +55,7 @@ func (c *RadosBlockDeviceClient) findDevicePath(pool string, name string) *ListB
 	deviceMountInfo := &ListBlock{Blockdevices: nil}
 
 	for _, image := range *list {
-		if image.Name != name || image.Pool != pool {
+		if !spec.matches(image.Pool, image.Namespace, image.Name, image.Snap) {
 			log.Trace().Interface("Image", image).Msgf("Skipping executeListBlock(%s)", image.Device)
 
 			continue
@@ -68,8 +66,10 @@ func (c *RadosBlockDeviceClient) findDevicePath(pool string, name string) *ListB
 		deviceMountInfo, listError = c.executeListBlock(image.Device)
 		log.Trace().Interface("deviceMountInfo", deviceMountInfo)
 
-		if listError != nil {
-			log.Trace().Str("Error", listError.Error()).Msgf("executeListBlock(%s)", image.Device)
+		if listError != nil || len(deviceMountInfo.Blockdevices) == 0 {
+			log.Trace().AnErr("Error", listError).Msgf("executeListBlock(%s)", image.Device)
+
+			continue
 		}
 
 		if len(deviceMountInfo.Blockdevices[0].Children) > 0 {
@@ -90,32 +90,22 @@ func (c *RadosBlockDeviceClient) findDevicePath(pool string, name string) *ListB
 func (c *RadosBlockDeviceClient) executeListBlock(device string) (*ListBlock, error) {
 	log.Trace().Str("Device", device).Msg("executeListBlock")
 
-	if !ValidateDevicePath(device) {
+	if err := validators.ValidateDevicePath(device); err != nil {
 		log.Trace().Str("Device", device).Msg("Device Path Invalid")
 
-		return &ListBlock{Blockdevices: nil}, validators.ErrInvalidDevicePath
+		return &ListBlock{Blockdevices: nil}, err
 	}
 
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "lsblk", "-J", device, "-o", "NAME,PATH,MOUNTPOINT,FSTYPE")
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("lsblk", "-J", device, "-o", "NAME,PATH,MOUNTPOINT,FSTYPE,TYPE")
+	if err != nil {
 		return &ListBlock{Blockdevices: nil}, fmt.Errorf("ERROR: lsblk failed:\n%w", err)
 	}
 
 	var list *ListBlock
 
-	if err := json.Unmarshal(stdOut.Bytes(), &list); err != nil {
+	if err := json.Unmarshal(stdOut, &list); err != nil {
 		return &ListBlock{Blockdevices: nil}, fmt.Errorf(
-			"ERROR: json for lsblk could not unmarshal:\n%w\n%s", err, stdOut.String(),
+			"ERROR: json for lsblk could not unmarshal:\n%w\n%s", err, string(stdOut),
 		)
 	}
 
@@ -0,0 +1,240 @@
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// MapBackend selects how an image is attached to the host as a block device.
+type MapBackend string
+
+const (
+	// MapBackendKRBD maps images with the kernel rbd driver (rbd map). It is the default.
+	MapBackendKRBD MapBackend = "krbd"
+	// MapBackendNBD maps images through librbd with rbd-nbd, which supports every librbd feature
+	// regardless of the kernel version.
+	MapBackendNBD MapBackend = "nbd"
+
+	mapBackendMetadataKey = metadataPrefix + "map-backend"
+)
+
+// mapper attaches images to and detaches them from the host.
+type mapper interface {
+	backend() MapBackend
+	mapImage(spec ImageSpec, options MapOptions) error
+	unmapDevice(device string) error
+	listMapped() (ShowMapped, error)
+}
+
+// ValidateMapBackend accepts the supported backends. The empty string selects the default.
+func ValidateMapBackend(backend MapBackend) error {
+	switch backend {
+	case "", MapBackendKRBD, MapBackendNBD:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q must be %q or %q", validators.ErrInvalidMapBackend, backend, MapBackendKRBD, MapBackendNBD)
+}
+
+// mapper returns the implementation of a backend.
+func (c *RadosBlockDeviceClient) mapper(backend MapBackend) mapper {
+	if backend == MapBackendNBD {
+		return &nbdMapper{client: c}
+	}
+
+	return &krbdMapper{client: c}
+}
+
+// mappers returns every supported backend.
+func (c *RadosBlockDeviceClient) mappers() []mapper {
+	return []mapper{c.mapper(MapBackendKRBD), c.mapper(MapBackendNBD)}
+}
+
+// mapBackend returns the backend used for an image. A backend stored in the image metadata with
+// SetImageMapBackend takes priority over the MapBackend of the client.
+func (c *RadosBlockDeviceClient) mapBackend(metadata ImageMetadata) MapBackend {
+	if backend := MapBackend(metadata[mapBackendMetadataKey]); backend != "" && ValidateMapBackend(backend) == nil {
+		return backend
+	}
+
+	if c.MapBackend != "" {
+		return c.MapBackend
+	}
+
+	return MapBackendKRBD
+}
+
+// SetImageMapBackend stores the backend used to map an image in its metadata, so that every host
+// maps it the same way. The empty string removes the setting and falls back to the client default.
+func (c *RadosBlockDeviceClient) SetImageMapBackend(spec ImageSpec, backend MapBackend) (err error) {
+	c, span := c.startSpan("SetImageMapBackend", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if err := ValidateMapBackend(backend); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Backend", string(backend)).Msg("SetImageMapBackend")
+
+	if backend == "" {
+		return c.executeImageMetaRemove(spec, mapBackendMetadataKey)
+	}
+
+	return c.executeImageMetaSet(spec, mapBackendMetadataKey, string(backend))
+}
+
+// deviceBackend returns the backend a device was mapped with.
+func deviceBackend(device string) MapBackend {
+	if strings.HasPrefix(device, "/dev/nbd") {
+		return MapBackendNBD
+	}
+
+	return MapBackendKRBD
+}
+
+// krbdMapper maps images with the kernel rbd driver.
+type krbdMapper struct {
+	client *RadosBlockDeviceClient
+}
+
+func (m *krbdMapper) backend() MapBackend {
+	return MapBackendKRBD
+}
+
+// mapImage maps the image with rbd map. Snapshots are mapped read-only and without the exclusive
+// lock, so that several hosts can map the same snapshot.
+func (m *krbdMapper) mapImage(spec ImageSpec, options MapOptions) error {
+	args := []string{"--exclusive"}
+	if spec.Snapshot != "" {
+		args = []string{"--read-only"}
+	}
+
+	if len(options) > 0 {
+		args = append(args, "--options", options.String())
+	}
+
+	if _, err := m.client.run("rbd", append(args, "map", spec.String())...); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
+
+func (m *krbdMapper) unmapDevice(device string) error {
+	if _, err := m.client.run("rbd", "unmap", device); err != nil {
+		return fmt.Errorf("ERROR: rbd unmap failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *krbdMapper) listMapped() (ShowMapped, error) {
+	list, err := m.client.executeShowMapped()
+	if err != nil {
+		return nil, err
+	}
+
+	if list == nil {
+		return ShowMapped{}, nil
+	}
+
+	for _, image := range *list {
+		image.Backend = MapBackendKRBD
+	}
+
+	return *list, nil
+}
+
+// NBDMapped
+/* rbd-nbd list-mapped --format json
+[
+  {
+    "id": "4026",
+    "pool": "rbd",
+    "namespace": "",
+    "image": "test-image",
+    "snap": "-",
+    "device": "/dev/nbd0"
+  }
+]
+NBDMapped is used to determine which images have been mapped to the local node with rbd-nbd.
+The id is the pid of the rbd-nbd process serving the device. */
+type NBDMapped []*struct {
+	ID        string `json:"id"`
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+	Image     string `json:"image"`
+	Snap      string `json:"snap"`
+	Device    string `json:"device"`
+}
+
+// nbdMapper maps images through librbd with rbd-nbd.
+type nbdMapper struct {
+	client *RadosBlockDeviceClient
+}
+
+func (m *nbdMapper) backend() MapBackend {
+	return MapBackendNBD
+}
+
+// mapImage maps the image with rbd-nbd. Only read_only applies to rbd-nbd, the other map options
+// are specific to krbd. Snapshots are mapped read-only and without the exclusive lock.
+func (m *nbdMapper) mapImage(spec ImageSpec, options MapOptions) error {
+	args := []string{"map", "--exclusive"}
+	if spec.Snapshot != "" {
+		args = []string{"map", "--read-only"}
+	} else if _, ok := options["read_only"]; ok {
+		args = append(args, "--read-only")
+	}
+
+	if _, err := m.client.run("rbd-nbd", append(args, spec.String())...); err != nil {
+		return fmt.Errorf("ERROR: rbd-nbd map failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *nbdMapper) unmapDevice(device string) error {
+	if _, err := m.client.run("rbd-nbd", "unmap", device); err != nil {
+		return fmt.Errorf("ERROR: rbd-nbd unmap failed: %w", err)
+	}
+
+	return nil
+}
+
+func (m *nbdMapper) listMapped() (ShowMapped, error) {
+	stdOut, err := m.client.run("rbd-nbd", "list-mapped", "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd-nbd list-mapped failed: %w", err)
+	}
+
+	var list NBDMapped
+
+	if err := json.Unmarshal(stdOut, &list); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd-nbd list-mapped could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	mapped := make(ShowMapped, 0, len(list))
+	for _, image := range list {
+		mapped = append(mapped, &MappedImage{
+			ID:        image.ID,
+			Pool:      image.Pool,
+			Namespace: image.Namespace,
+			Name:      image.Image,
+			Snap:      image.Snap,
+			Device:    image.Device,
+			Backend:   MapBackendNBD,
+		})
+	}
+
+	return mapped, nil
+}
@@ -0,0 +1,156 @@
+package rbd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+var errCommandNotFound = errors.New("command not found")
+
+const (
+	showMappedKRBD = `[{"id":"0","pool":"rbd","namespace":"","name":"test1","snap":"-","device":"/dev/rbd0"}]`
+	listMappedNBD  = `[{"id":"4026","pool":"rbd","namespace":"tenant1","image":"test2","snap":"-","device":"/dev/nbd0"}]`
+)
+
+// TestListMappedImages tests that the mappings of both backends are merged.
+func TestListMappedImages(t *testing.T) {
+	tests := []struct {
+		name    string
+		runner  *helperstest.Runner
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "TestBothBackends",
+			runner: &helperstest.Runner{Replies: map[string]string{
+				"rbd showmapped --format json":      showMappedKRBD,
+				"rbd-nbd list-mapped --format json": listMappedNBD,
+			}},
+			want: []string{"krbd /dev/rbd0 rbd/test1", "nbd /dev/nbd0 rbd/tenant1/test2"},
+		},
+		{
+			name: "TestNBDMissing",
+			runner: &helperstest.Runner{
+				Replies: map[string]string{"rbd showmapped --format json": showMappedKRBD},
+				Errors:  map[string]error{"rbd-nbd list-mapped --format json": errCommandNotFound},
+			},
+			want: []string{"krbd /dev/rbd0 rbd/test1"},
+		},
+		{
+			name: "TestKRBDFailure",
+			runner: &helperstest.Runner{
+				Errors: map[string]error{"rbd showmapped --format json": errCommandNotFound},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &RadosBlockDeviceClient{Runner: tt.runner}
+
+			list, err := client.ListMappedImages()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListMappedImages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			got := []string{}
+			for _, image := range *list {
+				spec := ImageSpec{Pool: image.Pool, Namespace: image.Namespace, Image: image.Name}
+				got = append(got, string(image.Backend)+" "+image.Device+" "+spec.String())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListMappedImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMapBackendSelection tests that the image metadata takes priority over the client default.
+func TestMapBackendSelection(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Image: "test1"}
+	metaList := "rbd image-meta list rbd/test1 --format json"
+
+	tests := []struct {
+		name     string
+		metadata string
+		client   MapBackend
+		wantCall string
+	}{
+		{
+			name:     "TestDefault",
+			wantCall: "rbd --exclusive --options lock_timeout=10 map rbd/test1",
+		},
+		{
+			name:     "TestClientDefault",
+			client:   MapBackendNBD,
+			wantCall: "rbd-nbd map --exclusive rbd/test1",
+		},
+		{
+			name:     "TestImageMetadata",
+			metadata: `{"scattered-storage.map-backend":"nbd"}`,
+			client:   MapBackendKRBD,
+			wantCall: "rbd-nbd map --exclusive rbd/test1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{Replies: map[string]string{metaList: tt.metadata}}
+			client := &RadosBlockDeviceClient{Runner: runner, MapBackend: tt.client}
+
+			if err := client.executeRBDMap(spec); err != nil {
+				t.Fatalf("executeRBDMap() error = %v", err)
+			}
+
+			if !runner.Called(tt.wantCall) {
+				t.Errorf("executeRBDMap() calls = %v, want %q", runner.Calls, tt.wantCall)
+			}
+		})
+	}
+}
+
+// TestUnmapBackend tests that devices are unmapped by the backend that mapped them.
+func TestUnmapBackend(t *testing.T) {
+	runner := &helperstest.Runner{}
+	client := &RadosBlockDeviceClient{Runner: runner}
+
+	for device, want := range map[string]string{
+		"/dev/rbd0": "rbd unmap /dev/rbd0",
+		"/dev/nbd3": "rbd-nbd unmap /dev/nbd3",
+	} {
+		if err := client.executeUnmap(device); err != nil {
+			t.Fatalf("executeUnmap(%s) error = %v", device, err)
+		}
+
+		if !runner.Called(want) {
+			t.Errorf("executeUnmap(%s) calls = %v, want %q", device, runner.Calls, want)
+		}
+	}
+
+	if err := client.SetImageMapBackend(ImageSpec{Pool: "rbd", Image: "test1"}, "iscsi"); !errors.Is(err, validators.ErrInvalidMapBackend) {
+		t.Errorf("SetImageMapBackend() error = %v, want %v", err, validators.ErrInvalidMapBackend)
+	}
+}
+
+// TestFindDevicePathListBlockFails tests that a mapped device lsblk cannot list is skipped.
+func TestFindDevicePathListBlockFails(t *testing.T) {
+	runner := &helperstest.Runner{
+		Replies: map[string]string{"rbd showmapped --format json": showMappedKRBD, "rbd-nbd list-mapped --format json": "[]"},
+		Errors:  map[string]error{"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": errCommandNotFound},
+	}
+
+	info := (&RadosBlockDeviceClient{Runner: runner}).findDevicePath(ImageSpec{Pool: "rbd", Image: "test1"})
+	if len(info.Blockdevices) != 0 {
+		t.Errorf("findDevicePath() = %+v, want no block devices", info)
+	}
+}
@@ -0,0 +1,205 @@
+package rbd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/spf13/cast"
+)
+
+const mapOptionsMetadataKey = metadataPrefix + "map-options"
+
+// MapOptions holds the krbd map options passed to rbd map with --options. Flags such as read_only
+// are stored with an empty value.
+type MapOptions map[string]string
+
+// DefaultMapOptions are used for every image unless overridden per pool or per image.
+var DefaultMapOptions = MapOptions{"lock_timeout": "10"}
+
+// mapOptionValidators lists the supported map options and checks their values.
+var mapOptionValidators = map[string]func(value string) bool{
+	"read_only":           isFlag,
+	"noshare":             isFlag,
+	"lock_on_read":        isFlag,
+	"queue_depth":         isPositiveInteger,
+	"alloc_size":          isAllocSize,
+	"osd_request_timeout": isUnsignedInteger,
+	"lock_timeout":        isUnsignedInteger,
+	"compression_hint":    oneOf("none", "compressible", "incompressible"),
+	"ms_mode":             oneOf("legacy", "crc", "secure", "prefer-crc", "prefer-secure"),
+}
+
+func isFlag(value string) bool {
+	return value == ""
+}
+
+func isUnsignedInteger(value string) bool {
+	_, err := cast.ToUint64E(value)
+
+	return value != "" && err == nil
+}
+
+func isPositiveInteger(value string) bool {
+	number, err := cast.ToUint64E(value)
+
+	return value != "" && err == nil && number > 0
+}
+
+// isAllocSize accepts powers of two from 512 bytes, as required by the kernel.
+func isAllocSize(value string) bool {
+	size, err := cast.ToUint64E(value)
+
+	return value != "" && err == nil && size >= 512 && size&(size-1) == 0
+}
+
+func oneOf(values ...string) func(string) bool {
+	return func(value string) bool {
+		for _, allowed := range values {
+			if value == allowed {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ParseMapOptions parses the comma separated 'name=value,flag' notation used by rbd map.
+func ParseMapOptions(options string) (MapOptions, error) {
+	result := MapOptions{}
+
+	for _, option := range strings.Split(options, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+
+		name, value, _ := strings.Cut(option, "=")
+		result[name] = value
+	}
+
+	if err := result.Validate(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Validate checks every option against the list of supported krbd map options.
+func (o MapOptions) Validate() error {
+	for name, value := range o {
+		isValid, ok := mapOptionValidators[name]
+		if !ok {
+			return fmt.Errorf("%w: %q is not supported", validators.ErrInvalidMapOption, name)
+		}
+
+		if !isValid(value) {
+			return fmt.Errorf("%w: %q is not a valid value for %s", validators.ErrInvalidMapOption, value, name)
+		}
+	}
+
+	return nil
+}
+
+// String formats the options in the notation expected by rbd map --options, sorted by name.
+func (o MapOptions) String() string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	options := make([]string, 0, len(names))
+	for _, name := range names {
+		if o[name] == "" {
+			options = append(options, name)
+		} else {
+			options = append(options, name+"="+o[name])
+		}
+	}
+
+	return strings.Join(options, ",")
+}
+
+// merge returns a copy of the options overridden by each of the others in turn.
+func (o MapOptions) merge(others ...MapOptions) MapOptions {
+	result := MapOptions{}
+
+	for _, options := range append([]MapOptions{o}, others...) {
+		for name, value := range options {
+			result[name] = value
+		}
+	}
+
+	return result
+}
+
+// mapOptions returns the map options of an image and whether they were read from the image
+// metadata. Options stored on the image take priority over the PoolMapOptions of the client,
+// which in turn override DefaultMapOptions.
+func (c *RadosBlockDeviceClient) mapOptions(spec ImageSpec, metadata ImageMetadata) (MapOptions, bool) {
+	if stored, ok := metadata[mapOptionsMetadataKey]; ok {
+		options, parseError := ParseMapOptions(stored)
+		if parseError == nil {
+			return options, true
+		}
+
+		log.Warn().Str("Image", spec.String()).Str("Error", parseError.Error()).Msg("ignoring stored map options")
+	}
+
+	return DefaultMapOptions.merge(c.PoolMapOptions[spec.Pool]), false
+}
+
+// recordMapOptions stores the options an image was mapped with, so that it is mapped with the same
+// options later on and on other hosts, even if the pool options change in the meantime.
+func (c *RadosBlockDeviceClient) recordMapOptions(spec ImageSpec, options MapOptions) {
+	image := spec
+	image.Snapshot = ""
+
+	if err := c.executeImageMetaSet(image, mapOptionsMetadataKey, options.String()); err != nil {
+		log.Warn().Str("Image", image.String()).Str("Error", err.Error()).Msg("could not record map options")
+	}
+}
+
+// SetImageMapOptions stores the map options of an image in its metadata. They replace the default
+// and pool options entirely. nil removes the stored options, so that the image is mapped with the
+// pool options again.
+func (c *RadosBlockDeviceClient) SetImageMapOptions(spec ImageSpec, options MapOptions) (err error) {
+	c, span := c.startSpan("SetImageMapOptions", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Options", options.String()).Msg("SetImageMapOptions")
+
+	if options == nil {
+		return c.executeImageMetaRemove(spec, mapOptionsMetadataKey)
+	}
+
+	return c.executeImageMetaSet(spec, mapOptionsMetadataKey, options.String())
+}
+
+// GetImageMapOptions returns the options the image is mapped with.
+func (c *RadosBlockDeviceClient) GetImageMapOptions(spec ImageSpec) (_ MapOptions, err error) {
+	c, span := c.startSpan("GetImageMapOptions", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	options, _ := c.mapOptions(spec, c.imageMetadata(spec))
+
+	return options, nil
+}
@@ -0,0 +1,86 @@
+package rbd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// TestParseMapOptions tests the validation and formatting of map options.
+func TestParseMapOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		want    string
+		wantErr error
+	}{
+		{name: "TestEmpty", options: "", want: ""},
+		{name: "TestSorted", options: "ms_mode=secure,read_only,queue_depth=128", want: "ms_mode=secure,queue_depth=128,read_only"},
+		{name: "TestAllocSize", options: "alloc_size=65536", want: "alloc_size=65536"},
+		{name: "TestUnknownOption", options: "exclusive", wantErr: validators.ErrInvalidMapOption},
+		{name: "TestInvalidMsMode", options: "ms_mode=plain", wantErr: validators.ErrInvalidMapOption},
+		{name: "TestFlagWithValue", options: "noshare=1", wantErr: validators.ErrInvalidMapOption},
+		{name: "TestZeroQueueDepth", options: "queue_depth=0", wantErr: validators.ErrInvalidMapOption},
+		{name: "TestAllocSizeNotPowerOfTwo", options: "alloc_size=1000", wantErr: validators.ErrInvalidMapOption},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMapOptions(tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseMapOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ParseMapOptions() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+// TestMapOptionsRecorded tests the precedence of the map options and that the options used are recorded.
+func TestMapOptionsRecorded(t *testing.T) {
+	spec := ImageSpec{Pool: "secure", Image: "test1"}
+	metaList := "rbd image-meta list secure/test1 --format json"
+	pools := map[string]MapOptions{"secure": {"ms_mode": "secure"}}
+
+	tests := []struct {
+		name       string
+		metadata   string
+		wantMap    string
+		wantRecord bool
+	}{
+		{
+			name:       "TestPoolOptions",
+			wantMap:    "rbd --exclusive --options lock_timeout=10,ms_mode=secure map secure/test1",
+			wantRecord: true,
+		},
+		{
+			name:     "TestStoredOptions",
+			metadata: `{"scattered-storage.map-options":"ms_mode=crc,read_only"}`,
+			wantMap:  "rbd --exclusive --options ms_mode=crc,read_only map secure/test1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &helperstest.Runner{Replies: map[string]string{metaList: tt.metadata}}
+			client := &RadosBlockDeviceClient{Runner: runner, PoolMapOptions: pools}
+
+			if err := client.executeRBDMap(spec); err != nil {
+				t.Fatalf("executeRBDMap() error = %v", err)
+			}
+
+			if !runner.Called(tt.wantMap) {
+				t.Errorf("executeRBDMap() calls = %v, want %q", runner.Calls, tt.wantMap)
+			}
+
+			record := "rbd image-meta set secure/test1 scattered-storage.map-options lock_timeout=10,ms_mode=secure"
+			if runner.Called(record) != tt.wantRecord {
+				t.Errorf("executeRBDMap() calls = %v, recorded %v", runner.Calls, !tt.wantRecord)
+			}
+		})
+	}
+}
@@ -1,14 +1,9 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 	"github.com/spf13/cast"
 )
@@ -32,12 +27,12 @@ const (
 
 // makeFilesystem takes a device path and a set of *MkfsOptions to execute the mkfs command.
 func (c *RadosBlockDeviceClient) makeFilesystem(device string, fsOptions *MkfsOptions) error {
-	if !ValidateDevicePath(device) {
-		return validators.ErrInvalidPoolName
+	if err := validators.ValidateDevicePath(device); err != nil {
+		return err
 	}
 
-	if !ValidateMakeFilesystemOptions(fsOptions) {
-		return validators.ErrInvalidMakeOptions
+	if err := ValidateMakeFilesystemOptions(fsOptions); err != nil {
+		return err
 	}
 
 	log.Trace().Str("Device", device).Interface("Options", fsOptions).Msg("makeFilesystem")
@@ -89,42 +84,32 @@ func (c *RadosBlockDeviceClient) getFilesystemOptionDefaults(fsType string) *Mkf
 func (c *RadosBlockDeviceClient) executeMakeFilesystem(device string, fsOptions *MkfsOptions) error {
 	log.Info().Str("Device", device).Interface("FsOptions", fsOptions).Msg("executeMakeFilesystem")
 
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
-	defer cancel()
-
 	fsType := cast.ToString(fsOptions.Options["fsType"].Value)
 
-	filesystemBlockSize := cast.ToString(fsOptions.Options["filesystemBlockSize"].Value)
 	blockSize := "" // will use xfs default block size if empty
 
-	if fsType == TagXfs {
-		if filesystemBlockSize != "" {
+	if option, ok := fsOptions.Options[filesystemBlockSizeKey]; ok && fsType == TagXfs {
+		if filesystemBlockSize := cast.ToString(option.Value); filesystemBlockSize != "" {
 			blockSize = "size=" + filesystemBlockSize
 		}
 	}
 
-	noDiscard := cast.ToBool(fsOptions.Options["noDiscard"].Value)
-	discard := "" // discard will happen if empty
-
-	if noDiscard {
-		discard = "-K" // do not discard
+	var args []string
+	if blockSize != "" {
+		args = append(args, "-b", blockSize)
 	}
 
-	cmd := exec.CommandContext(ctx, "mkfs."+TagXfs, "-b", blockSize, discard, device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Start(); err != nil {
-		log.Error().Interface("Error", err).Msg("Error starting mkfs")
+	// discard will happen unless disabled
+	if noDiscard := cast.ToBool(fsOptions.Options["noDiscard"].Value); noDiscard {
+		if fsType == TagExt4 {
+			args = append(args, "-E", "nodiscard")
+		} else {
+			args = append(args, "-K")
+		}
 	}
 
-	err := cmd.Wait()
-	if err != nil {
-		log.Error().Interface("Command", cmd).Interface("Error", err).Msgf("Error During mkfs.%s", fsType)
+	if _, err := c.runWithTimeout(c.makeFilesystemTimeout(), "mkfs."+fsType, append(args, device)...); err != nil {
+		log.Error().Str("Device", device).Interface("Error", err).Msgf("Error During mkfs.%s", fsType)
 
 		return fmt.Errorf("%w", err)
 	}
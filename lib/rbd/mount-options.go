@@ -0,0 +1,193 @@
+package rbd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// MountOptions configures Mount and MountEncrypted. The zero value, and nil, mount the image
+// read-write with the default options of its filesystem, creating an XFS filesystem on first use.
+//
+// Filesystem selects the filesystem created on first use. NoUUID is only supported by XFS and is
+// required to mount a clone next to its parent. NoRecovery, also XFS only, skips the log recovery
+// of a read-only mount, which is needed to mount a snapshot taken while the filesystem was in use.
+// NoLoad does the same for ext4 by not loading its journal.
+// UID, GID and Mode are applied to the root of the mounted filesystem, so that containers running
+// as another user can write to a fresh volume. SELinuxContext labels every file of the filesystem with the given context.
+//
+// A device is only formatted when it carries no signature at all. ForceFormat erases the
+// signatures of a device that cannot be mounted, such as a LUKS header, a partition table without
+// partitions or an unsupported filesystem, before formatting it. WholeDevice creates the
+// filesystem on the device itself instead of on a partition; such filesystems are always mounted.
+type MountOptions struct {
+	Filesystem     string
+	ForceFormat    bool
+	WholeDevice    bool
+	ReadOnly       bool
+	NoAtime        bool
+	NoDiscard      bool
+	ProjectQuota   bool
+	NoUUID         bool
+	NoRecovery     bool
+	NoLoad         bool
+	UID            *int
+	GID            *int
+	Mode           os.FileMode
+	SELinuxContext string
+}
+
+// filesystem returns the filesystem created on first use.
+func (o *MountOptions) filesystem() string {
+	if o == nil || o.Filesystem == "" {
+		return TagXfs
+	}
+
+	return o.Filesystem
+}
+
+// forceFormat reports whether existing signatures may be erased before formatting.
+func (o *MountOptions) forceFormat() bool {
+	return o != nil && o.ForceFormat
+}
+
+// wholeDevice reports whether a filesystem is created on the device instead of on a partition.
+func (o *MountOptions) wholeDevice() bool {
+	return o != nil && o.WholeDevice
+}
+
+// Validate checks the options against the filesystem created on first use.
+func (o *MountOptions) Validate() error {
+	return o.validateFor(o.filesystem())
+}
+
+// validateFor checks the options against the filesystem being mounted.
+func (o *MountOptions) validateFor(fsType string) error {
+	switch fsType {
+	case TagXfs, TagExt4:
+	default:
+		return fmt.Errorf("%w: filesystem %q must be %q or %q", validators.ErrInvalidMountOptions, fsType, TagXfs, TagExt4)
+	}
+
+	if o == nil {
+		return nil
+	}
+
+	if o.NoUUID && fsType != TagXfs {
+		return fmt.Errorf("%w: nouuid is not supported by %s", validators.ErrInvalidMountOptions, fsType)
+	}
+
+	if o.NoRecovery && (fsType != TagXfs || !o.ReadOnly) {
+		return fmt.Errorf("%w: norecovery requires a read-only %s mount", validators.ErrInvalidMountOptions, TagXfs)
+	}
+
+	if o.NoLoad && (fsType != TagExt4 || !o.ReadOnly) {
+		return fmt.Errorf("%w: noload requires a read-only %s mount", validators.ErrInvalidMountOptions, TagExt4)
+	}
+
+	if (o.UID != nil && *o.UID < 0) || (o.GID != nil && *o.GID < 0) {
+		return fmt.Errorf("%w: uid and gid must not be negative", validators.ErrInvalidMountOptions)
+	}
+
+	if o.Mode&^os.ModePerm != 0 {
+		return fmt.Errorf("%w: mode %o must only hold permission bits", validators.ErrInvalidMountOptions, uint32(o.Mode))
+	}
+
+	if o.ReadOnly && (o.UID != nil || o.GID != nil || o.Mode != 0) {
+		return fmt.Errorf("%w: ownership cannot be changed on a read-only mount", validators.ErrInvalidMountOptions)
+	}
+
+	if o.SELinuxContext != "" {
+		return validators.ValidateSELinuxContext(o.SELinuxContext)
+	}
+
+	return nil
+}
+
+// mountArguments returns the -o argument of the mount command, or nothing for the default options.
+func (o *MountOptions) mountArguments() []string {
+	options := o.optionList()
+	if len(options) == 0 {
+		return nil
+	}
+
+	return []string{"-o", strings.Join(options, ",")}
+}
+
+// optionList returns the mount options, without the defaults of the filesystem.
+func (o *MountOptions) optionList() []string {
+	if o == nil {
+		return nil
+	}
+
+	var options []string
+
+	if o.ReadOnly {
+		options = append(options, "ro")
+	}
+
+	if o.NoAtime {
+		options = append(options, "noatime")
+	}
+
+	if o.NoDiscard {
+		options = append(options, "nodiscard")
+	}
+
+	if o.ProjectQuota {
+		options = append(options, "prjquota")
+	}
+
+	if o.NoUUID {
+		options = append(options, "nouuid")
+	}
+
+	if o.NoRecovery {
+		options = append(options, "norecovery")
+	}
+
+	if o.NoLoad {
+		options = append(options, "noload")
+	}
+
+	if o.SELinuxContext != "" {
+		options = append(options, fmt.Sprintf("context=%q", o.SELinuxContext))
+	}
+
+	return options
+}
+
+// applyOwnership sets the owner and mode of the root of a mounted filesystem.
+func (o *MountOptions) applyOwnership(path string) error {
+	if o == nil {
+		return nil
+	}
+
+	if o.UID != nil || o.GID != nil {
+		uid, gid := -1, -1
+		if o.UID != nil {
+			uid = *o.UID
+		}
+
+		if o.GID != nil {
+			gid = *o.GID
+		}
+
+		log.Trace().Str("Path", path).Int("UID", uid).Int("GID", gid).Msg("applyOwnership")
+
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	if o.Mode != 0 {
+		if err := os.Chmod(path, o.Mode); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package rbd
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// TestMountOptions tests the validation of the mount options per filesystem and the resulting mount arguments.
+func TestMountOptions(t *testing.T) {
+	uid := 1000
+	negative := -1
+
+	tests := []struct {
+		name    string
+		options *MountOptions
+		fsType  string
+		want    []string
+		wantErr error
+	}{
+		{name: "TestNil", fsType: TagXfs},
+		{name: "TestDefaults", options: &MountOptions{}, fsType: TagExt4},
+		{
+			name:    "TestXFSClone",
+			options: &MountOptions{NoAtime: true, NoDiscard: true, ProjectQuota: true, NoUUID: true},
+			fsType:  TagXfs,
+			want:    []string{"-o", "noatime,nodiscard,prjquota,nouuid"},
+		},
+		{
+			name:    "TestSELinux",
+			options: &MountOptions{ReadOnly: true, SELinuxContext: "system_u:object_r:container_file_t:s0:c1,c2"},
+			fsType:  TagExt4,
+			want:    []string{"-o", `ro,context="system_u:object_r:container_file_t:s0:c1,c2"`},
+		},
+		{name: "TestOwner", options: &MountOptions{UID: &uid, GID: &uid, Mode: 0o770}, fsType: TagXfs},
+		{name: "TestNoUUIDExt4", options: &MountOptions{NoUUID: true}, fsType: TagExt4, wantErr: validators.ErrInvalidMountOptions},
+		{name: "TestFilesystem", options: &MountOptions{}, fsType: "btrfs", wantErr: validators.ErrInvalidMountOptions},
+		{name: "TestNegativeUID", options: &MountOptions{UID: &negative}, fsType: TagXfs, wantErr: validators.ErrInvalidMountOptions},
+		{name: "TestSetuidMode", options: &MountOptions{Mode: os.ModeSetuid | 0o755}, fsType: TagXfs, wantErr: validators.ErrInvalidMountOptions},
+		{name: "TestReadOnlyOwner", options: &MountOptions{ReadOnly: true, UID: &uid}, fsType: TagXfs, wantErr: validators.ErrInvalidMountOptions},
+		{name: "TestSELinuxInvalid", options: &MountOptions{SELinuxContext: "unconfined"}, fsType: TagXfs, wantErr: validators.ErrInvalidSELinuxContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.options.validateFor(tt.fsType); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("validateFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got := tt.options.mountArguments(); tt.wantErr == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mountArguments() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMountWithOptions tests that the options reach the mkfs and mount commands.
+func TestMountWithOptions(t *testing.T) {
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                             showMappedKRBD,
+			"rbd-nbd list-mapped --format json":                        "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE":   `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0"}]}`,
+			"lsblk -J /dev/rbd0p1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": `{"blockdevices":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"ext4"}]}`,
+		},
+	}
+	client := &RadosBlockDeviceClient{Runner: runner}
+	path := t.TempDir()
+
+	err := client.Mount(ImageSpec{Pool: "rbd", Image: "test1"}, path, &MountOptions{Filesystem: TagExt4, NoAtime: true})
+	if err != nil {
+		t.Fatalf("Mount() error = %v", err)
+	}
+
+	for _, want := range []string{"mkfs.ext4 -E nodiscard /dev/rbd0p1", "mount -o noatime /dev/rbd0p1 " + path} {
+		if !runner.Called(want) {
+			t.Errorf("Mount() calls = %v, want %q", runner.Calls, want)
+		}
+	}
+
+	err = client.Mount(ImageSpec{Pool: "rbd", Image: "test1"}, path, &MountOptions{Filesystem: TagXfs, NoUUID: true})
+	if !errors.Is(err, validators.ErrInvalidMountOptions) {
+		t.Errorf("Mount() nouuid on ext4 error = %v, want %v", err, validators.ErrInvalidMountOptions)
+	}
+}
+
+// TestMountPartition tests that a partition mounted on the path is left alone and that every mount
+// failure is returned, including the generic exit status 32 of mount.
+func TestMountPartition(t *testing.T) {
+	const (
+		listPartition = "lsblk -J /dev/rbd0p1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"
+		mountError    = "mount /dev/rbd0p1 "
+	)
+
+	path := t.TempDir()
+
+	mounted := &helperstest.Runner{Replies: map[string]string{
+		listPartition: `{"blockdevices":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"xfs","mountpoint":"` + path + `"}]}`,
+	}}
+
+	if err := (&RadosBlockDeviceClient{Runner: mounted}).mountPartition("/dev/rbd0p1", path, &MountOptions{}); err != nil {
+		t.Errorf("mountPartition() of a mounted partition error = %v", err)
+	}
+
+	if mounted.Called(mountError + path) {
+		t.Errorf("mountPartition() mounted a mounted partition again: %q", mounted.Calls)
+	}
+
+	failing := &helperstest.Runner{
+		Replies: map[string]string{listPartition: `{"blockdevices":[{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"xfs"}]}`},
+		Errors:  map[string]error{mountError + path: &helpers.CommandError{Command: "mount", ExitStatus: 32}},
+	}
+
+	if err := (&RadosBlockDeviceClient{Runner: failing}).mountPartition("/dev/rbd0p1", path, &MountOptions{}); !errors.Is(err, ErrMountFailed) {
+		t.Errorf("mountPartition() error = %v, want %v", err, ErrMountFailed)
+	}
+}
@@ -1,16 +1,12 @@
 package rbd
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
-	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
 var ErrMountFailed = errors.New("rbd could not be mounted")
@@ -26,40 +22,84 @@ func PathExists(path string) (bool, error) {
 	return false, err
 }
 
-// Mount will execute the mapping and mounting of a given RBD image.
-func (c *RadosBlockDeviceClient) Mount(pool, name, path string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
+// Mount will execute the mapping and mounting of a given RBD image. nil options mount the image
+// with the defaults described on MountOptions.
+func (c *RadosBlockDeviceClient) Mount(spec ImageSpec, path string, options *MountOptions) (err error) {
+	c, span := c.startSpan("Mount", append(spec.attributes(), tracing.MountPointKey.String(path))...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+	if err := options.Validate(); err != nil {
+		return err
 	}
 
 	if exists, err := PathExists(path); err != nil {
 		return err
 	} else {
 		if exists {
-			log.Trace().Str("Pool", pool).Str("Name", name).Msg("Mount")
-			return c.executeMount(pool, name, path)
+			log.Trace().Str("Image", spec.String()).Msg("Mount")
+			return c.executeMount(spec, path, options)
 		}
 	}
 	return ErrMountFailed
 }
 
 // executeMount performs the mapping, formatting, and mounting of an RBD image on the server.
-func (c *RadosBlockDeviceClient) executeMount(pool, name, path string) error {
+func (c *RadosBlockDeviceClient) executeMount(spec ImageSpec, path string, options *MountOptions) error {
 	log.Trace().Msg("executeMount")
 
-	device, mapped := c.isMapped(pool, name)
+	if encryption := c.imageMetadata(spec)[encryptionMetadataKey]; encryption != "" {
+		return fmt.Errorf("%w: %s uses %s encryption", ErrImageEncrypted, spec.String(), encryption)
+	}
+
+	device, err := c.mapDevice(spec)
+	if err != nil {
+		return err
+	}
+
+	return c.mountDevice(device, path, options)
+}
+
+// Map maps an image, or a snapshot read-only, unless it is mapped already, and returns its device.
+func (c *RadosBlockDeviceClient) Map(spec ImageSpec) (_ string, err error) {
+	c, span := c.startSpan("Map", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("Map")
+
+	device, err := c.mapDevice(spec)
+	if err == nil {
+		span.SetAttributes(tracing.DeviceKey.String(device))
+	}
+
+	return device, err
+}
+
+// mapDevice returns the device of an image, mapping the image first if needed.
+func (c *RadosBlockDeviceClient) mapDevice(spec ImageSpec) (string, error) {
+	device, mapped := c.isMapped(spec)
 	if !mapped {
-		if err := c.executeRBDMap(pool, name); err != nil {
-			return err
+		if err := c.executeRBDMap(spec); err != nil {
+			return "", err
 		}
 
-		device, _ = c.isMapped(pool, name)
+		device, _ = c.isMapped(spec)
 	}
 
+	return device, nil
+}
+
+// mountDevice mounts the first partition of a mapped device, or the filesystem written to the whole
+// device. A device without either is partitioned and formatted, or formatted as a whole with
+// WholeDevice set, once checkFormatSafe found it holds no other data.
+func (c *RadosBlockDeviceClient) mountDevice(device, path string, options *MountOptions) error {
 	partitionsExist, partitionCheckError := c.hasPartitions(device)
 
 	if partitionCheckError != nil {
@@ -68,8 +108,26 @@ func (c *RadosBlockDeviceClient) executeMount(pool, name, path string) error {
 
 	partitionPath := device + "p1"
 
+	if !partitionsExist && c.hasSupportedFileSystem(device) {
+		log.Info().Str("Device", device).Msg("mounting whole device filesystem")
+
+		return c.mountPartition(device, path, options)
+	}
+
 	if !partitionsExist {
-		if partitionError := c.PartitionEntireDisk(device); partitionError != nil {
+		if options.wholeDevice() {
+			if err := c.checkFormatSafe(device, options.forceFormat()); err != nil {
+				return err
+			}
+
+			if err := c.makeFilesystem(device, c.getFilesystemOptionDefaults(options.filesystem())); err != nil {
+				return err
+			}
+
+			return c.mountPartition(device, path, options)
+		}
+
+		if partitionError := c.PartitionEntireDisk(device, options.forceFormat()); partitionError != nil {
 			return partitionError
 		}
 
@@ -77,7 +135,7 @@ func (c *RadosBlockDeviceClient) executeMount(pool, name, path string) error {
 			return probeError
 		}
 
-		if makeFSError := c.makeFilesystem(partitionPath, c.getFilesystemOptionDefaults("xfs")); makeFSError != nil {
+		if makeFSError := c.makeFilesystem(partitionPath, c.getFilesystemOptionDefaults(options.filesystem())); makeFSError != nil {
 			return makeFSError
 		}
 
@@ -86,56 +144,81 @@ func (c *RadosBlockDeviceClient) executeMount(pool, name, path string) error {
 		}
 	}
 
+	return c.mountPartition(partitionPath, path, options)
+}
+
+// mountPartition mounts a formatted partition, or a whole device, creating the mount point if
+// needed. The options are checked against the filesystem found on the partition. A partition that
+// is already mounted on path is left as it is; any failure of mount is returned.
+func (c *RadosBlockDeviceClient) mountPartition(partitionPath, path string, options *MountOptions) error {
+	if info, err := c.executeListBlock(partitionPath); err == nil && len(info.Blockdevices) > 0 {
+		if fsType := info.Blockdevices[0].FSType; fsType != "" {
+			if err := options.validateFor(fsType); err != nil {
+				return err
+			}
+		}
+
+		if _, mountPoint := mountedDevice(info); mountPoint == path {
+			log.Trace().Str("Device", partitionPath).Str("Path", path).Msg("device is already mounted")
+
+			return options.applyOwnership(path)
+		}
+	}
+
 	if err := os.MkdirAll(path, 0o701); err != nil {
 		log.Error().Str("Path", path).Str("Error", err.Error()).Msg("could not create directory")
 
 		return fmt.Errorf("%w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	args := append(options.mountArguments(), partitionPath, path)
 
-	cmd := exec.CommandContext(ctx, "mount", partitionPath, path)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
+	if _, err := c.run("mount", args...); err != nil {
+		log.Error().Str("Device", partitionPath).Str("Error", err.Error()).Msg("error during mount")
 
-	if err := cmd.Start(); err != nil {
-		log.Error().Interface("Error", err).Msg("Error starting mkfs")
+		return fmt.Errorf("%w: %s", ErrMountFailed, err.Error())
 	}
 
-	err := cmd.Wait()
-	if err != nil {
-		var exitError *exec.ExitError
-		if ok := errors.Is(err, exitError); ok {
-			if exitError.ExitCode() == 32 {
-				log.Trace().Str("device", partitionPath).Str("mount", path).Msg("device is already mounted")
-			} else {
-				log.Error().Interface("command", cmd).Interface("error", err).Msg("error during mount")
-			}
-		}
-	}
-
-	return nil
+	return options.applyOwnership(path)
 }
 
 // GetMountPoint returns the path where a given RBD image is currently mounted.
-func (c *RadosBlockDeviceClient) GetMountPoint(pool string, name string) (string, error) {
-	if !ValidatePool(pool) {
-		return "", validators.ErrInvalidPoolName
-	}
+func (c *RadosBlockDeviceClient) GetMountPoint(spec ImageSpec) (_ string, err error) {
+	c, span := c.startSpan("GetMountPoint", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateName(name) {
-		return "", validators.ErrInvalidRBDName
+	if err := spec.Validate(); err != nil {
+		return "", err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("GetMountPoint")
+	log.Trace().Str("Image", spec.String()).Msg("GetMountPoint")
 
-	deviceMountInfo := c.findDevicePath(pool, name)
+	deviceMountInfo := c.findDevicePath(spec)
 
 	log.Trace().Interface("deviceMountInfo", deviceMountInfo).Msg("Device Information Found")
 
 	return c.findMount(deviceMountInfo), nil
 }
 
+// GetDeviceMountPoint returns where a mapped device, or one of its partitions, is mounted, or an
+// empty path when it is not mounted. Unlike GetMountPoint it lists the device once and does not
+// wait for partitions to appear.
+func (c *RadosBlockDeviceClient) GetDeviceMountPoint(device string) (_ string, err error) {
+	c, span := c.startSpan("GetDeviceMountPoint", tracing.DeviceKey.String(device))
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Str("Device", device).Msg("GetDeviceMountPoint")
+
+	deviceMountInfo, err := c.executeListBlock(device)
+	if err != nil {
+		return "", err
+	}
+
+	_, mountPoint := mountedDevice(deviceMountInfo)
+
+	return mountPoint, nil
+}
+
 // findMount returns the path where an RBD image has been mounted.
 func (c *RadosBlockDeviceClient) findMount(deviceMountInfo *ListBlock) string {
 	log.Trace().Interface("deviceMountInfo", deviceMountInfo).Msg("Executing findMount")
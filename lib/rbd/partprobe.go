@@ -1,21 +1,21 @@
 package rbd
 
 import (
-	"context"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
-func (c *RadosBlockDeviceClient) Partprobe(device string) error {
+func (c *RadosBlockDeviceClient) Partprobe(device string) (err error) {
+	c, span := c.startSpan("Partprobe", tracing.DeviceKey.String(device))
+	defer func() { tracing.End(span, err) }()
+
 	log.Trace().Msg("Partprobe")
 
-	if !ValidateDevicePath(device) {
-		return validators.ErrInvalidDevicePath
+	if err := validators.ValidateDevicePath(device); err != nil {
+		return err
 	}
 
 	if probeError := c.executePartprobe(device); probeError != nil {
@@ -28,13 +28,7 @@ func (c *RadosBlockDeviceClient) Partprobe(device string) error {
 func (c *RadosBlockDeviceClient) executePartprobe(device string) error {
 	log.Trace().Msg("executePartprobe")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "partprobe", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("partprobe", device); err != nil {
 		return fmt.Errorf("ERROR: partprobe failed: %w", err)
 	}
 
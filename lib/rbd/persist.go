@@ -0,0 +1,606 @@
+package rbd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+var (
+	ErrNotMounted          = errors.New("rbd image is not mounted")
+	ErrPersistNotSupported = errors.New("rbd image cannot be persisted")
+)
+
+// PersistMode selects how a mount is restored at boot.
+type PersistMode string
+
+const (
+	// PersistRBDMap adds the image to /etc/ceph/rbdmap, which the rbdmap service maps at boot, and
+	// mounts it through an /etc/fstab entry that waits for that service.
+	PersistRBDMap PersistMode = "rbdmap"
+	// PersistSystemd generates a oneshot service mapping the image and a .mount unit depending on it.
+	PersistSystemd PersistMode = "systemd"
+
+	defaultPersistCephUser = "admin"
+	persistMarker          = "# scattered-storage: "
+	mapUnitPrefix          = "scattered-storage-map-"
+)
+
+// PersistOptions configures Persist. CephUser defaults to 'admin' and Keyring to the keyring
+// location of that user that the rbd command uses by default. Mount holds the options the image
+// was mounted with, which are written to the fstab entry or the mount unit.
+type PersistOptions struct {
+	Mode     PersistMode
+	CephUser string
+	Keyring  string
+	Mount    *MountOptions
+}
+
+// Validate checks the mode and the cephx user.
+func (o *PersistOptions) Validate() error {
+	if o == nil {
+		return fmt.Errorf("%w: no options given", ErrPersistNotSupported)
+	}
+
+	switch o.Mode {
+	case PersistRBDMap, PersistSystemd:
+	default:
+		return fmt.Errorf("%w: mode %q must be %q or %q", ErrPersistNotSupported, o.Mode, PersistRBDMap, PersistSystemd)
+	}
+
+	if o.CephUser != "" {
+		return validators.ValidateCephxUser(o.CephUser)
+	}
+
+	return nil
+}
+
+// cephUser returns the cephx user without the 'client.' prefix.
+func (o *PersistOptions) cephUser() string {
+	if o.CephUser == "" {
+		return defaultPersistCephUser
+	}
+
+	return strings.TrimPrefix(o.CephUser, "client.")
+}
+
+// persistRoot returns the directory the configuration files are written below.
+func (c *RadosBlockDeviceClient) persistRoot() string {
+	if c.PersistRoot == "" {
+		return "/"
+	}
+
+	return c.PersistRoot
+}
+
+// Persist makes the current mount of an image survive a reboot. The image must be mounted. The
+// filesystem is referenced by the /dev/rbd udev link of the image, since the number of the device
+// changes between boots and clones share the filesystem UUID of their parent. Images mapped through
+// rbd-nbd have no such link and are referenced by the UUID.
+func (c *RadosBlockDeviceClient) Persist(spec ImageSpec, options *PersistOptions) (err error) {
+	c, span := c.startSpan("Persist", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if err := options.Validate(); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Mode", string(options.Mode)).Msg("Persist")
+
+	metadata := c.imageMetadata(spec)
+	if encryption := metadata[encryptionMetadataKey]; encryption != "" {
+		return fmt.Errorf("%w: %s uses %s encryption", ErrPersistNotSupported, spec.String(), encryption)
+	}
+
+	partition, mountPoint := c.mountedPartition(spec)
+	if partition == "" {
+		return fmt.Errorf("%w: %s", ErrNotMounted, spec.String())
+	}
+
+	filesystem, err := c.executeBlockID(partition)
+	if err != nil {
+		return err
+	}
+
+	if filesystem.UUID == "" || filesystem.Type == "" {
+		return fmt.Errorf("%w: %s has no filesystem UUID", ErrPersistNotSupported, partition)
+	}
+
+	if err := options.Mount.validateFor(filesystem.Type); err != nil {
+		return err
+	}
+
+	mount := &persistedMount{
+		Device:     c.persistedDevice(spec, metadata, partition, filesystem),
+		MountPoint: filepath.Clean(mountPoint),
+		Type:       filesystem.Type,
+		Options:    options.Mount.optionList(),
+	}
+
+	// Replace the entries of an earlier call, which may have used the other mode.
+	if err := c.Unpersist(spec); err != nil {
+		return err
+	}
+
+	if options.Mode == PersistRBDMap {
+		return c.persistRBDMap(spec, metadata, options, mount)
+	}
+
+	return c.persistSystemd(spec, metadata, options, mount)
+}
+
+// persistedMount is the filesystem mount written to the fstab entry or the mount unit.
+type persistedMount struct {
+	Device     string
+	MountPoint string
+	Type       string
+	Options    []string
+}
+
+// partitionSuffix matches the partition number of a /dev/rbdNpM or /dev/nbdNpM device.
+var partitionSuffix = regexp.MustCompile(`p([0-9]+)$`)
+
+// persistedDevice returns the device a persisted mount refers to: the udev link of the image, or
+// of its partition, for krbd and the filesystem UUID for rbd-nbd.
+func (c *RadosBlockDeviceClient) persistedDevice(
+	spec ImageSpec, metadata ImageMetadata, partition string, filesystem *BlockID,
+) string {
+	if c.mapBackend(metadata) == MapBackendNBD {
+		return "/dev/disk/by-uuid/" + filesystem.UUID
+	}
+
+	device := "/dev/rbd/" + spec.String()
+	if parts := partitionSuffix.FindStringSubmatch(partition); parts != nil {
+		device += "-part" + parts[1]
+	}
+
+	return device
+}
+
+// mountedPartition returns the partition of an image that is mounted and its mount point.
+func (c *RadosBlockDeviceClient) mountedPartition(spec ImageSpec) (string, string) {
+	return mountedDevice(c.findDevicePath(spec))
+}
+
+// mountedDevice returns the first mounted device in a listing and its mount point.
+func mountedDevice(deviceMountInfo *ListBlock) (string, string) {
+	if deviceMountInfo == nil {
+		return "", ""
+	}
+
+	for _, device := range deviceMountInfo.Blockdevices {
+		if device.Mountpoint != "" {
+			return device.Path, device.Mountpoint
+		}
+
+		for _, child := range device.Children {
+			if child.Mountpoint != "" {
+				return child.Path, child.Mountpoint
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// BlockID
+/* blkid -o export /dev/rbd0p1
+
+DEVNAME=/dev/rbd0p1
+UUID=976330da-8105-4514-b4c6-8914fcd8e6d3
+BLOCK_SIZE=4096
+TYPE=xfs
+PARTLABEL=primary
+PARTUUID=5c8ae0e1-1ec0-4c24-9b7e-4a0c7f5d8b5e
+
+BlockID is used to find the UUID and type of the filesystem on a partition. */
+type BlockID struct {
+	UUID string
+	Type string
+}
+
+// executeBlockID runs blkid against a partition.
+func (c *RadosBlockDeviceClient) executeBlockID(partition string) (*BlockID, error) {
+	if err := validators.ValidateDevicePath(partition); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Device", partition).Msg("executeBlockID")
+
+	stdOut, err := c.run("blkid", "-o", "export", partition)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: blkid failed: %w", err)
+	}
+
+	result := &BlockID{}
+
+	for _, line := range strings.Split(string(stdOut), "\n") {
+		name, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch name {
+		case "UUID":
+			result.UUID = value
+		case "TYPE":
+			result.Type = value
+		}
+	}
+
+	return result, nil
+}
+
+// persistRBDMap adds an rbdmap entry for the image and an fstab entry for its filesystem. The map
+// options the image is mapped with are quoted, as rbdmap splits its parameters at commas.
+func (c *RadosBlockDeviceClient) persistRBDMap(
+	spec ImageSpec, metadata ImageMetadata, options *PersistOptions, mount *persistedMount,
+) error {
+	if backend := c.mapBackend(metadata); backend != MapBackendKRBD {
+		return fmt.Errorf("%w: rbdmap only supports the %s backend", ErrPersistNotSupported, MapBackendKRBD)
+	}
+
+	parameters := "id=" + options.cephUser()
+	if options.Keyring != "" {
+		parameters += ",keyring=" + options.Keyring
+	}
+
+	if mapOptions, _ := c.mapOptions(spec, metadata); len(mapOptions) > 0 {
+		parameters += ",options='" + mapOptions.String() + "'"
+	}
+
+	if err := c.appendEntry(rbdmapPath, spec, spec.String()+"\t"+parameters); err != nil {
+		return err
+	}
+
+	mountOptions := strings.Join(append(mount.Options, "_netdev", "x-systemd.requires=rbdmap.service"), ",")
+	entry := fmt.Sprintf("%s\t%s\t%s\t%s\t0 0",
+		escapeFstabField(mount.Device), escapeFstabField(mount.MountPoint), mount.Type, escapeFstabField(mountOptions))
+
+	return c.appendEntry(fstabPath, spec, entry)
+}
+
+// escapeFstabField escapes the characters fstab uses to separate fields as octal sequences.
+func escapeFstabField(field string) string {
+	return strings.NewReplacer(`\`, `\134`, " ", `\040`, "\t", `\011`, "\n", `\012`).Replace(field)
+}
+
+const (
+	rbdmapPath  = "etc/ceph/rbdmap"
+	fstabPath   = "etc/fstab"
+	systemdPath = "etc/systemd/system"
+)
+
+// appendEntry appends an entry for an image to a configuration file, preceded by a marker comment.
+func (c *RadosBlockDeviceClient) appendEntry(name string, spec ImageSpec, entry string) error {
+	return c.rewriteFile(name, func(lines []string) []string {
+		return append(lines, persistMarker+spec.String(), entry)
+	})
+}
+
+// removeEntries removes the entries of an image, and their marker comments, from a configuration
+// file. A file without entries of the image is left untouched, so that unmapping works on hosts
+// whose /etc is read-only.
+func (c *RadosBlockDeviceClient) removeEntries(name string, spec ImageSpec) error {
+	content, err := os.ReadFile(filepath.Join(c.persistRoot(), name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	if !containsLine(string(content), persistMarker+spec.String()) {
+		return nil
+	}
+
+	return c.rewriteFile(name, func(lines []string) []string {
+		result := make([]string, 0, len(lines))
+
+		for index := 0; index < len(lines); index++ {
+			if lines[index] == persistMarker+spec.String() {
+				index++
+
+				continue
+			}
+
+			result = append(result, lines[index])
+		}
+
+		return result
+	})
+}
+
+// containsLine reports whether content holds line as a whole line.
+func containsLine(content, line string) bool {
+	for _, candidate := range strings.Split(content, "\n") {
+		if candidate == line {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteFile replaces the lines of a configuration file below the persist root. The new content
+// is written next to the file and renamed over it, keeping the original permissions.
+func (c *RadosBlockDeviceClient) rewriteFile(name string, edit func(lines []string) []string) error {
+	path := filepath.Join(c.persistRoot(), name)
+	mode := fs.FileMode(0o644)
+
+	var lines []string
+
+	file, err := os.Open(path)
+
+	switch {
+	case err == nil:
+		if info, statError := file.Stat(); statError == nil {
+			mode = info.Mode().Perm()
+		}
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+
+		err = scanner.Err()
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	default:
+		return fmt.Errorf("%w", err)
+	}
+
+	content := strings.Join(edit(lines), "\n")
+	if content != "" {
+		content += "\n"
+	}
+
+	return writeFileAtomic(path, []byte(content), mode)
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it.
+func writeFileAtomic(path string, content []byte, mode fs.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	_, writeError := file.Write(content)
+	if writeError == nil {
+		writeError = file.Chmod(mode)
+	}
+
+	if closeError := file.Close(); writeError == nil {
+		writeError = closeError
+	}
+
+	if writeError == nil {
+		writeError = os.Rename(file.Name(), path)
+	}
+
+	if writeError != nil {
+		_ = os.Remove(file.Name())
+
+		return fmt.Errorf("%w", writeError)
+	}
+
+	return nil
+}
+
+// escapeUnitName escapes a string the way systemd-escape does, treating it as a path when path is set.
+func escapeUnitName(name string, path bool) string {
+	if path {
+		name = strings.Trim(name, "/")
+		if name == "" {
+			return "-"
+		}
+	}
+
+	var builder strings.Builder
+
+	for index := 0; index < len(name); index++ {
+		character := name[index]
+
+		switch {
+		case character == '/':
+			builder.WriteByte('-')
+		case character == '.' && index == 0,
+			!(character >= 'a' && character <= 'z' || character >= 'A' && character <= 'Z' ||
+				character >= '0' && character <= '9' || character == ':' || character == '_' || character == '.'):
+			fmt.Fprintf(&builder, `\x%02x`, character)
+		default:
+			builder.WriteByte(character)
+		}
+	}
+
+	return builder.String()
+}
+
+// mapUnitName returns the name of the service mapping an image.
+func mapUnitName(spec ImageSpec) string {
+	return mapUnitPrefix + escapeUnitName(spec.String(), true) + ".service"
+}
+
+// persistSystemd writes a oneshot service mapping the image and a mount unit for its filesystem,
+// and enables both.
+func (c *RadosBlockDeviceClient) persistSystemd(
+	spec ImageSpec, metadata ImageMetadata, options *PersistOptions, mount *persistedMount,
+) error {
+	mapOptions, _ := c.mapOptions(spec, metadata)
+
+	mapCommand := []string{"/usr/bin/rbd", "--id", options.cephUser()}
+	if options.Keyring != "" {
+		mapCommand = append(mapCommand, "--keyring", options.Keyring)
+	}
+
+	mapCommand = append(mapCommand, "--exclusive")
+	if len(mapOptions) > 0 {
+		mapCommand = append(mapCommand, "--options", mapOptions.String())
+	}
+
+	mapCommand = append(mapCommand, "map", spec.String())
+	unmapCommand := "/usr/bin/rbd unmap " + spec.String()
+
+	if c.mapBackend(metadata) == MapBackendNBD {
+		mapCommand = []string{"/usr/bin/rbd-nbd", "--id", options.cephUser()}
+		if options.Keyring != "" {
+			mapCommand = append(mapCommand, "--keyring", options.Keyring)
+		}
+
+		mapCommand = append(mapCommand, "map", "--exclusive")
+		if _, ok := mapOptions["read_only"]; ok {
+			mapCommand = append(mapCommand, "--read-only")
+		}
+
+		mapCommand = append(mapCommand, spec.String())
+		unmapCommand = "/usr/bin/rbd-nbd unmap " + spec.String()
+	}
+
+	mapUnit := mapUnitName(spec)
+	mountUnit := escapeUnitName(mount.MountPoint, true) + ".mount"
+	// Unit files expand specifiers starting with '%' in every value.
+	unitValue := strings.NewReplacer("%", "%%").Replace
+
+	units := map[string]string{
+		mapUnit: fmt.Sprintf(`%s%s
+[Unit]
+Description=Map RBD image %s
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=%s
+ExecStop=%s
+
+[Install]
+WantedBy=multi-user.target
+`, persistMarker, spec.String(), spec.String(), strings.Join(mapCommand, " "), unmapCommand),
+		mountUnit: fmt.Sprintf(`%s%s
+[Unit]
+Description=Mount RBD image %s
+Requires=%s
+After=%s
+
+[Mount]
+What=%s
+Where=%s
+Type=%s
+Options=%s
+
+[Install]
+WantedBy=multi-user.target
+`, persistMarker, spec.String(), spec.String(), mapUnit, mapUnit, mount.Device, unitValue(mount.MountPoint), mount.Type,
+			unitValue(strings.Join(append(mount.Options, "_netdev"), ","))),
+	}
+
+	directory := filepath.Join(c.persistRoot(), systemdPath)
+	if err := os.MkdirAll(directory, 0o755); err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for name, content := range units {
+		if err := writeFileAtomic(filepath.Join(directory, name), []byte(content), 0o644); err != nil {
+			return err
+		}
+	}
+
+	return c.systemctl("enable", mapUnit, mountUnit)
+}
+
+// systemctl runs systemctl against the persist root, reloading the units first on the running system.
+func (c *RadosBlockDeviceClient) systemctl(args ...string) error {
+	if root := c.persistRoot(); root != "/" {
+		args = append([]string{"--root", root}, args...)
+	} else if _, err := c.run("systemctl", "daemon-reload"); err != nil {
+		return fmt.Errorf("ERROR: systemctl daemon-reload failed: %w", err)
+	}
+
+	if _, err := c.run("systemctl", args...); err != nil {
+		return fmt.Errorf("ERROR: systemctl %s failed: %w", args[0], err)
+	}
+
+	return nil
+}
+
+// Unpersist removes the rbdmap, fstab and systemd entries written by Persist for an image.
+func (c *RadosBlockDeviceClient) Unpersist(spec ImageSpec) (err error) {
+	c, span := c.startSpan("Unpersist", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("Unpersist")
+
+	for _, name := range []string{rbdmapPath, fstabPath} {
+		if err := c.removeEntries(name, spec); err != nil {
+			return err
+		}
+	}
+
+	units, err := c.persistedUnits(spec)
+	if err != nil || len(units) == 0 {
+		return err
+	}
+
+	if err := c.systemctl(append([]string{"disable"}, units...)...); err != nil {
+		return err
+	}
+
+	directory := filepath.Join(c.persistRoot(), systemdPath)
+	for _, unit := range units {
+		if err := os.Remove(filepath.Join(directory, unit)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	return nil
+}
+
+// persistedUnits returns the systemd units generated for an image, found by their marker comment.
+func (c *RadosBlockDeviceClient) persistedUnits(spec ImageSpec) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(c.persistRoot(), systemdPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var units []string
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".mount") || strings.HasPrefix(name, mapUnitPrefix)) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(c.persistRoot(), systemdPath, name))
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+
+		if firstLine, _, _ := strings.Cut(string(content), "\n"); firstLine == persistMarker+spec.String() {
+			units = append(units, name)
+		}
+	}
+
+	return units, nil
+}
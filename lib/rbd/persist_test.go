@@ -0,0 +1,252 @@
+package rbd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+const (
+	persistUUID   = "976330da-8105-4514-b4c6-8914fcd8e6d3"
+	lsblkMounted  = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[{"name":"rbd0p1","path":"/dev/rbd0p1","mountpoint":"/srv/test-1","fstype":"xfs","type":"part"}]}]}`
+	blkidExported = "DEVNAME=/dev/rbd0p1\nUUID=" + persistUUID + "\nBLOCK_SIZE=4096\nTYPE=xfs\n"
+)
+
+func newPersistClient(t *testing.T) (*RadosBlockDeviceClient, *helperstest.Runner, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                           showMappedKRBD,
+			"rbd-nbd list-mapped --format json":                      "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+			"blkid -o export /dev/rbd0p1":                            blkidExported,
+		},
+	}
+
+	return &RadosBlockDeviceClient{Runner: runner, PersistRoot: root}, runner, root
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile(%s) error = %v", path, err)
+	}
+
+	return string(content)
+}
+
+// TestPersistRBDMap tests the rbdmap and fstab entries and their removal, keeping unrelated entries.
+func TestPersistRBDMap(t *testing.T) {
+	client, _, root := newPersistClient(t)
+	spec := ImageSpec{Pool: "rbd", Image: "test1"}
+	fstab := filepath.Join(root, "etc", "fstab")
+
+	if err := os.MkdirAll(filepath.Dir(fstab), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(fstab, []byte("UUID=root-uuid / ext4 defaults 0 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Persist(spec, &PersistOptions{Mode: PersistRBDMap}); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+
+	if got := readFile(t, filepath.Join(root, "etc", "ceph", "rbdmap")); !strings.Contains(got, "rbd/test1\tid=admin,options='lock_timeout=10'\n") {
+		t.Errorf("rbdmap = %q", got)
+	}
+
+	want := "/dev/rbd/rbd/test1-part1\t/srv/test-1\txfs\t_netdev,x-systemd.requires=rbdmap.service\t0 0\n"
+	if got := readFile(t, fstab); !strings.HasPrefix(got, "UUID=root-uuid") || !strings.HasSuffix(got, want) {
+		t.Errorf("fstab = %q", got)
+	}
+
+	// Persisting again replaces the entries instead of adding more.
+	if err := client.Persist(spec, &PersistOptions{Mode: PersistRBDMap}); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+
+	if got := readFile(t, fstab); strings.Count(got, "/dev/rbd/rbd/test1-part1") != 1 {
+		t.Errorf("fstab = %q", got)
+	}
+
+	if err := client.Unpersist(spec); err != nil {
+		t.Fatalf("Unpersist() error = %v", err)
+	}
+
+	if got := readFile(t, fstab); got != "UUID=root-uuid / ext4 defaults 0 1\n" {
+		t.Errorf("fstab after Unpersist() = %q", got)
+	}
+
+	if got := readFile(t, filepath.Join(root, "etc", "ceph", "rbdmap")); got != "" {
+		t.Errorf("rbdmap after Unpersist() = %q", got)
+	}
+}
+
+// TestPersistSystemd tests the generated units and that they are enabled and disabled below the root.
+func TestPersistSystemd(t *testing.T) {
+	client, runner, root := newPersistClient(t)
+	spec := ImageSpec{Pool: "rbd", Image: "test1"}
+	directory := filepath.Join(root, "etc", "systemd", "system")
+	mapUnit := `scattered-storage-map-rbd-test1.service`
+	mountUnit := `srv-test\x2d1.mount`
+
+	options := &PersistOptions{
+		Mode: PersistSystemd, CephUser: "client.storage", Mount: &MountOptions{NoAtime: true, NoUUID: true},
+	}
+	if err := client.Persist(spec, options); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+
+	service := readFile(t, filepath.Join(directory, mapUnit))
+	if !strings.Contains(service, "ExecStart=/usr/bin/rbd --id storage --exclusive --options lock_timeout=10 map rbd/test1\n") {
+		t.Errorf("%s = %q", mapUnit, service)
+	}
+
+	mount := readFile(t, filepath.Join(directory, mountUnit))
+	for _, want := range []string{
+		"Requires=" + mapUnit, "What=/dev/rbd/rbd/test1-part1", "Where=/srv/test-1", "Type=xfs",
+		"Options=noatime,nouuid,_netdev",
+	} {
+		if !strings.Contains(mount, want+"\n") {
+			t.Errorf("%s = %q, want %q", mountUnit, mount, want)
+		}
+	}
+
+	if enable := "systemctl --root " + root + " enable " + mapUnit + " " + mountUnit; !runner.Called(enable) {
+		t.Errorf("Persist() calls = %v, want %q", runner.Calls, enable)
+	}
+
+	if err := client.Unpersist(spec); err != nil {
+		t.Fatalf("Unpersist() error = %v", err)
+	}
+
+	if entries, _ := os.ReadDir(directory); len(entries) != 0 {
+		t.Errorf("Unpersist() left %v", entries)
+	}
+}
+
+// TestPersistSystemdNBD tests that the rbd-nbd service maps with the keyring and read-only flag.
+func TestPersistSystemdNBD(t *testing.T) {
+	client, runner, root := newPersistClient(t)
+	runner.Replies["rbd image-meta list rbd/test1 --format json"] = `{"scattered-storage.map-backend":"nbd",` +
+		`"scattered-storage.map-options":"read_only"}`
+
+	options := &PersistOptions{Mode: PersistSystemd, Keyring: "/etc/ceph/ceph.client.admin.keyring"}
+	if err := client.Persist(ImageSpec{Pool: "rbd", Image: "test1"}, options); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+
+	want := "ExecStart=/usr/bin/rbd-nbd --id admin --keyring /etc/ceph/ceph.client.admin.keyring map --exclusive " +
+		"--read-only rbd/test1\n"
+	service := readFile(t, filepath.Join(root, "etc", "systemd", "system", "scattered-storage-map-rbd-test1.service"))
+	if !strings.Contains(service, want) {
+		t.Errorf("service = %q, want %q", service, want)
+	}
+
+	// rbd-nbd devices have no udev link, so the mount falls back to the filesystem UUID.
+	mount := readFile(t, filepath.Join(root, "etc", "systemd", "system", `srv-test\x2d1.mount`))
+	if want := "What=/dev/disk/by-uuid/" + persistUUID + "\n"; !strings.Contains(mount, want) {
+		t.Errorf("mount = %q, want %q", mount, want)
+	}
+}
+
+// TestPersistMountOptions tests that the mount options, a namespace and a mount point containing a
+// space end up escaped in the fstab entry.
+func TestPersistMountOptions(t *testing.T) {
+	client, runner, root := newPersistClient(t)
+	runner.Replies["rbd showmapped --format json"] = `[{"id":"0","pool":"rbd","namespace":"tenant1","name":"test1",` +
+		`"snap":"-","device":"/dev/rbd0"}]`
+	runner.Replies["lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"] = `{"blockdevices":[{"name":"rbd0",` +
+		`"path":"/dev/rbd0","mountpoint":"/srv/test data","fstype":"xfs","type":"disk"}]}`
+	runner.Replies["blkid -o export /dev/rbd0"] = blkidExported
+
+	options := &PersistOptions{
+		Mode: PersistRBDMap,
+		Mount: &MountOptions{
+			ReadOnly: true, ProjectQuota: true, SELinuxContext: "system_u:object_r:container_file_t:s0:c1,c2",
+		},
+	}
+	if err := client.Persist(ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "test1"}, options); err != nil {
+		t.Fatalf("Persist() error = %v", err)
+	}
+
+	want := "/dev/rbd/rbd/tenant1/test1\t/srv/test\\040data\txfs\t" +
+		`ro,prjquota,context="system_u:object_r:container_file_t:s0:c1,c2",_netdev,x-systemd.requires=rbdmap.service` +
+		"\t0 0\n"
+	if got := readFile(t, filepath.Join(root, "etc", "fstab")); !strings.HasSuffix(got, want) {
+		t.Errorf("fstab = %q, want %q", got, want)
+	}
+
+	options.Mount = &MountOptions{NoUUID: true}
+	runner.Replies["blkid -o export /dev/rbd0"] = "UUID=" + persistUUID + "\nTYPE=ext4\n"
+
+	if err := client.Persist(ImageSpec{Pool: "rbd", Namespace: "tenant1", Image: "test1"}, options); err == nil {
+		t.Error("Persist() with nouuid on ext4 error = nil")
+	}
+}
+
+// TestUnpersistUntouched tests that files without entries of the image are not rewritten.
+func TestUnpersistUntouched(t *testing.T) {
+	client, _, root := newPersistClient(t)
+	fstab := filepath.Join(root, "etc", "fstab")
+
+	if err := os.MkdirAll(filepath.Dir(fstab), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a final newline, a rewrite would show up as a changed file.
+	content := "# scattered-storage: rbd/test2\nUUID=other /srv/test-2 xfs _netdev 0 0"
+	if err := os.WriteFile(fstab, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Unpersist(ImageSpec{Pool: "rbd", Image: "test1"}); err != nil {
+		t.Fatalf("Unpersist() error = %v", err)
+	}
+
+	if got := readFile(t, fstab); got != content {
+		t.Errorf("fstab after Unpersist() = %q, want it untouched", got)
+	}
+}
+
+// TestPersistNotMounted tests that only mounted images are persisted.
+func TestPersistNotMounted(t *testing.T) {
+	client, runner, _ := newPersistClient(t)
+	runner.Replies["lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"] = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0"}]}`
+
+	err := client.Persist(ImageSpec{Pool: "rbd", Image: "test1"}, &PersistOptions{Mode: PersistSystemd})
+	if !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Persist() error = %v, want %v", err, ErrNotMounted)
+	}
+
+	if err := client.Persist(ImageSpec{Pool: "rbd", Image: "test1"}, &PersistOptions{Mode: "crypttab"}); !errors.Is(err, ErrPersistNotSupported) {
+		t.Errorf("Persist() error = %v, want %v", err, ErrPersistNotSupported)
+	}
+}
+
+// TestEscapeUnitName tests the systemd-escape compatible unit names.
+func TestEscapeUnitName(t *testing.T) {
+	tests := map[string]string{
+		"/":                   "-",
+		"/srv/data":           "srv-data",
+		"/srv/test-1/":        `srv-test\x2d1`,
+		"/mnt/.hidden":        `mnt-.hidden`,
+		"rbd/tenant1/image.1": "rbd-tenant1-image.1",
+	}
+
+	for input, want := range tests {
+		if got := escapeUnitName(input, true); got != want {
+			t.Errorf("escapeUnitName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
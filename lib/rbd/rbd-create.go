@@ -1,37 +1,33 @@
 package rbd
 
 import (
-	"context"
-	"errors"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
 	"github.com/scattered-network/scattered-storage/lib/validators"
-	"github.com/spf13/cast"
 )
 
-// CreateRBD validates the creation options and triggers the rbd create command.
-func (c *RadosBlockDeviceClient) CreateRBD(pool, name string, size int, suffix string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
-	}
+// DefaultObjectSize is the object size rbd create uses for new images (order 22).
+const DefaultObjectSize = 4 * units.MiB
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
-	}
+// CreateRBD validates the creation options and triggers the rbd create command.
+// The size is rounded up to a multiple of DefaultObjectSize.
+func (c *RadosBlockDeviceClient) CreateRBD(spec ImageSpec, size units.Size) (err error) {
+	c, span := c.startSpan("CreateRBD", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateSize(size) {
-		return validators.ErrInvalidSize
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	if !ValidateSuffix(suffix) {
-		return validators.ErrInvalidSuffix
+	if err := ValidateSize(size); err != nil {
+		return err
 	}
 
-	if createError := c.executeRBDCreate(pool, name, size, suffix); createError != nil {
+	if createError := c.executeRBDCreate(spec, alignSize(size, DefaultObjectSize)); createError != nil {
 		return createError
 	}
 
@@ -40,32 +36,38 @@ func (c *RadosBlockDeviceClient) CreateRBD(pool, name string, size int, suffix s
 
 // executeRBDCreate runs the rbd create command enabling the following features:
 // layering, striping, exclusive-lock, object-map, and fast-diff.
-func (c *RadosBlockDeviceClient) executeRBDCreate(pool, name string, size int, suffix string) error {
+func (c *RadosBlockDeviceClient) executeRBDCreate(spec ImageSpec, size units.Size) error {
 	log.Trace().Msg("executeRBDCreate")
 
-	sizeArgument := cast.ToString(size) + suffix
-	toCreate := pool + "/" + name
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if _, err := c.run(
+		"rbd", "create", "--image-feature", "layering", "--image-feature", "striping", "--image-feature",
+		"exclusive-lock", "--image-feature", "object-map", "--image-feature", "fast-diff", "--size", sizeArgument(size),
+		spec.String(),
+	); err != nil {
+		if helpers.ExitCode(err) == helpers.ExitExists {
+			return fmt.Errorf("%w", validators.ErrRBDExists)
+		}
 
-	cmd := exec.CommandContext(
-		ctx, "rbd", "create", "--image-feature", "layering", "--image-feature", "striping", "--image-feature",
-		"exclusive-lock", "--image-feature", "object-map", "--image-feature", "fast-diff", "--size", sizeArgument,
-		toCreate,
-	)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
+		return fmt.Errorf("ERROR: rbd create failed: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
-		var exitError *exec.ExitError
-		if ok := errors.Is(err, exitError); ok {
-			if exitError.ExitCode() == 17 {
-				return fmt.Errorf("%w", validators.ErrRBDExists)
-			}
+	return nil
+}
 
-			return fmt.Errorf("ERROR: rbd create failed: %w", err)
-		}
+// alignSize rounds a requested size up to the object size of the image, logging when it had to.
+func alignSize(size, objectSize units.Size) units.Size {
+	if size.IsAligned(objectSize) {
+		return size
 	}
 
-	return nil
+	aligned := size.AlignUp(objectSize)
+	log.Info().Str("Requested", size.String()).Str("Aligned", aligned.String()).
+		Str("ObjectSize", objectSize.String()).Msg("size rounded up to the image object size")
+
+	return aligned
+}
+
+// sizeArgument formats a size for --size, which would otherwise read a bare number as megabytes.
+func sizeArgument(size units.Size) string {
+	return fmt.Sprintf("%dB", size.Bytes())
 }
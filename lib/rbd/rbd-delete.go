@@ -1,57 +1,35 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
-	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
-func (c *RadosBlockDeviceClient) DeleteRBD(pool string, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
-	}
+func (c *RadosBlockDeviceClient) DeleteRBD(spec ImageSpec) (err error) {
+	c, span := c.startSpan("DeleteRBD", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	client := &RadosBlockDeviceClient{}
-	if deleteError := client.executeRBDDelete(pool, name); deleteError != nil {
+	if deleteError := c.executeRBDDelete(spec); deleteError != nil {
 		return fmt.Errorf("%w", deleteError)
 	}
 
 	return nil
 }
 
-func (c *RadosBlockDeviceClient) executeRBDDelete(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
-	}
-
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+func (c *RadosBlockDeviceClient) executeRBDDelete(spec ImageSpec) error {
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("executeRBDDelete")
-
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "rbd", "--pool", pool, "rm", name)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
+	log.Trace().Str("Image", spec.String()).Msg("executeRBDDelete")
 
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("rbd", "rm", spec.String()); err != nil {
 		return fmt.Errorf("ERROR: rbd rm failed: %w", err)
 	}
 
@@ -0,0 +1,93 @@
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// DiskUsage
+/* rbd du rbd/test-image --format json
+{
+  "images": [
+    {
+      "name": "test-image",
+      "id": "979ba5a95620ef",
+      "provisioned_size": 10737418240,
+      "used_size": 1073741824
+    }
+  ],
+  "total_provisioned_size": 10737418240,
+  "total_used_size": 1073741824
+}
+DiskUsage is used to compare the provisioned size of an image with the space it actually uses. */
+type DiskUsage struct {
+	Images []*struct {
+		Name            string     `json:"name"`
+		ID              string     `json:"id"`
+		Snapshot        string     `json:"snapshot,omitempty"`
+		ProvisionedSize units.Size `json:"provisioned_size"` //nolint:tagliatelle
+		UsedSize        units.Size `json:"used_size"`        //nolint:tagliatelle
+	} `json:"images"`
+	TotalProvisionedSize units.Size `json:"total_provisioned_size"` //nolint:tagliatelle
+	TotalUsedSize        units.Size `json:"total_used_size"`        //nolint:tagliatelle
+}
+
+// GetDiskUsage returns the provisioned and used size of an image and its snapshots.
+func (c *RadosBlockDeviceClient) GetDiskUsage(spec ImageSpec) (_ *DiskUsage, err error) {
+	c, span := c.startSpan("GetDiskUsage", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("GetDiskUsage")
+
+	stdOut, err := c.run("rbd", "du", spec.String(), "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd du failed: %w", err)
+	}
+
+	var usage *DiskUsage
+
+	if err := json.Unmarshal(stdOut, &usage); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd du could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	return usage, nil
+}
+
+// GetPoolDiskUsage returns the provisioned and used size of every image in a pool or one of its
+// namespaces, and of their snapshots.
+func (c *RadosBlockDeviceClient) GetPoolDiskUsage(pool, namespace string) (_ *DiskUsage, err error) {
+	c, span := c.startSpan("GetPoolDiskUsage", tracing.PoolKey.String(pool), tracing.NamespaceKey.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidatePoolName(pool); err != nil {
+		return nil, err
+	}
+
+	if err := validators.ValidateNamespaceName(namespace); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Pool", pool).Str("Namespace", namespace).Msg("GetPoolDiskUsage")
+
+	stdOut, err := c.run("rbd", "du", "--pool", pool, "--namespace", namespace, "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd du failed: %w", err)
+	}
+
+	var usage *DiskUsage
+
+	if err := json.Unmarshal(stdOut, &usage); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd du could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,116 @@
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+// metadataPrefix namespaces the image metadata keys written by scattered-storage.
+const metadataPrefix = "scattered-storage."
+
+// ImageMetadata
+/* rbd image-meta list rbd/test-image --format json
+{
+  "scattered-storage.map-backend": "nbd"
+}
+ImageMetadata holds the key/value pairs stored alongside an image. */
+type ImageMetadata map[string]string
+
+// executeImageMetaList runs rbd image-meta list for the image. Snapshots share the metadata of their image.
+func (c *RadosBlockDeviceClient) executeImageMetaList(spec ImageSpec) (ImageMetadata, error) {
+	image := spec
+	image.Snapshot = ""
+
+	log.Trace().Str("Image", image.String()).Msg("executeImageMetaList")
+
+	stdOut, err := c.run("rbd", "image-meta", "list", image.String(), "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd image-meta list failed: %w", err)
+	}
+
+	metadata := ImageMetadata{}
+
+	// rbd prints nothing at all when an image has no metadata.
+	if len(stdOut) == 0 {
+		return metadata, nil
+	}
+
+	if err := json.Unmarshal(stdOut, &metadata); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd image-meta list could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	return metadata, nil
+}
+
+// GetImageMetadata returns the key/value pairs stored alongside an image.
+func (c *RadosBlockDeviceClient) GetImageMetadata(spec ImageSpec) (_ ImageMetadata, err error) {
+	c, span := c.startSpan("GetImageMetadata", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c.executeImageMetaList(spec)
+}
+
+// SetImageMetadata stores a key/value pair alongside an image.
+func (c *RadosBlockDeviceClient) SetImageMetadata(spec ImageSpec, key, value string) (err error) {
+	c, span := c.startSpan("SetImageMetadata", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	return c.executeImageMetaSet(spec, key, value)
+}
+
+// RemoveImageMetadata removes a key stored alongside an image.
+func (c *RadosBlockDeviceClient) RemoveImageMetadata(spec ImageSpec, key string) (err error) {
+	c, span := c.startSpan("RemoveImageMetadata", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	return c.executeImageMetaRemove(spec, key)
+}
+
+// imageMetadata returns the metadata of an image, or no metadata at all if it cannot be read.
+func (c *RadosBlockDeviceClient) imageMetadata(spec ImageSpec) ImageMetadata {
+	metadata, err := c.executeImageMetaList(spec)
+	if err != nil {
+		log.Trace().Str("Image", spec.String()).Str("Error", err.Error()).Msg("could not read image metadata")
+
+		return ImageMetadata{}
+	}
+
+	return metadata
+}
+
+// executeImageMetaSet runs rbd image-meta set for a key of the image.
+func (c *RadosBlockDeviceClient) executeImageMetaSet(spec ImageSpec, key, value string) error {
+	log.Trace().Str("Image", spec.String()).Str("Key", key).Msg("executeImageMetaSet")
+
+	if _, err := c.run("rbd", "image-meta", "set", spec.String(), key, value); err != nil {
+		return fmt.Errorf("ERROR: rbd image-meta set failed: %w", err)
+	}
+
+	return nil
+}
+
+// executeImageMetaRemove runs rbd image-meta remove for a key of the image.
+func (c *RadosBlockDeviceClient) executeImageMetaRemove(spec ImageSpec, key string) error {
+	log.Trace().Str("Image", spec.String()).Str("Key", key).Msg("executeImageMetaRemove")
+
+	if _, err := c.run("rbd", "image-meta", "remove", spec.String(), key); err != nil {
+		return fmt.Errorf("ERROR: rbd image-meta remove failed: %w", err)
+	}
+
+	return nil
+}
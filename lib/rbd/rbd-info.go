@@ -1,32 +1,25 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
-	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
-// GetImageInfo Gathers *RBD image info for the '<pool>/<name>' image.
-func (c *RadosBlockDeviceClient) GetImageInfo(pool, name string) (*RBD, error) {
-	if !ValidatePool(pool) {
-		return nil, validators.ErrInvalidPoolName
-	}
+// GetImageInfo Gathers *RBD image info for the '<pool>/<namespace>/<name>' image.
+func (c *RadosBlockDeviceClient) GetImageInfo(spec ImageSpec) (_ *RBD, err error) {
+	c, span := c.startSpan("GetImageInfo", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateName(name) {
-		return nil, validators.ErrInvalidRBDName
+	if err := spec.Validate(); err != nil {
+		return nil, err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("GetImageInfo")
+	log.Trace().Str("Image", spec.String()).Msg("GetImageInfo")
 
-	client := &RadosBlockDeviceClient{}
-	image, infoError := client.executeRBDInfo(pool, name)
+	image, infoError := c.executeRBDInfo(spec)
 	if infoError != nil {
 		return nil, infoError
 	}
@@ -35,27 +28,17 @@ func (c *RadosBlockDeviceClient) GetImageInfo(pool, name string) (*RBD, error) {
 }
 
 // executeRBDInfo executes rbd info --format json for the given RBD image.
-func (c *RadosBlockDeviceClient) executeRBDInfo(pool, name string) (*RBD, error) {
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("executeRBDInfo")
-
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "rbd", "--pool", pool, "info", name, "--format", "json")
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
+func (c *RadosBlockDeviceClient) executeRBDInfo(spec ImageSpec) (*RBD, error) {
+	log.Trace().Str("Image", spec.String()).Msg("executeRBDInfo")
 
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("rbd", "info", spec.String(), "--format", "json")
+	if err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 
 	var image *RBD
 
-	if err := json.Unmarshal(stdOut.Bytes(), &image); err != nil {
+	if err := json.Unmarshal(stdOut, &image); err != nil {
 		return nil, fmt.Errorf("%w", err)
 	}
 
@@ -1,25 +1,29 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/scattered-network/scattered-storage/lib/helpers"
-	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
-func (c *RadosBlockDeviceClient) GetRBDList(pool string) ([]string, error) {
-	if !ValidatePool(pool) {
-		return nil, validators.ErrInvalidPoolName
+// GetRBDList returns the images of a pool. An empty namespace lists the default namespace.
+func (c *RadosBlockDeviceClient) GetRBDList(pool, namespace string) (_ []string, err error) {
+	c, span := c.startSpan("GetRBDList", tracing.PoolKey.String(pool), tracing.NamespaceKey.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidatePoolName(pool); err != nil {
+		return nil, err
+	}
+
+	if err := validators.ValidateNamespaceName(namespace); err != nil {
+		return nil, err
 	}
 
-	rbdList, listError := c.executeRBDList(pool)
+	rbdList, listError := c.executeRBDList(pool, namespace)
 	if listError != nil {
 		return nil, fmt.Errorf("%w", listError)
 	}
@@ -27,31 +31,22 @@ func (c *RadosBlockDeviceClient) GetRBDList(pool string) ([]string, error) {
 	return rbdList, nil
 }
 
-func (c *RadosBlockDeviceClient) executeRBDList(pool string) (helpers.List, error) {
-	if !ValidatePool(pool) {
-		return nil, validators.ErrInvalidPoolName
+func (c *RadosBlockDeviceClient) executeRBDList(pool, namespace string) (helpers.List, error) {
+	if err := validators.ValidatePoolName(pool); err != nil {
+		return nil, err
 	}
 
-	log.Trace().Str("Pool", pool).Msg("executeRBDList")
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "rbd", "--pool", pool, "list", "--format", "json")
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	var stdOut, stdErr bytes.Buffer
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
+	log.Trace().Str("Pool", pool).Str("Namespace", namespace).Msg("executeRBDList")
 
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("rbd", "--pool", pool, "--namespace", namespace, "list", "--format", "json")
+	if err != nil {
 		return nil, fmt.Errorf("ERROR: rbd list failed: %w", err)
 	}
 
 	var RBDList helpers.List
 
-	if err := json.Unmarshal(stdOut.Bytes(), &RBDList); err != nil {
-		return nil, fmt.Errorf("ERROR: json for rbd list could not unmarshal:\n%w\n%s", err, stdOut.String())
+	if err := json.Unmarshal(stdOut, &RBDList); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd list could not unmarshal:\n%w\n%s", err, string(stdOut))
 	}
 
 	return RBDList, nil
@@ -2,47 +2,39 @@ package rbd
 
 import (
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
-func (c *RadosBlockDeviceClient) listLocks(pool, name string) ([]*Lock, error) {
-	var list []*Lock
+// ListLocks returns the advisory locks held on an image.
+func (c *RadosBlockDeviceClient) ListLocks(spec ImageSpec) (_ []*Lock, err error) {
+	c, span := c.startSpan("ListLocks", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidatePool(pool) {
-		return list, validators.ErrInvalidPoolName //nolint:exhaustruct
-	}
+	var list []*Lock
 
-	if !ValidateName(name) {
-		return list, validators.ErrInvalidRBDName //nolint:exhaustruct
+	if err := spec.validateImage(); err != nil {
+		return list, err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("ListLocks")
-	return c.executeListLocks(pool, name)
+	log.Trace().Str("Image", spec.String()).Msg("ListLocks")
+	return c.executeListLocks(spec)
 }
 
-func (c *RadosBlockDeviceClient) addLock(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
+func (c *RadosBlockDeviceClient) addLock(spec ImageSpec) error {
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
-	}
-
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("AddLock")
-	return c.executeAddLock(pool, name)
+	log.Trace().Str("Image", spec.String()).Msg("AddLock")
+	return c.executeAddLock(spec)
 }
 
-func (c *RadosBlockDeviceClient) removeLock(pool, name string, lock *Lock) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
-	}
-
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+func (c *RadosBlockDeviceClient) removeLock(spec ImageSpec, lock *Lock) error {
+	if err := spec.validateImage(); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Interface("Lock", lock).Msg("RemoveLock")
+	log.Trace().Str("Image", spec.String()).Interface("Lock", lock).Msg("RemoveLock")
 
-	return c.executeRemoveLock(pool, name, lock)
+	return c.executeRemoveLock(spec, lock)
 }
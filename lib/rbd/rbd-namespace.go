@@ -0,0 +1,103 @@
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// NamespaceList
+/* rbd namespace ls --pool rbd --format json
+[
+  {
+    "name": "tenant1"
+  },
+  {
+    "name": "tenant2"
+  }
+]
+NamespaceList is used to find the namespaces that divide a pool between tenants. */
+type NamespaceList []*struct {
+	Name string `json:"name"`
+}
+
+func validateNamespace(pool, namespace string) error {
+	if err := validators.ValidatePoolName(pool); err != nil {
+		return err
+	}
+
+	if namespace == "" {
+		return fmt.Errorf("%w: the default namespace cannot be created or removed", validators.ErrInvalidNamespace)
+	}
+
+	return validators.ValidateNamespaceName(namespace)
+}
+
+// CreateNamespace creates a namespace within the pool.
+func (c *RadosBlockDeviceClient) CreateNamespace(pool, namespace string) (err error) {
+	c, span := c.startSpan("CreateNamespace", tracing.PoolKey.String(pool), tracing.NamespaceKey.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateNamespace(pool, namespace); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Pool", pool).Str("Namespace", namespace).Msg("CreateNamespace")
+
+	if _, err := c.run("rbd", "namespace", "create", "--pool", pool, "--namespace", namespace); err != nil {
+		return fmt.Errorf("ERROR: rbd namespace create failed: %w", err)
+	}
+
+	return nil
+}
+
+// ListNamespaces returns the names of the namespaces within the pool.
+func (c *RadosBlockDeviceClient) ListNamespaces(pool string) (_ []string, err error) {
+	c, span := c.startSpan("ListNamespaces", tracing.PoolKey.String(pool))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidatePoolName(pool); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Pool", pool).Msg("ListNamespaces")
+
+	stdOut, err := c.run("rbd", "namespace", "ls", "--pool", pool, "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd namespace ls failed: %w", err)
+	}
+
+	var list NamespaceList
+
+	if err := json.Unmarshal(stdOut, &list); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd namespace ls could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	names := make([]string, 0, len(list))
+	for _, namespace := range list {
+		names = append(names, namespace.Name)
+	}
+
+	return names, nil
+}
+
+// RemoveNamespace removes an empty namespace from the pool.
+func (c *RadosBlockDeviceClient) RemoveNamespace(pool, namespace string) (err error) {
+	c, span := c.startSpan("RemoveNamespace", tracing.PoolKey.String(pool), tracing.NamespaceKey.String(namespace))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateNamespace(pool, namespace); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Pool", pool).Str("Namespace", namespace).Msg("RemoveNamespace")
+
+	if _, err := c.run("rbd", "namespace", "remove", "--pool", pool, "--namespace", namespace); err != nil {
+		return fmt.Errorf("ERROR: rbd namespace remove failed: %w", err)
+	}
+
+	return nil
+}
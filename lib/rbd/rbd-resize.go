@@ -0,0 +1,50 @@
+package rbd
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+)
+
+// ResizeRBD changes the size of an image, rounded up to a multiple of the image's object size.
+// Shrinking discards data at the end of the image and is refused unless allowShrink is set.
+func (c *RadosBlockDeviceClient) ResizeRBD(spec ImageSpec, size units.Size, allowShrink bool) (err error) {
+	c, span := c.startSpan("ResizeRBD", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return err
+	}
+
+	if err := ValidateSize(size); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Str("Size", size.String()).Bool("AllowShrink", allowShrink).
+		Msg("ResizeRBD")
+
+	image, infoError := c.executeRBDInfo(spec)
+	if infoError != nil {
+		return infoError
+	}
+
+	return c.executeRBDResize(spec, alignSize(size, units.Size(image.ObjectSize)), allowShrink)
+}
+
+// executeRBDResize runs the rbd resize command.
+func (c *RadosBlockDeviceClient) executeRBDResize(spec ImageSpec, size units.Size, allowShrink bool) error {
+	log.Trace().Str("Image", spec.String()).Msg("executeRBDResize")
+
+	args := []string{"resize", "--size", sizeArgument(size)}
+	if allowShrink {
+		args = append(args, "--allow-shrink")
+	}
+
+	if _, err := c.run("rbd", append(args, spec.String())...); err != nil {
+		return fmt.Errorf("ERROR: rbd resize failed: %w", err)
+	}
+
+	return nil
+}
@@ -1,14 +1,11 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 )
 
 // ShowMapped
@@ -33,50 +30,62 @@ import (
 ]
 
 ShowMapped is used to determine which images have been mapped to the local node. */
-type ShowMapped []struct {
-	ID        string `json:"id"`
-	Pool      string `json:"pool"`
-	Namespace string `json:"namespace"`
-	Name      string `json:"name"`
-	Snap      string `json:"snap"`
-	Device    string `json:"device"`
+type ShowMapped []*MappedImage
+
+// MappedImage is a single image mapped to the host. Backend is not part of the rbd output and is
+// filled in by ListMappedImages.
+type MappedImage struct {
+	ID        string     `json:"id"`
+	Pool      string     `json:"pool"`
+	Namespace string     `json:"namespace"`
+	Name      string     `json:"name"`
+	Snap      string     `json:"snap"`
+	Device    string     `json:"device"`
+	Backend   MapBackend `json:"backend,omitempty"`
 }
 
-// ListMappedImages returns the RBD images mapped to the host.
-func (c *RadosBlockDeviceClient) ListMappedImages() (*ShowMapped, error) {
-	list, listError := c.executeShowMapped()
-	if listError != nil {
-		log.Error().Str("Error", listError.Error()).Msg("Could not list mapped images")
+// ListMappedImages returns the RBD images mapped to the host by any backend. rbd-nbd is not
+// installed on every host, so a failure to list its mappings is logged and otherwise ignored.
+func (c *RadosBlockDeviceClient) ListMappedImages() (_ *ShowMapped, err error) {
+	c, span := c.startSpan("ListMappedImages")
+	defer func() { tracing.End(span, err) }()
 
-		return nil, listError
-	}
+	list := ShowMapped{}
 
-	return list, nil
-}
+	for _, backend := range c.mappers() {
+		mapped, listError := backend.listMapped()
+		if listError != nil {
+			if backend.backend() != MapBackendKRBD {
+				log.Debug().Str("Backend", string(backend.backend())).Str("Error", listError.Error()).
+					Msg("Could not list mapped images")
 
-// executeShowMapped runs the rbd showmapped --format json command and returns the results as *ShowMapped.
-func (c *RadosBlockDeviceClient) executeShowMapped() (*ShowMapped, error) {
-	var stdOut, stdErr bytes.Buffer
+				continue
+			}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+			log.Error().Str("Error", listError.Error()).Msg("Could not list mapped images")
 
-	cmd := exec.CommandContext(ctx, "rbd", "showmapped", "--format", "json")
-	log.Trace().Str("Command", cmd.String()).Msg("Executing command")
+			return nil, listError
+		}
 
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
+		list = append(list, mapped...)
+	}
+
+	return &list, nil
+}
 
-	var list *ShowMapped
+// executeShowMapped runs the rbd showmapped --format json command and returns the results as *ShowMapped.
+func (c *RadosBlockDeviceClient) executeShowMapped() (*ShowMapped, error) {
+	list := &ShowMapped{}
 
-	if err := cmd.Run(); err != nil {
+	stdOut, err := c.run("rbd", "showmapped", "--format", "json")
+	if err != nil {
 		log.Error().Str("Error", err.Error()).Msg("Error executing command")
 
 		return list, fmt.Errorf("%w", err)
 	}
 
-	if err := json.Unmarshal(stdOut.Bytes(), &list); err != nil {
-		log.Error().Str("Response", stdOut.String()).Str("Error", err.Error()).
+	if err := json.Unmarshal(stdOut, &list); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).
 			Msg("Encountered Error Unmarshalling Response")
 
 		return nil, fmt.Errorf("%w", err)
@@ -0,0 +1,112 @@
+package rbd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// SnapshotList
+/* rbd snap ls rbd/test1 --format json
+[
+  {
+    "id": 4,
+    "name": "daily",
+    "size": 10737418240,
+    "protected": "false",
+    "timestamp": "Mon Oct 19 14:00:00 2026"
+  }
+]
+SnapshotList is used to find the snapshots of an image. */
+type SnapshotList []*Snapshot
+
+type Snapshot struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Protected string `json:"protected"`
+	Timestamp string `json:"timestamp"`
+}
+
+// CreateSnapshot takes the snapshot named by the spec.
+func (c *RadosBlockDeviceClient) CreateSnapshot(spec ImageSpec) (err error) {
+	c, span := c.startSpan("CreateSnapshot", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSnapshot(spec); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Snapshot", spec.String()).Msg("CreateSnapshot")
+
+	if _, err := c.run("rbd", "snap", "create", spec.String()); err != nil {
+		if helpers.ExitCode(err) == helpers.ExitExists {
+			return fmt.Errorf("%w: %s", validators.ErrSnapshotExists, spec.String())
+		}
+
+		return fmt.Errorf("ERROR: rbd snap create failed: %w", err)
+	}
+
+	return nil
+}
+
+// ListSnapshots returns the snapshots of an image.
+func (c *RadosBlockDeviceClient) ListSnapshots(spec ImageSpec) (_ SnapshotList, err error) {
+	c, span := c.startSpan("ListSnapshots", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("ListSnapshots")
+
+	stdOut, err := c.run("rbd", "snap", "ls", spec.String(), "--format", "json")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: rbd snap ls failed: %w", err)
+	}
+
+	list := SnapshotList{}
+
+	if err := json.Unmarshal(stdOut, &list); err != nil {
+		return nil, fmt.Errorf("ERROR: json for rbd snap ls could not unmarshal:\n%w\n%s", err, string(stdOut))
+	}
+
+	return list, nil
+}
+
+// RemoveSnapshot removes the snapshot named by the spec. Protected snapshots must be unprotected
+// first.
+func (c *RadosBlockDeviceClient) RemoveSnapshot(spec ImageSpec) (err error) {
+	c, span := c.startSpan("RemoveSnapshot", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateSnapshot(spec); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Snapshot", spec.String()).Msg("RemoveSnapshot")
+
+	if _, err := c.run("rbd", "snap", "rm", spec.String()); err != nil {
+		return fmt.Errorf("ERROR: rbd snap rm failed: %w", err)
+	}
+
+	return nil
+}
+
+// validateSnapshot checks a spec for operations on a snapshot, which it must name.
+func validateSnapshot(spec ImageSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	if spec.Snapshot == "" {
+		return fmt.Errorf("%w: %s", ErrSnapshotRequired, spec.String())
+	}
+
+	return nil
+}
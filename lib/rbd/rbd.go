@@ -1,10 +1,92 @@
 package rbd
 
 import (
+	"context"
+	"time"
+
 	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultCommandTimeout  = 5 * time.Second
+	makeFilesystemTimeout  = 300 * time.Second
+	checkFilesystemTimeout = time.Hour
+	encryptionTimeout      = 2 * time.Minute // deriving a key with the argon2 pbkdf of LUKS2 takes seconds
 )
 
-type RadosBlockDeviceClient struct{}
+// RadosBlockDeviceClient manages RBD images and their mappings on the local host. The zero value
+// runs commands on the local host and maps images with krbd; set Runner to replay recorded output
+// instead, MapBackend to map images with another backend by default and PoolMapOptions to override
+// DefaultMapOptions for the images of a pool. PersistRoot is the directory Persist writes the
+// rbdmap, fstab and systemd files below; it defaults to '/'. CommandTimeout and
+// MakeFilesystemTimeout replace the default timeouts of commands and of mkfs when set. Each
+// public operation is traced below the span of the context given to WithContext.
+type RadosBlockDeviceClient struct {
+	Runner                helpers.Runner
+	MapBackend            MapBackend
+	PoolMapOptions        map[string]MapOptions
+	PersistRoot           string
+	CommandTimeout        time.Duration
+	MakeFilesystemTimeout time.Duration
+
+	ctx context.Context //nolint:containedctx
+}
+
+// WithContext returns a copy of the client whose operations and commands are traced below the span
+// of ctx, such as the span of the API request or gRPC call they serve. Only the span is kept: the
+// commands are limited by their own timeouts, not by the cancellation or deadline of ctx.
+func (c *RadosBlockDeviceClient) WithContext(ctx context.Context) *RadosBlockDeviceClient {
+	traced := *c
+	traced.ctx = tracing.SpanOnly(ctx)
+
+	return &traced
+}
+
+// context returns the context the commands of the client run with.
+func (c *RadosBlockDeviceClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// startSpan starts the span of a public operation and returns a copy of the client whose commands,
+// and the operations it calls, are recorded below it.
+func (c *RadosBlockDeviceClient) startSpan(
+	operation string, attributes ...attribute.KeyValue,
+) (*RadosBlockDeviceClient, trace.Span) {
+	ctx, span := tracing.Start(c.context(), "rbd."+operation, attributes...)
+
+	return c.WithContext(ctx), span
+}
+
+// run executes a command through the configured Runner using the command timeout.
+func (c *RadosBlockDeviceClient) run(command string, args ...string) ([]byte, error) {
+	timeout := defaultCommandTimeout
+	if c.CommandTimeout > 0 {
+		timeout = c.CommandTimeout
+	}
+
+	return c.runWithTimeout(timeout, command, args...)
+}
+
+// runWithTimeout executes a command through the configured Runner, giving up after timeout.
+func (c *RadosBlockDeviceClient) runWithTimeout(timeout time.Duration, command string, args ...string) ([]byte, error) {
+	runner := c.Runner
+	if runner == nil {
+		runner = helpers.DefaultRunner
+	}
+
+	ctx, cancel := context.WithTimeout(c.context(), timeout)
+	defer cancel()
+
+	return runner.Run(ctx, command, args...) //nolint:wrapcheck
+}
 
 // RBD
 /* rbd --pool rbd info test-image --format json
@@ -46,20 +128,16 @@ type RBD struct {
 	ModifyTimestamp string         `json:"modify_timestamp"` //nolint:tagliatelle
 }
 
-// isMapped requires the 'pool' and 'name' of the rbd image to check and returns
+// isMapped requires the spec of the rbd image to check and returns
 // both the device path and a bool representing the mapped state.
-func (c *RadosBlockDeviceClient) isMapped(pool, name string) (string, bool) {
-	if !ValidatePool(pool) {
-		return "", false
-	}
-
-	if !ValidateName(name) {
+func (c *RadosBlockDeviceClient) isMapped(spec ImageSpec) (string, bool) {
+	if err := spec.Validate(); err != nil {
 		return "", false
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("isMapped")
+	log.Trace().Str("Image", spec.String()).Msg("isMapped")
 
-	list, listError := c.executeShowMapped()
+	list, listError := c.ListMappedImages()
 	if listError != nil {
 		log.Error().Str("Error", listError.Error()).Msg("error listing mapped images")
 
@@ -67,7 +145,7 @@ func (c *RadosBlockDeviceClient) isMapped(pool, name string) (string, bool) {
 	}
 
 	for _, image := range *list {
-		if image.Name == name && image.Pool == pool {
+		if spec.matches(image.Pool, image.Namespace, image.Name, image.Snap) {
 			return image.Device, true
 		}
 	}
@@ -1,22 +1,29 @@
 package rbd
 
 import (
-	"context"
 	"fmt"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
-func (c *RadosBlockDeviceClient) PartitionEntireDisk(device string) error {
-	if !ValidateDevicePath(device) {
-		return validators.ErrInvalidDevicePath
+// PartitionEntireDisk writes a new partition table holding a single partition spanning the device.
+// A device carrying any signature, such as a filesystem or a LUKS header, is refused unless force is
+// set, in which case the signatures are erased first.
+func (c *RadosBlockDeviceClient) PartitionEntireDisk(device string, force bool) (err error) {
+	c, span := c.startSpan("PartitionEntireDisk", tracing.DeviceKey.String(device))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateDevicePath(device); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Device", device).Msg("PartitionEntireDisk")
+	log.Trace().Str("Device", device).Bool("Force", force).Msg("PartitionEntireDisk")
+
+	if err := c.checkFormatSafe(device, force); err != nil {
+		return err
+	}
 
 	if clearError := c.executeClearPartitions(device); clearError != nil {
 		return clearError
@@ -32,13 +39,7 @@ func (c *RadosBlockDeviceClient) PartitionEntireDisk(device string) error {
 func (c *RadosBlockDeviceClient) executeClearPartitions(device string) error {
 	log.Trace().Str("Device", device).Msg("executeClearPartitions")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sgdisk", "-o", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("sgdisk", "-o", device); err != nil {
 		return fmt.Errorf("ERROR: sgdisk clear failed: %w", err)
 	}
 
@@ -48,13 +49,7 @@ func (c *RadosBlockDeviceClient) executeClearPartitions(device string) error {
 func (c *RadosBlockDeviceClient) executeZapPartitions(device string) error {
 	log.Trace().Str("Device", device).Msg("executeZapPartitions")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sgdisk", "--zap", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("sgdisk", "--zap", device); err != nil {
 		return fmt.Errorf("ERROR: sgdisk zap failed: %w", err)
 	}
 
@@ -64,13 +59,7 @@ func (c *RadosBlockDeviceClient) executeZapPartitions(device string) error {
 func (c *RadosBlockDeviceClient) executePartitionEntireDisk(device string) error {
 	log.Trace().Str("Device", device).Msg("executePartitionEntireDisk")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "sgdisk", "--new", "1::0", "--typecode", "1:8300", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-	if err := cmd.Run(); err != nil {
+	if _, err := c.run("sgdisk", "--new", "1::0", "--typecode", "1:8300", device); err != nil {
 		return fmt.Errorf("ERROR: sgdisk new failed: %w", err)
 	}
 
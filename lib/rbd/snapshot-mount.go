@@ -0,0 +1,124 @@
+package rbd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+)
+
+var ErrSnapshotRequired = errors.New("rbd image spec does not name a snapshot")
+
+// MountSnapshot maps a snapshot read-only and mounts its filesystem at path. Snapshots are mapped
+// without the exclusive lock, so several hosts can mount the same snapshot at once. The filesystem
+// is never created or modified: the snapshot must hold a filesystem created by Mount. XFS
+// snapshots are mounted with nouuid and norecovery, so that they can be mounted next to their
+// image and despite the log of a filesystem that was in use when the snapshot was taken, and ext4
+// snapshots with noload for the same reason. A snapshot mapped by this call is unmapped again when
+// it cannot be mounted.
+func (c *RadosBlockDeviceClient) MountSnapshot(spec ImageSpec, path string, options *MountOptions) (err error) {
+	c, span := c.startSpan("MountSnapshot", append(spec.attributes(), tracing.MountPointKey.String(path))...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	if spec.Snapshot == "" {
+		return fmt.Errorf("%w: %s", ErrSnapshotRequired, spec.String())
+	}
+
+	if exists, err := PathExists(path); err != nil {
+		return err
+	} else if !exists {
+		return ErrMountFailed
+	}
+
+	if err := snapshotMountOptions(options, "").Validate(); err != nil {
+		return err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("MountSnapshot")
+
+	if encryption := c.imageMetadata(spec)[encryptionMetadataKey]; encryption != "" {
+		return fmt.Errorf("%w: %s uses %s encryption", ErrImageEncrypted, spec.String(), encryption)
+	}
+
+	_, mapped := c.isMapped(spec)
+
+	device, err := c.mapDevice(spec)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil || mapped {
+			return
+		}
+
+		if unmapError := c.executeUnmap(device); unmapError != nil {
+			log.Warn().Err(unmapError).Str("Device", device).Msg("could not unmap snapshot after failed mount")
+		}
+	}()
+
+	return c.mountSnapshotDevice(spec, device, path, options)
+}
+
+// mountSnapshotDevice mounts the first partition of a mapped snapshot, or the filesystem written to
+// the whole device, with the options returned by snapshotMountOptions.
+func (c *RadosBlockDeviceClient) mountSnapshotDevice(spec ImageSpec, device, path string, options *MountOptions) error {
+	info, err := c.executeListBlock(device)
+	if err != nil {
+		return err
+	}
+
+	if len(info.Blockdevices) == 0 {
+		return fmt.Errorf("%w: snapshot %s has no block device", ErrMountFailed, spec.String())
+	}
+
+	// A filesystem written to the whole device has no partitions.
+	if whole := info.Blockdevices[0]; len(whole.Children) == 0 {
+		if whole.FSType == "" {
+			return fmt.Errorf("%w: snapshot %s has no partitions or filesystem", ErrMountFailed, spec.String())
+		}
+
+		return c.mountSnapshotPartition(whole.Path, whole.FSType, path, options)
+	}
+
+	partition := info.Blockdevices[0].Children[0]
+
+	return c.mountSnapshotPartition(partition.Path, partition.FSType, path, options)
+}
+
+// mountSnapshotPartition checks the snapshot options against the filesystem found on a partition,
+// or a whole device, and mounts it.
+func (c *RadosBlockDeviceClient) mountSnapshotPartition(partition, fsType, path string, options *MountOptions) error {
+	mountOptions := snapshotMountOptions(options, fsType)
+	if err := mountOptions.validateFor(fsType); err != nil {
+		return err
+	}
+
+	return c.mountPartition(partition, path, mountOptions)
+}
+
+// snapshotMountOptions returns a read-only copy of the options, adding the XFS or ext4 options
+// needed to mount a snapshot.
+func snapshotMountOptions(options *MountOptions, fsType string) *MountOptions {
+	result := &MountOptions{}
+	if options != nil {
+		*result = *options
+	}
+
+	result.ReadOnly = true
+
+	switch fsType {
+	case TagXfs:
+		result.NoUUID = true
+		result.NoRecovery = true
+	case TagExt4:
+		result.NoLoad = true
+	}
+
+	return result
+}
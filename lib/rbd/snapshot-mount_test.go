@@ -0,0 +1,138 @@
+package rbd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// TestMountSnapshot tests that snapshots are mapped read-only without the exclusive lock and
+// mounted with the XFS and ext4 snapshot options.
+func TestMountSnapshot(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Image: "test1", Snapshot: "daily"}
+	showMapped := `[{"id":"0","pool":"rbd","namespace":"","name":"test1","snap":"daily","device":"/dev/rbd0"}]`
+
+	tests := []struct {
+		name      string
+		backend   MapBackend
+		fsType    string
+		wantMap   string
+		wantMount string
+	}{
+		{
+			name:      "TestXFS",
+			fsType:    TagXfs,
+			wantMap:   "rbd --read-only --options lock_timeout=10 map rbd/test1@daily",
+			wantMount: "mount -o ro,nouuid,norecovery /dev/rbd0p1 ",
+		},
+		{
+			name:      "TestExt4NBD",
+			backend:   MapBackendNBD,
+			fsType:    TagExt4,
+			wantMap:   "rbd-nbd map --read-only rbd/test1@daily",
+			wantMount: "mount -o ro,noload /dev/rbd0p1 ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partition := `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[` +
+				`{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"` + tt.fsType + `","type":"part"}]}]}`
+			runner := &helperstest.Runner{
+				Replies: map[string]string{
+					"rbd showmapped --format json":                             "[]",
+					"rbd-nbd list-mapped --format json":                        "[]",
+					"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE":   partition,
+					"lsblk -J /dev/rbd0p1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": partition,
+				},
+			}
+			client := &RadosBlockDeviceClient{Runner: runner, MapBackend: tt.backend}
+
+			// The snapshot shows up as mapped once it has been mapped.
+			runner.OnCall = func(line string) {
+				if line == tt.wantMap {
+					runner.Replies["rbd showmapped --format json"] = showMapped
+				}
+			}
+
+			path := t.TempDir()
+			if err := client.MountSnapshot(spec, path, nil); err != nil {
+				t.Fatalf("MountSnapshot() error = %v", err)
+			}
+
+			for _, want := range []string{tt.wantMap, tt.wantMount + path} {
+				if !runner.Called(want) {
+					t.Errorf("MountSnapshot() calls = %v, want %q", runner.Calls, want)
+				}
+			}
+
+			for _, call := range runner.Calls {
+				if strings.HasPrefix(call, "rbd image-meta set") {
+					t.Errorf("MountSnapshot() recorded map options: %q", call)
+				}
+			}
+		})
+	}
+}
+
+// TestMountSnapshotRequiresSnapshot tests that MountSnapshot does not mount an image read-write.
+func TestMountSnapshotRequiresSnapshot(t *testing.T) {
+	client := &RadosBlockDeviceClient{Runner: &helperstest.Runner{}}
+
+	if err := client.MountSnapshot(ImageSpec{Pool: "rbd", Image: "test1"}, t.TempDir(), nil); !errors.Is(err, ErrSnapshotRequired) {
+		t.Errorf("MountSnapshot() error = %v, want %v", err, ErrSnapshotRequired)
+	}
+
+	if err := client.Mount(ImageSpec{Pool: "rbd", Image: "test1", Snapshot: "daily"}, t.TempDir(), nil); err == nil {
+		t.Errorf("Mount() accepted a snapshot")
+	}
+}
+
+// TestMountSnapshotFailure tests that invalid options are refused before mapping and that a
+// snapshot mapped for a failed mount is unmapped again.
+func TestMountSnapshotFailure(t *testing.T) {
+	spec := ImageSpec{Pool: "rbd", Image: "test1", Snapshot: "daily"}
+	uid := 1000
+
+	client := &RadosBlockDeviceClient{Runner: &helperstest.Runner{}}
+	if err := client.MountSnapshot(spec, t.TempDir(), &MountOptions{UID: &uid}); !errors.Is(err, validators.ErrInvalidMountOptions) {
+		t.Errorf("MountSnapshot() error = %v, want %v", err, validators.ErrInvalidMountOptions)
+	}
+
+	if calls := client.Runner.(*helperstest.Runner).Calls; len(calls) != 0 {
+		t.Errorf("MountSnapshot() ran %q before validating the options", calls)
+	}
+
+	const wantMap = "rbd --read-only --options lock_timeout=10 map rbd/test1@daily"
+
+	partition := `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","children":[` +
+		`{"name":"rbd0p1","path":"/dev/rbd0p1","fstype":"ext4","type":"part"}]}]}`
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                           "[]",
+			"rbd-nbd list-mapped --format json":                      "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": partition,
+		},
+	}
+	runner.OnCall = func(line string) {
+		if line == wantMap {
+			runner.Replies["rbd showmapped --format json"] = `[{"id":"0","pool":"rbd","namespace":"",` +
+				`"name":"test1","snap":"daily","device":"/dev/rbd0"}]`
+		}
+	}
+	client = &RadosBlockDeviceClient{Runner: runner}
+
+	// nouuid passes the checks made before mapping, but not those against the ext4 filesystem found.
+	if err := client.MountSnapshot(spec, t.TempDir(), &MountOptions{NoUUID: true}); !errors.Is(err, validators.ErrInvalidMountOptions) {
+		t.Errorf("MountSnapshot() error = %v, want %v", err, validators.ErrInvalidMountOptions)
+	}
+
+	for _, want := range []string{wantMap, "rbd unmap /dev/rbd0"} {
+		if !runner.Called(want) {
+			t.Errorf("MountSnapshot() calls = %v, want %q", runner.Calls, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package rbd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// TestTracing tests that operations are traced below the span of the client context, with their
+// commands below them.
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	runner := &helperstest.Runner{Replies: map[string]string{
+		"rbd showmapped --format json":      showMappedKRBD,
+		"rbd-nbd list-mapped --format json": "[]",
+	}}
+
+	ctx, request := tracing.Start(context.Background(), "POST /v1/mappings")
+	client := (&RadosBlockDeviceClient{Runner: &tracing.Runner{Next: runner}}).WithContext(ctx)
+
+	if _, err := client.Map(ImageSpec{Pool: "rbd", Image: "test1"}); err != nil {
+		t.Fatalf("Map() error = %v", err)
+	}
+
+	request.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+
+		if span.SpanContext().TraceID() != request.SpanContext().TraceID() {
+			t.Errorf("span %q is not part of the trace of the request", span.Name())
+		}
+	}
+
+	parents := map[string]string{
+		"rbd.Map":              "POST /v1/mappings",
+		"rbd.ListMappedImages": "rbd.Map",
+		"rbd showmapped":       "rbd.ListMappedImages",
+		"rbd-nbd list-mapped":  "rbd.ListMappedImages",
+	}
+
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no span %q in %v", name, recorder.Ended())
+
+			continue
+		}
+
+		if span.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of %q", name, parent)
+		}
+	}
+
+	want := map[attribute.Key]string{tracing.PoolKey: "rbd", tracing.ImageKey: "test1", tracing.DeviceKey: "/dev/rbd0"}
+	got := map[attribute.Key]string{}
+
+	for _, kv := range spans["rbd.Map"].Attributes() {
+		got[kv.Key] = kv.Value.Emit()
+	}
+
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("rbd.Map attribute %s = %q, want %q", key, got[key], value)
+		}
+	}
+}
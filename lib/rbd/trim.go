@@ -0,0 +1,252 @@
+package rbd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+const trimTimeout = 30 * time.Minute
+
+// TrimReport is the result of Trim. TrimmedBytes is what fstrim reported as discarded, which
+// includes free space that was never written. UsedBefore and UsedAfter are the used size that
+// rbd du reported around the trim, and Reclaimed is the space the pool actually got back.
+type TrimReport struct {
+	Image        string     `json:"image"`
+	MountPoint   string     `json:"mountPoint"`
+	TrimmedBytes units.Size `json:"trimmedBytes"`
+	UsedBefore   units.Size `json:"usedBefore"`
+	UsedAfter    units.Size `json:"usedAfter"`
+	Reclaimed    units.Size `json:"reclaimed"`
+}
+
+// fstrimPattern matches the summary of fstrim --verbose:
+// /srv/test-1: 1.2 GiB (1288490188 bytes) trimmed on /dev/rbd0p1.
+var fstrimPattern = regexp.MustCompile(`\((\d+) bytes\) trimmed`)
+
+// Trim discards the unused blocks of the filesystem of a mounted image, so that the space of
+// deleted files is returned to the pool. Filesystems are created without discarding and mounted
+// without online discard, so this has to run periodically; see TrimScheduler.
+func (c *RadosBlockDeviceClient) Trim(spec ImageSpec) (_ *TrimReport, err error) {
+	c, span := c.startSpan("Trim", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
+
+	if err := spec.validateImage(); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Image", spec.String()).Msg("Trim")
+
+	_, mountPoint := c.mountedPartition(spec)
+	if mountPoint == "" {
+		return nil, fmt.Errorf("%w: %s", ErrNotMounted, spec.String())
+	}
+
+	report := &TrimReport{Image: spec.String(), MountPoint: mountPoint}
+	report.UsedBefore = c.usedSize(spec)
+
+	trimmed, err := c.executeFilesystemTrim(mountPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	report.TrimmedBytes = trimmed
+	report.UsedAfter = c.usedSize(spec)
+
+	if report.UsedBefore > report.UsedAfter {
+		report.Reclaimed = report.UsedBefore - report.UsedAfter
+	}
+
+	log.Info().Str("Image", report.Image).Str("Trimmed", report.TrimmedBytes.String()).
+		Str("Reclaimed", report.Reclaimed.String()).Msg("Trim")
+
+	return report, nil
+}
+
+// usedSize returns the used size of an image without its snapshots, or zero when rbd du fails.
+// A failure only affects the report, so it does not fail the trim.
+func (c *RadosBlockDeviceClient) usedSize(spec ImageSpec) units.Size {
+	usage, err := c.GetDiskUsage(spec)
+	if err != nil {
+		log.Warn().Str("Image", spec.String()).Str("Error", err.Error()).Msg("could not get disk usage")
+
+		return 0
+	}
+
+	for _, image := range usage.Images {
+		if image.Name == spec.Image && image.Snapshot == "" {
+			return image.UsedSize
+		}
+	}
+
+	return usage.TotalUsedSize
+}
+
+// executeFilesystemTrim runs fstrim on a mount point and returns the number of bytes trimmed.
+func (c *RadosBlockDeviceClient) executeFilesystemTrim(mountPoint string) (units.Size, error) {
+	log.Trace().Str("Path", mountPoint).Msg("executeFilesystemTrim")
+
+	stdOut, err := c.runWithTimeout(trimTimeout, "fstrim", "--verbose", mountPoint)
+	if err != nil {
+		return 0, fmt.Errorf("ERROR: fstrim failed: %w", err)
+	}
+
+	match := fstrimPattern.FindSubmatch(stdOut)
+	if match == nil {
+		return 0, nil
+	}
+
+	trimmed, err := strconv.ParseUint(string(match[1]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ERROR: fstrim output could not be parsed: %w\n%s", err, string(stdOut))
+	}
+
+	return units.Size(trimmed), nil
+}
+
+// TrimMounted trims the mounted images mapped by this tool, which are those holding the map options
+// recorded when mapping them. Images mapped by other means and snapshots, which are read-only, are
+// skipped. An image that fails is logged and skipped, and the reports of the others are returned.
+func (c *RadosBlockDeviceClient) TrimMounted() (_ []*TrimReport, err error) {
+	c, span := c.startSpan("TrimMounted")
+	defer func() { tracing.End(span, err) }()
+
+	log.Trace().Msg("TrimMounted")
+
+	list, err := c.ListMappedImages()
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []*TrimReport
+
+	for _, image := range *list {
+		if image.Snap != "" && image.Snap != "-" {
+			continue
+		}
+
+		spec := ImageSpec{Pool: image.Pool, Namespace: image.Namespace, Image: image.Name}
+
+		if _, managed := c.imageMetadata(spec)[mapOptionsMetadataKey]; !managed {
+			log.Debug().Str("Image", spec.String()).Msg("not trimming an image mapped by other means")
+
+			continue
+		}
+
+		info, err := c.executeListBlock(image.Device)
+		if err != nil {
+			log.Warn().Str("Image", spec.String()).Str("Error", err.Error()).Msg("could not list device")
+
+			continue
+		}
+
+		if _, mountPoint := mountedDevice(info); mountPoint == "" {
+			continue
+		}
+
+		report, err := c.Trim(spec)
+		if err != nil {
+			log.Error().Str("Image", spec.String()).Str("Error", err.Error()).Msg("trim failed")
+
+			continue
+		}
+
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+// TrimScheduler runs TrimMounted every Interval. A random delay of up to Jitter is added to each
+// interval, so that the hosts of a cluster do not all discard at the same time. OnTrim, if set,
+// receives the reports of every run. Reconfigure swaps the client of a running scheduler.
+type TrimScheduler struct {
+	Client   *RadosBlockDeviceClient
+	Interval time.Duration
+	Jitter   time.Duration
+	OnTrim   func(reports []*TrimReport)
+
+	mutex sync.RWMutex
+}
+
+// Reconfigure replaces the client used by later runs.
+func (s *TrimScheduler) Reconfigure(client *RadosBlockDeviceClient) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Client = client
+}
+
+func (s *TrimScheduler) client() *RadosBlockDeviceClient {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.Client
+}
+
+// Validate checks the interval and the jitter.
+func (s *TrimScheduler) Validate() error {
+	if s.client() == nil {
+		return fmt.Errorf("%w: no client given", validators.ErrInvalidTrimSchedule)
+	}
+
+	if s.Interval <= 0 {
+		return fmt.Errorf("%w: interval %s must be positive", validators.ErrInvalidTrimSchedule, s.Interval)
+	}
+
+	if s.Jitter < 0 {
+		return fmt.Errorf("%w: jitter %s must not be negative", validators.ErrInvalidTrimSchedule, s.Jitter)
+	}
+
+	return nil
+}
+
+// Run trims on the schedule until the context is done, and returns the error of the context.
+func (s *TrimScheduler) Run(ctx context.Context) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	log.Info().Str("Interval", s.Interval.String()).Str("Jitter", s.Jitter.String()).Msg("TrimScheduler")
+
+	for {
+		timer := time.NewTimer(s.nextDelay())
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err() //nolint:wrapcheck
+		case <-timer.C:
+		}
+
+		reports, err := s.client().TrimMounted()
+		if err != nil {
+			log.Error().Str("Error", err.Error()).Msg("scheduled trim failed")
+
+			continue
+		}
+
+		if s.OnTrim != nil {
+			s.OnTrim(reports)
+		}
+	}
+}
+
+// nextDelay returns the interval plus a random part of the jitter.
+func (s *TrimScheduler) nextDelay() time.Duration {
+	if s.Jitter <= 0 {
+		return s.Interval
+	}
+
+	return s.Interval + time.Duration(rand.Int63n(int64(s.Jitter))) //nolint:gosec
+}
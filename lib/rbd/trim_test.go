@@ -0,0 +1,122 @@
+package rbd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+const (
+	duBefore = `{"images":[{"name":"test1","id":"979ba5a95620ef","provisioned_size":10737418240,"used_size":3221225472}],"total_provisioned_size":10737418240,"total_used_size":3221225472}`
+	duAfter  = `{"images":[{"name":"test1","id":"979ba5a95620ef","provisioned_size":10737418240,"used_size":1073741824}],"total_provisioned_size":10737418240,"total_used_size":1073741824}`
+)
+
+// newTrimRunner returns a runner for a mounted image whose used size drops once fstrim ran.
+func newTrimRunner() *helperstest.Runner {
+	runner := &helperstest.Runner{
+		Replies: map[string]string{
+			"rbd showmapped --format json":                           showMappedKRBD,
+			"rbd-nbd list-mapped --format json":                      "[]",
+			"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkMounted,
+			"rbd du rbd/test1 --format json":                         duBefore,
+			"rbd image-meta list rbd/test1 --format json":            `{"scattered-storage.map-options":"lock_timeout=10"}`,
+			"fstrim --verbose /srv/test-1":                           "/srv/test-1: 7.8 GiB (8375238656 bytes) trimmed on /dev/rbd0p1\n",
+		},
+	}
+	runner.OnCall = func(line string) {
+		if line == "fstrim --verbose /srv/test-1" {
+			runner.Replies["rbd du rbd/test1 --format json"] = duAfter
+		}
+	}
+
+	return runner
+}
+
+// TestTrim tests the trimmed bytes and the space reclaimed according to rbd du.
+func TestTrim(t *testing.T) {
+	client := &RadosBlockDeviceClient{Runner: newTrimRunner()}
+
+	report, err := client.Trim(ImageSpec{Pool: "rbd", Image: "test1"})
+	if err != nil {
+		t.Fatalf("Trim() error = %v", err)
+	}
+
+	want := TrimReport{
+		Image:        "rbd/test1",
+		MountPoint:   "/srv/test-1",
+		TrimmedBytes: 8375238656,
+		UsedBefore:   3 * units.GiB,
+		UsedAfter:    units.GiB,
+		Reclaimed:    2 * units.GiB,
+	}
+	if *report != want {
+		t.Errorf("Trim() = %+v, want %+v", *report, want)
+	}
+
+	if _, err := client.Trim(ImageSpec{Pool: "rbd", Image: "test2"}); !errors.Is(err, ErrNotMounted) {
+		t.Errorf("Trim() unmapped image error = %v, want %v", err, ErrNotMounted)
+	}
+}
+
+// TestTrimMounted tests that only the mounted images mapped by this tool are trimmed.
+func TestTrimMounted(t *testing.T) {
+	runner := newTrimRunner()
+	runner.Replies["rbd showmapped --format json"] = `[` +
+		`{"id":"0","pool":"rbd","namespace":"","name":"test1","snap":"-","device":"/dev/rbd0"},` +
+		`{"id":"1","pool":"rbd","namespace":"","name":"other","snap":"-","device":"/dev/rbd1"},` +
+		`{"id":"2","pool":"rbd","namespace":"","name":"test1","snap":"daily","device":"/dev/rbd2"}]`
+	runner.Replies["rbd image-meta list rbd/other --format json"] = "{}"
+	runner.Replies["lsblk -J /dev/rbd1 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"] = `{"blockdevices":[{"name":"rbd1",` +
+		`"path":"/dev/rbd1","mountpoint":"/mnt/other","fstype":"xfs","type":"disk"}]}`
+
+	reports, err := (&RadosBlockDeviceClient{Runner: runner}).TrimMounted()
+	if err != nil {
+		t.Fatalf("TrimMounted() error = %v", err)
+	}
+
+	if len(reports) != 1 || reports[0].Image != "rbd/test1" {
+		t.Errorf("TrimMounted() = %+v, want only rbd/test1", reports)
+	}
+
+	for _, unwanted := range []string{"fstrim --verbose /mnt/other", "lsblk -J /dev/rbd2 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE"} {
+		if runner.Called(unwanted) {
+			t.Errorf("TrimMounted() ran %q", unwanted)
+		}
+	}
+}
+
+// TestTrimScheduler tests that the scheduler trims mounted images and stops with its context.
+func TestTrimScheduler(t *testing.T) {
+	if err := (&TrimScheduler{Client: &RadosBlockDeviceClient{}}).Validate(); !errors.Is(err, validators.ErrInvalidTrimSchedule) {
+		t.Errorf("Validate() without interval error = %v, want %v", err, validators.ErrInvalidTrimSchedule)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var got []*TrimReport
+
+	scheduler := &TrimScheduler{
+		Client:   &RadosBlockDeviceClient{Runner: newTrimRunner()},
+		Interval: time.Millisecond,
+		Jitter:   time.Millisecond,
+		OnTrim: func(reports []*TrimReport) {
+			got = reports
+
+			cancel()
+		},
+	}
+
+	if err := scheduler.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+
+	if len(got) != 1 || got[0].Image != "rbd/test1" || got[0].Reclaimed != 2*units.GiB {
+		t.Errorf("Run() reports = %+v", got)
+	}
+}
@@ -1,132 +1,105 @@
 package rbd
 
 import (
-	"bytes"
-	"context"
-	"errors"
 	"fmt"
 	"os"
-	"os/exec"
-	"time"
 
 	"github.com/rs/zerolog/log"
-	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
-// Unmount will execute the umount and unmap of a given RBD image.
-func (c *RadosBlockDeviceClient) Unmount(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
-	}
+// Unmount will execute the umount of a given RBD image and remove the entries persisting the mount.
+func (c *RadosBlockDeviceClient) Unmount(spec ImageSpec) (err error) {
+	c, span := c.startSpan("Unmount", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+	if err := spec.Validate(); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("Unmount")
+	log.Trace().Str("Image", spec.String()).Msg("Unmount")
 
-	unmountError := c.executeUnmount(pool, name)
+	unmountError := c.executeUnmount(spec)
 	if unmountError != nil {
 		return unmountError
 	}
 
-	return nil
+	return c.Unpersist(spec)
 }
 
-// executeUnmount runs the umount -A command against the partition a device has mounted returns nil error on success.
-func (c *RadosBlockDeviceClient) executeUnmount(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidPoolName
+// executeUnmount runs the umount -A command against the partition, or whole device, an image has mounted and closes the
+// dm-crypt mapping of host encrypted images. It returns nil error on success.
+func (c *RadosBlockDeviceClient) executeUnmount(spec ImageSpec) error {
+	if err := spec.Validate(); err != nil {
+		return err
 	}
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
-	}
+	log.Trace().Str("Image", spec.String()).Msg("executeUnmount")
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("executeUnmount")
+	device := c.findDevicePath(spec)
 
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	if device.Blockdevices != nil {
+		partition, _ := mountedDevice(device)
+		if partition == "" {
+			return c.closeEncryption(device)
+		}
 
-	device := c.findDevicePath(pool, name)
+		if _, err := c.run("umount", "-A", partition); err != nil {
+			if exitCode := helpers.ExitCode(err); exitCode != 1 {
+				log.Trace().Str("Error", err.Error()).Int(
+					"ExitCode", exitCode,
+				).Msg("umount non-zero/non-one exit code")
 
-	if device.Blockdevices != nil {
-		partition := device.Blockdevices[0].Children[0].Path
-		cmd := exec.CommandContext(ctx, "umount", "-A", partition)
-		log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
-
-		cmd.Stdout = &stdOut
-		cmd.Stderr = &stdErr
-
-		if err := cmd.Run(); err != nil {
-			var exitError *exec.ExitError
-			if ok := errors.Is(err, exitError); ok {
-				if exitError.ExitCode() != 1 || exitError.ExitCode() != 0 {
-					log.Trace().Str("Error", err.Error()).Int(
-						"ExitCode", exitError.ExitCode(),
-					).Msg("umount non-zero/non-one exit code")
-
-					return fmt.Errorf("ERROR: umount failed: %w", err)
-					// return fmt.Errorf("%w", err)
-				}
+				return fmt.Errorf("ERROR: umount failed: %w", err)
 			}
 		}
+
+		return c.closeEncryption(device)
 	}
 
 	return nil
 }
 
-// Unmap will find the device path for a given image and unmap it from the server.
-func (c *RadosBlockDeviceClient) Unmap(pool, name string) error {
-	if !ValidatePool(pool) {
-		return validators.ErrInvalidDevicePath
-	}
+// Unmap will find the device path for a given image, unmap it from the server and remove the
+// entries persisting its mount.
+func (c *RadosBlockDeviceClient) Unmap(spec ImageSpec) (err error) {
+	c, span := c.startSpan("Unmap", spec.attributes()...)
+	defer func() { tracing.End(span, err) }()
 
-	if !ValidateName(name) {
-		return validators.ErrInvalidRBDName
+	if err := spec.Validate(); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Pool", pool).Str("Name", name).Msg("Unmap")
+	log.Trace().Str("Image", spec.String()).Msg("Unmap")
 
-	deviceMountInfo := c.findDevicePath(pool, name)
+	deviceMountInfo := c.findDevicePath(spec)
 	if len(deviceMountInfo.Blockdevices) == 0 {
-		_, _ = fmt.Fprintf(os.Stderr, "no block device path found (for: %s), skipping umount", name)
-		return nil
+		_, _ = fmt.Fprintf(os.Stderr, "no block device path found (for: %s), skipping umount", spec.String())
+		return c.Unpersist(spec)
 	}
 	device := deviceMountInfo.Blockdevices[0].Path
 
+	if closeError := c.closeEncryption(deviceMountInfo); closeError != nil {
+		return closeError
+	}
+
 	if unmountError := c.executeUnmap(device); unmountError != nil {
 		return unmountError
 	}
 
-	return nil
+	return c.Unpersist(spec)
 }
 
-// executeUnmap runs the rbd unmap command and returns nil error on success.
+// executeUnmap runs the unmap command of the backend the device was mapped with and returns nil error on success.
 func (c *RadosBlockDeviceClient) executeUnmap(device string) error {
-	if !ValidateDevicePath(device) {
-		return validators.ErrInvalidDevicePath
+	if err := validators.ValidateDevicePath(device); err != nil {
+		return err
 	}
 
-	log.Trace().Str("Device", device).Msg("executeUnmap")
-
-	var stdOut, stdErr bytes.Buffer
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, "rbd", "unmap", device)
-	log.Trace().Str("Command", cmd.String()).Msg(language.InfoExecutingCommand)
+	backend := deviceBackend(device)
+	log.Trace().Str("Device", device).Str("Backend", string(backend)).Msg("executeUnmap")
 
-	cmd.Stdout = &stdOut
-	cmd.Stderr = &stdErr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ERROR: rbd unmap failed: %w", err)
-	}
-
-	return nil
+	return c.mapper(backend).unmapDevice(device)
 }
@@ -1,80 +1,41 @@
 package rbd
 
 import (
-	"github.com/rs/zerolog/log"
+	"fmt"
+	"math"
+
+	"github.com/scattered-network/scattered-storage/lib/units"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 	"github.com/spf13/cast"
 )
 
-func ValidateName(name string) bool {
-	nameExpression := "^[a-zA-Z0-9-_.]+$"
-	if nameCheck := validators.ValidateRegex(nameExpression); nameCheck != nil {
-		return validators.ValidateInput(nameCheck, name)
-	}
-
-	return false
-}
-
-func ValidatePool(pool string) bool {
-	poolExpression := "^[a-zA-Z0-9-_]+$"
-	if poolCheck := validators.ValidateRegex(poolExpression); poolCheck != nil {
-		return validators.ValidateInput(poolCheck, pool)
+// ValidateSize requires a size greater than 0 that fits the 64-bit signed sizes used by librbd.
+func ValidateSize(size units.Size) error {
+	if size == 0 || size > math.MaxInt64 {
+		return fmt.Errorf("%w: %s must be greater than 0 and at most %d bytes", validators.ErrInvalidSize,
+			size.String(), int64(math.MaxInt64))
 	}
 
-	return false
+	return nil
 }
 
-func ValidateSize(size int) bool {
-	if size == 0 { // size MUST be greater than 0
-		return false
+// ValidateMakeFilesystemOptions requires an xfs or ext4 filesystem type, when one is given, and the
+// noDiscard option.
+func ValidateMakeFilesystemOptions(fsOptions *MkfsOptions) error {
+	if fsOptions == nil {
+		return fmt.Errorf("%w: no options given", validators.ErrInvalidMakeOptions)
 	}
 
-	sizeExpression := "^[0-9]+"
-	if sizeCheck := validators.ValidateRegex(sizeExpression); sizeCheck != nil {
-		return validators.ValidateInput(sizeCheck, cast.ToString(size))
-	}
-
-	return false
-}
-
-func ValidateSuffix(suffix string) bool {
-	suffixExpression := "^[bBkKmMgGtTpP]$"
-	if suffixCheck := validators.ValidateRegex(suffixExpression); suffixCheck != nil {
-		return validators.ValidateInput(suffixCheck, suffix)
-	}
-
-	return false
-}
-
-func ValidateDevicePath(device string) bool {
-	deviceExpression := "^/dev/[[:alnum:]]+$"
-	if deviceCheck := validators.ValidateRegex(deviceExpression); deviceCheck != nil {
-		return validators.ValidateInput(deviceCheck, device)
-	}
-
-	return false
-}
-
-func ValidateMakeFilesystemOptions(fsOptions *MkfsOptions) bool {
-	if fsType, ok := fsOptions.Options["fsType"]; ok {
-		stringType := cast.ToString(fsType.Value)
-		switch stringType {
-		case "xfs":
-			break
-		case "ext4":
-			break
-		default:
-			log.Error().Str("Filesystem", stringType).Msg("Filesystem is not supported")
-
-			return false
+	if fsType, ok := fsOptions.Options[fsTypeKey]; ok {
+		if stringType := cast.ToString(fsType.Value); stringType != TagXfs && stringType != TagExt4 {
+			return fmt.Errorf("%w: filesystem %q must be %s or %s", validators.ErrInvalidMakeOptions, stringType,
+				TagXfs, TagExt4)
 		}
 	}
 
-	if noDiscard, ok := fsOptions.Options["noDiscard"]; !ok {
-		log.Error().Interface("Value", noDiscard.Value).Msg("invalid noDiscard option value")
-
-		return false
+	if _, ok := fsOptions.Options[noDiscardKey]; !ok {
+		return fmt.Errorf("%w: the %s option is required", validators.ErrInvalidMakeOptions, noDiscardKey)
 	}
 
-	return true
+	return nil
 }
@@ -0,0 +1,63 @@
+package rbd
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// TestValidateSize tests the bounds of image sizes.
+func TestValidateSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size units.Size
+		want error
+	}{
+		{name: "TestSize", size: 10 * units.GiB},
+		{name: "TestLargest", size: math.MaxInt64},
+		{name: "TestZero", size: 0, want: validators.ErrInvalidSize},
+		{name: "TestTooLarge", size: math.MaxInt64 + 1, want: validators.ErrInvalidSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateSize(tt.size); !errors.Is(err, tt.want) {
+				t.Errorf("ValidateSize(%d) = %v, want %v", tt.size, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestValidateMakeFilesystemOptions tests the filesystem types and required options of mkfs.
+func TestValidateMakeFilesystemOptions(t *testing.T) {
+	client := &RadosBlockDeviceClient{}
+
+	tests := []struct {
+		name    string
+		options *MkfsOptions
+		want    error
+	}{
+		{name: "TestXFSDefaults", options: client.getFilesystemOptionDefaults(TagXfs)},
+		{name: "TestExt4Defaults", options: client.getFilesystemOptionDefaults(TagExt4)},
+		{
+			name:    "TestUnsupportedFilesystem",
+			options: &MkfsOptions{Options: map[string]*MkfsOption{fsTypeKey: {Value: "btrfs"}, noDiscardKey: {Value: true}}},
+			want:    validators.ErrInvalidMakeOptions,
+		},
+		{
+			name:    "TestMissingNoDiscard",
+			options: &MkfsOptions{Options: map[string]*MkfsOption{fsTypeKey: {Value: TagXfs}}},
+			want:    validators.ErrInvalidMakeOptions,
+		},
+		{name: "TestNil", want: validators.ErrInvalidMakeOptions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMakeFilesystemOptions(tt.options); !errors.Is(err, tt.want) {
+				t.Errorf("ValidateMakeFilesystemOptions() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package rbd
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/rs/zerolog/log"
 	"github.com/scattered-network/scattered-storage/lib/validators"
 )
 
+var ErrDeviceNotEmpty = errors.New("device holds existing data")
+
 // WipeFS
 /* wipefs -J -n /dev/rbd0
 
@@ -33,10 +37,10 @@ type WipeFS struct {
 
 // hasPartitions returns a boolean value representing success in finding any partitions.
 func (c *RadosBlockDeviceClient) hasPartitions(device string) (bool, error) {
-	if !ValidateDevicePath(device) {
+	if err := validators.ValidateDevicePath(device); err != nil {
 		log.Trace().Str("Device", device).Msg("could not validate device path")
 
-		return false, fmt.Errorf("%w", validators.ErrInvalidDevicePath)
+		return false, err
 	}
 
 	log.Trace().Str("Device", device).Msg("hasPartitions")
@@ -47,7 +51,7 @@ func (c *RadosBlockDeviceClient) hasPartitions(device string) (bool, error) {
 		return false, listError
 	}
 
-	if len(deviceInfo.Blockdevices[0].Children) > 0 {
+	if len(deviceInfo.Blockdevices) > 0 && len(deviceInfo.Blockdevices[0].Children) > 0 {
 		log.Info().Msg("Partitions found")
 
 		return true, nil
@@ -58,7 +62,7 @@ func (c *RadosBlockDeviceClient) hasPartitions(device string) (bool, error) {
 
 // hasSupportedFileSystem returns a boolean value representing success in finding a supported signature.
 func (c *RadosBlockDeviceClient) hasSupportedFileSystem(device string) bool {
-	if !ValidateDevicePath(device) {
+	if err := validators.ValidateDevicePath(device); err != nil {
 		log.Trace().Str("Device", device).Msg("could not validate device path")
 
 		return false
@@ -66,7 +70,7 @@ func (c *RadosBlockDeviceClient) hasSupportedFileSystem(device string) bool {
 
 	log.Trace().Str("Device", device).Msg("hasSupportedFileSystem")
 
-	if deviceCheck, fsCheckError := c.executeWipeFSWithoutAction(device); fsCheckError != nil {
+	if deviceCheck, fsCheckError := c.executeWipeFSWithoutAction(device); fsCheckError == nil {
 		for _, signature := range deviceCheck.Signatures {
 			if c.isValidFilesystemType(signature.Type) {
 				return true
@@ -87,3 +91,30 @@ func (c *RadosBlockDeviceClient) isValidFilesystemType(fsType string) bool {
 
 	return false
 }
+
+// checkFormatSafe refuses to partition or format a device carrying any signature, such as a
+// filesystem, a partition table or a LUKS header. With force set, the signatures are erased instead.
+func (c *RadosBlockDeviceClient) checkFormatSafe(device string, force bool) error {
+	signatures, err := c.executeWipeFSWithoutAction(device)
+	if err != nil {
+		return err
+	}
+
+	if len(signatures.Signatures) == 0 {
+		return nil
+	}
+
+	types := make([]string, 0, len(signatures.Signatures))
+	for _, signature := range signatures.Signatures {
+		types = append(types, signature.Type)
+	}
+
+	if !force {
+		return fmt.Errorf("%w: %s carries %s signatures, set ForceFormat to erase them",
+			ErrDeviceNotEmpty, device, strings.Join(types, ", "))
+	}
+
+	log.Warn().Str("Device", device).Strs("Signatures", types).Msg("erasing signatures before formatting")
+
+	return c.executeWipeFSAll(device)
+}
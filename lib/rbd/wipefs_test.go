@@ -0,0 +1,113 @@
+package rbd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+)
+
+const (
+	mountPathPlaceholder = "<path>"
+	lsblkUnpartitioned   = `{"blockdevices":[{"name":"rbd0","path":"/dev/rbd0","type":"disk"}]}`
+	wipefsXFS            = `{"signatures":[{"device":"rbd0","offset":"0x0","type":"xfs","uuid":"976330da-8105-4514-b4c6-8914fcd8e6d3","label":null}]}`
+	wipefsLUKS           = `{"signatures":[{"device":"rbd0","offset":"0x0","type":"crypto_LUKS","uuid":"0b6a9c3e-1f0e-4b8a-9a43-6f7b1c2d3e4f","label":null}]}`
+)
+
+// TestMountDeviceFormatSafety tests that devices carrying signatures are mounted or refused
+// instead of being partitioned and formatted.
+func TestMountDeviceFormatSafety(t *testing.T) {
+	tests := []struct {
+		name        string
+		wipefs      string
+		options     *MountOptions
+		wantCalls   []string
+		unwantCalls []string
+		wantErr     error
+	}{
+		{
+			name:        "TestWholeDeviceFilesystem",
+			wipefs:      wipefsXFS,
+			wantCalls:   []string{"mount /dev/rbd0 " + mountPathPlaceholder},
+			unwantCalls: []string{"sgdisk", "mkfs.xfs"},
+		},
+		{
+			name:        "TestLUKSHeader",
+			wipefs:      wipefsLUKS,
+			unwantCalls: []string{"sgdisk", "wipefs --all", "mkfs.xfs"},
+			wantErr:     ErrDeviceNotEmpty,
+		},
+		{
+			name:      "TestLUKSHeaderForced",
+			wipefs:    wipefsLUKS,
+			options:   &MountOptions{ForceFormat: true},
+			wantCalls: []string{"wipefs --all /dev/rbd0", "sgdisk -o /dev/rbd0", "mkfs.xfs -b size=4096 -K /dev/rbd0p1"},
+		},
+		{
+			name:      "TestEmptyDevice",
+			wantCalls: []string{"sgdisk -o /dev/rbd0", "mkfs.xfs -b size=4096 -K /dev/rbd0p1", "mount /dev/rbd0p1 " + mountPathPlaceholder},
+		},
+		{
+			name:        "TestEmptyWholeDevice",
+			options:     &MountOptions{WholeDevice: true},
+			wantCalls:   []string{"mkfs.xfs -b size=4096 -K /dev/rbd0", "mount /dev/rbd0 " + mountPathPlaceholder},
+			unwantCalls: []string{"sgdisk"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir()
+			runner := &helperstest.Runner{Replies: map[string]string{
+				"lsblk -J /dev/rbd0 -o NAME,PATH,MOUNTPOINT,FSTYPE,TYPE": lsblkUnpartitioned,
+				"wipefs -J -n /dev/rbd0":                                 tt.wipefs,
+			}}
+			client := &RadosBlockDeviceClient{Runner: runner}
+
+			err := client.mountDevice("/dev/rbd0", path, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("mountDevice() error = %v, want %v", err, tt.wantErr)
+			}
+
+			for _, call := range tt.wantCalls {
+				call = strings.ReplaceAll(call, mountPathPlaceholder, path)
+				if !runner.Called(call) {
+					t.Errorf("mountDevice() did not run %q, ran %q", call, runner.Calls)
+				}
+			}
+
+			for _, call := range tt.unwantCalls {
+				for _, ran := range runner.Calls {
+					if strings.HasPrefix(ran, call) {
+						t.Errorf("mountDevice() ran %q", ran)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestPartitionEntireDisk tests that a device carrying signatures is only repartitioned when forced.
+func TestPartitionEntireDisk(t *testing.T) {
+	runner := &helperstest.Runner{Replies: map[string]string{"wipefs -J -n /dev/rbd0": wipefsXFS}}
+	client := &RadosBlockDeviceClient{Runner: runner}
+
+	if err := client.PartitionEntireDisk("/dev/rbd0", false); !errors.Is(err, ErrDeviceNotEmpty) {
+		t.Errorf("PartitionEntireDisk() error = %v, want %v", err, ErrDeviceNotEmpty)
+	}
+
+	if runner.Called("sgdisk -o /dev/rbd0") {
+		t.Errorf("PartitionEntireDisk() cleared the partition table, ran %q", runner.Calls)
+	}
+
+	if err := client.PartitionEntireDisk("/dev/rbd0", true); err != nil {
+		t.Fatalf("PartitionEntireDisk() forced error = %v", err)
+	}
+
+	for _, want := range []string{"wipefs --all /dev/rbd0", "sgdisk -o /dev/rbd0", "sgdisk --new 1::0 --typecode 1:8300 /dev/rbd0"} {
+		if !runner.Called(want) {
+			t.Errorf("PartitionEntireDisk() did not run %q, ran %q", want, runner.Calls)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package rgw
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// BucketStats
+/* radosgw-admin bucket stats --bucket tenant1-data
+
+{
+    "bucket": "tenant1-data",
+    "num_shards": 11,
+    "tenant": "",
+    "zonegroup": "2f1f2b6c-3c3b-4d0c-9b3e-3a1d0f3b8c11",
+    "placement_rule": "default-placement",
+    "id": "8c1a1d2e-51b4-4a55-9f57-2f5c27a5b5a1.24158.1",
+    "marker": "8c1a1d2e-51b4-4a55-9f57-2f5c27a5b5a1.24158.1",
+    "index_type": "Normal",
+    "owner": "tenant1",
+    "mtime": "2022-05-21T15:31:59.448295Z",
+    "creation_time": "2022-05-21T15:31:59.443176Z",
+    "usage": {
+        "rgw.main": {
+            "size": 1048576,
+            "size_actual": 1048576,
+            "size_utilized": 1048576,
+            "size_kb": 1024,
+            "size_kb_actual": 1024,
+            "size_kb_utilized": 1024,
+            "num_objects": 1
+        }
+    },
+    "bucket_quota": {
+        "enabled": false,
+        "check_on_raw": false,
+        "max_size": -1,
+        "max_size_kb": 0,
+        "max_objects": -1
+    }
+}
+
+BucketStats is used to report the usage of a bucket. */
+type BucketStats struct {
+	Bucket        string                  `json:"bucket"`
+	NumShards     int                     `json:"num_shards"` //nolint:tagliatelle
+	Tenant        string                  `json:"tenant"`
+	Zonegroup     string                  `json:"zonegroup"`
+	PlacementRule string                  `json:"placement_rule"` //nolint:tagliatelle
+	ID            string                  `json:"id"`
+	Marker        string                  `json:"marker"`
+	IndexType     string                  `json:"index_type"` //nolint:tagliatelle
+	Owner         string                  `json:"owner"`
+	Mtime         string                  `json:"mtime"`
+	CreationTime  string                  `json:"creation_time"` //nolint:tagliatelle
+	Usage         map[string]*BucketUsage `json:"usage"`
+	BucketQuota   *Quota                  `json:"bucket_quota"` //nolint:tagliatelle
+}
+
+type BucketUsage struct {
+	Size           int64 `json:"size"`
+	SizeActual     int64 `json:"size_actual"`      //nolint:tagliatelle
+	SizeUtilized   int64 `json:"size_utilized"`    //nolint:tagliatelle
+	SizeKb         int64 `json:"size_kb"`          //nolint:tagliatelle
+	SizeKbActual   int64 `json:"size_kb_actual"`   //nolint:tagliatelle
+	SizeKbUtilized int64 `json:"size_kb_utilized"` //nolint:tagliatelle
+	NumObjects     int64 `json:"num_objects"`      //nolint:tagliatelle
+}
+
+// BucketLimitCheck
+/* radosgw-admin bucket limit check --uid tenant1
+
+[
+    {
+        "user_id": "tenant1",
+        "buckets": [
+            {
+                "bucket": "tenant1-data",
+                "tenant": "",
+                "num_objects": 1,
+                "num_shards": 11,
+                "objects_per_shard": 0,
+                "fill_status": "OK"
+            }
+        ]
+    }
+]
+
+BucketLimitCheck reports how full the index shards of a user's buckets are. */
+type BucketLimitCheck struct {
+	UserID  string `json:"user_id"` //nolint:tagliatelle
+	Buckets []*struct {
+		Bucket          string `json:"bucket"`
+		Tenant          string `json:"tenant"`
+		NumObjects      int64  `json:"num_objects"`       //nolint:tagliatelle
+		NumShards       int    `json:"num_shards"`        //nolint:tagliatelle
+		ObjectsPerShard int64  `json:"objects_per_shard"` //nolint:tagliatelle
+		FillStatus      string `json:"fill_status"`       //nolint:tagliatelle
+	} `json:"buckets"`
+}
+
+// ListBuckets returns the buckets owned by uid, or every bucket when uid is empty.
+func (c *RadosGatewayAdminClient) ListBuckets(uid string) (_ helpers.List, err error) {
+	c, span := c.startSpan("ListBuckets", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	args := []string{"bucket", "list"}
+
+	if uid != "" {
+		if err := validators.ValidateRGWUserID(uid); err != nil {
+			return nil, err
+		}
+
+		args = append(args, "--uid", uid)
+	}
+
+	log.Trace().Str("UID", uid).Msg("ListBuckets")
+
+	var buckets helpers.List
+	if err := c.runJSON(&buckets, args...); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
+
+// GetBucketStats returns the usage and quota of a bucket.
+func (c *RadosGatewayAdminClient) GetBucketStats(bucket string) (_ *BucketStats, err error) {
+	c, span := c.startSpan("GetBucketStats", tracing.BucketKey.String(bucket))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateBucketName(bucket); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("Bucket", bucket).Msg("GetBucketStats")
+
+	stats := &BucketStats{}
+	if err := c.runJSON(stats, "bucket", "stats", "--bucket", bucket); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// CheckBucketLimits reports the index shard fill status of the buckets owned by uid, or of every user when uid is empty.
+func (c *RadosGatewayAdminClient) CheckBucketLimits(uid string) (_ []*BucketLimitCheck, err error) {
+	c, span := c.startSpan("CheckBucketLimits", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	args := []string{"bucket", "limit", "check"}
+
+	if uid != "" {
+		if err := validators.ValidateRGWUserID(uid); err != nil {
+			return nil, err
+		}
+
+		args = append(args, "--uid", uid)
+	}
+
+	log.Trace().Str("UID", uid).Msg("CheckBucketLimits")
+
+	var checks []*BucketLimitCheck
+	if err := c.runJSON(&checks, args...); err != nil {
+		return nil, err
+	}
+
+	return checks, nil
+}
@@ -0,0 +1,87 @@
+package rgw
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+	"github.com/spf13/cast"
+)
+
+const (
+	QuotaScopeUser   = "user"
+	QuotaScopeBucket = "bucket"
+)
+
+// Quota is embedded in User as both the user and the bucket quota. A negative limit is unlimited.
+type Quota struct {
+	Enabled    bool  `json:"enabled"`
+	CheckOnRaw bool  `json:"check_on_raw"` //nolint:tagliatelle
+	MaxSize    int64 `json:"max_size"`     //nolint:tagliatelle
+	MaxSizeKb  int64 `json:"max_size_kb"`  //nolint:tagliatelle
+	MaxObjects int64 `json:"max_objects"`  //nolint:tagliatelle
+}
+
+// SetQuota sets and enables the user or bucket scoped quota of a user.
+// A maxSize of 0 or a negative maxObjects leaves that limit unlimited.
+func (c *RadosGatewayAdminClient) SetQuota(uid, scope string, maxSize units.Size, maxObjects int64) (err error) {
+	c, span := c.startSpan("SetQuota", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return err
+	}
+
+	if err := ValidateQuotaScope(scope); err != nil {
+		return err
+	}
+
+	log.Trace().Str("UID", uid).Str("Scope", scope).Str("MaxSize", maxSize.String()).Int64("MaxObjects", maxObjects).
+		Msg("SetQuota")
+
+	sizeLimit := "-1"
+	if maxSize > 0 {
+		sizeLimit = cast.ToString(maxSize.Bytes())
+	}
+
+	if maxObjects < 0 {
+		maxObjects = -1
+	}
+
+	if _, err := c.run(
+		"quota", "set", "--quota-scope", scope, "--uid", uid,
+		"--max-size", sizeLimit, "--max-objects", cast.ToString(maxObjects),
+	); err != nil {
+		return fmt.Errorf("ERROR: radosgw-admin quota set failed: %w", err)
+	}
+
+	if _, err := c.run("quota", "enable", "--quota-scope", scope, "--uid", uid); err != nil {
+		return fmt.Errorf("ERROR: radosgw-admin quota enable failed: %w", err)
+	}
+
+	return nil
+}
+
+// DisableQuota disables the user or bucket scoped quota of a user without clearing its limits.
+func (c *RadosGatewayAdminClient) DisableQuota(uid, scope string) (err error) {
+	c, span := c.startSpan("DisableQuota", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return err
+	}
+
+	if err := ValidateQuotaScope(scope); err != nil {
+		return err
+	}
+
+	log.Trace().Str("UID", uid).Str("Scope", scope).Msg("DisableQuota")
+
+	if _, err := c.run("quota", "disable", "--quota-scope", scope, "--uid", uid); err != nil {
+		return fmt.Errorf("ERROR: radosgw-admin quota disable failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package rgw
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/language"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultCommandTimeout = 10 * time.Second
+
+// RadosGatewayAdminClient wraps the radosgw-admin command. The zero value runs commands on the
+// local host; set Runner to replay recorded output instead. Each public operation is traced below
+// the span of the context given to WithContext.
+type RadosGatewayAdminClient struct {
+	Runner helpers.Runner
+
+	ctx context.Context //nolint:containedctx
+}
+
+// WithContext returns a copy of the client whose operations and commands are traced below the span
+// of ctx, such as the span of the API request or gRPC call they serve. Only the span is kept: the
+// commands are limited by their own timeouts, not by the cancellation or deadline of ctx.
+func (c *RadosGatewayAdminClient) WithContext(ctx context.Context) *RadosGatewayAdminClient {
+	traced := *c
+	traced.ctx = tracing.SpanOnly(ctx)
+
+	return &traced
+}
+
+// context returns the context the commands of the client run with.
+func (c *RadosGatewayAdminClient) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+
+	return c.ctx
+}
+
+// startSpan starts the span of a public operation and returns a copy of the client whose commands,
+// and the operations it calls, are recorded below it.
+func (c *RadosGatewayAdminClient) startSpan(operation string, attributes ...attribute.KeyValue) (*RadosGatewayAdminClient, trace.Span) {
+	ctx, span := tracing.Start(c.context(), "rgw."+operation, attributes...)
+
+	return c.WithContext(ctx), span
+}
+
+// run executes radosgw-admin through the configured Runner using the default command timeout.
+func (c *RadosGatewayAdminClient) run(args ...string) ([]byte, error) {
+	runner := c.Runner
+	if runner == nil {
+		runner = helpers.DefaultRunner
+	}
+
+	ctx, cancel := context.WithTimeout(c.context(), defaultCommandTimeout)
+	defer cancel()
+
+	return runner.Run(ctx, "radosgw-admin", args...) //nolint:wrapcheck
+}
+
+// runJSON executes radosgw-admin and unmarshals its output into result.
+func (c *RadosGatewayAdminClient) runJSON(result interface{}, args ...string) error {
+	stdOut, err := c.run(args...)
+	if err != nil {
+		return fmt.Errorf("ERROR: radosgw-admin %s %s failed: %w", args[0], args[1], err)
+	}
+
+	if err := json.Unmarshal(stdOut, result); err != nil {
+		log.Error().Str("Response", string(stdOut)).Str("Error", err.Error()).Msg(language.ErrUnmarshalling)
+
+		return fmt.Errorf("%w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package rgw
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/units"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// fixtureRunner replays recorded radosgw-admin output from testdata, keyed by the first two arguments.
+type fixtureRunner struct {
+	fixtures map[string]string
+	calls    [][]string
+}
+
+func (r *fixtureRunner) Run(_ context.Context, command string, args ...string) ([]byte, error) {
+	r.calls = append(r.calls, append([]string{command}, args...))
+
+	fixture, ok := r.fixtures[strings.Join(args[:2], " ")]
+	if !ok {
+		return nil, nil
+	}
+
+	return os.ReadFile(filepath.Join("testdata", fixture)) //nolint:wrapcheck
+}
+
+func newFixtureClient() (*RadosGatewayAdminClient, *fixtureRunner) {
+	runner := &fixtureRunner{
+		fixtures: map[string]string{
+			"user info":    "user-info.json",
+			"user create":  "user-info.json",
+			"key create":   "key-create.json",
+			"bucket list":  "bucket-list.json",
+			"bucket stats": "bucket-stats.json",
+			"bucket limit": "bucket-limit-check.json",
+		},
+	}
+
+	return &RadosGatewayAdminClient{Runner: runner}, runner
+}
+
+// TestGetUserInfo tests the decoding of the user info fixture.
+func TestGetUserInfo(t *testing.T) {
+	client, runner := newFixtureClient()
+
+	user, err := client.GetUserInfo("tenant1")
+	if err != nil {
+		t.Fatalf("GetUserInfo() error = %v", err)
+	}
+
+	if user.UserID != "tenant1" || user.DisplayName != "Tenant One" {
+		t.Errorf("GetUserInfo() user = %s (%s)", user.UserID, user.DisplayName)
+	}
+
+	if len(user.Keys) != 1 || user.Keys[0].AccessKey != "0555b35654ad1656d804" {
+		t.Errorf("GetUserInfo() keys = %v", user.Keys)
+	}
+
+	if !user.UserQuota.Enabled || user.UserQuota.MaxSize != 107374182400 || user.BucketQuota.MaxObjects != -1 {
+		t.Errorf("GetUserInfo() quotas = %+v / %+v", user.UserQuota, user.BucketQuota)
+	}
+
+	want := []string{"radosgw-admin", "user", "info", "--uid", "tenant1"}
+	if !reflect.DeepEqual(runner.calls[0], want) {
+		t.Errorf("GetUserInfo() ran %v, want %v", runner.calls[0], want)
+	}
+}
+
+// TestCreateKey tests that a generated key is returned alongside the existing ones.
+func TestCreateKey(t *testing.T) {
+	client, _ := newFixtureClient()
+
+	user, err := client.CreateKey("tenant1")
+	if err != nil {
+		t.Fatalf("CreateKey() error = %v", err)
+	}
+
+	if len(user.Keys) != 2 || user.Keys[1].AccessKey != "KX9L2C7Q0ZB4N1F6M3JD" {
+		t.Errorf("CreateKey() keys = %v", user.Keys)
+	}
+}
+
+// TestSetQuota tests that a quota is both set and enabled.
+func TestSetQuota(t *testing.T) {
+	client, runner := newFixtureClient()
+
+	if err := client.SetQuota("tenant1", QuotaScopeBucket, 10*units.GiB, -5); err != nil {
+		t.Fatalf("SetQuota() error = %v", err)
+	}
+
+	want := [][]string{
+		{
+			"radosgw-admin", "quota", "set", "--quota-scope", "bucket", "--uid", "tenant1",
+			"--max-size", "10737418240", "--max-objects", "-1",
+		},
+		{"radosgw-admin", "quota", "enable", "--quota-scope", "bucket", "--uid", "tenant1"},
+	}
+	if !reflect.DeepEqual(runner.calls, want) {
+		t.Errorf("SetQuota() ran %v, want %v", runner.calls, want)
+	}
+}
+
+// TestBuckets tests the decoding of the bucket list, stats and limit check fixtures.
+func TestBuckets(t *testing.T) {
+	client, _ := newFixtureClient()
+
+	buckets, err := client.ListBuckets("tenant1")
+	if err != nil {
+		t.Fatalf("ListBuckets() error = %v", err)
+	}
+
+	if !reflect.DeepEqual(buckets, helpers.List{"tenant1-data", "tenant1-backups"}) {
+		t.Errorf("ListBuckets() = %v", buckets)
+	}
+
+	stats, err := client.GetBucketStats("tenant1-data")
+	if err != nil {
+		t.Fatalf("GetBucketStats() error = %v", err)
+	}
+
+	if stats.Owner != "tenant1" || stats.Usage["rgw.main"].NumObjects != 1 || stats.Usage["rgw.main"].Size != 1048576 {
+		t.Errorf("GetBucketStats() = %+v", stats)
+	}
+
+	checks, err := client.CheckBucketLimits("")
+	if err != nil {
+		t.Fatalf("CheckBucketLimits() error = %v", err)
+	}
+
+	if len(checks) != 1 || len(checks[0].Buckets) != 2 || checks[0].Buckets[1].FillStatus != "WARN 109%" {
+		t.Errorf("CheckBucketLimits() = %+v", checks)
+	}
+}
+
+// TestValidation tests that invalid input is rejected before radosgw-admin is run.
+func TestValidation(t *testing.T) {
+	client, runner := newFixtureClient()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{
+			name: "TestInvalidUserID",
+			call: func() error {
+				_, err := client.GetUserInfo("tenant 1")
+
+				return err
+			},
+			want: validators.ErrInvalidRGWUser,
+		},
+		{
+			name: "TestInvalidBucketName",
+			call: func() error {
+				_, err := client.GetBucketStats("Tenant_Data")
+
+				return err
+			},
+			want: validators.ErrInvalidBucketName,
+		},
+		{
+			name: "TestInvalidQuotaScope",
+			call: func() error {
+				return client.SetQuota("tenant1", "pool", 1, 1)
+			},
+			want: validators.ErrInvalidQuotaScope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.call(); !errors.Is(err, tt.want) {
+					t.Errorf("error = %v, want %v", err, tt.want)
+				}
+			},
+		)
+	}
+
+	if len(runner.calls) != 0 {
+		t.Errorf("radosgw-admin was run for invalid input: %v", runner.calls)
+	}
+}
@@ -0,0 +1,176 @@
+package rgw
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"github.com/scattered-network/scattered-storage/lib/tracing"
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// User
+/* radosgw-admin user info --uid tenant1
+
+{
+    "user_id": "tenant1",
+    "display_name": "Tenant One",
+    "email": "",
+    "suspended": 0,
+    "max_buckets": 1000,
+    "subusers": [],
+    "keys": [
+        {
+            "user": "tenant1",
+            "access_key": "0555b35654ad1656d804",
+            "secret_key": "h7GhxuBLTrlhVUyxSPUKUV8r/2EI4ngqJxD7iBdBYLhwluN30JaT3Q=="
+        }
+    ],
+    "swift_keys": [],
+    "caps": [],
+    "op_mask": "read, write, delete",
+    "default_placement": "",
+    "default_storage_class": "",
+    "placement_tags": [],
+    "bucket_quota": {
+        "enabled": false,
+        "check_on_raw": false,
+        "max_size": -1,
+        "max_size_kb": 0,
+        "max_objects": -1
+    },
+    "user_quota": {
+        "enabled": false,
+        "check_on_raw": false,
+        "max_size": -1,
+        "max_size_kb": 0,
+        "max_objects": -1
+    },
+    "temp_url_keys": [],
+    "type": "rgw",
+    "mfa_ids": []
+}
+
+User is returned by the user create, user info and key create commands. */
+type User struct {
+	UserID      string      `json:"user_id"`      //nolint:tagliatelle
+	DisplayName string      `json:"display_name"` //nolint:tagliatelle
+	Email       string      `json:"email"`
+	Suspended   int         `json:"suspended"`
+	MaxBuckets  int         `json:"max_buckets"` //nolint:tagliatelle
+	Subusers    []*Subuser  `json:"subusers"`
+	Keys        []*Key      `json:"keys"`
+	SwiftKeys   []*SwiftKey `json:"swift_keys"` //nolint:tagliatelle
+	Caps        []*Cap      `json:"caps"`
+	OpMask      string      `json:"op_mask"`      //nolint:tagliatelle
+	BucketQuota *Quota      `json:"bucket_quota"` //nolint:tagliatelle
+	UserQuota   *Quota      `json:"user_quota"`   //nolint:tagliatelle
+	Type        string      `json:"type"`
+}
+
+type Key struct {
+	User      string `json:"user"`
+	AccessKey string `json:"access_key"` //nolint:tagliatelle
+	SecretKey string `json:"secret_key"` //nolint:tagliatelle
+}
+
+type SwiftKey struct {
+	User      string `json:"user"`
+	SecretKey string `json:"secret_key"` //nolint:tagliatelle
+}
+
+type Subuser struct {
+	ID          string `json:"id"`
+	Permissions string `json:"permissions"`
+}
+
+type Cap struct {
+	Type string `json:"type"`
+	Perm string `json:"perm"`
+}
+
+// CreateUser creates a radosgw user. A new S3 key pair is generated along with the user.
+func (c *RadosGatewayAdminClient) CreateUser(uid, displayName, email string) (_ *User, err error) {
+	c, span := c.startSpan("CreateUser", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("UID", uid).Str("DisplayName", displayName).Msg("CreateUser")
+
+	args := []string{"user", "create", "--uid", uid, "--display-name", displayName}
+	if email != "" {
+		args = append(args, "--email", email)
+	}
+
+	user := &User{}
+	if err := c.runJSON(user, args...); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUserInfo returns the radosgw user, including its keys and quotas.
+func (c *RadosGatewayAdminClient) GetUserInfo(uid string) (_ *User, err error) {
+	c, span := c.startSpan("GetUserInfo", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("UID", uid).Msg("GetUserInfo")
+
+	user := &User{}
+	if err := c.runJSON(user, "user", "info", "--uid", uid); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// RemoveUser removes a radosgw user. With purgeData set, the buckets and objects of the user are removed as well.
+func (c *RadosGatewayAdminClient) RemoveUser(uid string, purgeData bool) (err error) {
+	c, span := c.startSpan("RemoveUser", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return err
+	}
+
+	log.Trace().Str("UID", uid).Bool("PurgeData", purgeData).Msg("RemoveUser")
+
+	args := []string{"user", "rm", "--uid", uid}
+	if purgeData {
+		args = append(args, "--purge-data")
+	}
+
+	if _, err := c.run(args...); err != nil {
+		return fmt.Errorf("ERROR: radosgw-admin user rm failed: %w", err)
+	}
+
+	return nil
+}
+
+// CreateKey generates an additional S3 key pair for the user and returns the updated user.
+func (c *RadosGatewayAdminClient) CreateKey(uid string) (_ *User, err error) {
+	c, span := c.startSpan("CreateKey", tracing.UserKey.String(uid))
+	defer func() { tracing.End(span, err) }()
+
+	if err := validators.ValidateRGWUserID(uid); err != nil {
+		return nil, err
+	}
+
+	log.Trace().Str("UID", uid).Msg("CreateKey")
+
+	user := &User{}
+	if err := c.runJSON(
+		user, "key", "create", "--uid", uid, "--key-type", "s3", "--gen-access-key", "--gen-secret",
+	); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
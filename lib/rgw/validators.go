@@ -0,0 +1,17 @@
+package rgw
+
+import (
+	"fmt"
+
+	"github.com/scattered-network/scattered-storage/lib/validators"
+)
+
+// ValidateQuotaScope accepts the 'user' and 'bucket' quota scopes.
+func ValidateQuotaScope(scope string) error {
+	switch scope {
+	case QuotaScopeUser, QuotaScopeBucket:
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q must be %q or %q", validators.ErrInvalidQuotaScope, scope, QuotaScopeUser, QuotaScopeBucket)
+}
@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+// Runner records a span for every command it runs through Next, below the span of the context the
+// command is run with. A nil Next runs commands on the local host.
+type Runner struct {
+	Next helpers.Runner
+}
+
+// Run executes the command in a span named after the binary and its subcommand, such as
+// 'rbd map', which holds the command line and its exit code.
+func (r *Runner) Run(ctx context.Context, command string, args ...string) ([]byte, error) {
+	next := r.Next
+	if next == nil {
+		next = helpers.DefaultRunner
+	}
+
+	name := strings.TrimSpace(command + " " + helpers.Subcommand(command, args))
+
+	ctx, span := Start(ctx, name,
+		semconv.ProcessExecutableName(command),
+		semconv.ProcessCommandArgs(append([]string{command}, args...)...),
+	)
+
+	stdOut, err := next.Run(ctx, command, args...)
+
+	span.SetAttributes(ExitCodeKey.Int(helpers.ExitCode(err)))
+	End(span, err)
+
+	return stdOut, err //nolint:wrapcheck
+}
@@ -0,0 +1,107 @@
+// Package tracing records OpenTelemetry spans for the operations of the clients and for every
+// command they run, so that a slow operation shows which of its steps took the time.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the spans recorded here.
+const instrumentationName = "github.com/scattered-network/scattered-storage"
+
+// Exporters of Setup.
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Attributes of the spans of operations.
+const (
+	PoolKey       = attribute.Key("rbd.pool")
+	NamespaceKey  = attribute.Key("rbd.namespace")
+	ImageKey      = attribute.Key("rbd.image")
+	SnapshotKey   = attribute.Key("rbd.snapshot")
+	DeviceKey     = attribute.Key("rbd.device")
+	MountPointKey = attribute.Key("rbd.mount_point")
+	FilesystemKey = attribute.Key("ceph.filesystem")
+	UserKey       = attribute.Key("rgw.user")
+	BucketKey     = attribute.Key("rgw.bucket")
+	ExitCodeKey   = attribute.Key("process.exit_code")
+)
+
+var ErrUnknownExporter = errors.New("trace exporter must be none, otlp or stdout")
+
+// Start starts a span below the span of ctx, if any, and returns the context holding it.
+func Start(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+// SpanOnly returns a context holding only the span of ctx. Commands started with it are traced below
+// that span, but keep running when ctx is canceled or reaches its deadline, so that a client going
+// away does not kill a command halfway through mapping or formatting an image.
+func SpanOnly(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx))
+}
+
+// End ends a span, marking it as failed with err unless err is nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Setup installs the tracer provider exporting the spans of service with an exporter, and the W3C
+// trace context propagator. The otlp exporter is configured with the standard
+// OTEL_EXPORTER_OTLP_* variables, such as OTEL_EXPORTER_OTLP_ENDPOINT; the stdout exporter writes
+// the spans to out as JSON. An empty exporter or none records nothing. The returned function
+// flushes the spans that have not been exported yet and stops exporting.
+func Setup(ctx context.Context, exporter, service, version string, out io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var (
+		spanExporter sdktrace.SpanExporter
+		err          error
+	)
+
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracegrpc.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(out), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExporter, exporter)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: trace exporter could not be created: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(service), semconv.ServiceVersion(version))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/scattered-network/scattered-storage/lib/helpers"
+	"github.com/scattered-network/scattered-storage/lib/helpers/helperstest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans records the spans ended during a test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	return recorder
+}
+
+// attributeValue returns the value of an attribute of a span as a string.
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value.Emit()
+		}
+	}
+
+	return ""
+}
+
+// TestSpanOnly tests that the span of a context is kept without its cancellation.
+func TestSpanOnly(t *testing.T) {
+	recordSpans(t)
+
+	ctx, span := Start(context.Background(), "api.request")
+	defer span.End()
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	detached := SpanOnly(ctx)
+	if detached.Err() != nil {
+		t.Errorf("SpanOnly() context error = %v, want <nil>", detached.Err())
+	}
+
+	if got := trace.SpanFromContext(detached).SpanContext(); !got.Equal(span.SpanContext()) {
+		t.Errorf("SpanOnly() span = %v, want %v", got, span.SpanContext())
+	}
+}
+
+// TestRunner tests the spans of commands and their parent.
+func TestRunner(t *testing.T) {
+	recorder := recordSpans(t)
+	runner := &Runner{Next: &helperstest.Runner{Errors: map[string]error{
+		"rbd unmap /dev/rbd0": &helpers.CommandError{Command: "rbd unmap /dev/rbd0", ExitStatus: 16},
+	}}}
+
+	ctx, parent := Start(context.Background(), "rbd.Unmount", PoolKey.String("rbd"))
+
+	_, _ = runner.Run(ctx, "umount", "/srv/test-1")
+	_, err := runner.Run(ctx, "rbd", "unmap", "/dev/rbd0")
+	End(parent, err)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(spans))
+	}
+
+	tests := []struct {
+		name     string
+		span     sdktrace.ReadOnlySpan
+		wantName string
+		exitCode string
+		status   codes.Code
+	}{
+		{name: "TestSuccess", span: spans[0], wantName: "umount", exitCode: "0", status: codes.Unset},
+		{name: "TestFailure", span: spans[1], wantName: "rbd unmap", exitCode: "16", status: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.span.Name() != tt.wantName {
+				t.Errorf("span name = %q, want %q", tt.span.Name(), tt.wantName)
+			}
+
+			if got := attributeValue(tt.span, ExitCodeKey); got != tt.exitCode {
+				t.Errorf("exit code = %s, want %s", got, tt.exitCode)
+			}
+
+			if tt.span.Status().Code != tt.status {
+				t.Errorf("status = %v, want %v", tt.span.Status().Code, tt.status)
+			}
+
+			if tt.span.Parent().SpanID() != spans[2].SpanContext().SpanID() {
+				t.Errorf("span %q is not a child of %q", tt.span.Name(), spans[2].Name())
+			}
+		})
+	}
+
+	if spans[2].Status().Code != codes.Error || attributeValue(spans[2], PoolKey) != "rbd" {
+		t.Errorf("parent span status = %v, pool = %q", spans[2].Status().Code, attributeValue(spans[2], PoolKey))
+	}
+}
+
+// TestSetup tests the exporters.
+func TestSetup(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	if _, err := Setup(context.Background(), "jaeger", "scattered-storage", "dev", nil); !errors.Is(err, ErrUnknownExporter) {
+		t.Errorf("Setup() error = %v, want %v", err, ErrUnknownExporter)
+	}
+
+	if _, err := Setup(context.Background(), ExporterNone, "scattered-storage", "dev", nil); err != nil {
+		t.Errorf("Setup() with none error = %v", err)
+	}
+
+	var out bytes.Buffer
+
+	shutdown, err := Setup(context.Background(), ExporterStdout, "scattered-storage", "dev", &out)
+	if err != nil {
+		t.Fatalf("Setup() with stdout error = %v", err)
+	}
+
+	_, span := Start(context.Background(), "rbd.Map", ImageKey.String("test1"))
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+
+	for _, want := range []string{`"Name": "rbd.Map"`, `"rbd.image"`, `"scattered-storage"`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("stdout exporter output does not hold %s:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package units
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Size is a number of bytes. Sizes are parsed from human input such as '10G', '512MiB',
+// '1.5TiB' or '100GB' and are formatted back for display with String.
+type Size uint64
+
+const (
+	Byte Size = 1
+
+	KiB = Byte << 10
+	MiB = KiB << 10
+	GiB = MiB << 10
+	TiB = GiB << 10
+	PiB = TiB << 10
+	EiB = PiB << 10
+
+	KB = Byte * 1000
+	MB = KB * 1000
+	GB = MB * 1000
+	TB = GB * 1000
+	PB = TB * 1000
+	EB = PB * 1000
+)
+
+var (
+	ErrInvalidSize = errors.New("invalid size")
+	ErrSizeTooBig  = errors.New("size is too big")
+)
+
+// iecUnits and siUnits are ordered from the largest unit to the smallest for formatting.
+var (
+	iecUnits = []struct { //nolint:gochecknoglobals
+		symbol string
+		size   Size
+	}{
+		{"EiB", EiB}, {"PiB", PiB}, {"TiB", TiB}, {"GiB", GiB}, {"MiB", MiB}, {"KiB", KiB},
+	}
+	siUnits = []struct { //nolint:gochecknoglobals
+		symbol string
+		size   Size
+	}{
+		{"EB", EB}, {"PB", PB}, {"TB", TB}, {"GB", GB}, {"MB", MB}, {"KB", KB},
+	}
+)
+
+// unitMultiplier returns the multiplier of a unit suffix.
+//
+// The suffix is read as follows:
+//   - none or 'B' means bytes
+//   - 'KiB', 'MiB', 'GiB', ... are binary (IEC) units
+//   - 'KB', 'MB', 'GB', ... are decimal (SI) units
+//   - a single letter 'K', 'M', 'G', ... is binary, matching the rbd and ceph commands
+func unitMultiplier(unit string) (Size, bool) {
+	if unit == "" || unit == "B" || unit == "b" {
+		return Byte, true
+	}
+
+	prefixes := map[string]int{"K": 1, "M": 2, "G": 3, "T": 4, "P": 5, "E": 6}
+
+	prefix, ok := prefixes[strings.ToUpper(unit[:1])]
+	if !ok {
+		return 0, false
+	}
+
+	switch strings.ToLower(unit[1:]) {
+	case "", "ib":
+		return Byte << (10 * prefix), true
+	case "b":
+		multiplier := Byte
+		for i := 0; i < prefix; i++ {
+			multiplier *= 1000
+		}
+
+		return multiplier, true
+	}
+
+	return 0, false
+}
+
+// ParseSize parses a size such as '10G', '512MiB', '1.5TiB', '100GB' or '4096'.
+// Fractions are allowed as long as the result is a whole number of bytes.
+func ParseSize(input string) (Size, error) {
+	trimmed := strings.TrimSpace(input)
+
+	split := strings.IndexFunc(trimmed, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(trimmed)
+	}
+
+	number, unit := trimmed[:split], strings.TrimSpace(trimmed[split:])
+	if number == "" || strings.HasPrefix(number, ".") || strings.HasSuffix(number, ".") {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidSize, input)
+	}
+
+	multiplier, ok := unitMultiplier(unit)
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown unit %q", ErrInvalidSize, unit)
+	}
+
+	value, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidSize, input)
+	}
+
+	value.Mul(value, new(big.Rat).SetUint64(uint64(multiplier)))
+
+	if !value.IsInt() {
+		return 0, fmt.Errorf("%w: %q is not a whole number of bytes", ErrInvalidSize, input)
+	}
+
+	if !value.Num().IsUint64() {
+		return 0, fmt.Errorf("%w: %q", ErrSizeTooBig, input)
+	}
+
+	return Size(value.Num().Uint64()), nil
+}
+
+// MustParseSize is ParseSize for constants and tests. It panics when the size cannot be parsed.
+func MustParseSize(input string) Size {
+	size, err := ParseSize(input)
+	if err != nil {
+		panic(err)
+	}
+
+	return size
+}
+
+// Bytes returns the size as a plain number of bytes.
+func (s Size) Bytes() uint64 {
+	return uint64(s)
+}
+
+// String formats the size using binary (IEC) units, e.g. '1.5 TiB'.
+func (s Size) String() string {
+	for _, unit := range iecUnits {
+		if s >= unit.size {
+			return formatUnit(s, unit.size, unit.symbol)
+		}
+	}
+
+	return strconv.FormatUint(uint64(s), 10) + " B"
+}
+
+// FormatSI formats the size using decimal (SI) units, e.g. '100 GB'.
+func (s Size) FormatSI() string {
+	for _, unit := range siUnits {
+		if s >= unit.size {
+			return formatUnit(s, unit.size, unit.symbol)
+		}
+	}
+
+	return strconv.FormatUint(uint64(s), 10) + " B"
+}
+
+// formatUnit renders size in the given unit with at most two decimals, dropping trailing zeros.
+func formatUnit(size, unit Size, symbol string) string {
+	value := new(big.Rat).SetFrac(new(big.Int).SetUint64(uint64(size)), new(big.Int).SetUint64(uint64(unit)))
+	formatted := strings.TrimRight(strings.TrimRight(value.FloatString(2), "0"), ".")
+
+	return formatted + " " + symbol
+}
+
+// IsAligned reports whether the size is a multiple of alignment, e.g. the object size of an image.
+func (s Size) IsAligned(alignment Size) bool {
+	if alignment == 0 {
+		return true
+	}
+
+	return s%alignment == 0
+}
+
+// AlignUp rounds the size up to the next multiple of alignment.
+func (s Size) AlignUp(alignment Size) Size {
+	if s.IsAligned(alignment) {
+		return s
+	}
+
+	return (s/alignment + 1) * alignment
+}
+
+// Set parses the size from a command line flag, so that Size can be used as a pflag.Value.
+func (s *Size) Set(value string) error {
+	size, err := ParseSize(value)
+	if err != nil {
+		return err
+	}
+
+	*s = size
+
+	return nil
+}
+
+// Type names the flag type for the pflag.Value interface.
+func (s *Size) Type() string {
+	return "size"
+}
+
+// UnmarshalJSON accepts either a number of bytes or a size string such as "10G".
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		return s.Set(text)
+	}
+
+	var bytes uint64
+	if err := json.Unmarshal(data, &bytes); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSize, string(data))
+	}
+
+	*s = Size(bytes)
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package units
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// TestParseSize tests the ParseSize function.
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Size
+		wantErr error
+	}{
+		{name: "TestBytes", input: "4096", want: 4096},
+		{name: "TestByteSuffix", input: "512B", want: 512},
+		{name: "TestSingleLetterIsIEC", input: "10G", want: 10 * GiB},
+		{name: "TestLowerCaseSingleLetter", input: "10g", want: 10 * GiB},
+		{name: "TestIEC", input: "512MiB", want: 512 * MiB},
+		{name: "TestFractionalIEC", input: "1.5TiB", want: TiB + TiB/2},
+		{name: "TestSI", input: "100GB", want: 100 * GB},
+		{name: "TestFractionalSI", input: "0.1GB", want: 100 * MB},
+		{name: "TestSpaceBeforeUnit", input: " 2 KiB ", want: 2 * KiB},
+		{name: "TestLargest", input: "15EiB", want: 15 * EiB},
+		{name: "TestEmpty", input: "", wantErr: ErrInvalidSize},
+		{name: "TestUnknownUnit", input: "10X", wantErr: ErrInvalidSize},
+		{name: "TestUnknownSuffix", input: "10Gb/s", wantErr: ErrInvalidSize},
+		{name: "TestNegative", input: "-1G", wantErr: ErrInvalidSize},
+		{name: "TestPartialByte", input: "0.5B", wantErr: ErrInvalidSize},
+		{name: "TestTrailingDot", input: "1.G", wantErr: ErrInvalidSize},
+		{name: "TestTooBig", input: "16EiB", wantErr: ErrSizeTooBig},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				got, err := ParseSize(tt.input)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ParseSize(%q) error = %v, want %v", tt.input, err, tt.wantErr)
+				}
+
+				if got != tt.want {
+					t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+				}
+			},
+		)
+	}
+}
+
+// TestFormat tests the String and FormatSI functions.
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    Size
+		wantIEC string
+		wantSI  string
+	}{
+		{name: "TestZero", size: 0, wantIEC: "0 B", wantSI: "0 B"},
+		{name: "TestBytes", size: 512, wantIEC: "512 B", wantSI: "512 B"},
+		{name: "TestWholeUnit", size: 10 * GiB, wantIEC: "10 GiB", wantSI: "10.74 GB"},
+		{name: "TestFraction", size: TiB + TiB/2, wantIEC: "1.5 TiB", wantSI: "1.65 TB"},
+		{name: "TestSI", size: 100 * GB, wantIEC: "93.13 GiB", wantSI: "100 GB"},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if got := tt.size.String(); got != tt.wantIEC {
+					t.Errorf("String() = %s, want %s", got, tt.wantIEC)
+				}
+
+				if got := tt.size.FormatSI(); got != tt.wantSI {
+					t.Errorf("FormatSI() = %s, want %s", got, tt.wantSI)
+				}
+			},
+		)
+	}
+}
+
+// TestAlignment tests the IsAligned and AlignUp functions against the default 4MiB object size.
+func TestAlignment(t *testing.T) {
+	objectSize := 4 * MiB
+
+	if !(10 * GiB).IsAligned(objectSize) {
+		t.Error("IsAligned() = false for 10GiB")
+	}
+
+	if (100 * GB).IsAligned(objectSize) {
+		t.Error("IsAligned() = true for 100GB")
+	}
+
+	if got := (100 * GB).AlignUp(objectSize); got != 23842*objectSize {
+		t.Errorf("AlignUp() = %d, want %d", got, 23842*objectSize)
+	}
+
+	if got := (8 * MiB).AlignUp(objectSize); got != 8*MiB {
+		t.Errorf("AlignUp() = %d, want %d", got, 8*MiB)
+	}
+}
+
+// TestUnmarshalJSON tests that sizes can be given as numbers or strings.
+func TestUnmarshalJSON(t *testing.T) {
+	var request struct {
+		Numeric Size `json:"numeric"`
+		Text    Size `json:"text"`
+	}
+
+	if err := json.Unmarshal([]byte(`{"numeric": 1048576, "text": "1.5GiB"}`), &request); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if request.Numeric != MiB || request.Text != GiB+GiB/2 {
+		t.Errorf("Unmarshal() = %+v", request)
+	}
+
+	if err := json.Unmarshal([]byte(`{"text": "ten gigs"}`), &request); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("Unmarshal() error = %v, want %v", err, ErrInvalidSize)
+	}
+}
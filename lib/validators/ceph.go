@@ -0,0 +1,120 @@
+package validators
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// mapperDirectory holds the device mapper devices, such as dm-crypt mappings.
+const mapperDirectory = "/dev/mapper/"
+
+// The rbd image-spec 'pool/namespace/image@snapshot' reserves '/' and '@', so none of its parts may
+// contain them. Names are further limited to the characters Ceph tooling handles without quoting.
+var (
+	poolNameExpression     = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+	namespaceExpression    = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+	imageNameExpression    = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+	snapshotNameExpression = regexp.MustCompile(`^[A-Za-z0-9_.:+-]{1,255}$`)
+	cephxUserExpression    = regexp.MustCompile(`^(client\.)?[A-Za-z0-9_.-]{1,255}$`)
+	kernelDeviceExpression = regexp.MustCompile(`^/dev/(rbd|nbd)[0-9]+(p[0-9]+)?$`)
+	mapperDeviceExpression = regexp.MustCompile(`^/dev/mapper/[A-Za-z0-9_.+-]{1,127}$`)
+	udevDeviceExpression   = regexp.MustCompile(`^/dev/rbd/([^/]+)/(?:([^/]+)/)?([^/@]+?)(?:@([^/@]+?))?(?:-part([0-9]+))?$`)
+)
+
+// matchName checks a name against expression and rejects the '.' and '..' path components.
+func matchName(expression *regexp.Regexp, sentinel error, kind, input, rule string) error {
+	if input == "." || input == ".." || !expression.MatchString(input) {
+		return fmt.Errorf("%w: %s %q must be %s", sentinel, kind, input, rule)
+	}
+
+	return nil
+}
+
+// ValidatePoolName checks a RADOS pool name, e.g. 'rbd' or '.rgw.root'.
+func ValidatePoolName(pool string) error {
+	return matchName(poolNameExpression, ErrInvalidPoolName, "pool", pool,
+		"1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateNamespaceName checks an rbd namespace. The empty string selects the default namespace.
+func ValidateNamespaceName(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+
+	return matchName(namespaceExpression, ErrInvalidNamespace, "namespace", namespace,
+		"empty or 1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateImageName checks an rbd image name.
+func ValidateImageName(image string) error {
+	return matchName(imageNameExpression, ErrInvalidRBDName, "image", image,
+		"1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateSnapshotName checks an rbd snapshot name, which may also contain ':' and '+' for timestamps.
+func ValidateSnapshotName(snapshot string) error {
+	return matchName(snapshotNameExpression, ErrInvalidSnapshotName, "snapshot", snapshot,
+		"1 to 255 letters, digits, '.', '_', ':', '+' or '-'")
+}
+
+// ValidateCephxUser checks a cephx client, given either as 'client.name' or as the bare 'name'.
+func ValidateCephxUser(user string) error {
+	if !cephxUserExpression.MatchString(user) || strings.HasSuffix(user, "client.") {
+		return fmt.Errorf("%w: %q must be 'client.<id>' or '<id>' of letters, digits, '.', '_' or '-'",
+			ErrInvalidCephxUser, user)
+	}
+
+	return nil
+}
+
+// ValidateDevicePath checks a mapped image device: a krbd '/dev/rbdN' or nbd '/dev/nbdN' device
+// with an optional 'pN' partition suffix, or a '/dev/rbd/pool[/namespace]/image[@snapshot][-partN]'
+// udev link. Device mapper devices layered on top of an image, such as dm-crypt mappings, are
+// accepted as '/dev/mapper/name'.
+func ValidateDevicePath(device string) error {
+	if kernelDeviceExpression.MatchString(device) {
+		return nil
+	}
+
+	if mapperDeviceExpression.MatchString(device) {
+		if strings.Trim(strings.TrimPrefix(device, mapperDirectory), ".") == "" {
+			return fmt.Errorf("%w: %q must name a device mapper device", ErrInvalidDevicePath, device)
+		}
+
+		return nil
+	}
+
+	if parts := udevDeviceExpression.FindStringSubmatch(device); parts != nil {
+		if err := validateDeviceLink(parts[1], parts[2], parts[3], parts[4]); err != nil {
+			return fmt.Errorf("%w: %q: %s", ErrInvalidDevicePath, device, err.Error())
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: %q must be /dev/rbdN, /dev/nbdN (with an optional pN suffix) "+
+		"or /dev/rbd/<pool>/[<namespace>/]<image>[@<snapshot>][-partN]", ErrInvalidDevicePath, device)
+}
+
+// validateDeviceLink checks the parts of a udev device link.
+func validateDeviceLink(pool, namespace, image, snapshot string) error {
+	if err := ValidatePoolName(pool); err != nil {
+		return err
+	}
+
+	if err := ValidateNamespaceName(namespace); err != nil {
+		return err
+	}
+
+	if err := ValidateImageName(image); err != nil {
+		return err
+	}
+
+	if snapshot != "" {
+		return ValidateSnapshotName(snapshot)
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package validators
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCephValidators tests the pool, namespace, image, snapshot, cephx user and device path validators.
+func TestCephValidators(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) error
+		input    string
+		want     error
+	}{
+		{name: "TestPool", validate: ValidatePoolName, input: "rbd-ssd"},
+		{name: "TestPoolWithDots", validate: ValidatePoolName, input: ".rgw.root"},
+		{name: "TestPoolWithUnderscore", validate: ValidatePoolName, input: "device_health_metrics"},
+		{name: "TestPoolEmpty", validate: ValidatePoolName, input: "", want: ErrInvalidPoolName},
+		{name: "TestPoolDotDot", validate: ValidatePoolName, input: "..", want: ErrInvalidPoolName},
+		{name: "TestPoolWithSlash", validate: ValidatePoolName, input: "rbd/ns", want: ErrInvalidPoolName},
+		{name: "TestPoolTooLong", validate: ValidatePoolName, input: strings.Repeat("p", 256), want: ErrInvalidPoolName},
+		{name: "TestNamespaceDefault", validate: ValidateNamespaceName, input: ""},
+		{name: "TestNamespace", validate: ValidateNamespaceName, input: "tenant-1"},
+		{name: "TestNamespaceWithAt", validate: ValidateNamespaceName, input: "tenant@1", want: ErrInvalidNamespace},
+		{name: "TestImage", validate: ValidateImageName, input: "test-image.v2"},
+		{name: "TestImageEmpty", validate: ValidateImageName, input: "", want: ErrInvalidRBDName},
+		{name: "TestImageWithSpace", validate: ValidateImageName, input: "test image", want: ErrInvalidRBDName},
+		{name: "TestImageWithAt", validate: ValidateImageName, input: "image@snap", want: ErrInvalidRBDName},
+		{name: "TestSnapshot", validate: ValidateSnapshotName, input: "daily"},
+		{name: "TestSnapshotTimestamp", validate: ValidateSnapshotName, input: "2022-05-21T15:31:59+00:00"},
+		{name: "TestSnapshotWithSlash", validate: ValidateSnapshotName, input: "daily/1", want: ErrInvalidSnapshotName},
+		{name: "TestCephxClient", validate: ValidateCephxUser, input: "client.docker"},
+		{name: "TestCephxBareID", validate: ValidateCephxUser, input: "admin"},
+		{name: "TestCephxEmptyID", validate: ValidateCephxUser, input: "client.", want: ErrInvalidCephxUser},
+		{name: "TestCephxOSD", validate: ValidateCephxUser, input: "osd 0", want: ErrInvalidCephxUser},
+		{name: "TestDeviceKRBD", validate: ValidateDevicePath, input: "/dev/rbd0"},
+		{name: "TestDeviceKRBDPartition", validate: ValidateDevicePath, input: "/dev/rbd12p1"},
+		{name: "TestDeviceNBD", validate: ValidateDevicePath, input: "/dev/nbd0"},
+		{name: "TestDeviceNBDPartition", validate: ValidateDevicePath, input: "/dev/nbd3p2"},
+		{name: "TestDeviceUdevLink", validate: ValidateDevicePath, input: "/dev/rbd/rbd/test-image"},
+		{name: "TestDeviceUdevNamespace", validate: ValidateDevicePath, input: "/dev/rbd/rbd/tenant1/test-image"},
+		{name: "TestDeviceUdevSnapshot", validate: ValidateDevicePath, input: "/dev/rbd/rbd/test-image@daily"},
+		{name: "TestDeviceUdevPartition", validate: ValidateDevicePath, input: "/dev/rbd/rbd/test-image-part1"},
+		{name: "TestDeviceMapper", validate: ValidateDevicePath, input: "/dev/mapper/rbd-rbd--1--test-image"},
+		{name: "TestDeviceMapperDot", validate: ValidateDevicePath, input: "/dev/mapper/.", want: ErrInvalidDevicePath},
+		{name: "TestDeviceMapperDotDot", validate: ValidateDevicePath, input: "/dev/mapper/..", want: ErrInvalidDevicePath},
+		{name: "TestDeviceMapperDots", validate: ValidateDevicePath, input: "/dev/mapper/...", want: ErrInvalidDevicePath},
+		{name: "TestDeviceMapperTraversal", validate: ValidateDevicePath, input: "/dev/mapper/../sda", want: ErrInvalidDevicePath},
+		{name: "TestSELinuxContext", validate: ValidateSELinuxContext, input: "system_u:object_r:container_file_t:s0:c1,c2"},
+		{name: "TestSELinuxContextQuote", validate: ValidateSELinuxContext, input: `system_u:object_r:t:s0",rw`, want: ErrInvalidSELinuxContext},
+		{name: "TestDeviceUdevBadPool", validate: ValidateDevicePath, input: "/dev/rbd/r b/image", want: ErrInvalidDevicePath},
+		{name: "TestDeviceUdevTooDeep", validate: ValidateDevicePath, input: "/dev/rbd/a/b/c/d", want: ErrInvalidDevicePath},
+		{name: "TestDeviceDisk", validate: ValidateDevicePath, input: "/dev/sda", want: ErrInvalidDevicePath},
+		{name: "TestDevicePartitionLetter", validate: ValidateDevicePath, input: "/dev/rbd0px", want: ErrInvalidDevicePath},
+		{name: "TestDeviceTraversal", validate: ValidateDevicePath, input: "/dev/rbd0/../sda", want: ErrInvalidDevicePath},
+		{name: "TestDeviceRelative", validate: ValidateDevicePath, input: "rbd0", want: ErrInvalidDevicePath},
+	}
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				if err := tt.validate(tt.input); !errors.Is(err, tt.want) {
+					t.Errorf("validate(%q) = %v, want %v", tt.input, err, tt.want)
+				}
+			},
+		)
+	}
+}
+
+// TestValidateMountPathBelow tests that mount paths must lie below the mount root, also through symlinks.
+func TestValidateMountPathBelow(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Symlink("/etc", filepath.Join(root, "etc")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		root string
+		want error
+	}{
+		{name: "TestBelow", path: filepath.Join(root, "volume-1"), root: root},
+		{name: "TestNested", path: filepath.Join(root, "tenant1", "volume-1"), root: root},
+		{name: "TestRoot", path: root, root: root, want: ErrInvalidMountPath},
+		{name: "TestOutside", path: "/etc/volume-1", root: root, want: ErrInvalidMountPath},
+		{name: "TestSiblingPrefix", path: root + "-other/volume-1", root: root, want: ErrInvalidMountPath},
+		{name: "TestTraversal", path: root + "/../volume-1", root: root, want: ErrInvalidMountPath},
+		{name: "TestSymlink", path: filepath.Join(root, "etc", "volume-1"), root: root, want: ErrInvalidMountPath},
+		{name: "TestRelative", path: "volume-1", root: root, want: ErrInvalidMountPath},
+		{name: "TestEmptyRoot", path: filepath.Join(root, "volume-1"), want: ErrInvalidMountPath},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateMountPathBelow(tt.path, tt.root); !errors.Is(err, tt.want) {
+				t.Errorf("ValidateMountPathBelow(%q, %q) = %v, want %v", tt.path, tt.root, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package validators
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	filesystemNameExpression = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+	subvolumeNameExpression  = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,255}$`)
+)
+
+// ValidateFilesystemName checks a CephFS filesystem (volume) name.
+func ValidateFilesystemName(filesystem string) error {
+	return matchName(filesystemNameExpression, ErrInvalidFilesystemName, "filesystem", filesystem,
+		"1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateSubvolumeName checks a CephFS subvolume name.
+func ValidateSubvolumeName(name string) error {
+	return matchName(subvolumeNameExpression, ErrInvalidSubvolumeName, "subvolume", name,
+		"1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateSubvolumeGroupName checks a CephFS subvolume group. The empty string selects the default '_nogroup' group.
+func ValidateSubvolumeGroupName(group string) error {
+	if group == "" {
+		return nil
+	}
+
+	return matchName(subvolumeNameExpression, ErrInvalidSubvolumeGroupName, "subvolume group", group,
+		"empty or 1 to 255 letters, digits, '.', '_' or '-'")
+}
+
+// ValidateMountPath requires a clean absolute path other than the root directory.
+func ValidateMountPath(path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path || path == "/" {
+		return fmt.Errorf("%w: %q must be a clean absolute path other than '/'", ErrInvalidMountPath, path)
+	}
+
+	return nil
+}
+
+// ValidateMountPathBelow requires a mount path that ValidateMountPath accepts and that lies below
+// root, so that callers of a remote API cannot mount over the directories of the host. Symlinks in
+// the existing part of either path are resolved before they are compared.
+func ValidateMountPathBelow(path, root string) error {
+	if err := ValidateMountPath(path); err != nil {
+		return err
+	}
+
+	if err := ValidateMountPath(root); err != nil {
+		return fmt.Errorf("%w: mount root %q must be a clean absolute path other than '/'", ErrInvalidMountPath, root)
+	}
+
+	relative, err := filepath.Rel(resolveSymlinks(root), resolveSymlinks(path))
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, "../") {
+		return fmt.Errorf("%w: %q is not below the mount root %s", ErrInvalidMountPath, path, root)
+	}
+
+	return nil
+}
+
+// resolveSymlinks resolves the symlinks of the longest existing parent of an absolute path.
+func resolveSymlinks(path string) string {
+	missing := ""
+
+	for current := path; current != filepath.Dir(current); current = filepath.Dir(current) {
+		if resolved, err := filepath.EvalSymlinks(current); err == nil {
+			return filepath.Join(resolved, missing)
+		}
+
+		missing = filepath.Join(filepath.Base(current), missing)
+	}
+
+	return path
+}
+
+// ValidateSecretFile requires a clean absolute path to a cephx secret file. Commas are refused as
+// the path is passed within the comma-separated options of mount.ceph.
+func ValidateSecretFile(path string) error {
+	if err := ValidateMountPath(path); err != nil || strings.Contains(path, ",") {
+		return fmt.Errorf("%w: %q must be a clean absolute path without ','", ErrInvalidSecretFile, path)
+	}
+
+	return nil
+}
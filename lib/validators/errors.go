@@ -5,13 +5,33 @@ import "errors"
 var (
 	ErrRBDExists                   = errors.New("rbd already exists")
 	ErrInvalidRegex                = errors.New("invalid regex")
+	ErrSnapshotExists              = errors.New("rbd snapshot already exists")
 	ErrInvalidRBDName              = errors.New("invalid rbd name")
 	ErrInvalidPoolName             = errors.New("invalid pool name")
+	ErrInvalidNamespace            = errors.New("invalid rbd namespace")
+	ErrInvalidSnapshotName         = errors.New("invalid rbd snapshot name")
+	ErrInvalidImageSpec            = errors.New("invalid rbd image spec")
+	ErrSnapshotNotSupported        = errors.New("operation does not accept an rbd snapshot")
 	ErrInvalidSize                 = errors.New("invalid rbd size")
-	ErrInvalidSuffix               = errors.New("invalid rbd size suffix")
 	ErrInvalidDevicePath           = errors.New("invalid device path")
+	ErrInvalidMapBackend           = errors.New("invalid map backend")
+	ErrInvalidMapOption            = errors.New("invalid map option")
+	ErrInvalidEncryptionOptions    = errors.New("invalid encryption options")
+	ErrInvalidMountOptions         = errors.New("invalid mount options")
+	ErrInvalidSELinuxContext       = errors.New("invalid selinux context")
 	ErrInvalidMakeOptions          = errors.New("invalid make options")
+	ErrInvalidTrimSchedule         = errors.New("invalid trim schedule")
 	ErrNotTaggedForRBD             = errors.New("pool does not have the 'rbd' application tag")
 	ErrNotTaggedForRGW             = errors.New("pool does not have the 'rgw' application tag")
 	ErrNotTaggedForMgrDevicehealth = errors.New("pool does not have the 'mgr_devicehealth' application tag")
+	ErrInvalidFilesystemName       = errors.New("invalid cephfs filesystem name")
+	ErrInvalidSubvolumeName        = errors.New("invalid cephfs subvolume name")
+	ErrInvalidSubvolumeGroupName   = errors.New("invalid cephfs subvolume group name")
+	ErrInvalidCephxUser            = errors.New("invalid cephx user")
+	ErrInvalidMountPath            = errors.New("invalid mount path")
+	ErrInvalidSecretFile           = errors.New("invalid cephx secret file")
+	ErrInvalidQuota                = errors.New("invalid quota")
+	ErrInvalidRGWUser              = errors.New("invalid rgw user id")
+	ErrInvalidBucketName           = errors.New("invalid bucket name")
+	ErrInvalidQuotaScope           = errors.New("invalid quota scope")
 )
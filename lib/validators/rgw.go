@@ -0,0 +1,32 @@
+package validators
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	rgwUserExpression    = regexp.MustCompile(`^([A-Za-z0-9_-]+\$)?[A-Za-z0-9_.@-]+$`)
+	bucketNameExpression = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	ipAddressExpression  = regexp.MustCompile(`^[0-9]+\.[0-9]+\.[0-9]+\.[0-9]+$`)
+)
+
+// ValidateRGWUserID checks a radosgw user id, given either as 'user' or as 'tenant$user'.
+func ValidateRGWUserID(uid string) error {
+	if !rgwUserExpression.MatchString(uid) {
+		return fmt.Errorf("%w: %q must be '<user>' or '<tenant>$<user>' of letters, digits, '.', '_', '@' or '-'",
+			ErrInvalidRGWUser, uid)
+	}
+
+	return nil
+}
+
+// ValidateBucketName applies the S3 bucket naming rules.
+func ValidateBucketName(bucket string) error {
+	if !bucketNameExpression.MatchString(bucket) || ipAddressExpression.MatchString(bucket) {
+		return fmt.Errorf("%w: %q must be 3 to 63 lowercase letters, digits, '.' or '-', "+
+			"start and end with a letter or digit and not look like an IP address", ErrInvalidBucketName, bucket)
+	}
+
+	return nil
+}
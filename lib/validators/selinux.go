@@ -0,0 +1,19 @@
+package validators
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// selinuxContextExpression matches 'user:role:type' with an optional MLS/MCS range such as
+// 's0' or 's0:c1,c2'.
+var selinuxContextExpression = regexp.MustCompile(`^[A-Za-z0-9_]+:[A-Za-z0-9_]+:[A-Za-z0-9_]+(:[A-Za-z0-9_.,:-]+)?$`)
+
+// ValidateSELinuxContext checks an SELinux security context, e.g. 'system_u:object_r:container_file_t:s0'.
+func ValidateSELinuxContext(context string) error {
+	if !selinuxContextExpression.MatchString(context) {
+		return fmt.Errorf("%w: %q must be 'user:role:type[:range]'", ErrInvalidSELinuxContext, context)
+	}
+
+	return nil
+}
@@ -7,6 +7,9 @@ import (
 )
 
 // ValidateInput exists to output debug data on err.
+//
+// Deprecated: use the validator of the name being checked, such as ValidatePoolName, which
+// returns an error describing the input instead of logging it.
 func ValidateInput(check *regexp.Regexp, input string) bool {
 	if check.MatchString(input) {
 		return true
@@ -18,6 +21,8 @@ func ValidateInput(check *regexp.Regexp, input string) bool {
 }
 
 // ValidateRegex ensures that the regex expression is valid.
+//
+// Deprecated: compile expressions once with regexp.MustCompile, as the validators of this package do.
 func ValidateRegex(expression string) *regexp.Regexp {
 	if check, err := regexp.Compile(expression); err == nil {
 		return check